@@ -447,7 +447,7 @@ CREATE TABLE orders (
   location_id UUID NOT NULL REFERENCES locations(id),
   order_number VARCHAR(50) NOT NULL,
   status VARCHAR(20) NOT NULL DEFAULT 'pending'
-    CHECK (status IN ('pending', 'preparing', 'ready', 'completed', 'cancelled', 'refunded')),
+    CHECK (status IN ('pending', 'accepted', 'preparing', 'ready', 'completed', 'cancelled', 'refunded', 'voided')),
   order_type VARCHAR(20) NOT NULL DEFAULT 'dine_in'
     CHECK (order_type IN ('dine_in', 'takeout', 'delivery', 'drive_through', 'online')),
   customer_id UUID,
@@ -489,7 +489,7 @@ CREATE TABLE payments (
   tenant_id UUID NOT NULL REFERENCES tenants(id),
   order_id UUID NOT NULL REFERENCES orders(id),
   method VARCHAR(30) NOT NULL
-    CHECK (method IN ('cash', 'card', 'mobile_pay', 'loyalty_points', 'split', 'online')),
+    CHECK (method IN ('cash', 'card', 'mobile_pay', 'loyalty_points', 'split', 'online', 'gift_card', 'store_credit')),
   amount DECIMAL(12,2) NOT NULL,
   currency VARCHAR(3) NOT NULL DEFAULT 'SAR',
   status VARCHAR(20) NOT NULL DEFAULT 'pending'
@@ -131,7 +131,7 @@ CREATE TABLE payments (
   tenant_id UUID NOT NULL REFERENCES tenants(id),
   order_id UUID NOT NULL REFERENCES orders(id),
   method VARCHAR(30) NOT NULL
-    CHECK (method IN ('cash', 'card', 'mobile_pay', 'loyalty_points', 'split', 'online')),
+    CHECK (method IN ('cash', 'card', 'mobile_pay', 'loyalty_points', 'split', 'online', 'gift_card', 'store_credit')),
   amount DECIMAL(12,2) NOT NULL,
   currency VARCHAR(3) NOT NULL DEFAULT 'SAR',
   status VARCHAR(20) NOT NULL DEFAULT 'pending'
@@ -0,0 +1,553 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/berhot/products/commerce/pos-engine/internal/money"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ── Cash Drawers ────────────────────────────────────────────
+
+// Drawer movement types. Pay-ins add float to the drawer, pay-outs take cash
+// out for expenses and drops move surplus cash to the safe.
+const (
+	movementPayIn  = "pay_in"
+	movementPayOut = "pay_out"
+	movementDrop   = "drop"
+)
+
+func migrateCashDrawers() {
+	db.Exec(`ALTER TABLE cash_drawers ADD COLUMN IF NOT EXISTS name VARCHAR(100) NOT NULL DEFAULT 'Main'`)
+	db.Exec(`ALTER TABLE cash_drawers ADD COLUMN IF NOT EXISTS z_number INTEGER`)
+	db.Exec(`ALTER TABLE cash_drawers ADD COLUMN IF NOT EXISTS closing_report JSONB`)
+	db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_cash_drawers_open ON cash_drawers(tenant_id, location_id, name) WHERE status = 'open'`)
+	db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_cash_drawers_z ON cash_drawers(tenant_id, location_id, z_number) WHERE z_number IS NOT NULL`)
+	db.Exec(`CREATE TABLE IF NOT EXISTS cash_drawer_movements (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		tenant_id UUID NOT NULL,
+		drawer_id UUID NOT NULL REFERENCES cash_drawers(id),
+		movement_type VARCHAR(20) NOT NULL CHECK (movement_type IN ('pay_in', 'pay_out', 'drop')),
+		amount DECIMAL(12,2) NOT NULL CHECK (amount > 0),
+		reason TEXT NOT NULL DEFAULT '',
+		user_id UUID,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`)
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_cash_drawer_movements_drawer ON cash_drawer_movements(drawer_id)")
+	db.Exec(`ALTER TABLE payments ADD COLUMN IF NOT EXISTS cash_drawer_id UUID REFERENCES cash_drawers(id)`)
+	db.Exec(`ALTER TABLE refund_payments ADD COLUMN IF NOT EXISTS cash_drawer_id UUID REFERENCES cash_drawers(id)`)
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_payments_drawer ON payments(cash_drawer_id)")
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_refund_payments_drawer ON refund_payments(cash_drawer_id)")
+}
+
+// drawerFor picks the open drawer that takes a payment or refund at a
+// location: the one named in the X-Drawer-ID header, otherwise the only
+// drawer open there. With several open the header is required, as guessing
+// would book one till's cash to another. It returns nil when no drawer is
+// open.
+func drawerFor(tx *sql.Tx, tenantID, locationID, requested string) (*string, error) {
+	var id string
+	var err error
+	if requested != "" {
+		err = tx.QueryRow(
+			`SELECT id FROM cash_drawers WHERE id = $1 AND tenant_id = $2 AND location_id = $3 AND status = 'open'`,
+			requested, tenantID, locationID).Scan(&id)
+		if err == sql.ErrNoRows {
+			return nil, paymentError("Cash drawer is not open at this location")
+		}
+	} else {
+		var open int
+		err = tx.QueryRow(
+			`SELECT COUNT(*), COALESCE(MIN(id::text), '') FROM cash_drawers WHERE tenant_id = $1 AND location_id = $2 AND status = 'open'`,
+			tenantID, locationID).Scan(&open, &id)
+		if err == nil && open == 0 {
+			return nil, nil
+		}
+		if err == nil && open > 1 {
+			return nil, paymentError("Several cash drawers are open at this location; send X-Drawer-ID")
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &id, nil
+}
+
+func openCashDrawer(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	var req struct {
+		LocationID    string       `json:"locationId" binding:"required"`
+		Name          string       `json:"name"`
+		OpeningAmount money.Amount `json:"openingAmount"`
+		Notes         string       `json:"notes"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	by := actorFromContext(c)
+	if by.idOrNil() == nil {
+		c.JSON(400, gin.H{"error": "X-User-ID is required to open a drawer"})
+		return
+	}
+	if req.OpeningAmount < 0 {
+		c.JSON(400, gin.H{"error": "Opening amount cannot be negative"})
+		return
+	}
+	if req.Name == "" {
+		req.Name = "Main"
+	}
+	var exists bool
+	db.QueryRow(
+		`SELECT EXISTS(SELECT 1 FROM cash_drawers WHERE tenant_id = $1 AND location_id = $2 AND name = $3 AND status = 'open')`,
+		tenantID, req.LocationID, req.Name).Scan(&exists)
+	if exists {
+		c.JSON(409, gin.H{"error": fmt.Sprintf("Drawer %q is already open at this location", req.Name)})
+		return
+	}
+	id := uuid.New().String()
+	var openedAt time.Time
+	err := db.QueryRow(
+		`INSERT INTO cash_drawers (id, tenant_id, location_id, name, opened_by, opening_amount, status, notes)
+		 VALUES ($1, $2, $3, $4, $5, $6, 'open', $7) RETURNING opened_at`,
+		id, tenantID, req.LocationID, req.Name, by.ID, req.OpeningAmount, req.Notes,
+	).Scan(&openedAt)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(201, gin.H{
+		"id": id, "locationId": req.LocationID, "name": req.Name, "status": "open",
+		"openingAmount": req.OpeningAmount, "openedBy": by.ID, "openedAt": openedAt,
+	})
+}
+
+func listCashDrawers(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	query := `SELECT id, location_id, name, status, opening_amount, closing_amount, expected_amount, difference,
+	                 opened_by, COALESCE(closed_by::text, ''), opened_at, closed_at, z_number
+	          FROM cash_drawers WHERE tenant_id = $1`
+	args := []interface{}{tenantID}
+	if status := c.Query("status"); status != "" {
+		args = append(args, status)
+		query += fmt.Sprintf(" AND status = $%d", len(args))
+	}
+	if loc := c.Query("locationId"); loc != "" {
+		args = append(args, loc)
+		query += fmt.Sprintf(" AND location_id = $%d", len(args))
+	}
+	query += " ORDER BY opened_at DESC LIMIT 50"
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	drawers := []gin.H{}
+	for rows.Next() {
+		var id, locID, name, status, openedBy, closedBy string
+		var opening money.Amount
+		var closing, expected, diff *money.Amount
+		var openedAt time.Time
+		var closedAt *time.Time
+		var zNumber *int
+		rows.Scan(&id, &locID, &name, &status, &opening, &closing, &expected, &diff, &openedBy, &closedBy, &openedAt, &closedAt, &zNumber)
+		drawers = append(drawers, gin.H{
+			"id": id, "locationId": locID, "name": name, "status": status,
+			"openingAmount": opening, "closingAmount": closing, "expectedAmount": expected, "difference": diff,
+			"openedBy": openedBy, "closedBy": closedBy, "openedAt": openedAt, "closedAt": closedAt, "zNumber": zNumber,
+		})
+	}
+	c.JSON(200, gin.H{"cashDrawers": drawers, "total": len(drawers)})
+}
+
+func getCashDrawer(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	report, err := drawerReport(db, tenantID, c.Param("id"))
+	if err == sql.ErrNoRows {
+		c.JSON(404, gin.H{"error": "Cash drawer not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{
+		"id": report["drawerId"], "locationId": report["locationId"], "name": report["name"],
+		"status": report["status"], "openedBy": report["openedBy"], "openedAt": report["openedAt"],
+		"closedAt": report["closedAt"], "cash": report["cash"],
+	})
+}
+
+func payInCashDrawer(c *gin.Context)  { addDrawerMovement(c, movementPayIn) }
+func payOutCashDrawer(c *gin.Context) { addDrawerMovement(c, movementPayOut) }
+func dropCashDrawer(c *gin.Context)   { addDrawerMovement(c, movementDrop) }
+
+func addDrawerMovement(c *gin.Context, kind string) {
+	tenantID := c.GetString("tenantId")
+	drawerID := c.Param("id")
+	var req struct {
+		Amount money.Amount `json:"amount" binding:"required"`
+		Reason string       `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if !req.Amount.IsPositive() {
+		c.JSON(400, gin.H{"error": "Amount must be positive"})
+		return
+	}
+	if kind == movementPayOut && req.Reason == "" {
+		c.JSON(400, gin.H{"error": "Pay-outs need a reason"})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Transaction failed"})
+		return
+	}
+	defer tx.Rollback()
+	var status string
+	err = tx.QueryRow("SELECT status FROM cash_drawers WHERE id = $1 AND tenant_id = $2 FOR UPDATE", drawerID, tenantID).Scan(&status)
+	if err == sql.ErrNoRows {
+		c.JSON(404, gin.H{"error": "Cash drawer not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if status != "open" {
+		c.JSON(409, gin.H{"error": "Cash drawer is closed"})
+		return
+	}
+	// Cash cannot leave the drawer if it is not there.
+	if kind != movementPayIn {
+		cash, err := drawerCash(tx, tenantID, drawerID)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		if req.Amount > cash.expected {
+			c.JSON(400, gin.H{"error": fmt.Sprintf("Drawer only holds %s", cash.expected)})
+			return
+		}
+	}
+	id := uuid.New().String()
+	by := actorFromContext(c)
+	if _, err := tx.Exec(
+		`INSERT INTO cash_drawer_movements (id, tenant_id, drawer_id, movement_type, amount, reason, user_id)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		id, tenantID, drawerID, kind, req.Amount, req.Reason, by.idOrNil()); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	cash, err := drawerCash(tx, tenantID, drawerID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(201, gin.H{"id": id, "drawerId": drawerID, "type": kind, "amount": req.Amount, "reason": req.Reason, "expectedAmount": cash.expected})
+}
+
+func closeCashDrawer(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	drawerID := c.Param("id")
+	var req struct {
+		ClosingAmount *money.Amount `json:"closingAmount" binding:"required"`
+		Notes         string        `json:"notes"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	by := actorFromContext(c)
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Transaction failed"})
+		return
+	}
+	defer tx.Rollback()
+	var status, locationID string
+	err = tx.QueryRow("SELECT status, location_id FROM cash_drawers WHERE id = $1 AND tenant_id = $2 FOR UPDATE", drawerID, tenantID).Scan(&status, &locationID)
+	if err == sql.ErrNoRows {
+		c.JSON(404, gin.H{"error": "Cash drawer not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if status != "open" {
+		c.JSON(409, gin.H{"error": "Cash drawer is already closed"})
+		return
+	}
+
+	// Z numbers run per location; the location row serialises closes.
+	var zNumber int
+	if _, err := tx.Exec("SELECT 1 FROM locations WHERE id = $1 FOR UPDATE", locationID); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	tx.QueryRow("SELECT COALESCE(MAX(z_number), 0) + 1 FROM cash_drawers WHERE tenant_id = $1 AND location_id = $2", tenantID, locationID).Scan(&zNumber)
+
+	cash, err := drawerCash(tx, tenantID, drawerID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	difference := *req.ClosingAmount - cash.expected
+	if _, err := tx.Exec(
+		`UPDATE cash_drawers SET status = 'closed', closed_by = $1, closed_at = NOW(), closing_amount = $2,
+		        expected_amount = $3, difference = $4, z_number = $5, notes = COALESCE(NULLIF($6, ''), notes)
+		 WHERE id = $7 AND tenant_id = $8`,
+		by.idOrNil(), *req.ClosingAmount, cash.expected, difference, zNumber, req.Notes, drawerID, tenantID); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	// The Z report is frozen at close so later edits cannot change it.
+	report, err := drawerReport(tx, tenantID, drawerID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	snapshot, _ := json.Marshal(report)
+	if _, err := tx.Exec("UPDATE cash_drawers SET closing_report = $1 WHERE id = $2 AND tenant_id = $3", string(snapshot), drawerID, tenantID); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, report)
+}
+
+func getXReport(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	report, err := drawerReport(db, tenantID, c.Param("id"))
+	if err == sql.ErrNoRows {
+		c.JSON(404, gin.H{"error": "Cash drawer not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if report["status"] != "open" {
+		c.JSON(409, gin.H{"error": "Cash drawer is closed, use its Z report"})
+		return
+	}
+	c.JSON(200, report)
+}
+
+func getZReport(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	var status string
+	var snapshot sql.NullString
+	err := db.QueryRow("SELECT status, closing_report FROM cash_drawers WHERE id = $1 AND tenant_id = $2", c.Param("id"), tenantID).Scan(&status, &snapshot)
+	if err == sql.ErrNoRows {
+		c.JSON(404, gin.H{"error": "Cash drawer not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if status != "closed" || !snapshot.Valid {
+		c.JSON(409, gin.H{"error": "Z reports are produced when the drawer is closed, use its X report"})
+		return
+	}
+	c.Data(200, "application/json; charset=utf-8", []byte(snapshot.String))
+}
+
+// drawerCashTotals is the cash that went through a drawer.
+type drawerCashTotals struct {
+	opening, sales, tendered, change, payIns, payOuts, drops, refunds, expected money.Amount
+}
+
+// drawerCash works out what a drawer should hold: the opening float plus
+// cash taken (tendered less change given) and pay-ins, less pay-outs, drops
+// and cash refunds.
+func drawerCash(q queryer, tenantID, drawerID string) (drawerCashTotals, error) {
+	var t drawerCashTotals
+	err := q.QueryRow(
+		`SELECT d.opening_amount,
+		        COALESCE((SELECT SUM(amount) FROM payments WHERE cash_drawer_id = d.id AND method = 'cash' AND status IN ('completed', 'refunded')), 0),
+		        COALESCE((SELECT SUM(COALESCE(tendered_amount, amount)) FROM payments WHERE cash_drawer_id = d.id AND method = 'cash' AND status IN ('completed', 'refunded')), 0),
+		        COALESCE((SELECT SUM(change_amount) FROM payments WHERE cash_drawer_id = d.id AND method = 'cash' AND status IN ('completed', 'refunded')), 0),
+		        COALESCE((SELECT SUM(amount) FROM cash_drawer_movements WHERE drawer_id = d.id AND movement_type = 'pay_in'), 0),
+		        COALESCE((SELECT SUM(amount) FROM cash_drawer_movements WHERE drawer_id = d.id AND movement_type = 'pay_out'), 0),
+		        COALESCE((SELECT SUM(amount) FROM cash_drawer_movements WHERE drawer_id = d.id AND movement_type = 'drop'), 0),
+		        COALESCE((SELECT SUM(amount) FROM refund_payments WHERE cash_drawer_id = d.id AND method = 'cash'), 0)
+		 FROM cash_drawers d WHERE d.id = $1 AND d.tenant_id = $2`, drawerID, tenantID,
+	).Scan(&t.opening, &t.sales, &t.tendered, &t.change, &t.payIns, &t.payOuts, &t.drops, &t.refunds)
+	if err != nil {
+		return t, err
+	}
+	t.expected = t.opening + t.sales + t.payIns - t.payOuts - t.drops - t.refunds
+	return t, nil
+}
+
+// drawerReport builds the X/Z report of a drawer session. Sales, tax and
+// discounts cover the orders the drawer took payments for, each in the share
+// of the order the drawer was paid, so an order paid across drawers or split
+// over several checks is only counted once.
+func drawerReport(q queryer, tenantID, drawerID string) (gin.H, error) {
+	var locationID, name, status, openedBy, closedBy string
+	var openedAt time.Time
+	var closedAt *time.Time
+	var closing, difference *money.Amount
+	var zNumber *int
+	err := q.QueryRow(
+		`SELECT location_id, name, status, opened_by, COALESCE(closed_by::text, ''), opened_at, closed_at, closing_amount, difference, z_number
+		 FROM cash_drawers WHERE id = $1 AND tenant_id = $2`, drawerID, tenantID,
+	).Scan(&locationID, &name, &status, &openedBy, &closedBy, &openedAt, &closedAt, &closing, &difference, &zNumber)
+	if err != nil {
+		return nil, err
+	}
+	cash, err := drawerCash(q, tenantID, drawerID)
+	if err != nil {
+		return nil, err
+	}
+
+	tenders := []gin.H{}
+	rows, err := q.Query(
+		`SELECT method, COUNT(*), SUM(amount), SUM(refunded_amount) FROM payments
+		 WHERE cash_drawer_id = $1 AND tenant_id = $2 AND status IN ('completed', 'refunded')
+		 GROUP BY method ORDER BY method`, drawerID, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var method string
+		var count int
+		var amount, refunded money.Amount
+		rows.Scan(&method, &count, &amount, &refunded)
+		tenders = append(tenders, gin.H{"method": method, "count": count, "amount": amount, "refundedAmount": refunded})
+	}
+	rows.Close()
+
+	const drawerShares = `(SELECT p.order_id, SUM(p.amount) AS paid, SUM(p.amount) / NULLIF(o.total, 0) AS share
+		 FROM payments p JOIN orders o ON o.id = p.order_id
+		 WHERE p.cash_drawer_id = $1 AND p.tenant_id = $2 AND p.status IN ('completed', 'refunded')
+		 GROUP BY p.order_id, o.total) s`
+	var orderCount int
+	var gross, discounts, taxTotal money.Amount
+	if err := q.QueryRow(
+		`SELECT COUNT(*), COALESCE(SUM(s.paid), 0), COALESCE(SUM(ROUND(o.discount_amount * s.share, 2)), 0),
+		        COALESCE(SUM(ROUND(COALESCE(o.tax_amount, 0) * s.share, 2)), 0)
+		 FROM orders o JOIN `+drawerShares+` ON s.order_id = o.id`, drawerID, tenantID,
+	).Scan(&orderCount, &gross, &discounts, &taxTotal); err != nil {
+		return nil, err
+	}
+
+	taxes := []gin.H{}
+	rows, err = q.Query(
+		`SELECT t.tax_category_code, t.tax_kind, t.rate, COALESCE(SUM(ROUND(t.taxable_amount * s.share, 2)), 0),
+		        COALESCE(SUM(ROUND(t.tax_amount * s.share, 2)), 0)
+		 FROM order_tax_summary t JOIN `+drawerShares+` ON s.order_id = t.order_id
+		 GROUP BY t.tax_category_code, t.tax_kind, t.rate ORDER BY t.rate DESC, t.tax_kind`, drawerID, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var code, kind string
+		var rate float64
+		var taxable, amount money.Amount
+		rows.Scan(&code, &kind, &rate, &taxable, &amount)
+		taxes = append(taxes, gin.H{"code": code, "kind": kind, "rate": rate, "taxableAmount": taxable, "taxAmount": amount})
+	}
+	rows.Close()
+
+	promos := []gin.H{}
+	rows, err = q.Query(
+		`SELECT op.promotion_id, op.name, COALESCE(op.code, ''), COUNT(*), COALESCE(SUM(ROUND(op.amount * s.share, 2)), 0)
+		 FROM order_promotions op JOIN `+drawerShares+` ON s.order_id = op.order_id
+		 GROUP BY op.promotion_id, op.name, op.code ORDER BY 5 DESC`, drawerID, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var pid, pname, code string
+		var count int
+		var amount money.Amount
+		rows.Scan(&pid, &pname, &code, &count, &amount)
+		promos = append(promos, gin.H{"promotionId": pid, "name": pname, "code": code, "orders": count, "amount": amount})
+	}
+	rows.Close()
+
+	refundsByMethod := []gin.H{}
+	var refundTotal money.Amount
+	var refundCount int
+	rows, err = q.Query(
+		`SELECT method, COUNT(DISTINCT refund_id), SUM(amount) FROM refund_payments
+		 WHERE cash_drawer_id = $1 AND tenant_id = $2 GROUP BY method ORDER BY method`, drawerID, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var method string
+		var count int
+		var amount money.Amount
+		rows.Scan(&method, &count, &amount)
+		refundTotal += amount
+		refundCount += count
+		refundsByMethod = append(refundsByMethod, gin.H{"method": method, "count": count, "amount": amount})
+	}
+	rows.Close()
+
+	cashiers := []gin.H{}
+	rows, err = q.Query(
+		`SELECT COALESCE(p.received_by::text, ''), COALESCE(u.first_name || ' ' || u.last_name, ''), COUNT(*), SUM(p.amount),
+		        COALESCE(SUM(p.amount) FILTER (WHERE p.method = 'cash'), 0)
+		 FROM payments p
+		 LEFT JOIN users u ON u.id = p.received_by
+		 WHERE p.cash_drawer_id = $1 AND p.tenant_id = $2 AND p.status IN ('completed', 'refunded')
+		 GROUP BY p.received_by, u.first_name, u.last_name ORDER BY SUM(p.amount) DESC`, drawerID, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var userID, userName string
+		var count int
+		var amount, cashAmount money.Amount
+		rows.Scan(&userID, &userName, &count, &amount, &cashAmount)
+		cashiers = append(cashiers, gin.H{"userId": userID, "name": userName, "payments": count, "amount": amount, "cashAmount": cashAmount})
+	}
+	rows.Close()
+
+	reportType := "X"
+	if status == "closed" {
+		reportType = "Z"
+	}
+	return gin.H{
+		"type": reportType, "drawerId": drawerID, "locationId": locationID, "name": name, "status": status,
+		"zNumber": zNumber, "openedBy": openedBy, "closedBy": closedBy, "openedAt": openedAt, "closedAt": closedAt,
+		"generatedAt": time.Now(), "currency": currencyFor(tenantID, locationID),
+		"cash": gin.H{
+			"openingAmount": cash.opening, "cashSales": cash.sales, "tendered": cash.tendered, "changeGiven": cash.change,
+			"payIns": cash.payIns, "payOuts": cash.payOuts, "drops": cash.drops, "cashRefunds": cash.refunds,
+			"expectedAmount": cash.expected, "closingAmount": closing, "difference": difference,
+		},
+		"sales": gin.H{
+			"orders": orderCount, "grossSales": gross, "discounts": discounts, "tax": taxTotal,
+			"refunds": refundTotal, "netSales": gross - refundTotal,
+		},
+		"tenders": tenders, "taxSummary": taxes, "promotions": promos,
+		"refunds":  gin.H{"count": refundCount, "amount": refundTotal, "byMethod": refundsByMethod},
+		"cashiers": cashiers,
+	}, nil
+}
@@ -0,0 +1,553 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/berhot/products/commerce/pos-engine/internal/money"
+	"github.com/gin-gonic/gin"
+)
+
+// ── Combos ──────────────────────────────────────────────────
+
+// A combo is a product of type 'combo' sold at its own price. Its fixed
+// parts (the burger in a burger meal) are combo_components; its choices (the
+// side, the drink) are the modifier groups linked to it, whose items name
+// the product they stand for. A choice's price adjustment is its upcharge.
+func migrateCombos() {
+	db.Exec(`ALTER TABLE modifier_items ADD COLUMN IF NOT EXISTS component_product_id UUID REFERENCES products(id) ON DELETE SET NULL`)
+	db.Exec(`ALTER TABLE modifier_items ADD COLUMN IF NOT EXISTS component_variant_id UUID REFERENCES product_variants(id) ON DELETE SET NULL`)
+	db.Exec(`ALTER TABLE modifier_items ADD COLUMN IF NOT EXISTS component_quantity DECIMAL(10,3) NOT NULL DEFAULT 1`)
+
+	db.Exec(`CREATE TABLE IF NOT EXISTS combo_components (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		tenant_id UUID NOT NULL,
+		combo_product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+		product_id UUID NOT NULL REFERENCES products(id),
+		variant_id UUID REFERENCES product_variants(id),
+		quantity DECIMAL(10,3) NOT NULL DEFAULT 1 CHECK (quantity > 0),
+		sort_order INTEGER NOT NULL DEFAULT 0
+	)`)
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_combo_components_combo ON combo_components(combo_product_id)")
+
+	// What one of a combo line was made of. unit_allocation is the part of
+	// the line's unit price that belongs to the component; the parts add up
+	// to the unit price exactly.
+	db.Exec(`CREATE TABLE IF NOT EXISTS order_item_components (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		tenant_id UUID NOT NULL,
+		order_item_id UUID NOT NULL REFERENCES order_items(id) ON DELETE CASCADE,
+		product_id UUID NOT NULL REFERENCES products(id),
+		variant_id UUID,
+		modifier_item_id UUID,
+		name VARCHAR(255) NOT NULL,
+		quantity DECIMAL(10,3) NOT NULL,
+		unit_allocation DECIMAL(12,2) NOT NULL DEFAULT 0
+	)`)
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_order_item_components_item ON order_item_components(order_item_id)")
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_order_item_components_product ON order_item_components(tenant_id, product_id)")
+}
+
+// comboPart is one component of a combo as sold on a line.
+type comboPart struct {
+	productID      string
+	variantID      *string
+	modifierItemID *string
+	name           string
+	quantity       float64
+	tracked        bool
+	standalone     money.Amount
+	upcharge       money.Amount
+	allocation     money.Amount
+}
+
+// comboSelections checks the choices made on a combo against the modifier
+// groups linked to it and returns them priced as the menu and the running
+// price lists price them, with the combo's components: its fixed parts and
+// the products its choices stand for. Errors are meant for the client.
+func comboSelections(tenantID, comboID, comboName string, chosen []orderModifier, prices priceContext) ([]orderModifier, []comboPart, error) {
+	type group struct {
+		name     string
+		min, max int
+		required bool
+		count    int
+	}
+	groups := map[string]*group{}
+	var order []string
+	rows, err := db.Query(
+		`SELECT mg.id, mg.name, mg.min_selections, mg.max_selections, mg.is_required
+		 FROM modifier_groups mg JOIN product_modifier_groups pmg ON pmg.modifier_group_id = mg.id
+		 WHERE pmg.product_id = $1 AND mg.tenant_id = $2 AND mg.is_active
+		 ORDER BY pmg.sort_order, mg.sort_order`, comboID, tenantID)
+	if err != nil {
+		return nil, nil, err
+	}
+	for rows.Next() {
+		var id string
+		g := &group{}
+		rows.Scan(&id, &g.name, &g.min, &g.max, &g.required)
+		groups[id] = g
+		order = append(order, id)
+	}
+	rows.Close()
+
+	type choice struct {
+		groupID, name string
+		price         money.Amount
+		part          *comboPart
+	}
+	choices := map[string]choice{}
+	rows, err = db.Query(
+		`SELECT mi.id, mi.modifier_group_id, mi.name, mi.price_adjustment, mi.component_product_id, mi.component_variant_id::text,
+		        mi.component_quantity, COALESCE(p.name, ''), COALESCE(p.price, 0) + COALESCE(v.price_adjustment, 0), COALESCE(p.track_inventory, false),
+		        COALESCE(v.name, '')
+		 FROM modifier_items mi
+		 JOIN product_modifier_groups pmg ON pmg.modifier_group_id = mi.modifier_group_id
+		 JOIN modifier_groups mg ON mg.id = mi.modifier_group_id AND mg.is_active
+		 LEFT JOIN products p ON p.id = mi.component_product_id
+		 LEFT JOIN product_variants v ON v.id = mi.component_variant_id
+		 WHERE pmg.product_id = $1 AND mi.tenant_id = $2 AND mi.is_active`, comboID, tenantID)
+	if err != nil {
+		return nil, nil, err
+	}
+	for rows.Next() {
+		var id string
+		var ch choice
+		var productID, variantID sql.NullString
+		var qty float64
+		var productName, variantName string
+		var standalone money.Amount
+		var tracked bool
+		rows.Scan(&id, &ch.groupID, &ch.name, &ch.price, &productID, &variantID, &qty, &productName, &standalone, &tracked, &variantName)
+		if listed, ok := prices.modifierPrices[id]; ok {
+			ch.price = listed
+		}
+		if productID.Valid {
+			itemID := id
+			ch.part = &comboPart{
+				productID: productID.String, modifierItemID: &itemID, name: productName, quantity: qty,
+				tracked: tracked, standalone: standalone, upcharge: ch.price,
+			}
+			if variantID.Valid {
+				ch.part.variantID = &variantID.String
+				ch.part.name += " - " + variantName
+			}
+		}
+		choices[id] = ch
+	}
+	rows.Close()
+
+	var parts []comboPart
+	rows, err = db.Query(
+		`SELECT cc.product_id, cc.variant_id::text, cc.quantity, p.name, COALESCE(v.name, ''),
+		        p.price + COALESCE(v.price_adjustment, 0), p.track_inventory
+		 FROM combo_components cc
+		 JOIN products p ON p.id = cc.product_id
+		 LEFT JOIN product_variants v ON v.id = cc.variant_id
+		 WHERE cc.combo_product_id = $1 AND cc.tenant_id = $2
+		 ORDER BY cc.sort_order`, comboID, tenantID)
+	if err != nil {
+		return nil, nil, err
+	}
+	for rows.Next() {
+		var p comboPart
+		var variantID sql.NullString
+		var variantName string
+		rows.Scan(&p.productID, &variantID, &p.quantity, &p.name, &variantName, &p.standalone, &p.tracked)
+		if variantID.Valid {
+			p.variantID = &variantID.String
+			p.name += " - " + variantName
+		}
+		parts = append(parts, p)
+	}
+	rows.Close()
+
+	priced := make([]orderModifier, 0, len(chosen))
+	for _, mod := range chosen {
+		ch, ok := choices[mod.ItemID]
+		if !ok {
+			return nil, nil, fmt.Errorf("%s is not a choice on %s", mod.ItemID, comboName)
+		}
+		g := groups[ch.groupID]
+		g.count++
+		priced = append(priced, orderModifier{GroupID: ch.groupID, GroupName: g.name, ItemID: mod.ItemID, ItemName: ch.name, Price: ch.price})
+		if ch.part != nil {
+			parts = append(parts, *ch.part)
+		}
+	}
+	for _, id := range order {
+		g := groups[id]
+		need := g.min
+		if g.required && need < 1 {
+			need = 1
+		}
+		if g.count < need {
+			return nil, nil, fmt.Errorf("%s needs %d choice(s) from %s", comboName, need, g.name)
+		}
+		if g.max > 0 && g.count > g.max {
+			return nil, nil, fmt.Errorf("%s allows at most %d choice(s) from %s", comboName, g.max, g.name)
+		}
+	}
+	if len(parts) == 0 {
+		return nil, nil, fmt.Errorf("Combo %s has no components", comboName)
+	}
+	return priced, parts, nil
+}
+
+// allocateCombo shares a combo's unit price among its components. Each
+// choice keeps its own upcharge; the rest is split by what the components
+// sell for on their own, or evenly when none has a price.
+func allocateCombo(unitPrice money.Amount, parts []comboPart) {
+	base := unitPrice
+	weights := make([]money.Amount, len(parts))
+	var total money.Amount
+	for i, p := range parts {
+		base -= p.upcharge
+		weights[i] = p.standalone.Mul(p.quantity)
+		total += weights[i]
+	}
+	if total <= 0 {
+		for i := range weights {
+			weights[i] = 1
+		}
+	}
+	for i, share := range base.Allocate(weights) {
+		parts[i].allocation = share + parts[i].upcharge
+	}
+}
+
+// insertComboParts records what an order line's combo was made of.
+func insertComboParts(tx *sql.Tx, tenantID, itemID string, parts []comboPart) error {
+	for _, p := range parts {
+		if _, err := tx.Exec(
+			`INSERT INTO order_item_components (tenant_id, order_item_id, product_id, variant_id, modifier_item_id, name, quantity, unit_allocation)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+			tenantID, itemID, p.productID, p.variantID, p.modifierItemID, p.name, p.quantity, p.allocation); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// itemStockLines is the stock qty of an order line takes: its tracked
+// components for a combo, otherwise its product if tracked.
+func itemStockLines(q queryer, tenantID, itemID string, qty float64) ([]stockLine, error) {
+	rows, err := q.Query(
+		`SELECT oic.product_id, oic.variant_id::text, oic.name, oic.quantity
+		 FROM order_item_components oic JOIN products p ON p.id = oic.product_id
+		 WHERE oic.order_item_id = $1 AND oic.tenant_id = $2 AND p.track_inventory
+		 UNION ALL
+		 SELECT oi.product_id, oi.variant_id::text, oi.name, 1
+		 FROM order_items oi JOIN products p ON p.id = oi.product_id
+		 WHERE oi.id = $1 AND oi.tenant_id = $2 AND p.track_inventory
+		   AND NOT EXISTS (SELECT 1 FROM order_item_components WHERE order_item_id = oi.id)`, itemID, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var lines []stockLine
+	for rows.Next() {
+		var l stockLine
+		var variantID sql.NullString
+		var per float64
+		if err := rows.Scan(&l.productID, &variantID, &l.name, &per); err != nil {
+			return nil, err
+		}
+		if variantID.Valid {
+			l.variantID = &variantID.String
+		}
+		l.quantity = per * qty
+		lines = append(lines, l)
+	}
+	return lines, rows.Err()
+}
+
+// getCombo shows what a combo is made of: its fixed components and the
+// choice groups linked to it.
+func getCombo(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	productID := c.Param("id")
+	var name, productType string
+	var price money.Amount
+	if err := db.QueryRow("SELECT name, product_type, price FROM products WHERE id = $1 AND tenant_id = $2", productID, tenantID).
+		Scan(&name, &productType, &price); err != nil {
+		c.JSON(404, gin.H{"error": "Product not found"})
+		return
+	}
+	rows, err := db.Query(
+		`SELECT cc.product_id, COALESCE(cc.variant_id::text, ''), cc.quantity, p.name, COALESCE(v.name, ''),
+		        p.price + COALESCE(v.price_adjustment, 0)
+		 FROM combo_components cc
+		 JOIN products p ON p.id = cc.product_id
+		 LEFT JOIN product_variants v ON v.id = cc.variant_id
+		 WHERE cc.combo_product_id = $1 AND cc.tenant_id = $2
+		 ORDER BY cc.sort_order`, productID, tenantID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	components := []gin.H{}
+	for rows.Next() {
+		var pid, variantID, pname, variantName string
+		var qty float64
+		var standalone money.Amount
+		rows.Scan(&pid, &variantID, &qty, &pname, &variantName, &standalone)
+		components = append(components, gin.H{
+			"productId": pid, "variantId": variantID, "name": pname, "variantName": variantName,
+			"quantity": qty, "standalonePrice": standalone,
+		})
+	}
+	rows.Close()
+
+	choiceRows, err := db.Query(
+		`SELECT mg.id, mg.name, mg.min_selections, mg.max_selections, mg.is_required,
+		        mi.id, mi.name, mi.price_adjustment, mi.is_default, COALESCE(mi.component_product_id::text, ''),
+		        COALESCE(mi.component_variant_id::text, ''), mi.component_quantity
+		 FROM modifier_groups mg
+		 JOIN product_modifier_groups pmg ON pmg.modifier_group_id = mg.id
+		 JOIN modifier_items mi ON mi.modifier_group_id = mg.id AND mi.is_active
+		 WHERE pmg.product_id = $1 AND mg.tenant_id = $2 AND mg.is_active
+		 ORDER BY pmg.sort_order, mg.sort_order, mi.sort_order`, productID, tenantID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer choiceRows.Close()
+	groups := []gin.H{}
+	var current gin.H
+	for choiceRows.Next() {
+		var gid, gname, iid, iname, componentID, componentVariantID string
+		var minSel, maxSel int
+		var required, isDefault bool
+		var upcharge money.Amount
+		var qty float64
+		choiceRows.Scan(&gid, &gname, &minSel, &maxSel, &required, &iid, &iname, &upcharge, &isDefault, &componentID, &componentVariantID, &qty)
+		if current == nil || current["id"] != gid {
+			current = gin.H{"id": gid, "name": gname, "minSelections": minSel, "maxSelections": maxSel, "isRequired": required, "items": []gin.H{}}
+			groups = append(groups, current)
+		}
+		current["items"] = append(current["items"].([]gin.H), gin.H{
+			"id": iid, "name": iname, "upcharge": upcharge, "isDefault": isDefault,
+			"componentProductId": componentID, "componentVariantId": componentVariantID, "componentQuantity": qty,
+		})
+	}
+	c.JSON(200, gin.H{
+		"productId": productID, "name": name, "isCombo": productType == "combo", "price": price,
+		"components": components, "choiceGroups": groups,
+	})
+}
+
+// setComboComponents replaces a combo's fixed components and makes the
+// product a combo if it was not one.
+func setComboComponents(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	productID := c.Param("id")
+	var req struct {
+		Components []struct {
+			ProductID string  `json:"productId" binding:"required"`
+			VariantID string  `json:"variantId"`
+			Quantity  float64 `json:"quantity"`
+		} `json:"components" binding:"dive"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	var productType string
+	if err := db.QueryRow("SELECT product_type FROM products WHERE id = $1 AND tenant_id = $2", productID, tenantID).Scan(&productType); err != nil {
+		c.JSON(404, gin.H{"error": "Product not found"})
+		return
+	}
+	if productType != "simple" && productType != "combo" {
+		c.JSON(409, gin.H{"error": fmt.Sprintf("A %s product cannot be a combo", productType)})
+		return
+	}
+	for _, comp := range req.Components {
+		if comp.ProductID == productID {
+			c.JSON(400, gin.H{"error": "A combo cannot contain itself"})
+			return
+		}
+		if comp.Quantity < 0 {
+			c.JSON(400, gin.H{"error": "Quantity cannot be negative"})
+			return
+		}
+		var componentType string
+		if err := db.QueryRow("SELECT product_type FROM products WHERE id = $1 AND tenant_id = $2", comp.ProductID, tenantID).
+			Scan(&componentType); err != nil {
+			c.JSON(404, gin.H{"error": fmt.Sprintf("Product %s not found", comp.ProductID)})
+			return
+		}
+		if componentType == "combo" {
+			c.JSON(400, gin.H{"error": "Combos cannot be nested"})
+			return
+		}
+		if _, err := variantForOrder(tenantID, comp.ProductID, comp.VariantID); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Transaction failed"})
+		return
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM combo_components WHERE combo_product_id = $1 AND tenant_id = $2", productID, tenantID); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	for i, comp := range req.Components {
+		qty := comp.Quantity
+		if qty == 0 {
+			qty = 1
+		}
+		if _, err := tx.Exec(
+			`INSERT INTO combo_components (tenant_id, combo_product_id, product_id, variant_id, quantity, sort_order)
+			 VALUES ($1, $2, $3, NULLIF($4, '')::uuid, $5, $6)`,
+			tenantID, productID, comp.ProductID, comp.VariantID, qty, i); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if _, err := tx.Exec("UPDATE products SET product_type = 'combo', updated_at = NOW() WHERE id = $1 AND tenant_id = $2",
+		productID, tenantID); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Combo updated", "productId": productID, "components": len(req.Components)})
+}
+
+// setModifierComponent makes a modifier item stand for a product, so that
+// choosing it on a combo takes that product's stock and revenue share. An
+// empty productId unlinks it.
+func setModifierComponent(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	itemID := c.Param("id")
+	var req struct {
+		ProductID string  `json:"productId"`
+		VariantID string  `json:"variantId"`
+		Quantity  float64 `json:"quantity"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Quantity < 0 {
+		c.JSON(400, gin.H{"error": "Quantity cannot be negative"})
+		return
+	}
+	if req.Quantity == 0 {
+		req.Quantity = 1
+	}
+	if req.ProductID != "" {
+		var componentType string
+		if err := db.QueryRow("SELECT product_type FROM products WHERE id = $1 AND tenant_id = $2", req.ProductID, tenantID).
+			Scan(&componentType); err != nil {
+			c.JSON(404, gin.H{"error": "Product not found"})
+			return
+		}
+		if componentType == "combo" {
+			c.JSON(400, gin.H{"error": "Combos cannot be nested"})
+			return
+		}
+		if _, err := variantForOrder(tenantID, req.ProductID, req.VariantID); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	res, err := db.Exec(
+		`UPDATE modifier_items SET component_product_id = NULLIF($1, '')::uuid, component_variant_id = NULLIF($2, '')::uuid,
+		        component_quantity = $3
+		 WHERE id = $4 AND tenant_id = $5`, req.ProductID, req.VariantID, req.Quantity, itemID, tenantID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(404, gin.H{"error": "Modifier item not found"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Modifier component updated", "id": itemID, "componentProductId": req.ProductID})
+}
+
+// getComboReport shows completed combo sales with their revenue, before
+// tax and after discounts, shared out among the components each combo line
+// was made of.
+func getComboReport(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	from := c.DefaultQuery("from", time.Now().Format("2006-01-02"))
+	to := c.DefaultQuery("to", from)
+	locationID := c.Query("locationId")
+
+	const filter = `oi.tenant_id = $1 AND o.status = 'completed' AND oi.line_status <> 'voided'
+		   AND o.created_at >= $2::date AND o.created_at < $3::date + 1 AND ($4 = '' OR o.location_id::text = $4)`
+	args := []interface{}{tenantID, from, to, locationID}
+
+	rows, err := db.Query(
+		`SELECT oi.product_id, MAX(p.name), SUM(oi.quantity), SUM(oi.net_amount)
+		 FROM order_items oi
+		 JOIN orders o ON o.id = oi.order_id
+		 JOIN products p ON p.id = oi.product_id
+		 WHERE `+filter+` AND EXISTS (SELECT 1 FROM order_item_components WHERE order_item_id = oi.id)
+		 GROUP BY oi.product_id ORDER BY SUM(oi.net_amount) DESC`, args...)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	combos := []gin.H{}
+	byCombo := map[string]gin.H{}
+	for rows.Next() {
+		var id, name string
+		var qty float64
+		var revenue money.Amount
+		rows.Scan(&id, &name, &qty, &revenue)
+		combo := gin.H{"productId": id, "name": name, "quantitySold": qty, "revenue": revenue, "components": []gin.H{}}
+		combos = append(combos, combo)
+		byCombo[id] = combo
+	}
+	rows.Close()
+
+	rows, err = db.Query(
+		`SELECT oi.product_id, oic.product_id, MAX(cp.name), SUM(oi.quantity * oic.quantity),
+		        SUM(CASE WHEN oi.unit_price = 0 THEN 0 ELSE ROUND(oi.net_amount * oic.unit_allocation / oi.unit_price, 2) END)
+		 FROM order_item_components oic
+		 JOIN order_items oi ON oi.id = oic.order_item_id
+		 JOIN orders o ON o.id = oi.order_id
+		 JOIN products cp ON cp.id = oic.product_id
+		 WHERE `+filter+`
+		 GROUP BY oi.product_id, oic.product_id ORDER BY 5 DESC`, args...)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	components := []gin.H{}
+	byComponent := map[string]gin.H{}
+	for rows.Next() {
+		var comboID, productID, name string
+		var qty float64
+		var revenue money.Amount
+		rows.Scan(&comboID, &productID, &name, &qty, &revenue)
+		if combo, ok := byCombo[comboID]; ok {
+			combo["components"] = append(combo["components"].([]gin.H), gin.H{
+				"productId": productID, "name": name, "quantity": qty, "revenue": revenue,
+			})
+		}
+		total, ok := byComponent[productID]
+		if !ok {
+			total = gin.H{"productId": productID, "name": name, "quantity": 0.0, "revenue": money.Amount(0)}
+			byComponent[productID] = total
+			components = append(components, total)
+		}
+		total["quantity"] = total["quantity"].(float64) + qty
+		total["revenue"] = total["revenue"].(money.Amount) + revenue
+	}
+	c.JSON(200, gin.H{"from": from, "to": to, "combos": combos, "byComponent": components})
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/berhot/products/commerce/pos-engine/internal/geo"
+	"github.com/berhot/products/commerce/pos-engine/internal/money"
+	"github.com/berhot/products/commerce/pos-engine/internal/tax"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ── Delivery Zones ──────────────────────────────────────────
+
+// A zone is either a polygon drawn on the map or a ring around a centre
+// point; rings let fees step up with distance.
+const (
+	zoneKindPolygon = "polygon"
+	zoneKindRadius  = "radius"
+	deliveryFeeSKU  = "DELIVERY-FEE"
+)
+
+func migrateDelivery() {
+	db.Exec(`CREATE TABLE IF NOT EXISTS delivery_zones (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		tenant_id UUID NOT NULL,
+		location_id UUID NOT NULL REFERENCES locations(id) ON DELETE CASCADE,
+		name VARCHAR(100) NOT NULL,
+		kind VARCHAR(10) NOT NULL CHECK (kind IN ('polygon', 'radius')),
+		polygon JSONB NOT NULL DEFAULT '[]',
+		center_latitude DOUBLE PRECISION,
+		center_longitude DOUBLE PRECISION,
+		inner_radius_km DECIMAL(8,3) NOT NULL DEFAULT 0,
+		radius_km DECIMAL(8,3) NOT NULL DEFAULT 0,
+		fee DECIMAL(12,2) NOT NULL DEFAULT 0 CHECK (fee >= 0),
+		minimum_order DECIMAL(12,2) NOT NULL DEFAULT 0,
+		eta_minutes INTEGER NOT NULL DEFAULT 30,
+		sort_order INTEGER NOT NULL DEFAULT 0,
+		is_active BOOLEAN NOT NULL DEFAULT true,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`)
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_delivery_zones_tenant ON delivery_zones(tenant_id, location_id) WHERE is_active")
+
+	db.Exec(`ALTER TABLE orders ADD COLUMN IF NOT EXISTS delivery_zone_id UUID REFERENCES delivery_zones(id) ON DELETE SET NULL`)
+	db.Exec(`ALTER TABLE orders ADD COLUMN IF NOT EXISTS delivery_address_id UUID`)
+	db.Exec(`ALTER TABLE orders ADD COLUMN IF NOT EXISTS delivery_latitude DOUBLE PRECISION`)
+	db.Exec(`ALTER TABLE orders ADD COLUMN IF NOT EXISTS delivery_longitude DOUBLE PRECISION`)
+	db.Exec(`ALTER TABLE orders ADD COLUMN IF NOT EXISTS delivery_fee DECIMAL(12,2) NOT NULL DEFAULT 0`)
+	db.Exec(`ALTER TABLE orders ADD COLUMN IF NOT EXISTS delivery_eta_minutes INTEGER`)
+}
+
+// deliveryZone is an active zone as orders are checked against it.
+type deliveryZone struct {
+	id, locationID, locationName, name, kind string
+	polygon                                  []geo.Point
+	center                                   geo.Point
+	innerKm, outerKm                         float64
+	fee, minimumOrder                        money.Amount
+	etaMinutes                               int
+}
+
+func (z deliveryZone) contains(p geo.Point) bool {
+	if z.kind == zoneKindRadius {
+		return geo.InRing(p, z.center, z.innerKm, z.outerKm)
+	}
+	return geo.InPolygon(p, z.polygon)
+}
+
+func (z deliveryZone) quote() gin.H {
+	return gin.H{
+		"zoneId": z.id, "zoneName": z.name, "locationId": z.locationID, "locationName": z.locationName,
+		"fee": z.fee, "minimumOrder": z.minimumOrder, "etaMinutes": z.etaMinutes,
+	}
+}
+
+// quoteDelivery finds the zone that delivers to p, from the given location
+// or, with none given, from whichever location serves it. Where zones
+// overlap the cheapest, then the quickest, wins. It returns nil when p is out
+// of every zone, and reports whether the tenant has any zones at all: until
+// it does, delivery orders are not checked.
+func quoteDelivery(tenantID, locationID string, p geo.Point) (*deliveryZone, bool, error) {
+	rows, err := db.Query(
+		`SELECT z.id, z.location_id, l.name, z.name, z.kind, z.polygon, COALESCE(z.center_latitude, 0), COALESCE(z.center_longitude, 0),
+		        z.inner_radius_km, z.radius_km, z.fee, z.minimum_order, z.eta_minutes
+		 FROM delivery_zones z JOIN locations l ON l.id = z.location_id AND l.status = 'active'
+		 WHERE z.tenant_id = $1 AND z.is_active
+		 ORDER BY z.fee, z.eta_minutes, z.sort_order`, tenantID)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+	configured := false
+	for rows.Next() {
+		var z deliveryZone
+		var polygon string
+		if err := rows.Scan(&z.id, &z.locationID, &z.locationName, &z.name, &z.kind, &polygon, &z.center.Lat, &z.center.Lng,
+			&z.innerKm, &z.outerKm, &z.fee, &z.minimumOrder, &z.etaMinutes); err != nil {
+			return nil, false, err
+		}
+		configured = true
+		if locationID != "" && z.locationID != locationID {
+			continue
+		}
+		json.Unmarshal([]byte(polygon), &z.polygon)
+		if z.contains(p) {
+			return &z, true, nil
+		}
+	}
+	return nil, configured, rows.Err()
+}
+
+// deliveryPoint is where an order is to be delivered: a saved address of
+// the tenant's, or a point given directly.
+func deliveryPoint(tenantID, addressID string, given *geo.Point) (geo.Point, error) {
+	if addressID != "" {
+		var p geo.Point
+		err := db.QueryRow("SELECT COALESCE(latitude, 0), COALESCE(longitude, 0) FROM customer_addresses WHERE id = $1 AND tenant_id = $2",
+			addressID, tenantID).Scan(&p.Lat, &p.Lng)
+		if err != nil {
+			return p, fmt.Errorf("Address %s not found", addressID)
+		}
+		if !p.Valid() {
+			return p, fmt.Errorf("Address %s has no map location", addressID)
+		}
+		return p, nil
+	}
+	if given == nil || !given.Valid() {
+		return geo.Point{}, fmt.Errorf("Delivery orders need an addressId or a deliveryLocation")
+	}
+	return *given, nil
+}
+
+// deliveryFeeLine is the line that charges a zone's delivery fee, sold as
+// the tenant's delivery fee service product and taxed at the location's
+// default rate.
+func deliveryFeeLine(tenantID string, taxCtx taxContext, fee money.Amount) (orderLine, error) {
+	var productID string
+	err := db.QueryRow("SELECT id FROM products WHERE tenant_id = $1 AND sku = $2 AND product_type = 'service'", tenantID, deliveryFeeSKU).
+		Scan(&productID)
+	if err == sql.ErrNoRows {
+		productID = uuid.New().String()
+		_, err = db.Exec(
+			`INSERT INTO products (id, tenant_id, sku, name, name_en, name_ar, price, currency, product_type, is_active, track_inventory)
+			 VALUES ($1, $2, $3, 'Delivery fee', 'Delivery fee', 'رسوم التوصيل', 0, $4, 'service', false, false)`,
+			productID, tenantID, deliveryFeeSKU, currencyFor(tenantID, ""))
+	}
+	if err != nil {
+		return orderLine{}, err
+	}
+	return orderLine{
+		productID: productID, name: "Delivery fee", price: fee, quantity: 1, round: 1,
+		taxCategory: taxCtx.productTaxCategory(tax.Category{}, false, sql.NullFloat64{}), modifiers: "[]",
+	}, nil
+}
+
+// saveOrderDelivery records where and how an order is delivered.
+func saveOrderDelivery(tx *sql.Tx, orderID string, zone *deliveryZone, p geo.Point, addressID string) error {
+	var address *string
+	if addressID != "" {
+		address = &addressID
+	}
+	_, err := tx.Exec(
+		`UPDATE orders SET delivery_zone_id = $1, delivery_address_id = $2, delivery_latitude = $3, delivery_longitude = $4,
+		        delivery_fee = $5, delivery_eta_minutes = $6
+		 WHERE id = $7`, zone.id, address, p.Lat, p.Lng, zone.fee, zone.etaMinutes, orderID)
+	return err
+}
+
+// getDeliveryQuote tells a customer whether a place can be delivered to,
+// from where, and at what fee, minimum order and wait.
+func getDeliveryQuote(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	var req struct {
+		AddressID  string     `json:"addressId"`
+		Location   *geo.Point `json:"location"`
+		LocationID string     `json:"locationId"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	p, err := deliveryPoint(tenantID, req.AddressID, req.Location)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	zone, configured, err := quoteDelivery(tenantID, req.LocationID, p)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if zone == nil {
+		c.JSON(200, gin.H{"deliverable": !configured, "location": p})
+		return
+	}
+	q := zone.quote()
+	q["deliverable"], q["location"] = true, p
+	c.JSON(200, q)
+}
+
+// deliveryZoneRequest is shared by create and update. A polygon is a list of
+// at least three points; a radius zone is a ring from innerRadiusKm up to
+// radiusKm around its centre.
+type deliveryZoneRequest struct {
+	LocationID    *string       `json:"locationId"`
+	Name          *string       `json:"name"`
+	Kind          *string       `json:"kind"`
+	Polygon       *[]geo.Point  `json:"polygon"`
+	Center        *geo.Point    `json:"center"`
+	InnerRadiusKm *float64      `json:"innerRadiusKm"`
+	RadiusKm      *float64      `json:"radiusKm"`
+	Fee           *money.Amount `json:"fee"`
+	MinimumOrder  *money.Amount `json:"minimumOrder"`
+	EtaMinutes    *int          `json:"etaMinutes"`
+	SortOrder     *int          `json:"sortOrder"`
+	IsActive      *bool         `json:"isActive"`
+}
+
+func listDeliveryZones(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	query := `SELECT z.id, z.location_id, l.name, z.name, z.kind, z.polygon, z.center_latitude, z.center_longitude,
+	                 z.inner_radius_km, z.radius_km, z.fee, z.minimum_order, z.eta_minutes, z.sort_order, z.is_active
+	          FROM delivery_zones z JOIN locations l ON l.id = z.location_id WHERE z.tenant_id = $1`
+	args := []interface{}{tenantID}
+	if locationID := c.Query("locationId"); locationID != "" {
+		args = append(args, locationID)
+		query += fmt.Sprintf(" AND z.location_id = $%d", len(args))
+	}
+	rows, err := db.Query(query+" ORDER BY l.name, z.sort_order, z.fee", args...)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	zones := []gin.H{}
+	for rows.Next() {
+		var id, locationID, locationName, name, kind, polygon string
+		var centerLat, centerLng sql.NullFloat64
+		var inner, outer float64
+		var fee, minOrder money.Amount
+		var eta, sortOrder int
+		var active bool
+		rows.Scan(&id, &locationID, &locationName, &name, &kind, &polygon, &centerLat, &centerLng, &inner, &outer,
+			&fee, &minOrder, &eta, &sortOrder, &active)
+		z := gin.H{
+			"id": id, "locationId": locationID, "locationName": locationName, "name": name, "kind": kind,
+			"fee": fee, "minimumOrder": minOrder, "etaMinutes": eta, "sortOrder": sortOrder, "isActive": active,
+		}
+		if kind == zoneKindRadius {
+			z["center"] = geo.Point{Lat: centerLat.Float64, Lng: centerLng.Float64}
+			z["innerRadiusKm"], z["radiusKm"] = inner, outer
+		} else {
+			z["polygon"] = json.RawMessage(polygon)
+		}
+		zones = append(zones, z)
+	}
+	c.JSON(200, gin.H{"zones": zones, "total": len(zones)})
+}
+
+func createDeliveryZone(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	var req deliveryZoneRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if req.LocationID == nil || req.Name == nil || *req.Name == "" || req.Kind == nil {
+		c.JSON(400, gin.H{"error": "locationId, name and kind are required"})
+		return
+	}
+	var known bool
+	db.QueryRow("SELECT EXISTS(SELECT 1 FROM locations WHERE id::text = $1 AND tenant_id = $2)", *req.LocationID, tenantID).Scan(&known)
+	if !known {
+		c.JSON(404, gin.H{"error": "Location not found"})
+		return
+	}
+	if err := validateZoneShape(*req.Kind, req.Polygon, req.Center, req.InnerRadiusKm, req.RadiusKm); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	polygon, center, inner, outer := zoneShape(req)
+	id := uuid.New().String()
+	_, err := db.Exec(
+		`INSERT INTO delivery_zones (id, tenant_id, location_id, name, kind, polygon, center_latitude, center_longitude, inner_radius_km, radius_km,
+		        fee, minimum_order, eta_minutes, sort_order)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, COALESCE($11, 0), COALESCE($12, 0), COALESCE($13, 30), COALESCE($14, 0))`,
+		id, tenantID, *req.LocationID, *req.Name, *req.Kind, polygon, center.lat, center.lng, inner, outer,
+		req.Fee, req.MinimumOrder, req.EtaMinutes, req.SortOrder)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(201, gin.H{"id": id, "name": *req.Name, "kind": *req.Kind, "locationId": *req.LocationID})
+}
+
+func updateDeliveryZone(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	id := c.Param("id")
+	var req deliveryZoneRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	var kind, polygonJSON string
+	var centerLat, centerLng sql.NullFloat64
+	var inner, outer float64
+	err := db.QueryRow(
+		`SELECT kind, polygon, center_latitude, center_longitude, inner_radius_km, radius_km
+		 FROM delivery_zones WHERE id = $1 AND tenant_id = $2`, id, tenantID).
+		Scan(&kind, &polygonJSON, &centerLat, &centerLng, &inner, &outer)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Delivery zone not found"})
+		return
+	}
+	if req.LocationID != nil {
+		var known bool
+		db.QueryRow("SELECT EXISTS(SELECT 1 FROM locations WHERE id::text = $1 AND tenant_id = $2)", *req.LocationID, tenantID).Scan(&known)
+		if !known {
+			c.JSON(404, gin.H{"error": "Location not found"})
+			return
+		}
+	}
+	// The shape is checked as it will be after the update.
+	if req.Kind == nil {
+		req.Kind = &kind
+	}
+	if req.Polygon == nil {
+		var current []geo.Point
+		json.Unmarshal([]byte(polygonJSON), &current)
+		req.Polygon = &current
+	}
+	if req.Center == nil && centerLat.Valid {
+		req.Center = &geo.Point{Lat: centerLat.Float64, Lng: centerLng.Float64}
+	}
+	if req.InnerRadiusKm == nil {
+		req.InnerRadiusKm = &inner
+	}
+	if req.RadiusKm == nil {
+		req.RadiusKm = &outer
+	}
+	if err := validateZoneShape(*req.Kind, req.Polygon, req.Center, req.InnerRadiusKm, req.RadiusKm); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	polygon, center, newInner, newOuter := zoneShape(req)
+	_, err = db.Exec(
+		`UPDATE delivery_zones SET
+			location_id = COALESCE($1::uuid, location_id),
+			name = COALESCE(NULLIF($2, ''), name),
+			kind = $3, polygon = $4, center_latitude = $5, center_longitude = $6, inner_radius_km = $7, radius_km = $8,
+			fee = COALESCE($9, fee),
+			minimum_order = COALESCE($10, minimum_order),
+			eta_minutes = COALESCE($11, eta_minutes),
+			sort_order = COALESCE($12, sort_order),
+			is_active = COALESCE($13, is_active),
+			updated_at = NOW()
+		 WHERE id = $14 AND tenant_id = $15`,
+		req.LocationID, req.Name, *req.Kind, polygon, center.lat, center.lng, newInner, newOuter,
+		req.Fee, req.MinimumOrder, req.EtaMinutes, req.SortOrder, req.IsActive, id, tenantID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Delivery zone updated", "id": id})
+}
+
+// validateZoneShape checks a zone has the geometry its kind needs.
+func validateZoneShape(kind string, polygon *[]geo.Point, center *geo.Point, inner, outer *float64) error {
+	switch kind {
+	case zoneKindPolygon:
+		if polygon == nil || len(*polygon) < 3 {
+			return fmt.Errorf("A polygon zone needs at least 3 points")
+		}
+		for _, p := range *polygon {
+			if !p.Valid() {
+				return fmt.Errorf("Polygon point %v is not a valid location", p)
+			}
+		}
+	case zoneKindRadius:
+		if center == nil || !center.Valid() {
+			return fmt.Errorf("A radius zone needs a valid center")
+		}
+		if outer == nil || *outer <= 0 {
+			return fmt.Errorf("radiusKm must be more than 0")
+		}
+		if inner != nil && (*inner < 0 || *inner >= *outer) {
+			return fmt.Errorf("innerRadiusKm must be at least 0 and less than radiusKm")
+		}
+	default:
+		return fmt.Errorf("kind must be polygon or radius")
+	}
+	return nil
+}
+
+// zoneShape picks out the columns a zone's kind uses; the others are
+// cleared.
+func zoneShape(req deliveryZoneRequest) (string, struct{ lat, lng *float64 }, float64, float64) {
+	var center struct{ lat, lng *float64 }
+	if *req.Kind == zoneKindPolygon {
+		polygon, _ := json.Marshal(*req.Polygon)
+		return string(polygon), center, 0, 0
+	}
+	center.lat, center.lng = &req.Center.Lat, &req.Center.Lng
+	var inner float64
+	if req.InnerRadiusKm != nil {
+		inner = *req.InnerRadiusKm
+	}
+	return "[]", center, inner, *req.RadiusKm
+}
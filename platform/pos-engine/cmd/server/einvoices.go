@@ -0,0 +1,307 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"os"
+	"time"
+
+	"github.com/berhot/products/commerce/pos-engine/internal/money"
+	"github.com/berhot/products/commerce/pos-engine/internal/tax"
+	"github.com/berhot/products/commerce/pos-engine/internal/zatca"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ── E-Invoicing (ZATCA) ─────────────────────────────────────
+
+// invoiceSigner signs every tenant's invoices. It is loaded from
+// ZATCA_SIGNING_KEY_FILE, the key followed by the certificate ZATCA issued
+// for it. The service will not start without one unless ZATCA_DEV_SIGNER=1
+// asks for a throwaway key to exercise the flow locally.
+var invoiceSigner *zatca.Signer
+
+func migrateEInvoices() {
+	db.Exec(`CREATE TABLE IF NOT EXISTS zatca_settings (
+		tenant_id UUID PRIMARY KEY,
+		seller_name VARCHAR(255) NOT NULL,
+		vat_number VARCHAR(15) NOT NULL,
+		cr_number VARCHAR(50) NOT NULL DEFAULT '',
+		street VARCHAR(255) NOT NULL DEFAULT '',
+		building_number VARCHAR(10) NOT NULL DEFAULT '',
+		district VARCHAR(255) NOT NULL DEFAULT '',
+		city VARCHAR(100) NOT NULL DEFAULT '',
+		postal_code VARCHAR(10) NOT NULL DEFAULT '',
+		country_code VARCHAR(2) NOT NULL DEFAULT 'SA',
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`)
+	db.Exec(`CREATE TABLE IF NOT EXISTS e_invoice_chain (
+		tenant_id UUID PRIMARY KEY,
+		counter BIGINT NOT NULL DEFAULT 0,
+		last_hash TEXT NOT NULL DEFAULT ''
+	)`)
+	db.Exec(`CREATE TABLE IF NOT EXISTS e_invoices (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		tenant_id UUID NOT NULL,
+		order_id UUID NOT NULL REFERENCES orders(id),
+		invoice_number VARCHAR(50) NOT NULL,
+		invoice_uuid UUID NOT NULL,
+		counter BIGINT NOT NULL,
+		invoice_hash TEXT NOT NULL,
+		previous_hash TEXT NOT NULL,
+		signature TEXT NOT NULL,
+		qr_code TEXT NOT NULL,
+		xml TEXT NOT NULL,
+		currency VARCHAR(3) NOT NULL,
+		total DECIMAL(12,2) NOT NULL,
+		tax_amount DECIMAL(12,2) NOT NULL,
+		issued_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		UNIQUE(tenant_id, order_id),
+		UNIQUE(tenant_id, counter)
+	)`)
+
+	if path := os.Getenv("ZATCA_SIGNING_KEY_FILE"); path != "" {
+		pemKey, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatalf("Failed to read ZATCA signing key: %v", err)
+		}
+		if invoiceSigner, err = zatca.NewSigner(pemKey); err != nil {
+			log.Fatalf("Failed to load ZATCA signing key: %v", err)
+		}
+		return
+	}
+	// Invoices signed with a throwaway key cannot be verified, yet they are
+	// chained for good, so one is only made when asked for in development.
+	if os.Getenv("ZATCA_DEV_SIGNER") != "1" {
+		log.Fatal("ZATCA_SIGNING_KEY_FILE is not set; set ZATCA_DEV_SIGNER=1 to sign with a throwaway key in development")
+	}
+	var err error
+	if invoiceSigner, err = zatca.GenerateSigner(); err != nil {
+		log.Fatalf("Failed to generate ZATCA signing key: %v", err)
+	}
+	log.Println("POS Engine: ZATCA_DEV_SIGNER set, signing e-invoices with a throwaway key")
+}
+
+// loadSeller returns the tenant's e-invoicing details, or false when the
+// tenant has not set up e-invoicing.
+func loadSeller(q queryer, tenantID string) (zatca.Seller, bool, error) {
+	var s zatca.Seller
+	err := q.QueryRow(
+		`SELECT seller_name, vat_number, cr_number, street, building_number, district, city, postal_code, country_code
+		 FROM zatca_settings WHERE tenant_id = $1`, tenantID,
+	).Scan(&s.Name, &s.VATNumber, &s.CRNumber, &s.Street, &s.BuildingNumber, &s.District, &s.City, &s.PostalCode, &s.CountryCode)
+	if err == sql.ErrNoRows {
+		return s, false, nil
+	}
+	if err != nil {
+		return s, false, err
+	}
+	return s, s.VATNumber != "", nil
+}
+
+// issueInvoice creates the e-invoice for a completed order inside tx. The
+// tenant's chain row is locked so counters and previous-invoice hashes stay
+// gap-free under concurrent completions. Tenants without e-invoicing set up
+// and orders that already have an invoice are left alone.
+func issueInvoice(tx *sql.Tx, tenantID, orderID string) error {
+	seller, ok, err := loadSeller(tx, tenantID)
+	if err != nil || !ok {
+		return err
+	}
+	var exists bool
+	if err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM e_invoices WHERE tenant_id = $1 AND order_id = $2)", tenantID, orderID).Scan(&exists); err != nil || exists {
+		return err
+	}
+
+	if _, err := tx.Exec("INSERT INTO e_invoice_chain (tenant_id) VALUES ($1) ON CONFLICT DO NOTHING", tenantID); err != nil {
+		return err
+	}
+	var counter int64
+	var lastHash string
+	if err := tx.QueryRow("SELECT counter, last_hash FROM e_invoice_chain WHERE tenant_id = $1 FOR UPDATE", tenantID).Scan(&counter, &lastHash); err != nil {
+		return err
+	}
+
+	inv := zatca.Invoice{
+		UUID:         uuid.New().String(),
+		Counter:      counter + 1,
+		IssuedAt:     time.Now(),
+		PreviousHash: lastHash,
+		Seller:       seller,
+	}
+	if err := tx.QueryRow("SELECT order_number, currency, total, COALESCE(tax_amount, 0) FROM orders WHERE id = $1 AND tenant_id = $2", orderID, tenantID).
+		Scan(&inv.Number, &inv.Currency, &inv.Total, &inv.Tax); err != nil {
+		return err
+	}
+
+	rows, err := tx.Query(
+		`SELECT name, quantity, COALESCE(net_amount, total_price - COALESCE(tax_amount, 0)), COALESCE(tax_amount, 0), tax_kind, tax_rate
+		 FROM order_items WHERE order_id = $1 AND tenant_id = $2 AND line_status <> 'voided' ORDER BY created_at, id`, orderID, tenantID)
+	if err != nil {
+		return err
+	}
+	type bucketKey struct {
+		kind string
+		rate float64
+	}
+	buckets := map[bucketKey]*tax.Bucket{}
+	var order []bucketKey
+	for rows.Next() {
+		var l zatca.Line
+		if err := rows.Scan(&l.Name, &l.Quantity, &l.Net, &l.Tax, &l.Kind, &l.Rate); err != nil {
+			rows.Close()
+			return err
+		}
+		inv.Lines = append(inv.Lines, l)
+		inv.Net += l.Net
+		k := bucketKey{l.Kind, l.Rate}
+		b, ok := buckets[k]
+		if !ok {
+			b = &tax.Bucket{Kind: l.Kind, Rate: l.Rate}
+			buckets[k] = b
+			order = append(order, k)
+		}
+		b.Taxable += l.Net
+		b.Tax += l.Tax
+	}
+	rows.Close()
+	for _, k := range order {
+		inv.Summary = append(inv.Summary, *buckets[k])
+	}
+
+	signed, err := invoiceSigner.Issue(inv)
+	if err != nil {
+		return err
+	}
+	previous := inv.PreviousHash
+	if previous == "" {
+		previous = zatca.FirstPreviousHash
+	}
+	if _, err := tx.Exec(
+		`INSERT INTO e_invoices (id, tenant_id, order_id, invoice_number, invoice_uuid, counter, invoice_hash, previous_hash, signature, qr_code, xml, currency, total, tax_amount, issued_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)`,
+		uuid.New().String(), tenantID, orderID, inv.Number, inv.UUID, inv.Counter, signed.Hash, previous, signed.Signature, signed.QR,
+		string(signed.XML), inv.Currency, inv.Total, inv.Tax, inv.IssuedAt); err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE e_invoice_chain SET counter = $1, last_hash = $2 WHERE tenant_id = $3", inv.Counter, signed.Hash, tenantID)
+	return err
+}
+
+func getOrderInvoice(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	orderID := c.Param("id")
+
+	var status string
+	if err := db.QueryRow("SELECT status FROM orders WHERE id = $1 AND tenant_id = $2", orderID, tenantID).Scan(&status); err != nil {
+		c.JSON(404, gin.H{"error": "Order not found"})
+		return
+	}
+
+	var invoiceID, number, invoiceUUID, hash, previous, signature, qr, xmlDoc, currency string
+	var counter int64
+	var total, taxAmount money.Amount
+	var issuedAt time.Time
+	load := func() error {
+		return db.QueryRow(
+			`SELECT id, invoice_number, invoice_uuid, counter, invoice_hash, previous_hash, signature, qr_code, xml, currency, total, tax_amount, issued_at
+			 FROM e_invoices WHERE tenant_id = $1 AND order_id = $2`, tenantID, orderID,
+		).Scan(&invoiceID, &number, &invoiceUUID, &counter, &hash, &previous, &signature, &qr, &xmlDoc, &currency, &total, &taxAmount, &issuedAt)
+	}
+	err := load()
+	if err == sql.ErrNoRows {
+		if status != orderCompleted && status != orderRefunded {
+			c.JSON(409, gin.H{"error": "Invoices are issued once an order is completed", "status": status})
+			return
+		}
+		// Orders completed before e-invoicing was set up get theirs now.
+		if _, ok, _ := loadSeller(db, tenantID); !ok {
+			c.JSON(404, gin.H{"error": "E-invoicing is not set up for this tenant"})
+			return
+		}
+		tx, err := db.Begin()
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Transaction failed"})
+			return
+		}
+		if err := issueInvoice(tx, tenantID, orderID); err != nil {
+			tx.Rollback()
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		err = load()
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	if c.Query("format") == "xml" {
+		c.Data(200, "application/xml; charset=utf-8", []byte(xmlDoc))
+		return
+	}
+	c.JSON(200, gin.H{
+		"id": invoiceID, "orderId": orderID, "invoiceNumber": number, "uuid": invoiceUUID,
+		"counter": counter, "invoiceHash": hash, "previousHash": previous, "signature": signature,
+		"qrCode": qr, "xml": xmlDoc, "currency": currency, "total": total, "taxAmount": taxAmount,
+		"issuedAt": issuedAt,
+	})
+}
+
+func getZatcaSettings(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	s, ok, err := loadSeller(db, tenantID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if !ok {
+		c.JSON(404, gin.H{"error": "E-invoicing is not set up for this tenant"})
+		return
+	}
+	c.JSON(200, gin.H{
+		"sellerName": s.Name, "vatNumber": s.VATNumber, "crNumber": s.CRNumber,
+		"street": s.Street, "buildingNumber": s.BuildingNumber, "district": s.District,
+		"city": s.City, "postalCode": s.PostalCode, "countryCode": s.CountryCode,
+	})
+}
+
+func updateZatcaSettings(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	var req struct {
+		SellerName     string `json:"sellerName" binding:"required"`
+		VATNumber      string `json:"vatNumber" binding:"required,len=15,numeric"`
+		CRNumber       string `json:"crNumber"`
+		Street         string `json:"street"`
+		BuildingNumber string `json:"buildingNumber"`
+		District       string `json:"district"`
+		City           string `json:"city"`
+		PostalCode     string `json:"postalCode"`
+		CountryCode    string `json:"countryCode"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if req.CountryCode == "" {
+		req.CountryCode = "SA"
+	}
+	_, err := db.Exec(
+		`INSERT INTO zatca_settings (tenant_id, seller_name, vat_number, cr_number, street, building_number, district, city, postal_code, country_code)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		 ON CONFLICT (tenant_id) DO UPDATE SET
+			seller_name = EXCLUDED.seller_name, vat_number = EXCLUDED.vat_number, cr_number = EXCLUDED.cr_number,
+			street = EXCLUDED.street, building_number = EXCLUDED.building_number, district = EXCLUDED.district,
+			city = EXCLUDED.city, postal_code = EXCLUDED.postal_code, country_code = EXCLUDED.country_code,
+			updated_at = NOW()`,
+		tenantID, req.SellerName, req.VATNumber, req.CRNumber, req.Street, req.BuildingNumber, req.District, req.City, req.PostalCode, req.CountryCode)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "E-invoicing settings saved"})
+}
@@ -0,0 +1,950 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"math/big"
+	"strconv"
+	"time"
+
+	"github.com/berhot/products/commerce/pos-engine/internal/money"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ── Gift Cards & Store Credit ───────────────────────────────
+
+// Gift cards and store credit are both stored value: an account whose
+// balance only moves through its ledger, one entry per issue, redemption,
+// refund or expiry. A gift card is a bearer card, found by its code and
+// guarded by a PIN. Store credit belongs to a customer, one account per
+// currency, and is spent on that customer's orders.
+const (
+	storedGiftCard    = "gift_card"
+	storedStoreCredit = "store_credit"
+)
+
+// Card statuses. Cards sold on an order wait as pending until the order
+// completes, so an unpaid or cancelled sale never carries a balance.
+const (
+	cardPending = "pending"
+	cardActive  = "active"
+	cardVoid    = "void"
+)
+
+// Ledger entry types. Refund puts a redemption back on the card it was
+// paid from; credit is store credit given in place of a refund.
+const (
+	ledgerIssue  = "issue"
+	ledgerRedeem = "redeem"
+	ledgerRefund = "refund"
+	ledgerCredit = "credit"
+	ledgerExpire = "expire"
+	ledgerVoid   = "void"
+)
+
+const (
+	giftCardExpiryBatch    = 100
+	giftCardExpiryInterval = 10 * time.Minute
+)
+
+// giftCardValidityDays is how long a sold gift card can be spent for; 0
+// means sold cards never expire.
+var giftCardValidityDays = func() int {
+	v, err := strconv.Atoi(getEnv("GIFT_CARD_VALIDITY_DAYS", "365"))
+	if err != nil || v < 0 {
+		return 365
+	}
+	return v
+}()
+
+func migrateGiftCards() {
+	// Service products, like the delivery fee line, and gift cards are sold
+	// but never made or counted.
+	db.Exec(`ALTER TABLE products DROP CONSTRAINT IF EXISTS products_product_type_check`)
+	db.Exec(`ALTER TABLE products ADD CONSTRAINT products_product_type_check
+		CHECK (product_type IN ('simple', 'variant', 'combo', 'modifier_group', 'service', 'gift_card'))`)
+	// Gift cards and store credit are tenders too.
+	db.Exec(`ALTER TABLE payments DROP CONSTRAINT IF EXISTS payments_method_check`)
+	db.Exec(`ALTER TABLE payments ADD CONSTRAINT payments_method_check
+		CHECK (method IN ('cash', 'card', 'mobile_pay', 'loyalty_points', 'split', 'online', 'gift_card', 'store_credit'))`)
+
+	db.Exec(`CREATE TABLE IF NOT EXISTS gift_cards (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		tenant_id UUID NOT NULL,
+		kind VARCHAR(20) NOT NULL CHECK (kind IN ('gift_card', 'store_credit')),
+		code VARCHAR(32) NOT NULL,
+		pin_hash TEXT NOT NULL DEFAULT '',
+		customer_id UUID REFERENCES customers(id),
+		currency VARCHAR(3) NOT NULL,
+		initial_amount DECIMAL(12,2) NOT NULL DEFAULT 0,
+		balance DECIMAL(12,2) NOT NULL DEFAULT 0 CHECK (balance >= 0),
+		status VARCHAR(10) NOT NULL DEFAULT 'active' CHECK (status IN ('pending', 'active', 'void')),
+		expires_at TIMESTAMPTZ,
+		order_id UUID REFERENCES orders(id),
+		reason TEXT NOT NULL DEFAULT '',
+		issued_by UUID,
+		approved_by UUID,
+		activated_at TIMESTAMPTZ,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		UNIQUE(tenant_id, code)
+	)`)
+	db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_store_credit_customer ON gift_cards(tenant_id, customer_id, currency) WHERE kind = 'store_credit'")
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_gift_cards_order ON gift_cards(order_id) WHERE order_id IS NOT NULL")
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_gift_cards_expiry ON gift_cards(expires_at) WHERE status = 'active' AND balance > 0")
+	db.Exec(`CREATE TABLE IF NOT EXISTS gift_card_transactions (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		tenant_id UUID NOT NULL,
+		gift_card_id UUID NOT NULL REFERENCES gift_cards(id),
+		entry_type VARCHAR(10) NOT NULL CHECK (entry_type IN ('issue', 'redeem', 'refund', 'credit', 'expire', 'void')),
+		amount DECIMAL(12,2) NOT NULL,
+		balance_after DECIMAL(12,2) NOT NULL,
+		order_id UUID,
+		payment_id UUID,
+		refund_id UUID,
+		reason TEXT NOT NULL DEFAULT '',
+		created_by UUID,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`)
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_gift_card_transactions_card ON gift_card_transactions(gift_card_id, created_at)")
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_gift_card_transactions_tenant ON gift_card_transactions(tenant_id, created_at)")
+	db.Exec(`ALTER TABLE payments ADD COLUMN IF NOT EXISTS gift_card_id UUID REFERENCES gift_cards(id)`)
+}
+
+// randomDigits returns n random decimal digits.
+func randomDigits(n int) (string, error) {
+	b := make([]byte, n)
+	for i := range b {
+		d, err := rand.Int(rand.Reader, big.NewInt(10))
+		if err != nil {
+			return "", err
+		}
+		b[i] = byte('0' + d.Int64())
+	}
+	return string(b), nil
+}
+
+// storedValue is a card or store credit account to open.
+type storedValue struct {
+	kind       string
+	customerID string
+	currency   string
+	amount     money.Amount
+	status     string
+	pin        string
+	expiresAt  *time.Time
+	orderID    string
+	reason     string
+	by         actor
+	approvedBy *string
+}
+
+// openStoredValue creates a card with a fresh 16 digit code and returns its
+// id and code. An active card is issued its amount through the ledger; a
+// pending one records the amount and waits for activateOrderGiftCards.
+func openStoredValue(tx *sql.Tx, tenantID string, v storedValue) (string, string, error) {
+	var pinHash string
+	if v.pin != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(v.pin), bcrypt.DefaultCost)
+		if err != nil {
+			return "", "", err
+		}
+		pinHash = string(hash)
+	}
+	id := uuid.New().String()
+	for {
+		code, err := randomDigits(16)
+		if err != nil {
+			return "", "", err
+		}
+		res, err := tx.Exec(
+			`INSERT INTO gift_cards (id, tenant_id, kind, code, pin_hash, customer_id, currency, initial_amount, status, expires_at,
+			                         order_id, reason, issued_by, approved_by)
+			 VALUES ($1, $2, $3, $4, $5, NULLIF($6, '')::uuid, $7, $8, $9, $10, NULLIF($11, '')::uuid, $12, $13, $14)
+			 ON CONFLICT (tenant_id, code) DO NOTHING`,
+			id, tenantID, v.kind, code, pinHash, v.customerID, v.currency, v.amount, v.status, v.expiresAt,
+			v.orderID, v.reason, v.by.idOrNil(), v.approvedBy)
+		if err != nil {
+			return "", "", err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			continue
+		}
+		if v.status == cardActive && v.amount.IsPositive() {
+			if _, err := postLedger(tx, tenantID, id, ledgerIssue, v.amount, ledgerRef{orderID: v.orderID, reason: v.reason, by: v.by}); err != nil {
+				return "", "", err
+			}
+			if _, err := tx.Exec("UPDATE gift_cards SET activated_at = NOW() WHERE id = $1", id); err != nil {
+				return "", "", err
+			}
+		}
+		return id, code, nil
+	}
+}
+
+// ledgerRef is what a ledger entry was for.
+type ledgerRef struct {
+	orderID, paymentID, refundID string
+	reason                       string
+	by                           actor
+}
+
+// postLedger moves a card's balance by amount and records the entry,
+// returning the new balance.
+func postLedger(tx *sql.Tx, tenantID, cardID, entryType string, amount money.Amount, ref ledgerRef) (money.Amount, error) {
+	var balance money.Amount
+	if err := tx.QueryRow(
+		"UPDATE gift_cards SET balance = balance + $1, updated_at = NOW() WHERE id = $2 AND tenant_id = $3 RETURNING balance",
+		amount, cardID, tenantID).Scan(&balance); err != nil {
+		return 0, err
+	}
+	_, err := tx.Exec(
+		`INSERT INTO gift_card_transactions (id, tenant_id, gift_card_id, entry_type, amount, balance_after, order_id, payment_id, refund_id, reason, created_by)
+		 VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, '')::uuid, NULLIF($8, '')::uuid, NULLIF($9, '')::uuid, $10, $11)`,
+		uuid.New().String(), tenantID, cardID, entryType, amount, balance, ref.orderID, ref.paymentID, ref.refundID, ref.reason, ref.by.idOrNil())
+	return balance, err
+}
+
+// storeCreditAccount returns the customer's store credit account in
+// currency, opening it on first use.
+func storeCreditAccount(tx *sql.Tx, tenantID, customerID, currency string, by actor) (string, error) {
+	var id string
+	err := tx.QueryRow(
+		`SELECT id FROM gift_cards WHERE tenant_id = $1 AND customer_id = $2 AND currency = $3 AND kind = 'store_credit' FOR UPDATE`,
+		tenantID, customerID, currency).Scan(&id)
+	if err != sql.ErrNoRows {
+		return id, err
+	}
+	id, _, err = openStoredValue(tx, tenantID, storedValue{
+		kind: storedStoreCredit, customerID: customerID, currency: currency, status: cardActive, by: by,
+	})
+	return id, err
+}
+
+const (
+	errGiftCardInvalid     = paymentError("Gift card code or PIN is incorrect")
+	errGiftCardInactive    = paymentError("Gift card has not been activated")
+	errGiftCardVoid        = paymentError("Gift card is void")
+	errGiftCardExpired     = paymentError("Gift card has expired")
+	errStoreCreditCustomer = paymentError("Store credit needs an order with a customer")
+	errGiftCardLocked      = paymentError("Too many incorrect gift card PINs; try again later")
+)
+
+// verifyGiftCardPin checks the PIN entered on device for card id, left empty
+// when no card has the code given. Wrong codes count against the device and
+// wrong PINs against the card as well, so neither can be guessed at for
+// long: either locks out after pinMaxFailures.
+func verifyGiftCardPin(tenantID, device, id, pinHash, pin string) error {
+	subjects := []string{"gift_card_device:" + device}
+	if id != "" {
+		subjects = append(subjects, "gift_card:"+id)
+	}
+	if pinLocked(tenantID, subjects...) {
+		return errGiftCardLocked
+	}
+	if id == "" || pinHash != "" && bcrypt.CompareHashAndPassword([]byte(pinHash), []byte(pin)) != nil {
+		recordPinFailure(tenantID, subjects...)
+		return errGiftCardInvalid
+	}
+	clearPinFailures(tenantID, subjects...)
+	return nil
+}
+
+// redeemStoredValue takes amount off a gift card, found by code and the PIN
+// entered on device, or off the customer's store credit, inside tx and
+// returns the card's id.
+// Shortfalls are paymentErrors naming the balance, so the till can take the
+// rest by another tender.
+func redeemStoredValue(tx *sql.Tx, tenantID, method, code, pin, device string, customerID sql.NullString, currency string, amount money.Amount, ref ledgerRef) (string, error) {
+	var id, status, cardCurrency, pinHash string
+	var balance money.Amount
+	var expiresAt sql.NullTime
+	var err error
+	if method == storedStoreCredit {
+		if !customerID.Valid {
+			return "", errStoreCreditCustomer
+		}
+		err = tx.QueryRow(
+			`SELECT id, status, currency, pin_hash, balance, expires_at FROM gift_cards
+			 WHERE tenant_id = $1 AND customer_id = $2 AND currency = $3 AND kind = 'store_credit' FOR UPDATE`,
+			tenantID, customerID.String, currency).Scan(&id, &status, &cardCurrency, &pinHash, &balance, &expiresAt)
+		if err == sql.ErrNoRows {
+			return "", paymentError("Customer has no store credit")
+		}
+	} else {
+		err = tx.QueryRow(
+			`SELECT id, status, currency, pin_hash, balance, expires_at FROM gift_cards
+			 WHERE tenant_id = $1 AND code = $2 AND kind = 'gift_card' FOR UPDATE`,
+			tenantID, code).Scan(&id, &status, &cardCurrency, &pinHash, &balance, &expiresAt)
+		if err == sql.ErrNoRows {
+			return "", verifyGiftCardPin(tenantID, device, "", "", pin)
+		}
+		if err == nil {
+			if err := verifyGiftCardPin(tenantID, device, id, pinHash, pin); err != nil {
+				return "", err
+			}
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case status == cardPending:
+		return "", errGiftCardInactive
+	case status == cardVoid:
+		return "", errGiftCardVoid
+	case expiresAt.Valid && !expiresAt.Time.After(time.Now()):
+		return "", errGiftCardExpired
+	case cardCurrency != currency:
+		return "", paymentError(fmt.Sprintf("Balance is in %s, the order is in %s", cardCurrency, currency))
+	case amount > balance:
+		return "", paymentError(fmt.Sprintf("Balance %s does not cover %s", balance, amount))
+	}
+	_, err = postLedger(tx, tenantID, id, ledgerRedeem, -amount, ref)
+	return id, err
+}
+
+// isGiftCardLine reports whether an order line sells gift cards. Their
+// cards are opened, PIN and all, when the line is added, so the line's
+// quantity is fixed from then on.
+func isGiftCardLine(q queryer, itemID string) bool {
+	var ok bool
+	q.QueryRow(
+		`SELECT EXISTS(SELECT 1 FROM order_items oi JOIN products p ON p.id = oi.product_id
+		 WHERE oi.id = $1 AND p.product_type = 'gift_card')`, itemID).Scan(&ok)
+	return ok
+}
+
+// openOrderGiftCards opens a pending card for each gift card sold on a
+// line. The codes and PINs are returned for the receipt; PINs are only
+// ever shown here.
+func openOrderGiftCards(tx *sql.Tx, tenantID, orderID, currency string, l orderLine, by actor) ([]gin.H, error) {
+	cards := []gin.H{}
+	if !l.giftCard {
+		return cards, nil
+	}
+	if l.quantity != math.Trunc(l.quantity) {
+		return nil, fmt.Errorf("%s is sold in whole cards", l.name)
+	}
+	var expiresAt *time.Time
+	if giftCardValidityDays > 0 {
+		t := time.Now().AddDate(0, 0, giftCardValidityDays)
+		expiresAt = &t
+	}
+	for i := 0; i < int(l.quantity); i++ {
+		pin, err := randomDigits(4)
+		if err != nil {
+			return nil, err
+		}
+		id, code, err := openStoredValue(tx, tenantID, storedValue{
+			kind: storedGiftCard, currency: currency, amount: l.unitPrice(), status: cardPending, pin: pin,
+			expiresAt: expiresAt, orderID: orderID, reason: "Sold on order", by: by,
+		})
+		if err != nil {
+			return nil, err
+		}
+		cards = append(cards, gin.H{"id": id, "code": code, "pin": pin, "amount": l.unitPrice(), "expiresAt": expiresAt, "status": cardPending})
+	}
+	return cards, nil
+}
+
+// activateOrderGiftCards issues the balance of the order's pending cards
+// once it completes. Lines voided since they were added take their cards
+// with them: as many cards of each value are activated as the order still
+// sells, and the rest are voided.
+func activateOrderGiftCards(tx *sql.Tx, tenantID, orderID string, by actor) error {
+	rows, err := tx.Query(
+		`SELECT oi.unit_price, SUM(oi.quantity)
+		 FROM order_items oi JOIN products p ON p.id = oi.product_id
+		 WHERE oi.order_id = $1 AND oi.tenant_id = $2 AND oi.line_status <> 'voided' AND p.product_type = 'gift_card'
+		 GROUP BY oi.unit_price`, orderID, tenantID)
+	if err != nil {
+		return err
+	}
+	sold := map[money.Amount]int{}
+	for rows.Next() {
+		var value money.Amount
+		var qty float64
+		rows.Scan(&value, &qty)
+		sold[value] = int(qty)
+	}
+	rows.Close()
+
+	rows, err = tx.Query(
+		`SELECT id, initial_amount FROM gift_cards
+		 WHERE order_id = $1 AND tenant_id = $2 AND status = 'pending' ORDER BY created_at FOR UPDATE`, orderID, tenantID)
+	if err != nil {
+		return err
+	}
+	type pending struct {
+		id    string
+		value money.Amount
+	}
+	var cards []pending
+	for rows.Next() {
+		var p pending
+		rows.Scan(&p.id, &p.value)
+		cards = append(cards, p)
+	}
+	rows.Close()
+
+	for _, card := range cards {
+		if sold[card.value] == 0 {
+			if _, err := tx.Exec("UPDATE gift_cards SET status = 'void', updated_at = NOW() WHERE id = $1", card.id); err != nil {
+				return err
+			}
+			continue
+		}
+		sold[card.value]--
+		if _, err := tx.Exec("UPDATE gift_cards SET status = 'active', activated_at = NOW(), updated_at = NOW() WHERE id = $1", card.id); err != nil {
+			return err
+		}
+		if _, err := postLedger(tx, tenantID, card.id, ledgerIssue, card.value, ledgerRef{orderID: orderID, reason: "Sold on order", by: by}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// voidPendingGiftCards voids the cards of an order cancelled before it was
+// paid for.
+func voidPendingGiftCards(tx *sql.Tx, tenantID, orderID string) error {
+	_, err := tx.Exec(
+		"UPDATE gift_cards SET status = 'void', updated_at = NOW() WHERE order_id = $1 AND tenant_id = $2 AND status = 'pending'",
+		orderID, tenantID)
+	return err
+}
+
+// voidRefundedGiftCards voids the cards of a refunded gift card line.
+// Only cards nobody has spent from can be refunded.
+func voidRefundedGiftCards(tx *sql.Tx, tenantID, orderID, orderItemID, refundID string, quantity float64, by actor) error {
+	var value money.Amount
+	err := tx.QueryRow(
+		`SELECT oi.unit_price FROM order_items oi JOIN products p ON p.id = oi.product_id
+		 WHERE oi.id = $1 AND p.product_type = 'gift_card'`, orderItemID).Scan(&value)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	n := int(quantity)
+	rows, err := tx.Query(
+		`SELECT id FROM gift_cards
+		 WHERE order_id = $1 AND tenant_id = $2 AND status = 'active' AND initial_amount = $3 AND balance = initial_amount
+		 ORDER BY created_at LIMIT $4 FOR UPDATE`, orderID, tenantID, value, n)
+	if err != nil {
+		return err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		rows.Scan(&id)
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if len(ids) < n {
+		return paymentError("Gift cards that have been spent from cannot be refunded")
+	}
+	for _, id := range ids {
+		if _, err := postLedger(tx, tenantID, id, ledgerVoid, -value, ledgerRef{orderID: orderID, refundID: refundID, reason: "Refunded", by: by}); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("UPDATE gift_cards SET status = 'void', updated_at = NOW() WHERE id = $1", id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startGiftCardExpiry writes off the balance of expired cards, so that
+// outstanding liability falls and the breakage is booked when it happens.
+func startGiftCardExpiry() {
+	go func() {
+		for {
+			n, err := expireGiftCards()
+			if err != nil {
+				log.Printf("Gift card expiry: %v", err)
+			}
+			if n < giftCardExpiryBatch {
+				time.Sleep(giftCardExpiryInterval)
+			}
+		}
+	}()
+}
+
+func expireGiftCards() (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	rows, err := tx.Query(
+		`SELECT id, tenant_id, balance FROM gift_cards
+		 WHERE status = 'active' AND balance > 0 AND expires_at <= NOW()
+		 ORDER BY expires_at LIMIT $1
+		 FOR UPDATE SKIP LOCKED`, giftCardExpiryBatch)
+	if err != nil {
+		return 0, err
+	}
+	type due struct {
+		id, tenantID string
+		balance      money.Amount
+	}
+	var batch []due
+	for rows.Next() {
+		var d due
+		rows.Scan(&d.id, &d.tenantID, &d.balance)
+		batch = append(batch, d)
+	}
+	rows.Close()
+	for _, d := range batch {
+		if _, err := postLedger(tx, d.tenantID, d.id, ledgerExpire, -d.balance, ledgerRef{reason: "Expired", by: actor{Source: "system"}}); err != nil {
+			return 0, err
+		}
+	}
+	return len(batch), tx.Commit()
+}
+
+// giftCardJSON is a card as listed; the PIN is never returned.
+func giftCardJSON(id, kind, code, customerID, currency, status string, initial, balance money.Amount, expiresAt, activatedAt sql.NullTime, orderID, reason string, createdAt time.Time) gin.H {
+	card := gin.H{
+		"id": id, "kind": kind, "code": code, "customerId": customerID, "currency": currency, "status": status,
+		"initialAmount": initial, "balance": balance, "orderId": orderID, "reason": reason, "createdAt": createdAt,
+		"expiresAt": nil, "activatedAt": nil,
+		"expired": expiresAt.Valid && !expiresAt.Time.After(time.Now()),
+	}
+	if expiresAt.Valid {
+		card["expiresAt"] = expiresAt.Time
+	}
+	if activatedAt.Valid {
+		card["activatedAt"] = activatedAt.Time
+	}
+	return card
+}
+
+const giftCardColumns = `id, kind, code, COALESCE(customer_id::text, ''), currency, status, initial_amount, balance,
+	expires_at, activated_at, COALESCE(order_id::text, ''), reason, created_at`
+
+func scanGiftCard(row interface{ Scan(...interface{}) error }) (gin.H, error) {
+	var id, kind, code, customerID, currency, status, orderID, reason string
+	var initial, balance money.Amount
+	var expiresAt, activatedAt sql.NullTime
+	var createdAt time.Time
+	if err := row.Scan(&id, &kind, &code, &customerID, &currency, &status, &initial, &balance, &expiresAt, &activatedAt, &orderID, &reason, &createdAt); err != nil {
+		return nil, err
+	}
+	return giftCardJSON(id, kind, code, customerID, currency, status, initial, balance, expiresAt, activatedAt, orderID, reason, createdAt), nil
+}
+
+func listGiftCards(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	kind := c.DefaultQuery("kind", storedGiftCard)
+	rows, err := db.Query(
+		`SELECT `+giftCardColumns+` FROM gift_cards
+		 WHERE tenant_id = $1 AND kind = $2 AND ($3 = '' OR status = $3) AND ($4 = '' OR customer_id::text = $4) AND ($5 = '' OR order_id::text = $5)
+		 ORDER BY created_at DESC LIMIT 200`,
+		tenantID, kind, c.Query("status"), c.Query("customerId"), c.Query("orderId"))
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	cards := []gin.H{}
+	for rows.Next() {
+		card, err := scanGiftCard(rows)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		cards = append(cards, card)
+	}
+	c.JSON(200, gin.H{"giftCards": cards, "total": len(cards)})
+}
+
+// getGiftCard returns a card and its ledger.
+func getGiftCard(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	id := c.Param("id")
+	card, err := scanGiftCard(db.QueryRow(`SELECT `+giftCardColumns+` FROM gift_cards WHERE id = $1 AND tenant_id = $2`, id, tenantID))
+	if err == sql.ErrNoRows {
+		c.JSON(404, gin.H{"error": "Gift card not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	card["transactions"] = giftCardLedger(tenantID, id)
+	c.JSON(200, card)
+}
+
+func giftCardLedger(tenantID, cardID string) []gin.H {
+	entries := []gin.H{}
+	rows, err := db.Query(
+		`SELECT id, entry_type, amount, balance_after, COALESCE(order_id::text, ''), COALESCE(payment_id::text, ''),
+		        COALESCE(refund_id::text, ''), reason, COALESCE(created_by::text, ''), created_at
+		 FROM gift_card_transactions WHERE gift_card_id = $1 AND tenant_id = $2 ORDER BY created_at`, cardID, tenantID)
+	if err != nil {
+		return entries
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, entryType, orderID, paymentID, refundID, reason, createdBy string
+		var amount, balance money.Amount
+		var createdAt time.Time
+		rows.Scan(&id, &entryType, &amount, &balance, &orderID, &paymentID, &refundID, &reason, &createdBy, &createdAt)
+		entries = append(entries, gin.H{
+			"id": id, "type": entryType, "amount": amount, "balanceAfter": balance, "orderId": orderID,
+			"paymentId": paymentID, "refundId": refundID, "reason": reason, "createdBy": createdBy, "createdAt": createdAt,
+		})
+	}
+	return entries
+}
+
+// issueGiftCard grants a gift card outside a sale, such as a goodwill
+// gesture, with a manager's approval. The card holds the currency of the
+// location it is issued at, and only orders in that currency can spend it.
+func issueGiftCard(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	var req struct {
+		Amount     money.Amount     `json:"amount" binding:"required"`
+		Currency   string           `json:"currency"`
+		CustomerID string           `json:"customerId"`
+		LocationID string           `json:"locationId"`
+		ExpiresAt  *time.Time       `json:"expiresAt"`
+		Reason     string           `json:"reason" binding:"required"`
+		Approval   *managerApproval `json:"approval"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if !req.Amount.IsPositive() {
+		c.JSON(400, gin.H{"error": "amount must be positive"})
+		return
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		c.JSON(400, gin.H{"error": "expiresAt must be in the future"})
+		return
+	}
+	currency := currencyFor(tenantID, req.LocationID)
+	if req.Currency != "" && req.Currency != currency {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("Gift cards here are issued in %s, not %s", currency, req.Currency)})
+		return
+	}
+	req.Currency = currency
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Transaction failed"})
+		return
+	}
+	defer tx.Rollback()
+	managerID, err := verifyManagerApproval(tx, tenantID, approvalDevice(c), req.Approval)
+	if err != nil {
+		c.JSON(403, gin.H{"error": err.Error()})
+		return
+	}
+	if req.CustomerID != "" {
+		var exists bool
+		tx.QueryRow("SELECT EXISTS(SELECT 1 FROM customers WHERE id::text = $1 AND tenant_id = $2)", req.CustomerID, tenantID).Scan(&exists)
+		if !exists {
+			c.JSON(400, gin.H{"error": "Customer not found"})
+			return
+		}
+	}
+	pin, err := randomDigits(4)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	id, code, err := openStoredValue(tx, tenantID, storedValue{
+		kind: storedGiftCard, customerID: req.CustomerID, currency: req.Currency, amount: req.Amount, status: cardActive,
+		pin: pin, expiresAt: req.ExpiresAt, reason: req.Reason, by: actorFromContext(c), approvedBy: &managerID,
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(201, gin.H{
+		"id": id, "code": code, "pin": pin, "balance": req.Amount, "currency": req.Currency,
+		"expiresAt": req.ExpiresAt, "status": cardActive, "approvedBy": managerID,
+	})
+}
+
+// checkGiftCard tells a customer their balance. The code and PIN go in the
+// body so they stay out of URLs and logs; wrong ones lock out the card and
+// the device asking as payments do.
+func checkGiftCard(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	var req struct {
+		Code string `json:"code" binding:"required"`
+		Pin  string `json:"pin"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	var pinHash string
+	row := db.QueryRow(`SELECT `+giftCardColumns+`, pin_hash FROM gift_cards WHERE tenant_id = $1 AND code = $2 AND kind = 'gift_card'`, tenantID, req.Code)
+	var id, kind, code, customerID, currency, status, orderID, reason string
+	var initial, balance money.Amount
+	var expiresAt, activatedAt sql.NullTime
+	var createdAt time.Time
+	err := row.Scan(&id, &kind, &code, &customerID, &currency, &status, &initial, &balance, &expiresAt, &activatedAt, &orderID, &reason, &createdAt, &pinHash)
+	if err == sql.ErrNoRows {
+		id = ""
+	} else if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err := verifyGiftCardPin(tenantID, approvalDevice(c), id, pinHash, req.Pin); err == errGiftCardLocked {
+		c.JSON(429, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(404, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, giftCardJSON(id, kind, code, customerID, currency, status, initial, balance, expiresAt, activatedAt, orderID, reason, createdAt))
+}
+
+// resetGiftCardPin gives a card a new PIN, for a customer who lost theirs,
+// with a manager's approval. It lifts any lockout on the card.
+func resetGiftCardPin(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	var req struct {
+		Approval *managerApproval `json:"approval"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := verifyManagerApproval(db, tenantID, approvalDevice(c), req.Approval); err != nil {
+		c.JSON(403, gin.H{"error": err.Error()})
+		return
+	}
+	pin, err := randomDigits(4)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(pin), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	res, err := db.Exec(
+		"UPDATE gift_cards SET pin_hash = $1, updated_at = NOW() WHERE id = $2 AND tenant_id = $3 AND kind = 'gift_card' AND status <> 'void'",
+		string(hash), c.Param("id"), tenantID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(404, gin.H{"error": "Gift card not found"})
+		return
+	}
+	clearPinFailures(tenantID, "gift_card:"+c.Param("id"))
+	c.JSON(200, gin.H{"id": c.Param("id"), "pin": pin})
+}
+
+// voidGiftCard writes off a card's balance, such as one reported stolen,
+// with a manager's approval.
+func voidGiftCard(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	id := c.Param("id")
+	var req struct {
+		Reason   string           `json:"reason" binding:"required"`
+		Approval *managerApproval `json:"approval"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Transaction failed"})
+		return
+	}
+	defer tx.Rollback()
+	if _, err := verifyManagerApproval(tx, tenantID, approvalDevice(c), req.Approval); err != nil {
+		c.JSON(403, gin.H{"error": err.Error()})
+		return
+	}
+	var status string
+	var balance money.Amount
+	err = tx.QueryRow("SELECT status, balance FROM gift_cards WHERE id = $1 AND tenant_id = $2 AND kind = 'gift_card' FOR UPDATE", id, tenantID).
+		Scan(&status, &balance)
+	if err == sql.ErrNoRows {
+		c.JSON(404, gin.H{"error": "Gift card not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if status == cardVoid {
+		c.JSON(409, gin.H{"error": "Gift card is already void"})
+		return
+	}
+	if balance.IsPositive() {
+		if _, err := postLedger(tx, tenantID, id, ledgerVoid, -balance, ledgerRef{reason: req.Reason, by: actorFromContext(c)}); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if _, err := tx.Exec("UPDATE gift_cards SET status = 'void', updated_at = NOW() WHERE id = $1", id); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"id": id, "status": cardVoid, "writtenOff": balance})
+}
+
+// getCustomerStoreCredit returns a customer's store credit accounts and
+// their ledgers.
+func getCustomerStoreCredit(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	rows, err := db.Query(
+		`SELECT `+giftCardColumns+` FROM gift_cards
+		 WHERE tenant_id = $1 AND customer_id::text = $2 AND kind = 'store_credit' ORDER BY currency`, tenantID, c.Param("id"))
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	var accounts []gin.H
+	for rows.Next() {
+		account, err := scanGiftCard(rows)
+		if err != nil {
+			rows.Close()
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		accounts = append(accounts, account)
+	}
+	rows.Close()
+	for _, a := range accounts {
+		a["transactions"] = giftCardLedger(tenantID, a["id"].(string))
+	}
+	if accounts == nil {
+		accounts = []gin.H{}
+	}
+	c.JSON(200, gin.H{"customerId": c.Param("id"), "accounts": accounts})
+}
+
+// getGiftCardLiability reports what the tenant owes on gift cards and store
+// credit: the outstanding balance as of a date, which finance carries as
+// deferred revenue, and how it moved over a period. Expired and voided
+// balances are breakage.
+func getGiftCardLiability(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	asOf := c.DefaultQuery("asOf", time.Now().Format("2006-01-02"))
+	day, err := time.Parse("2006-01-02", asOf)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "asOf must be a date, YYYY-MM-DD"})
+		return
+	}
+	from := c.DefaultQuery("from", day.Format("2006-01")+"-01")
+	to := c.DefaultQuery("to", asOf)
+
+	type key struct{ kind, currency string }
+	report := map[key]gin.H{}
+	var order []key
+	row := func(k key) gin.H {
+		if r, ok := report[k]; ok {
+			return r
+		}
+		r := gin.H{
+			"kind": k.kind, "currency": k.currency, "outstanding": money.Amount(0), "accounts": 0,
+			"issued": money.Amount(0), "redeemed": money.Amount(0), "refunded": money.Amount(0),
+			"credited": money.Amount(0), "expired": money.Amount(0), "voided": money.Amount(0),
+		}
+		report[k] = r
+		order = append(order, k)
+		return r
+	}
+
+	rows, err := db.Query(
+		`SELECT g.kind, g.currency, SUM(t.amount)
+		 FROM gift_card_transactions t JOIN gift_cards g ON g.id = t.gift_card_id
+		 WHERE t.tenant_id = $1 AND t.created_at < $2::date + 1
+		 GROUP BY g.kind, g.currency
+		 ORDER BY g.kind, g.currency`, tenantID, asOf)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	for rows.Next() {
+		var k key
+		var outstanding money.Amount
+		rows.Scan(&k.kind, &k.currency, &outstanding)
+		row(k)["outstanding"] = outstanding
+	}
+	rows.Close()
+
+	// Accounts still holding a balance as of the date.
+	rows, err = db.Query(
+		`SELECT kind, currency, COUNT(*) FROM (
+			SELECT g.kind, g.currency, g.id FROM gift_card_transactions t JOIN gift_cards g ON g.id = t.gift_card_id
+			WHERE t.tenant_id = $1 AND t.created_at < $2::date + 1
+			GROUP BY g.kind, g.currency, g.id HAVING SUM(t.amount) > 0
+		 ) held GROUP BY kind, currency`, tenantID, asOf)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	for rows.Next() {
+		var k key
+		var n int
+		rows.Scan(&k.kind, &k.currency, &n)
+		row(k)["accounts"] = n
+	}
+	rows.Close()
+
+	movements := map[string]string{
+		ledgerIssue: "issued", ledgerRedeem: "redeemed", ledgerRefund: "refunded",
+		ledgerCredit: "credited", ledgerExpire: "expired", ledgerVoid: "voided",
+	}
+	rows, err = db.Query(
+		`SELECT g.kind, g.currency, t.entry_type, SUM(t.amount)
+		 FROM gift_card_transactions t JOIN gift_cards g ON g.id = t.gift_card_id
+		 WHERE t.tenant_id = $1 AND t.created_at >= $2::date AND t.created_at < $3::date + 1
+		 GROUP BY g.kind, g.currency, t.entry_type`, tenantID, from, to)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var k key
+		var entryType string
+		var amount money.Amount
+		rows.Scan(&k.kind, &k.currency, &entryType, &amount)
+		if field, ok := movements[entryType]; ok {
+			// Outflows are reported as positive amounts.
+			if amount < 0 {
+				amount = -amount
+			}
+			row(k)[field] = amount
+		}
+	}
+
+	lines := []gin.H{}
+	for _, k := range order {
+		lines = append(lines, report[k])
+	}
+	c.JSON(200, gin.H{"asOf": asOf, "from": from, "to": to, "liabilities": lines})
+}
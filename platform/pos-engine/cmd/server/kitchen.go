@@ -0,0 +1,473 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ── Kitchen Display ─────────────────────────────────────────
+
+// Kitchen item statuses, kept on order_items. An item is new when it reaches
+// its station, preparing once a cook starts it, ready when it is up and
+// bumped when it has left the screen.
+const (
+	kitchenNew       = "new"
+	kitchenPreparing = "preparing"
+	kitchenReady     = "ready"
+	kitchenBumped    = "bumped"
+)
+
+func migrateKitchen() {
+	db.Exec(`CREATE TABLE IF NOT EXISTS kitchen_stations (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		tenant_id UUID NOT NULL,
+		code VARCHAR(50) NOT NULL,
+		name VARCHAR(255) NOT NULL,
+		target_prep_seconds INTEGER NOT NULL DEFAULT 600,
+		is_default BOOLEAN NOT NULL DEFAULT false,
+		sort_order INTEGER NOT NULL DEFAULT 0,
+		is_active BOOLEAN NOT NULL DEFAULT true,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		UNIQUE(tenant_id, code)
+	)`)
+	db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_kitchen_stations_default ON kitchen_stations(tenant_id) WHERE is_default`)
+
+	// A product goes to its own station, else its category's, else the
+	// tenant's default. Items with none of these never reach the kitchen.
+	db.Exec(`ALTER TABLE products ADD COLUMN IF NOT EXISTS kitchen_station_id UUID REFERENCES kitchen_stations(id) ON DELETE SET NULL`)
+	db.Exec(`ALTER TABLE categories ADD COLUMN IF NOT EXISTS kitchen_station_id UUID REFERENCES kitchen_stations(id) ON DELETE SET NULL`)
+
+	db.Exec(`ALTER TABLE order_items ADD COLUMN IF NOT EXISTS kitchen_station_id UUID REFERENCES kitchen_stations(id)`)
+	db.Exec(`ALTER TABLE order_items ADD COLUMN IF NOT EXISTS kitchen_status VARCHAR(20)
+		CHECK (kitchen_status IN ('new', 'preparing', 'ready', 'bumped'))`)
+	db.Exec(`ALTER TABLE order_items ADD COLUMN IF NOT EXISTS kitchen_routed_at TIMESTAMPTZ`)
+	db.Exec(`ALTER TABLE order_items ADD COLUMN IF NOT EXISTS kitchen_started_at TIMESTAMPTZ`)
+	db.Exec(`ALTER TABLE order_items ADD COLUMN IF NOT EXISTS kitchen_ready_at TIMESTAMPTZ`)
+	db.Exec(`ALTER TABLE order_items ADD COLUMN IF NOT EXISTS kitchen_bumped_at TIMESTAMPTZ`)
+	db.Exec(`ALTER TABLE order_items ADD COLUMN IF NOT EXISTS kitchen_recalls INTEGER NOT NULL DEFAULT 0`)
+	db.Exec(`CREATE INDEX IF NOT EXISTS idx_order_items_kitchen ON order_items(kitchen_station_id, kitchen_status) WHERE kitchen_station_id IS NOT NULL`)
+}
+
+// routeOrderItems sends an order's items to their prep stations. Service
+// lines, like delivery fees, have nothing to prepare, and a scheduled order's
+// items wait until the order is released to the kitchen.
+func routeOrderItems(tx *sql.Tx, tenantID, orderID string) error {
+	_, err := tx.Exec(
+		`UPDATE order_items oi SET
+			kitchen_station_id = r.station_id, kitchen_status = 'new', kitchen_routed_at = NOW()
+		 FROM (
+			SELECT i.id, COALESCE(p.kitchen_station_id, cat.kitchen_station_id,
+			       (SELECT id FROM kitchen_stations WHERE tenant_id = $1 AND is_default AND is_active)) AS station_id
+			FROM order_items i
+			JOIN orders o ON o.id = i.order_id AND (o.release_at IS NULL OR o.released_at IS NOT NULL)
+			JOIN products p ON p.id = i.product_id
+			LEFT JOIN categories cat ON cat.id = p.category_id
+			WHERE i.order_id = $2 AND i.tenant_id = $1 AND i.kitchen_status IS NULL AND p.product_type NOT IN ('service', 'gift_card')
+		 ) r
+		 WHERE oi.id = r.id AND r.station_id IS NOT NULL`, tenantID, orderID)
+	return err
+}
+
+// ── Stations ────────────────────────────────────────────────
+
+func listKitchenStations(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	rows, err := db.Query(
+		`SELECT s.id, s.code, s.name, s.target_prep_seconds, s.is_default, s.sort_order, s.is_active,
+		        (SELECT COUNT(*) FROM order_items oi WHERE oi.kitchen_station_id = s.id AND oi.kitchen_status IN ('new', 'preparing', 'ready')
+		           AND oi.line_status <> 'voided')
+		 FROM kitchen_stations s WHERE s.tenant_id = $1 ORDER BY s.sort_order, s.name`, tenantID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	stations := []gin.H{}
+	for rows.Next() {
+		var id, code, name string
+		var target, sortOrder, open int
+		var isDefault, active bool
+		rows.Scan(&id, &code, &name, &target, &isDefault, &sortOrder, &active, &open)
+		stations = append(stations, gin.H{
+			"id": id, "code": code, "name": name, "targetPrepSeconds": target, "isDefault": isDefault,
+			"sortOrder": sortOrder, "isActive": active, "openItems": open,
+		})
+	}
+	c.JSON(200, gin.H{"stations": stations, "total": len(stations)})
+}
+
+type kitchenStationRequest struct {
+	Code              string `json:"code"`
+	Name              string `json:"name"`
+	TargetPrepSeconds *int   `json:"targetPrepSeconds"`
+	IsDefault         *bool  `json:"isDefault"`
+	SortOrder         *int   `json:"sortOrder"`
+	IsActive          *bool  `json:"isActive"`
+}
+
+func createKitchenStation(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	var req kitchenStationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Code == "" || req.Name == "" {
+		c.JSON(400, gin.H{"error": "code and name are required"})
+		return
+	}
+	target, sortOrder := 600, 0
+	if req.TargetPrepSeconds != nil {
+		target = *req.TargetPrepSeconds
+	}
+	if req.SortOrder != nil {
+		sortOrder = *req.SortOrder
+	}
+	isDefault := req.IsDefault != nil && *req.IsDefault
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Transaction failed"})
+		return
+	}
+	defer tx.Rollback()
+	var taken bool
+	tx.QueryRow("SELECT EXISTS(SELECT 1 FROM kitchen_stations WHERE tenant_id = $1 AND code = $2)", tenantID, req.Code).Scan(&taken)
+	if taken {
+		c.JSON(409, gin.H{"error": "A station with this code already exists"})
+		return
+	}
+	if isDefault {
+		tx.Exec("UPDATE kitchen_stations SET is_default = false WHERE tenant_id = $1 AND is_default", tenantID)
+	}
+	id := uuid.New().String()
+	if _, err := tx.Exec(
+		`INSERT INTO kitchen_stations (id, tenant_id, code, name, target_prep_seconds, is_default, sort_order)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		id, tenantID, req.Code, req.Name, target, isDefault, sortOrder); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(201, gin.H{"id": id, "code": req.Code, "name": req.Name, "targetPrepSeconds": target, "isDefault": isDefault, "sortOrder": sortOrder})
+}
+
+func updateKitchenStation(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	id := c.Param("id")
+	var req kitchenStationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Transaction failed"})
+		return
+	}
+	defer tx.Rollback()
+	if req.Code != "" {
+		var taken bool
+		tx.QueryRow("SELECT EXISTS(SELECT 1 FROM kitchen_stations WHERE tenant_id = $1 AND code = $2 AND id::text <> $3)", tenantID, req.Code, id).Scan(&taken)
+		if taken {
+			c.JSON(409, gin.H{"error": "A station with this code already exists"})
+			return
+		}
+	}
+	if req.IsDefault != nil && *req.IsDefault {
+		tx.Exec("UPDATE kitchen_stations SET is_default = false WHERE tenant_id = $1 AND is_default AND id::text <> $2", tenantID, id)
+	}
+	res, err := tx.Exec(
+		`UPDATE kitchen_stations SET
+			code = COALESCE(NULLIF($1, ''), code),
+			name = COALESCE(NULLIF($2, ''), name),
+			target_prep_seconds = COALESCE($3, target_prep_seconds),
+			is_default = COALESCE($4, is_default),
+			sort_order = COALESCE($5, sort_order),
+			is_active = COALESCE($6, is_active),
+			updated_at = NOW()
+		 WHERE id = $7 AND tenant_id = $8`,
+		req.Code, req.Name, req.TargetPrepSeconds, req.IsDefault, req.SortOrder, req.IsActive, id, tenantID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(404, gin.H{"error": "Station not found"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Station updated"})
+}
+
+func setProductKitchenStation(c *gin.Context) {
+	setKitchenStation(c, "products", "Product not found")
+}
+
+func setCategoryKitchenStation(c *gin.Context) {
+	setKitchenStation(c, "categories", "Category not found")
+}
+
+// setKitchenStation points a product or category at a station. A null
+// stationId clears it so routing falls through to the next level.
+func setKitchenStation(c *gin.Context, table, notFound string) {
+	tenantID := c.GetString("tenantId")
+	var req struct {
+		StationID *string `json:"stationId"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if req.StationID != nil {
+		var exists bool
+		db.QueryRow("SELECT EXISTS(SELECT 1 FROM kitchen_stations WHERE id::text = $1 AND tenant_id = $2)", *req.StationID, tenantID).Scan(&exists)
+		if !exists {
+			c.JSON(404, gin.H{"error": "Station not found"})
+			return
+		}
+	}
+	res, err := db.Exec("UPDATE "+table+" SET kitchen_station_id = $1, updated_at = NOW() WHERE id = $2 AND tenant_id = $3",
+		req.StationID, c.Param("id"), tenantID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(404, gin.H{"error": notFound})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Kitchen station updated", "stationId": req.StationID})
+}
+
+// ── Tickets ─────────────────────────────────────────────────
+
+// getStationTickets lists a station's open tickets, one per order, oldest
+// first. Counter orders are often paid, and so completed, before the food is
+// made, so only cancelled orders drop off the screen. view=bumped shows the
+// last items bumped instead so they can be recalled.
+func getStationTickets(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	stationID := c.Param("id")
+	locationID := c.Query("locationId")
+	bumped := c.Query("view") == "bumped"
+
+	var stationName string
+	var target int
+	if err := db.QueryRow("SELECT name, target_prep_seconds FROM kitchen_stations WHERE id = $1 AND tenant_id = $2", stationID, tenantID).Scan(&stationName, &target); err != nil {
+		c.JSON(404, gin.H{"error": "Station not found"})
+		return
+	}
+
+	query := `SELECT o.id, o.order_number, o.order_type, o.status, COALESCE(o.notes, ''),
+	                 oi.id, oi.name, oi.quantity, COALESCE(oi.notes, ''), COALESCE(oi.modifiers, '[]'),
+	                 oi.kitchen_status, oi.kitchen_routed_at, oi.kitchen_started_at, oi.kitchen_ready_at, oi.kitchen_recalls
+	          FROM order_items oi JOIN orders o ON o.id = oi.order_id
+	          WHERE oi.tenant_id = $1 AND oi.kitchen_station_id = $2 AND ($3 = '' OR o.location_id::text = $3)`
+	if bumped {
+		query += ` AND oi.kitchen_status = 'bumped' ORDER BY oi.kitchen_bumped_at DESC LIMIT 20`
+	} else {
+		query += ` AND oi.kitchen_status <> 'bumped' AND oi.line_status <> 'voided' AND o.status NOT IN ('cancelled', 'voided', 'refunded')
+		          ORDER BY oi.kitchen_routed_at, o.order_number, oi.created_at`
+	}
+	rows, err := db.Query(query, tenantID, stationID, locationID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	tickets := []gin.H{}
+	byOrder := map[string]gin.H{}
+	for rows.Next() {
+		var orderID, number, orderType, orderStatus, orderNotes, itemID, name, notes, mods, status string
+		var qty float64
+		var routedAt time.Time
+		var startedAt, readyAt *time.Time
+		var recalls int
+		rows.Scan(&orderID, &number, &orderType, &orderStatus, &orderNotes, &itemID, &name, &qty, &notes, &mods,
+			&status, &routedAt, &startedAt, &readyAt, &recalls)
+		var modifiers interface{}
+		json.Unmarshal([]byte(mods), &modifiers)
+		elapsed := int(now.Sub(routedAt).Seconds())
+		item := gin.H{
+			"id": itemID, "name": name, "quantity": qty, "notes": notes, "modifiers": modifiers, "status": status,
+			"routedAt": routedAt, "startedAt": startedAt, "readyAt": readyAt, "elapsedSeconds": elapsed,
+			"late": status != kitchenReady && elapsed > target, "recalls": recalls,
+		}
+		ticket, ok := byOrder[orderID]
+		if !ok {
+			ticket = gin.H{
+				"orderId": orderID, "orderNumber": number, "orderType": orderType, "orderStatus": orderStatus,
+				"notes": orderNotes, "firedAt": routedAt, "items": []gin.H{},
+			}
+			byOrder[orderID] = ticket
+			tickets = append(tickets, ticket)
+		}
+		ticket["items"] = append(ticket["items"].([]gin.H), item)
+	}
+	c.JSON(200, gin.H{"stationId": stationID, "stationName": stationName, "targetPrepSeconds": target, "tickets": tickets, "total": len(tickets)})
+}
+
+func startKitchenItem(c *gin.Context) {
+	changeKitchenItem(c, "start", []string{kitchenNew}, kitchenPreparing,
+		"kitchen_started_at = NOW()")
+}
+
+func readyKitchenItem(c *gin.Context) {
+	changeKitchenItem(c, "ready", []string{kitchenNew, kitchenPreparing}, kitchenReady,
+		"kitchen_started_at = COALESCE(kitchen_started_at, NOW()), kitchen_ready_at = NOW()")
+}
+
+func bumpKitchenItem(c *gin.Context) {
+	changeKitchenItem(c, "bump", []string{kitchenNew, kitchenPreparing, kitchenReady}, kitchenBumped,
+		"kitchen_started_at = COALESCE(kitchen_started_at, NOW()), kitchen_ready_at = COALESCE(kitchen_ready_at, NOW()), kitchen_bumped_at = NOW()")
+}
+
+// recallKitchenItem puts a bumped item back on its station's screen as
+// ready, keeping its prep times. An order already moved on stays where it is.
+func recallKitchenItem(c *gin.Context) {
+	changeKitchenItem(c, "recall", []string{kitchenBumped}, kitchenReady,
+		"kitchen_bumped_at = NULL, kitchen_recalls = kitchen_recalls + 1")
+}
+
+// changeKitchenItem moves one item along the kitchen flow. The order row is
+// locked first so two stations bumping at once agree on which was last.
+// Starting an item moves a new order into preparing; bumping an order's last
+// open item moves it to ready.
+func changeKitchenItem(c *gin.Context, action string, from []string, to, set string) {
+	tenantID := c.GetString("tenantId")
+	itemID := c.Param("id")
+	by := actorFromContext(c)
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Transaction failed"})
+		return
+	}
+	defer tx.Rollback()
+	var orderID string
+	if err := tx.QueryRow("SELECT order_id FROM order_items WHERE id = $1 AND tenant_id = $2 AND kitchen_station_id IS NOT NULL AND line_status <> 'voided'",
+		itemID, tenantID).Scan(&orderID); err != nil {
+		c.JSON(404, gin.H{"error": "Kitchen item not found"})
+		return
+	}
+	var orderStatus string
+	tx.QueryRow("SELECT status FROM orders WHERE id = $1 FOR UPDATE", orderID).Scan(&orderStatus)
+	var status string
+	tx.QueryRow("SELECT kitchen_status FROM order_items WHERE id = $1", itemID).Scan(&status)
+	allowed := false
+	for _, f := range from {
+		allowed = allowed || f == status
+	}
+	if !allowed {
+		c.JSON(409, gin.H{"error": fmt.Sprintf("Cannot %s an item that is %s", action, status), "status": status})
+		return
+	}
+	if _, err := tx.Exec("UPDATE order_items SET kitchen_status = $1, "+set+" WHERE id = $2", to, itemID); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	if (to == kitchenPreparing || to == kitchenBumped) && (orderStatus == orderPending || orderStatus == orderAccepted) {
+		if _, err := transitionOrder(tx, tenantID, orderID, orderPreparing, "", by, "Kitchen started"); err != nil {
+			writeTransitionError(c, orderStatus, err)
+			return
+		}
+		orderStatus = orderPreparing
+	}
+	if to == kitchenBumped && orderStatus == orderPreparing {
+		var open bool
+		tx.QueryRow("SELECT EXISTS(SELECT 1 FROM order_items WHERE order_id = $1 AND kitchen_station_id IS NOT NULL AND kitchen_status <> 'bumped' AND line_status <> 'voided')",
+			orderID).Scan(&open)
+		if !open {
+			if _, err := transitionOrder(tx, tenantID, orderID, orderReady, "", by, "All items bumped"); err != nil {
+				writeTransitionError(c, orderStatus, err)
+				return
+			}
+			orderStatus = orderReady
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"id": itemID, "status": to, "orderId": orderID, "orderStatus": orderStatus})
+}
+
+// ── Kitchen Performance ─────────────────────────────────────
+
+// getKitchenPerformance reports prep times from routing to ready, per
+// station and per product, for items made in the period.
+func getKitchenPerformance(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	from := c.DefaultQuery("from", time.Now().Format("2006-01-02"))
+	to := c.DefaultQuery("to", from)
+	locationID := c.Query("locationId")
+
+	const prep = "EXTRACT(EPOCH FROM oi.kitchen_ready_at - oi.kitchen_routed_at)"
+	const where = `FROM order_items oi
+		 JOIN orders o ON o.id = oi.order_id
+		 JOIN kitchen_stations s ON s.id = oi.kitchen_station_id
+		 WHERE oi.tenant_id = $1 AND oi.kitchen_ready_at IS NOT NULL
+		   AND oi.kitchen_routed_at >= $2::date AND oi.kitchen_routed_at < $3::date + 1
+		   AND ($4 = '' OR o.location_id::text = $4)`
+
+	rows, err := db.Query(
+		`SELECT s.id, s.name, s.target_prep_seconds, COUNT(*), AVG(`+prep+`),
+		        PERCENTILE_CONT(0.9) WITHIN GROUP (ORDER BY `+prep+`), MAX(`+prep+`),
+		        COUNT(*) FILTER (WHERE `+prep+` > s.target_prep_seconds), SUM(oi.kitchen_recalls)
+		 `+where+`
+		 GROUP BY s.id, s.name, s.target_prep_seconds
+		 ORDER BY s.name`, tenantID, from, to, locationID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	stations := []gin.H{}
+	for rows.Next() {
+		var id, name string
+		var target, items, late, recalls int
+		var avg, p90, max float64
+		rows.Scan(&id, &name, &target, &items, &avg, &p90, &max, &late, &recalls)
+		stations = append(stations, gin.H{
+			"stationId": id, "stationName": name, "targetPrepSeconds": target, "items": items,
+			"avgPrepSeconds": int(avg), "p90PrepSeconds": int(p90), "maxPrepSeconds": int(max),
+			"lateItems": late, "recalls": recalls,
+		})
+	}
+	rows.Close()
+
+	rows, err = db.Query(
+		`SELECT oi.product_id, oi.name, s.name, COUNT(*), AVG(`+prep+`)
+		 `+where+`
+		 GROUP BY oi.product_id, oi.name, s.name
+		 ORDER BY AVG(`+prep+`) DESC LIMIT 20`, tenantID, from, to, locationID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	products := []gin.H{}
+	for rows.Next() {
+		var pid, name, station string
+		var items int
+		var avg float64
+		rows.Scan(&pid, &name, &station, &items, &avg)
+		products = append(products, gin.H{"productId": pid, "name": name, "stationName": station, "items": items, "avgPrepSeconds": int(avg)})
+	}
+	c.JSON(200, gin.H{"from": from, "to": to, "stations": stations, "slowestProducts": products})
+}
@@ -10,9 +10,14 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/berhot/products/commerce/pos-engine/internal/geo"
+	"github.com/berhot/products/commerce/pos-engine/internal/money"
+	"github.com/berhot/products/commerce/pos-engine/internal/promotion"
+	"github.com/berhot/products/commerce/pos-engine/internal/tax"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	_ "github.com/lib/pq"
@@ -88,6 +93,32 @@ func main() {
 	db.Exec(`ALTER TABLE app_banners ADD COLUMN IF NOT EXISTS show_overlay BOOLEAN NOT NULL DEFAULT false`)
 	db.Exec(`ALTER TABLE app_banners ADD COLUMN IF NOT EXISTS overlay_title TEXT NOT NULL DEFAULT ''`)
 	db.Exec(`ALTER TABLE app_banners ADD COLUMN IF NOT EXISTS overlay_description TEXT NOT NULL DEFAULT ''`)
+	migrateOrderLifecycle()
+	migrateRefunds()
+	migrateTenders()
+	migratePromotions()
+	migrateTax()
+	migrateEInvoices()
+	migrateCashDrawers()
+	migrateVariants()
+	migrateStock()
+	migrateRecipes()
+	migratePurchasing()
+	migrateTransfers()
+	migrateStockCounts()
+	migrateKitchen()
+	migrateOrderEvents()
+	migrateOutbox()
+	migrateTables()
+	migrateOpenOrders()
+	migrateOrderAdjustments()
+	migrateCombos()
+	migrateMenus()
+	migrateDelivery()
+	migrateOpeningHours()
+	migrateScheduling()
+	migrateReceipts()
+	migrateGiftCards()
 	log.Println("POS Engine: database tables migrated")
 
 	// Ensure uploads directory exists
@@ -105,7 +136,7 @@ func main() {
 	router.Use(func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", "*")
 		c.Header("Access-Control-Allow-Methods", "GET,POST,PUT,DELETE,OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Tenant-ID")
+		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Tenant-ID, X-User-ID, X-Client-App, X-Drawer-ID, X-Device-ID, Idempotency-Key, Last-Event-ID")
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
 			return
@@ -133,28 +164,105 @@ func main() {
 		v1.PUT("/products/:id", updateProduct)
 		v1.GET("/products/:id/modifiers", getProductModifiers)
 		v1.POST("/products/:id/modifier-groups", linkModifierGroup)
+		v1.GET("/products/lookup", lookupProduct)
+		v1.GET("/products/:id/variants", listVariants)
+		v1.POST("/products/:id/variants", createVariant)
+		v1.PUT("/products/:id/variants/:variantId", updateVariant)
+		v1.DELETE("/products/:id/variants/:variantId", deleteVariant)
+		v1.GET("/products/:id/recipe", getProductRecipe)
+		v1.PUT("/products/:id/recipe", setProductRecipe)
+		v1.PUT("/products/:id/kitchen-station", setProductKitchenStation)
+		v1.GET("/products/:id/combo", getCombo)
+		v1.PUT("/products/:id/combo", setComboComponents)
 
 		v1.GET("/categories", listCategories)
 		v1.POST("/categories", createCategory)
+		v1.PUT("/categories/:id/kitchen-station", setCategoryKitchenStation)
 
 		v1.GET("/modifier-groups", listModifierGroups)
 		v1.POST("/modifier-groups", createModifierGroup)
+		v1.GET("/menus", listMenus)
+		v1.POST("/menus", createMenu)
+		v1.PUT("/menus/:id", updateMenu)
+		v1.GET("/price-lists", listPriceLists)
+		v1.POST("/price-lists", createPriceList)
+		v1.GET("/price-lists/:id", getPriceList)
+		v1.PUT("/price-lists/:id", updatePriceList)
+		v1.PUT("/price-lists/:id/items", setPriceListItems)
+		v1.GET("/delivery-zones", listDeliveryZones)
+		v1.POST("/delivery-zones", createDeliveryZone)
+		v1.PUT("/delivery-zones/:id", updateDeliveryZone)
+		v1.POST("/delivery/quote", getDeliveryQuote)
+		v1.GET("/modifier-items/:id/recipe", getModifierRecipe)
+		v1.PUT("/modifier-items/:id/recipe", setModifierRecipe)
+		v1.PUT("/modifier-items/:id/component", setModifierComponent)
+
+		v1.GET("/ingredients", listIngredients)
+		v1.POST("/ingredients", createIngredient)
+		v1.PUT("/ingredients/:id", updateIngredient)
 
 		v1.GET("/locations", listLocations)
+		v1.PUT("/locations/:id/tax-settings", updateLocationTaxSettings)
+		v1.PUT("/locations/:id/stock-policy", updateLocationStockPolicy)
+		v1.PUT("/locations/:id/scheduling", updateLocationScheduling)
+		v1.GET("/locations/:id/hours", getLocationHours)
+		v1.PUT("/locations/:id/hours", setLocationHours)
+		v1.GET("/locations/:id/slots", getLocationSlots)
+		v1.GET("/locations/:id/special-hours", listSpecialHours)
+		v1.PUT("/locations/:id/special-hours", setSpecialHours)
+		v1.DELETE("/locations/:id/special-hours/:date", deleteSpecialHours)
+		v1.PUT("/locations/:id/online-ordering", setOnlineOrdering)
+		v1.GET("/locations/:id/status", getLocationStatus)
+
+		v1.GET("/tax-categories", listTaxCategories)
+		v1.POST("/tax-categories", createTaxCategory)
+
+		v1.GET("/promotions", listPromotions)
+		v1.POST("/promotions", createPromotion)
+		v1.PUT("/promotions/:id", updatePromotion)
 
 		v1.POST("/orders", createOrder)
 		v1.GET("/orders", listOrders)
+		v1.POST("/orders/tabs", openTab)
+		v1.GET("/orders/open", listOpenOrders)
+		v1.GET("/orders/stream", streamOrders)
 		v1.GET("/orders/:id", getOrder)
+		v1.GET("/orders/:id/history", getOrderHistory)
+		v1.GET("/orders/:id/invoice", getOrderInvoice)
+		v1.GET("/orders/:id/receipt", getOrderReceipt)
 		v1.PUT("/orders/:id/status", updateOrderStatus)
 		v1.POST("/orders/:id/complete", completeOrder)
 		v1.POST("/orders/:id/cancel", cancelOrder)
 		v1.POST("/orders/:id/accept", acceptOrder)
+		v1.POST("/orders/:id/refunds", createRefund)
+		v1.GET("/orders/:id/refunds", listOrderRefunds)
+		v1.GET("/orders/:id/balance", getOrderBalance)
+		v1.POST("/orders/:id/items", addOrderItems)
+		v1.PUT("/orders/:id/items/:itemId", updateOrderItem)
+		v1.POST("/orders/:id/items/:itemId/void", voidOrderItem)
+		v1.POST("/orders/:id/items/:itemId/comp", compOrderItem)
+		v1.POST("/orders/:id/park", parkOrder)
+		v1.POST("/orders/:id/resume", resumeOrder)
+		v1.POST("/orders/:id/split", splitCheck)
+
+		v1.GET("/reason-codes", listReasonCodes)
+		v1.POST("/reason-codes", createReasonCode)
+		v1.PUT("/reason-codes/:id", updateReasonCode)
+		v1.PUT("/staff/:id/manager-pin", setManagerPin)
 
 		v1.POST("/payments", processPayment)
 		v1.GET("/payments/:orderId", getOrderPayments)
 
 		v1.GET("/customers", listCustomers)
 		v1.POST("/customers", createCustomer)
+		v1.GET("/customers/:id/store-credit", getCustomerStoreCredit)
+
+		v1.GET("/gift-cards", listGiftCards)
+		v1.POST("/gift-cards", issueGiftCard)
+		v1.POST("/gift-cards/check", checkGiftCard)
+		v1.GET("/gift-cards/:id", getGiftCard)
+		v1.PUT("/gift-cards/:id/pin", resetGiftCardPin)
+		v1.POST("/gift-cards/:id/void", voidGiftCard)
 
 		v1.POST("/addresses", createAddress)
 		v1.GET("/addresses/:customerId", listAddresses)
@@ -169,9 +277,85 @@ func main() {
 
 		v1.GET("/inventory", listInventory)
 		v1.PUT("/inventory/:productId", updateInventory)
+		v1.GET("/inventory/movements", listStockMovements)
+		v1.POST("/inventory/movements", createStockMovement)
+		v1.GET("/inventory/reorder-suggestions", getReorderSuggestions)
+
+		v1.GET("/stock-transfers", listTransfers)
+		v1.POST("/stock-transfers", createTransfer)
+		v1.GET("/stock-transfers/:id", getTransfer)
+		v1.POST("/stock-transfers/:id/dispatch", dispatchTransfer)
+		v1.POST("/stock-transfers/:id/receive", receiveTransfer)
+		v1.POST("/stock-transfers/:id/cancel", cancelTransfer)
+
+		v1.GET("/floors", listFloors)
+		v1.POST("/floors", createFloor)
+		v1.PUT("/floors/:id", updateFloor)
+		v1.GET("/floors/:id/plan", getFloorPlan)
+		v1.GET("/tables", listTables)
+		v1.POST("/tables", createTable)
+		v1.PUT("/tables/:id", updateTable)
+		v1.POST("/tables/:id/seat", seatTable)
+		v1.PUT("/tables/:id/status", setTableStatus)
+		v1.POST("/tables/:id/transfer", transferTable)
+		v1.POST("/tables/:id/merge", mergeTables)
+
+		v1.GET("/kitchen/stations", listKitchenStations)
+		v1.POST("/kitchen/stations", createKitchenStation)
+		v1.PUT("/kitchen/stations/:id", updateKitchenStation)
+		v1.GET("/kitchen/stations/:id/tickets", getStationTickets)
+		v1.POST("/kitchen/items/:id/start", startKitchenItem)
+		v1.POST("/kitchen/items/:id/ready", readyKitchenItem)
+		v1.POST("/kitchen/items/:id/bump", bumpKitchenItem)
+		v1.POST("/kitchen/items/:id/recall", recallKitchenItem)
+
+		v1.GET("/stock-counts", listStockCounts)
+		v1.POST("/stock-counts", createStockCount)
+		v1.GET("/stock-counts/:id", getStockCount)
+		v1.PUT("/stock-counts/:id/lines", recordStockCountLines)
+		v1.POST("/stock-counts/:id/submit", submitStockCount)
+		v1.POST("/stock-counts/:id/recount", recountStockCount)
+		v1.POST("/stock-counts/:id/approve", approveStockCount)
+		v1.POST("/stock-counts/:id/cancel", cancelStockCount)
+
+		v1.GET("/suppliers", listSuppliers)
+		v1.POST("/suppliers", createSupplier)
+		v1.PUT("/suppliers/:id", updateSupplier)
+
+		v1.GET("/purchase-orders", listPurchaseOrders)
+		v1.POST("/purchase-orders", createPurchaseOrder)
+		v1.GET("/purchase-orders/:id", getPurchaseOrder)
+		v1.POST("/purchase-orders/:id/submit", submitPurchaseOrder)
+		v1.POST("/purchase-orders/:id/cancel", cancelPurchaseOrder)
+		v1.POST("/purchase-orders/:id/receive", receivePurchaseOrder)
 
 		v1.GET("/reports/daily-sales", getDailySales)
 		v1.GET("/reports/top-products", getTopProducts)
+		v1.GET("/reports/promotions", getPromotionReport)
+		v1.GET("/reports/tax", getTaxReport)
+		v1.GET("/reports/ingredient-variance", getIngredientVariance)
+		v1.GET("/reports/kitchen-performance", getKitchenPerformance)
+		v1.GET("/reports/voids-comps", getVoidCompReport)
+		v1.GET("/reports/combos", getComboReport)
+		v1.GET("/reports/gift-card-liability", getGiftCardLiability)
+
+		v1.GET("/zatca/settings", getZatcaSettings)
+		v1.PUT("/zatca/settings", updateZatcaSettings)
+		v1.GET("/receipt-template", getReceiptTemplate)
+		v1.PUT("/receipt-template", updateReceiptTemplate)
+
+		v1.GET("/cash-drawers", listCashDrawers)
+		v1.POST("/cash-drawers", openCashDrawer)
+		v1.GET("/cash-drawers/:id", getCashDrawer)
+		v1.POST("/cash-drawers/:id/pay-in", payInCashDrawer)
+		v1.POST("/cash-drawers/:id/pay-out", payOutCashDrawer)
+		v1.POST("/cash-drawers/:id/drop", dropCashDrawer)
+		v1.POST("/cash-drawers/:id/close", closeCashDrawer)
+		v1.GET("/cash-drawers/:id/x-report", getXReport)
+		v1.GET("/cash-drawers/:id/z-report", getZReport)
+
+		v1.GET("/outbox/events", listOutboxEvents)
+		v1.POST("/outbox/events/:id/retry", retryOutboxEvent)
 
 		v1.POST("/seed/cafe-menu", seedCafeMenu)
 
@@ -187,6 +371,11 @@ func main() {
 		v1.POST("/upload", uploadFile)
 	}
 
+	startOrderStream(dbURL)
+	startOutboxRelay()
+	startOrderScheduler()
+	startGiftCardExpiry()
+
 	log.Printf("POS Engine listening on :%s", port)
 	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%s", port), router))
 }
@@ -208,14 +397,33 @@ func tenantMiddleware() gin.HandlerFunc {
 			tenantID = "4fcf6201-0e81-41a7-8b61-356d39def62a"
 		}
 		c.Set("tenantId", tenantID)
+		c.Set("userId", c.GetHeader("X-User-ID"))
+		c.Set("clientApp", c.GetHeader("X-Client-App"))
 		c.Next()
 	}
 }
 
 // ── Products ────────────────────────────────────────────────
 
+// listProducts lists the catalogue. Given a locationId it lists only what
+// that location sells now on the channel asked for (in_store by default),
+// at the price its running price lists charge.
 func listProducts(c *gin.Context) {
 	tenantID := c.GetString("tenantId")
+	var prices priceContext
+	locationID := c.Query("locationId")
+	channel := c.DefaultQuery("channel", channelInStore)
+	if locationID != "" {
+		if !validChannel(channel) {
+			c.JSON(400, gin.H{"error": fmt.Sprintf("Unknown channel %q", channel)})
+			return
+		}
+		var err error
+		if prices, err = loadPriceContext(tenantID, locationID, channel, locationNow(tenantID, locationID)); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+	}
 	rows, err := db.Query(
 		`SELECT p.id, p.name, p.sku, p.price, p.currency, p.product_type, p.is_active,
 		        COALESCE(p.description, ''), COALESCE(p.barcode, ''),
@@ -239,42 +447,55 @@ func listProducts(c *gin.Context) {
 		var id, name, sku, currency, ptype, desc, barcode, catName, imageUrl string
 		var nameEn, nameAr, descEn, descAr, catNameEn, catNameAr string
 		var catID sql.NullString
-		var price float64
+		var price money.Amount
 		var active bool
 		var createdAt time.Time
 		rows.Scan(&id, &name, &sku, &price, &currency, &ptype, &active, &desc, &barcode, &catName, &catID, &imageUrl, &createdAt,
 			&nameEn, &nameAr, &descEn, &descAr, &catNameEn, &catNameAr)
+		if locationID != "" && (!active || !prices.onMenu(id, catID.String)) {
+			continue
+		}
+		basePrice := price
+		if listed, ok := prices.productPrices[id]; ok {
+			price = listed
+		}
 		p := gin.H{
 			"id": id, "name": name, "sku": sku, "price": price, "currency": currency,
 			"type": ptype, "isActive": active, "description": desc, "barcode": barcode,
 			"categoryName": catName, "categoryId": catID.String, "imageUrl": imageUrl, "createdAt": createdAt,
 			"nameEn": nameEn, "nameAr": nameAr,
 			"descriptionEn": descEn, "descriptionAr": descAr,
-			"categoryNameEn": catNameEn, "categoryNameAr": catNameAr,
+			"categoryNameEn": catNameEn, "categoryNameAr": catNameAr, "basePrice": basePrice,
 		}
 		var hasRequired bool
 		db.QueryRow("SELECT EXISTS(SELECT 1 FROM product_modifier_groups pmg JOIN modifier_groups mg ON mg.id = pmg.modifier_group_id WHERE pmg.product_id = $1 AND mg.is_required = true)", id).Scan(&hasRequired)
 		p["hasRequiredModifiers"] = hasRequired
 		products = append(products, p)
 	}
+	if locationID != "" {
+		c.JSON(200, gin.H{"products": products, "total": len(products), "channel": channel, "menus": prices.menus, "priceLists": prices.priceLists})
+		return
+	}
 	c.JSON(200, gin.H{"products": products, "total": len(products)})
 }
 
 func createProduct(c *gin.Context) {
 	tenantID := c.GetString("tenantId")
 	var req struct {
-		Name          string  `json:"name" binding:"required"`
-		NameEn        string  `json:"nameEn"`
-		NameAr        string  `json:"nameAr"`
-		SKU           string  `json:"sku"`
-		Price         float64 `json:"price" binding:"required"`
-		CategoryID    string  `json:"categoryId"`
-		Description   string  `json:"description"`
-		DescriptionEn string  `json:"descriptionEn"`
-		DescriptionAr string  `json:"descriptionAr"`
-		ProductType   string  `json:"type"`
-		TaxRate       float64 `json:"taxRate"`
-		ImageUrl      string  `json:"imageUrl"`
+		Name           string       `json:"name" binding:"required"`
+		NameEn         string       `json:"nameEn"`
+		NameAr         string       `json:"nameAr"`
+		SKU            string       `json:"sku"`
+		Price          money.Amount `json:"price" binding:"required"`
+		CategoryID     string       `json:"categoryId"`
+		Description    string       `json:"description"`
+		DescriptionEn  string       `json:"descriptionEn"`
+		DescriptionAr  string       `json:"descriptionAr"`
+		ProductType    string       `json:"type"`
+		TaxRate        *float64     `json:"taxRate"`
+		TaxCategoryID  string       `json:"taxCategoryId"`
+		ImageUrl       string       `json:"imageUrl"`
+		TrackInventory bool         `json:"trackInventory"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(400, gin.H{"error": err.Error()})
@@ -288,21 +509,25 @@ func createProduct(c *gin.Context) {
 	}
 
 	id := uuid.New().String()
-	var catID *string
+	var catID, taxCatID *string
 	if req.CategoryID != "" {
 		catID = &req.CategoryID
 	}
+	if req.TaxCategoryID != "" {
+		taxCatID = &req.TaxCategoryID
+	}
 
 	_, err := db.Exec(
-		`INSERT INTO products (id, tenant_id, category_id, sku, name, name_en, name_ar, description, description_en, description_ar, price, currency, tax_rate, product_type, is_active, image_url)
-		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, 'SAR', $12, $13, true, $14)`,
-		id, tenantID, catID, req.SKU, req.Name, req.NameEn, req.NameAr, req.Description, req.DescriptionEn, req.DescriptionAr, req.Price, req.TaxRate, req.ProductType, req.ImageUrl,
+		`INSERT INTO products (id, tenant_id, category_id, sku, name, name_en, name_ar, description, description_en, description_ar, price, currency, tax_rate, product_type, is_active, image_url, tax_category_id, track_inventory)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, true, $15, $16, $17)`,
+		id, tenantID, catID, req.SKU, req.Name, req.NameEn, req.NameAr, req.Description, req.DescriptionEn, req.DescriptionAr, req.Price,
+		currencyFor(tenantID, ""), req.TaxRate, req.ProductType, req.ImageUrl, taxCatID, req.TrackInventory,
 	)
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(201, gin.H{"id": id, "name": req.Name, "nameEn": req.NameEn, "nameAr": req.NameAr, "sku": req.SKU, "price": req.Price, "imageUrl": req.ImageUrl})
+	c.JSON(201, gin.H{"id": id, "name": req.Name, "nameEn": req.NameEn, "nameAr": req.NameAr, "sku": req.SKU, "price": req.Price, "imageUrl": req.ImageUrl, "trackInventory": req.TrackInventory})
 }
 
 func getProduct(c *gin.Context) {
@@ -310,27 +535,34 @@ func getProduct(c *gin.Context) {
 	id := c.Param("id")
 	var name, sku, currency, ptype, desc, imageUrl string
 	var nameEn, nameAr, descEn, descAr string
-	var price, taxRate float64
-	var active bool
-	var catID sql.NullString
+	var price money.Amount
+	var taxRate float64
+	var active, tracked bool
+	var catID, taxCatID sql.NullString
 	err := db.QueryRow(
 		`SELECT name, sku, price, currency, product_type, COALESCE(tax_rate,0), is_active,
 		        COALESCE(description,''), COALESCE(image_url,''), category_id,
 		        COALESCE(name_en,''), COALESCE(name_ar,''),
-		        COALESCE(description_en,''), COALESCE(description_ar,'')
+		        COALESCE(description_en,''), COALESCE(description_ar,''), tax_category_id, track_inventory
 		 FROM products WHERE id = $1 AND tenant_id = $2`, id, tenantID,
 	).Scan(&name, &sku, &price, &currency, &ptype, &taxRate, &active, &desc, &imageUrl, &catID,
-		&nameEn, &nameAr, &descEn, &descAr)
+		&nameEn, &nameAr, &descEn, &descAr, &taxCatID, &tracked)
 	if err != nil {
 		c.JSON(404, gin.H{"error": "Product not found"})
 		return
 	}
+	variants, err := productVariants(tenantID, id, price, true)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
 	c.JSON(200, gin.H{
-		"id": id, "name": name, "nameEn": nameEn, "nameAr": nameAr,
+		"id": id, "name": name, "nameEn": nameEn, "nameAr": nameAr, "variants": variants,
 		"sku": sku, "price": price, "currency": currency,
 		"type": ptype, "taxRate": taxRate, "isActive": active,
 		"description": desc, "descriptionEn": descEn, "descriptionAr": descAr,
-		"imageUrl": imageUrl, "categoryId": catID.String,
+		"imageUrl": imageUrl, "categoryId": catID.String, "taxCategoryId": taxCatID.String,
+		"trackInventory": tracked,
 	})
 }
 
@@ -338,15 +570,18 @@ func updateProduct(c *gin.Context) {
 	tenantID := c.GetString("tenantId")
 	id := c.Param("id")
 	var req struct {
-		Name          string   `json:"name"`
-		NameEn        string   `json:"nameEn"`
-		NameAr        string   `json:"nameAr"`
-		Price         *float64 `json:"price"`
-		IsActive      *bool    `json:"isActive"`
-		Description   string   `json:"description"`
-		DescriptionEn string   `json:"descriptionEn"`
-		DescriptionAr string   `json:"descriptionAr"`
-		ImageUrl      string   `json:"imageUrl"`
+		Name           string        `json:"name"`
+		NameEn         string        `json:"nameEn"`
+		NameAr         string        `json:"nameAr"`
+		Price          *money.Amount `json:"price"`
+		IsActive       *bool         `json:"isActive"`
+		Description    string        `json:"description"`
+		DescriptionEn  string        `json:"descriptionEn"`
+		DescriptionAr  string        `json:"descriptionAr"`
+		ImageUrl       string        `json:"imageUrl"`
+		TaxRate        *float64      `json:"taxRate"`
+		TaxCategoryID  *string       `json:"taxCategoryId"`
+		TrackInventory *bool         `json:"trackInventory"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(400, gin.H{"error": err.Error()})
@@ -362,10 +597,13 @@ func updateProduct(c *gin.Context) {
 			name_en = COALESCE(NULLIF($8,''), name_en),
 			name_ar = COALESCE(NULLIF($9,''), name_ar),
 			description_en = COALESCE(NULLIF($10,''), description_en),
-			description_ar = COALESCE(NULLIF($11,''), description_ar)
+			description_ar = COALESCE(NULLIF($11,''), description_ar),
+			tax_rate = COALESCE($12, tax_rate),
+			tax_category_id = CASE WHEN $13::text IS NULL THEN tax_category_id ELSE NULLIF($13, '')::uuid END,
+			track_inventory = COALESCE($14, track_inventory)
 		 WHERE id = $6 AND tenant_id = $7`,
 		req.Name, req.Price, req.IsActive, req.Description, req.ImageUrl, id, tenantID,
-		req.NameEn, req.NameAr, req.DescriptionEn, req.DescriptionAr,
+		req.NameEn, req.NameAr, req.DescriptionEn, req.DescriptionAr, req.TaxRate, req.TaxCategoryID, req.TrackInventory,
 	)
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
@@ -378,9 +616,21 @@ func updateProduct(c *gin.Context) {
 	c.JSON(200, gin.H{"message": "Product updated"})
 }
 
+// getProductModifiers lists a product's modifier groups. Given a
+// locationId, and optionally a channel, the prices are the ones the
+// location's running price lists charge.
 func getProductModifiers(c *gin.Context) {
 	tenantID := c.GetString("tenantId")
 	productID := c.Param("id")
+	var prices priceContext
+	if locationID := c.Query("locationId"); locationID != "" {
+		var err error
+		prices, err = loadPriceContext(tenantID, locationID, c.DefaultQuery("channel", channelInStore), locationNow(tenantID, locationID))
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+	}
 	rows, err := db.Query(
 		`SELECT mg.id, mg.name, COALESCE(mg.display_name, mg.name), mg.selection_type,
 		        mg.min_selections, mg.max_selections, mg.is_required, mg.sort_order,
@@ -407,19 +657,22 @@ func getProductModifiers(c *gin.Context) {
 
 		itemRows, _ := db.Query(
 			`SELECT id, name, price_adjustment, is_default, sort_order,
-			        COALESCE(name_en, ''), COALESCE(name_ar, '')
+			        COALESCE(name_en, ''), COALESCE(name_ar, ''), COALESCE(component_product_id::text, '')
 			 FROM modifier_items WHERE modifier_group_id = $1 AND tenant_id = $2 AND is_active = true
 			 ORDER BY sort_order`, gid, tenantID)
 		items := []gin.H{}
 		if itemRows != nil {
 			for itemRows.Next() {
-				var iid, iname, inameEn, inameAr string
-				var priceAdj float64
+				var iid, iname, inameEn, inameAr, componentID string
+				var priceAdj money.Amount
 				var isDef bool
 				var iSort int
-				itemRows.Scan(&iid, &iname, &priceAdj, &isDef, &iSort, &inameEn, &inameAr)
+				itemRows.Scan(&iid, &iname, &priceAdj, &isDef, &iSort, &inameEn, &inameAr, &componentID)
+				if listed, ok := prices.modifierPrices[iid]; ok {
+					priceAdj = listed
+				}
 				items = append(items, gin.H{"id": iid, "name": iname, "nameEn": inameEn, "nameAr": inameAr,
-					"priceAdjustment": priceAdj, "isDefault": isDef, "sortOrder": iSort})
+					"priceAdjustment": priceAdj, "isDefault": isDef, "sortOrder": iSort, "componentProductId": componentID})
 			}
 			itemRows.Close()
 		}
@@ -533,19 +786,19 @@ func listModifierGroups(c *gin.Context) {
 
 		itemRows, _ := db.Query(
 			`SELECT id, name, price_adjustment, is_default, sort_order,
-			        COALESCE(name_en, ''), COALESCE(name_ar, '')
+			        COALESCE(name_en, ''), COALESCE(name_ar, ''), COALESCE(component_product_id::text, '')
 			 FROM modifier_items WHERE modifier_group_id = $1 AND tenant_id = $2 AND is_active = true
 			 ORDER BY sort_order`, gid, tenantID)
 		items := []gin.H{}
 		if itemRows != nil {
 			for itemRows.Next() {
-				var iid, iname, inameEn, inameAr string
-				var priceAdj float64
+				var iid, iname, inameEn, inameAr, componentID string
+				var priceAdj money.Amount
 				var isDef bool
 				var iSort int
-				itemRows.Scan(&iid, &iname, &priceAdj, &isDef, &iSort, &inameEn, &inameAr)
+				itemRows.Scan(&iid, &iname, &priceAdj, &isDef, &iSort, &inameEn, &inameAr, &componentID)
 				items = append(items, gin.H{"id": iid, "name": iname, "nameEn": inameEn, "nameAr": inameAr,
-					"priceAdjustment": priceAdj, "isDefault": isDef, "sortOrder": iSort})
+					"priceAdjustment": priceAdj, "isDefault": isDef, "sortOrder": iSort, "componentProductId": componentID})
 			}
 			itemRows.Close()
 		}
@@ -563,24 +816,26 @@ func listModifierGroups(c *gin.Context) {
 func createModifierGroup(c *gin.Context) {
 	tenantID := c.GetString("tenantId")
 	var req struct {
-		Name            string `json:"name" binding:"required"`
-		NameEn          string `json:"nameEn"`
-		NameAr          string `json:"nameAr"`
-		DisplayName     string `json:"displayName"`
-		DisplayNameEn   string `json:"displayNameEn"`
-		DisplayNameAr   string `json:"displayNameAr"`
-		SelectionType   string `json:"selectionType"`
-		MinSelections   int    `json:"minSelections"`
-		MaxSelections   int    `json:"maxSelections"`
-		IsRequired      bool   `json:"isRequired"`
-		SortOrder       int    `json:"sortOrder"`
-		Items           []struct {
-			Name            string  `json:"name" binding:"required"`
-			NameEn          string  `json:"nameEn"`
-			NameAr          string  `json:"nameAr"`
-			PriceAdjustment float64 `json:"priceAdjustment"`
-			IsDefault       bool    `json:"isDefault"`
-			SortOrder       int     `json:"sortOrder"`
+		Name          string `json:"name" binding:"required"`
+		NameEn        string `json:"nameEn"`
+		NameAr        string `json:"nameAr"`
+		DisplayName   string `json:"displayName"`
+		DisplayNameEn string `json:"displayNameEn"`
+		DisplayNameAr string `json:"displayNameAr"`
+		SelectionType string `json:"selectionType"`
+		MinSelections int    `json:"minSelections"`
+		MaxSelections int    `json:"maxSelections"`
+		IsRequired    bool   `json:"isRequired"`
+		SortOrder     int    `json:"sortOrder"`
+		Items         []struct {
+			Name               string       `json:"name" binding:"required"`
+			NameEn             string       `json:"nameEn"`
+			NameAr             string       `json:"nameAr"`
+			PriceAdjustment    money.Amount `json:"priceAdjustment"`
+			IsDefault          bool         `json:"isDefault"`
+			SortOrder          int          `json:"sortOrder"`
+			ComponentProductID string       `json:"componentProductId"`
+			ComponentVariantID string       `json:"componentVariantId"`
 		} `json:"items"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -612,11 +867,13 @@ func createModifierGroup(c *gin.Context) {
 	for _, item := range req.Items {
 		itemID := uuid.New().String()
 		db.Exec(
-			`INSERT INTO modifier_items (id, tenant_id, modifier_group_id, name, name_en, name_ar, price_adjustment, is_default, sort_order)
-			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
-			itemID, tenantID, groupID, item.Name, item.NameEn, item.NameAr, item.PriceAdjustment, item.IsDefault, item.SortOrder)
+			`INSERT INTO modifier_items (id, tenant_id, modifier_group_id, name, name_en, name_ar, price_adjustment, is_default, sort_order,
+			        component_product_id, component_variant_id)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NULLIF($10, '')::uuid, NULLIF($11, '')::uuid)`,
+			itemID, tenantID, groupID, item.Name, item.NameEn, item.NameAr, item.PriceAdjustment, item.IsDefault, item.SortOrder,
+			item.ComponentProductID, item.ComponentVariantID)
 		items = append(items, gin.H{"id": itemID, "name": item.Name, "nameEn": item.NameEn, "nameAr": item.NameAr,
-			"priceAdjustment": item.PriceAdjustment, "isDefault": item.IsDefault})
+			"priceAdjustment": item.PriceAdjustment, "isDefault": item.IsDefault, "componentProductId": item.ComponentProductID})
 	}
 
 	c.JSON(201, gin.H{"id": groupID, "name": req.Name, "nameEn": req.NameEn, "nameAr": req.NameAr, "items": items})
@@ -624,9 +881,31 @@ func createModifierGroup(c *gin.Context) {
 
 // ── Locations ───────────────────────────────────────────────
 
+// currencyFor is the currency a location trades in, falling back to the
+// currency of the tenant's country. An empty locationID means the tenant's
+// first active location.
+func currencyFor(tenantID, locationID string) string {
+	var currency string
+	db.QueryRow(
+		`SELECT COALESCE(NULLIF(l.currency, ''), NULLIF(co.currency_code, ''), '')
+		 FROM tenants t
+		 LEFT JOIN locations l ON l.tenant_id = t.id AND (l.id::text = $2 OR ($2 = '' AND l.status = 'active'))
+		 LEFT JOIN countries co ON co.code = t.country_code
+		 WHERE t.id = $1
+		 ORDER BY l.created_at LIMIT 1`, tenantID, locationID,
+	).Scan(&currency)
+	if currency == "" {
+		return money.DefaultCurrency
+	}
+	return currency
+}
+
 func listLocations(c *gin.Context) {
 	tenantID := c.GetString("tenantId")
-	rows, err := db.Query("SELECT id, name, timezone, currency, tax_rate, status FROM locations WHERE tenant_id = $1", tenantID)
+	rows, err := db.Query(
+		`SELECT id, name, timezone, currency, COALESCE(tax_rate, 0), status, prices_include_tax, tax_rounding, COALESCE(default_tax_category_id::text, ''), stock_policy,
+		        prep_time_minutes, slot_minutes, pickup_slot_capacity, delivery_slot_capacity, schedule_days_ahead
+		 FROM locations WHERE tenant_id = $1`, tenantID)
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
@@ -634,10 +913,18 @@ func listLocations(c *gin.Context) {
 	defer rows.Close()
 	locs := []gin.H{}
 	for rows.Next() {
-		var id, name, tz, cur, status string
+		var id, name, tz, cur, status, rounding, defaultTaxCat, stockPolicy string
 		var taxRate float64
-		rows.Scan(&id, &name, &tz, &cur, &taxRate, &status)
-		locs = append(locs, gin.H{"id": id, "name": name, "timezone": tz, "currency": cur, "taxRate": taxRate, "status": status})
+		var inclusive bool
+		var prep, slot, pickupCap, deliveryCap, daysAhead int
+		rows.Scan(&id, &name, &tz, &cur, &taxRate, &status, &inclusive, &rounding, &defaultTaxCat, &stockPolicy,
+			&prep, &slot, &pickupCap, &deliveryCap, &daysAhead)
+		locs = append(locs, gin.H{
+			"id": id, "name": name, "timezone": tz, "currency": cur, "taxRate": taxRate, "status": status,
+			"pricesIncludeTax": inclusive, "taxRounding": rounding, "defaultTaxCategoryId": defaultTaxCat,
+			"stockPolicy": stockPolicy, "prepTimeMinutes": prep, "slotMinutes": slot,
+			"pickupSlotCapacity": pickupCap, "deliverySlotCapacity": deliveryCap, "scheduleDaysAhead": daysAhead,
+		})
 	}
 	c.JSON(200, gin.H{"locations": locs, "total": len(locs)})
 }
@@ -647,22 +934,16 @@ func listLocations(c *gin.Context) {
 func createOrder(c *gin.Context) {
 	tenantID := c.GetString("tenantId")
 	var req struct {
-		LocationID string `json:"locationId"`
-		OrderType  string `json:"orderType"`
-		Items      []struct {
-			ProductID string  `json:"productId" binding:"required"`
-			Quantity  float64 `json:"quantity" binding:"required"`
-			Notes     string  `json:"notes"`
-			Modifiers []struct {
-				GroupID   string  `json:"groupId"`
-				GroupName string  `json:"groupName"`
-				ItemID    string  `json:"itemId"`
-				ItemName  string  `json:"itemName"`
-				Price     float64 `json:"priceAdjustment"`
-			} `json:"modifiers"`
-		} `json:"items" binding:"required,min=1"`
-		CustomerID string `json:"customerId"`
-		Notes      string `json:"notes"`
+		LocationID       string             `json:"locationId"`
+		OrderType        string             `json:"orderType"`
+		Channel          string             `json:"channel"`
+		Items            []orderItemRequest `json:"items" binding:"required,min=1"`
+		CustomerID       string             `json:"customerId"`
+		Notes            string             `json:"notes"`
+		PromoCodes       []string           `json:"promoCodes"`
+		AddressID        string             `json:"addressId"`
+		DeliveryLocation *geo.Point         `json:"deliveryLocation"`
+		ScheduledFor     *time.Time         `json:"scheduledFor"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(400, gin.H{"error": err.Error()})
@@ -671,6 +952,29 @@ func createOrder(c *gin.Context) {
 	if req.OrderType == "" {
 		req.OrderType = "pickup"
 	}
+	// Delivery orders go out from the location whose zone takes in the
+	// address, and pay that zone's fee.
+	var zone *deliveryZone
+	var dropoff geo.Point
+	if req.OrderType == "delivery" {
+		var err error
+		if dropoff, err = deliveryPoint(tenantID, req.AddressID, req.DeliveryLocation); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		var zoned bool
+		if zone, zoned, err = quoteDelivery(tenantID, req.LocationID, dropoff); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		if zone == nil && zoned {
+			c.JSON(400, gin.H{"error": "The delivery address is outside every delivery zone"})
+			return
+		}
+		if zone != nil {
+			req.LocationID = zone.locationID
+		}
+	}
 	if req.LocationID == "" {
 		db.QueryRow("SELECT id FROM locations WHERE tenant_id = $1 AND status = 'active' LIMIT 1", tenantID).Scan(&req.LocationID)
 		if req.LocationID == "" {
@@ -678,39 +982,125 @@ func createOrder(c *gin.Context) {
 		}
 	}
 
-	var subtotal, taxTotal float64
-	type itemCalc struct {
-		productID     string
-		name          string
-		price         float64
-		taxRate       float64
-		quantity      float64
-		notes         string
-		modifiers     string
-		modifierTotal float64
+	channel, err := orderChannel(tenantID, c.GetString("userId"), req.OrderType, req.Channel)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
 	}
-	var items []itemCalc
-	for _, item := range req.Items {
-		var name string
-		var price, taxRate float64
-		err := db.QueryRow("SELECT name, price, COALESCE(tax_rate,0) FROM products WHERE id = $1 AND tenant_id = $2", item.ProductID, tenantID).
-			Scan(&name, &price, &taxRate)
-		if err != nil {
-			c.JSON(400, gin.H{"error": fmt.Sprintf("Product %s not found", item.ProductID)})
+	req.Channel = channel
+	// Orders from outside the store are refused while online ordering is
+	// paused. They, and pickup orders however they come in, are refused while
+	// the store is closed unless they are for a slot later.
+	if req.Channel != channelInStore || req.OrderType == "pickup" {
+		st, err := locationOpenStatus(tenantID, req.LocationID)
+		if err != nil && err != sql.ErrNoRows {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		if err == nil && st.paused && req.Channel != channelInStore {
+			c.JSON(409, gin.H{"error": "Online ordering is paused", "status": st.json()})
+			return
+		}
+		if err == nil && !st.open && req.ScheduledFor == nil {
+			c.JSON(409, gin.H{"error": "The store is closed", "status": st.json()})
+			return
+		}
+	}
+
+	// An order for later must be for a slot the location offers, and is
+	// priced from the menus that will be running then.
+	at := locationNow(tenantID, req.LocationID)
+	var release time.Time
+	if req.ScheduledFor != nil {
+		etaMinutes := 0
+		if zone != nil {
+			etaMinutes = zone.etaMinutes
+		}
+		var err error
+		if release, err = promisedSlot(tenantID, req.LocationID, req.OrderType, *req.ScheduledFor, etaMinutes); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
 			return
 		}
-		var modTotal float64
-		for _, mod := range item.Modifiers {
-			modTotal += mod.Price
+		at = req.ScheduledFor.In(at.Location())
+	}
+
+	taxCtx := loadTaxContext(tenantID, req.LocationID)
+	prices, err := loadPriceContext(tenantID, req.LocationID, req.Channel, at)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	items, err := priceOrderItems(tenantID, taxCtx, prices, req.Items)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	var subtotal money.Amount
+	cart := promotion.Cart{Codes: req.PromoCodes, At: time.Now()}
+	for i, item := range items {
+		subtotal += item.unitPrice().Mul(item.quantity)
+		// A gift card is loaded with its full face value, so promotions
+		// leave it alone as they do the delivery fee.
+		if item.giftCard {
+			continue
 		}
-		lineTotal := (price + modTotal) * item.Quantity
-		lineTax := lineTotal * taxRate / 100
-		subtotal += lineTotal
-		taxTotal += lineTax
-		modJSON, _ := json.Marshal(item.Modifiers)
-		items = append(items, itemCalc{item.ProductID, name, price, taxRate, item.Quantity, item.Notes, string(modJSON), modTotal})
+		cart.Lines = append(cart.Lines, promotion.Line{
+			Key: strconv.Itoa(i), ProductID: item.productID, CategoryID: item.categoryID,
+			UnitPrice: item.unitPrice(), Quantity: item.quantity,
+		})
+	}
+	if zone != nil && subtotal < zone.minimumOrder {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("Delivery orders to this address must be at least %s", zone.minimumOrder), "minimumOrder": zone.minimumOrder})
+		return
 	}
-	total := subtotal + taxTotal
+
+	if req.CustomerID != "" {
+		db.QueryRow("SELECT COALESCE(loyalty_tier, '') FROM customers WHERE id = $1 AND tenant_id = $2", req.CustomerID, tenantID).Scan(&cart.CustomerTier)
+	}
+	promos, err := loadPromotions(db, tenantID, true)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	discounts := promotion.Apply(cart, promos)
+	if len(discounts.Rejected) > 0 {
+		rejected := []gin.H{}
+		for _, r := range discounts.Rejected {
+			rejected = append(rejected, gin.H{"code": r.Code, "reason": r.Reason})
+		}
+		c.JSON(400, gin.H{"error": "Promo code could not be applied", "rejectedCodes": rejected})
+		return
+	}
+	// The fee is its own line, added after promotions so none discount it.
+	if zone != nil && zone.fee > 0 {
+		fee, err := deliveryFeeLine(tenantID, taxCtx, zone.fee)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		items = append(items, fee)
+		subtotal += zone.fee
+	}
+
+	// Tax is charged on what the customer pays after discounts.
+	var taxLines []tax.Line
+	for i, item := range items {
+		key := strconv.Itoa(i)
+		taxLines = append(taxLines, tax.Line{
+			Key:      key,
+			Amount:   item.unitPrice().Mul(item.quantity) - discounts.LineDiscounts[key],
+			Category: item.taxCategory,
+		})
+	}
+	taxes := tax.Calculate(taxLines, taxCtx.settings)
+	discountTotal := discounts.Total
+	taxTotal := taxes.Tax
+	total := taxes.Gross
+	if taxCtx.settings.PricesIncludeTax {
+		// Shelf prices already carry the tax, so the subtotal is backed out.
+		subtotal = total + discountTotal - taxTotal
+	}
+	currency := currencyFor(tenantID, req.LocationID)
 
 	orderID := uuid.New().String()
 	orderNum := fmt.Sprintf("ORD-%s-%s", time.Now().Format("20060102150405"), uuid.New().String()[:4])
@@ -726,46 +1116,120 @@ func createOrder(c *gin.Context) {
 		customerID = &req.CustomerID
 	}
 	_, err = tx.Exec(
-		`INSERT INTO orders (id, tenant_id, location_id, order_number, status, order_type, customer_id, subtotal, tax_amount, total, currency, notes)
-		 VALUES ($1, $2, $3, $4, 'pending', $5, $6, $7, $8, $9, 'SAR', $10)`,
-		orderID, tenantID, req.LocationID, orderNum, req.OrderType, customerID, subtotal, taxTotal, total, req.Notes,
+		`INSERT INTO orders (id, tenant_id, location_id, order_number, status, order_type, customer_id, subtotal, discount_amount, tax_amount, total, currency, notes, prices_include_tax, tax_rounding,
+		        channel)
+		 VALUES ($1, $2, $3, $4, 'pending', $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)`,
+		orderID, tenantID, req.LocationID, orderNum, req.OrderType, customerID, subtotal, discountTotal, taxTotal, total, currency, req.Notes,
+		taxCtx.settings.PricesIncludeTax, taxCtx.settings.Rounding, req.Channel,
 	)
+	if err == nil && zone != nil {
+		err = saveOrderDelivery(tx, orderID, zone, dropoff, req.AddressID)
+	}
 	if err != nil {
 		tx.Rollback()
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
+	if req.ScheduledFor != nil {
+		if err := reserveSlot(tx, tenantID, req.LocationID, orderID, req.OrderType, *req.ScheduledFor, release); err != nil {
+			tx.Rollback()
+			c.JSON(409, gin.H{"error": err.Error()})
+			return
+		}
+	}
 
 	orderItems := []gin.H{}
-	for _, item := range items {
+	lineItems := map[string]string{}
+	giftCards := []gin.H{}
+	for i, item := range items {
 		itemID := uuid.New().String()
-		unitPrice := item.price + item.modifierTotal
-		itemTax := unitPrice * item.quantity * item.taxRate / 100
-		itemTotal := unitPrice*item.quantity + itemTax
-		_, err = tx.Exec(
-			`INSERT INTO order_items (id, tenant_id, order_id, product_id, name, quantity, unit_price, tax_amount, total_price, notes, modifiers)
-			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
-			itemID, tenantID, orderID, item.productID, item.name, item.quantity, unitPrice, itemTax, itemTotal, item.notes, item.modifiers,
-		)
-		if err != nil {
+		lineItems[strconv.Itoa(i)] = itemID
+		unitPrice := item.unitPrice()
+		itemDiscount := discounts.LineDiscounts[strconv.Itoa(i)]
+		lineTax := taxes.Lines[strconv.Itoa(i)]
+		itemTax, itemTotal := lineTax.Tax, lineTax.Gross
+		if err := insertOrderItem(tx, tenantID, orderID, itemID, item, itemDiscount, lineTax); err != nil {
 			tx.Rollback()
 			c.JSON(500, gin.H{"error": err.Error()})
 			return
 		}
 		orderItems = append(orderItems, gin.H{
-			"id": itemID, "productId": item.productID, "name": item.name,
-			"quantity": item.quantity, "unitPrice": unitPrice, "taxAmount": itemTax, "totalPrice": itemTotal,
+			"id": itemID, "productId": item.productID, "variantId": item.variantID, "name": item.name,
+			"quantity": item.quantity, "unitPrice": unitPrice, "discountAmount": itemDiscount,
+			"taxAmount": itemTax, "totalPrice": itemTotal,
 		})
+		cards, err := openOrderGiftCards(tx, tenantID, orderID, currency, item, actorFromContext(c))
+		if err != nil {
+			tx.Rollback()
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		giftCards = append(giftCards, cards...)
+	}
+
+	if err := routeOrderItems(tx, tenantID, orderID); err != nil {
+		tx.Rollback()
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err := saveOrderTaxSummary(tx, tenantID, orderID, taxes.Summary); err != nil {
+		tx.Rollback()
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err := redeemPromotions(tx, tenantID, orderID, discounts.Applied, lineItems); err != nil {
+		tx.Rollback()
+		c.JSON(409, gin.H{"error": err.Error()})
+		return
+	}
+	if err := recordOrderStatus(tx, tenantID, orderID, "", orderPending, actorFromContext(c), ""); err != nil {
+		tx.Rollback()
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err := recordOrderEvent(tx, tenantID, orderID, orderEventCreated, gin.H{
+		"orderType": req.OrderType, "total": total, "currency": currency, "itemCount": len(items),
+	}); err != nil {
+		tx.Rollback()
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err := enqueueOrderEvent(tx, tenantID, orderID, eventOrderCreated, gin.H{"itemCount": len(items)}); err != nil {
+		tx.Rollback()
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	stockWarnings, err := deductOrderStock(tx, tenantID, req.LocationID, orderID, orderStockLines(items), actorFromContext(c))
+	if err != nil {
+		tx.Rollback()
+		if se, ok := err.(*stockShortageError); ok {
+			c.JSON(409, gin.H{"error": se.Error(), "shortages": se.shortages})
+			return
+		}
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
 	}
 
 	tx.Commit()
 
-	c.JSON(201, gin.H{
+	applied := []gin.H{}
+	for _, a := range discounts.Applied {
+		applied = append(applied, gin.H{"promotionId": a.Promotion.ID, "name": a.Promotion.Name, "code": a.Promotion.Code, "amount": a.Amount})
+	}
+	resp := gin.H{
 		"id": orderID, "orderNumber": orderNum, "status": "pending",
-		"orderType": req.OrderType, "locationId": req.LocationID,
-		"subtotal": subtotal, "taxAmount": taxTotal, "total": total, "currency": "SAR",
-		"items": orderItems,
-	})
+		"orderType": req.OrderType, "channel": req.Channel, "locationId": req.LocationID,
+		"subtotal": subtotal, "discountAmount": discountTotal, "taxAmount": taxTotal, "total": total, "currency": currency,
+		"items": orderItems, "promotions": applied, "taxSummary": taxes.Summary,
+		"pricesIncludeTax": taxCtx.settings.PricesIncludeTax, "stockWarnings": stockWarnings, "giftCards": giftCards,
+	}
+	if zone != nil {
+		resp["delivery"] = zone.quote()
+	}
+	if req.ScheduledFor != nil {
+		resp["scheduledFor"], resp["releaseAt"] = *req.ScheduledFor, release
+	}
+	c.JSON(201, resp)
 }
 
 func listOrders(c *gin.Context) {
@@ -776,7 +1240,7 @@ func listOrders(c *gin.Context) {
 	query := `SELECT o.id, o.order_number, o.status, o.order_type, o.subtotal, o.tax_amount,
 	           COALESCE(o.discount_amount, 0), o.total, o.currency, o.created_at,
 	           COALESCE(cu.first_name || ' ' || cu.last_name, ''),
-	           (SELECT COUNT(*) FROM order_items oi WHERE oi.order_id = o.id)
+	           (SELECT COUNT(*) FROM order_items oi WHERE oi.order_id = o.id AND oi.line_status <> 'voided')
 	           FROM orders o
 	           LEFT JOIN customers cu ON cu.id = o.customer_id
 	           WHERE o.tenant_id = $1`
@@ -804,7 +1268,7 @@ func listOrders(c *gin.Context) {
 	orders := []gin.H{}
 	for rows.Next() {
 		var id, num, st, ot, cur, customerName string
-		var sub, tax, disc, tot float64
+		var sub, tax, disc, tot money.Amount
 		var createdAt time.Time
 		var itemCount int
 		rows.Scan(&id, &num, &st, &ot, &sub, &tax, &disc, &tot, &cur, &createdAt, &customerName, &itemCount)
@@ -823,94 +1287,107 @@ func getOrder(c *gin.Context) {
 	id := c.Param("id")
 
 	var num, st, ot, cur, customerName string
-	var sub, tax, disc, tot float64
+	var sub, tax, disc, tot, refunded, paid money.Amount
+	var paymentStatus, tableID, channel, deliveryZoneID string
+	var deliveryFee money.Amount
+	var covers, deliveryEta int
+	var scheduledFor, releaseAt sql.NullTime
 	var createdAt time.Time
 	err := db.QueryRow(
 		`SELECT o.order_number, o.status, o.order_type, o.subtotal, o.tax_amount,
 		        COALESCE(o.discount_amount, 0), o.total, o.currency, o.created_at,
-		        COALESCE(cu.first_name || ' ' || cu.last_name, '')
+		        COALESCE(cu.first_name || ' ' || cu.last_name, ''), o.refunded_amount,
+		        o.paid_amount, o.payment_status, COALESCE(o.table_id::text, ''), COALESCE(o.covers, 0), o.channel,
+		        COALESCE(o.delivery_zone_id::text, ''), o.delivery_fee, COALESCE(o.delivery_eta_minutes, 0),
+		        o.scheduled_for, o.release_at
 		 FROM orders o
 		 LEFT JOIN customers cu ON cu.id = o.customer_id
 		 WHERE o.id = $1 AND o.tenant_id = $2`,
 		id, tenantID,
-	).Scan(&num, &st, &ot, &sub, &tax, &disc, &tot, &cur, &createdAt, &customerName)
+	).Scan(&num, &st, &ot, &sub, &tax, &disc, &tot, &cur, &createdAt, &customerName, &refunded, &paid, &paymentStatus, &tableID, &covers, &channel,
+		&deliveryZoneID, &deliveryFee, &deliveryEta, &scheduledFor, &releaseAt)
 	if err != nil {
 		c.JSON(404, gin.H{"error": "Order not found"})
 		return
 	}
 
-	rows, _ := db.Query("SELECT id, product_id, name, quantity, unit_price, tax_amount, total_price, COALESCE(modifiers, '[]') FROM order_items WHERE order_id = $1 AND tenant_id = $2", id, tenantID)
+	rows, _ := db.Query("SELECT id, product_id, COALESCE(variant_id::text, ''), name, quantity, unit_price, tax_amount, total_price, COALESCE(modifiers, '[]'), refunded_quantity, tax_rate, tax_kind, COALESCE(kitchen_status, ''), COALESCE(seat_number, 0), round, line_status FROM order_items WHERE order_id = $1 AND tenant_id = $2 ORDER BY round, created_at", id, tenantID)
 	defer rows.Close()
 	items := []gin.H{}
 	for rows.Next() {
-		var iid, pid, iname, mods string
-		var qty, up, itax, itot float64
-		rows.Scan(&iid, &pid, &iname, &qty, &up, &itax, &itot, &mods)
+		var iid, pid, vid, iname, mods, taxKind, kitchenStatus, lineStatus string
+		var qty, refundedQty, taxRate float64
+		var seat, round int
+		var up, itax, itot money.Amount
+		rows.Scan(&iid, &pid, &vid, &iname, &qty, &up, &itax, &itot, &mods, &refundedQty, &taxRate, &taxKind, &kitchenStatus, &seat, &round, &lineStatus)
 		var modifiers interface{}
 		json.Unmarshal([]byte(mods), &modifiers)
 		items = append(items, gin.H{
-			"id": iid, "productId": pid, "productName": iname, "name": iname, "quantity": qty,
+			"id": iid, "productId": pid, "variantId": vid, "productName": iname, "name": iname, "quantity": qty,
 			"unitPrice": up, "taxAmount": itax, "totalPrice": itot, "modifiers": modifiers,
+			"refundedQuantity": refundedQty, "taxRate": taxRate, "taxKind": taxKind, "kitchenStatus": kitchenStatus,
+			"seat": seat, "round": round, "lineStatus": lineStatus,
 		})
 	}
 
-	c.JSON(200, gin.H{
+	promotions := []gin.H{}
+	promoRows, _ := db.Query("SELECT promotion_id, name, COALESCE(code, ''), amount FROM order_promotions WHERE order_id = $1 AND tenant_id = $2 ORDER BY created_at", id, tenantID)
+	if promoRows != nil {
+		for promoRows.Next() {
+			var pid, pname, code string
+			var amount money.Amount
+			promoRows.Scan(&pid, &pname, &code, &amount)
+			promotions = append(promotions, gin.H{"promotionId": pid, "name": pname, "code": code, "amount": amount})
+		}
+		promoRows.Close()
+	}
+
+	order := gin.H{
 		"id": id, "orderNumber": num, "status": st, "orderType": ot,
 		"subtotal": sub, "taxAmount": tax, "discountAmount": disc,
-		"totalAmount": tot, "total": tot, "currency": cur,
-		"items": items, "createdAt": createdAt, "customerName": customerName,
-	})
+		"totalAmount": tot, "total": tot, "currency": cur, "refundedAmount": refunded,
+		"paidAmount": paid, "balanceDue": money.Max(tot-paid, 0), "paymentStatus": paymentStatus,
+		"items": items, "promotions": promotions, "taxSummary": orderTaxSummary(tenantID, id),
+		"createdAt": createdAt, "customerName": customerName, "tableId": tableID, "covers": covers, "channel": channel,
+		"deliveryZoneId": deliveryZoneID, "deliveryFee": deliveryFee, "deliveryEtaMinutes": deliveryEta,
+	}
+	if scheduledFor.Valid {
+		order["scheduledFor"], order["releaseAt"] = scheduledFor.Time, releaseAt.Time
+	}
+	c.JSON(200, order)
 }
 
 func updateOrderStatus(c *gin.Context) {
-	tenantID := c.GetString("tenantId")
-	id := c.Param("id")
 	var req struct {
-		Status string `json:"status" binding:"required"`
+		Status         string `json:"status" binding:"required"`
+		ExpectedStatus string `json:"expectedStatus"`
+		Reason         string `json:"reason"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
-	res, _ := db.Exec("UPDATE orders SET status = $1 WHERE id = $2 AND tenant_id = $3", req.Status, id, tenantID)
-	if n, _ := res.RowsAffected(); n == 0 {
-		c.JSON(404, gin.H{"error": "Order not found"})
+	if !isValidOrderStatus(req.Status) {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("Unknown order status %q", req.Status)})
 		return
 	}
-	c.JSON(200, gin.H{"message": "Status updated", "status": req.Status})
+	changeOrderStatus(c, req.Status, req.ExpectedStatus, req.Reason, "Status updated")
 }
 
 func acceptOrder(c *gin.Context) {
-	tenantID := c.GetString("tenantId")
-	id := c.Param("id")
-	res, _ := db.Exec("UPDATE orders SET status = 'accepted' WHERE id = $1 AND tenant_id = $2 AND status = 'pending'", id, tenantID)
-	if n, _ := res.RowsAffected(); n == 0 {
-		c.JSON(400, gin.H{"error": "Order not found or cannot be accepted"})
-		return
-	}
-	c.JSON(200, gin.H{"message": "Order accepted", "status": "accepted"})
+	changeOrderStatus(c, orderAccepted, "", "", "Order accepted")
 }
 
 func completeOrder(c *gin.Context) {
-	tenantID := c.GetString("tenantId")
-	id := c.Param("id")
-	res, _ := db.Exec("UPDATE orders SET status = 'completed', completed_at = NOW() WHERE id = $1 AND tenant_id = $2 AND status != 'completed'", id, tenantID)
-	if n, _ := res.RowsAffected(); n == 0 {
-		c.JSON(404, gin.H{"error": "Order not found or already completed"})
-		return
-	}
-	c.JSON(200, gin.H{"message": "Order completed", "status": "completed"})
+	changeOrderStatus(c, orderCompleted, "", "", "Order completed")
 }
 
 func cancelOrder(c *gin.Context) {
-	tenantID := c.GetString("tenantId")
-	id := c.Param("id")
-	res, _ := db.Exec("UPDATE orders SET status = 'cancelled' WHERE id = $1 AND tenant_id = $2 AND status IN ('pending','accepted')", id, tenantID)
-	if n, _ := res.RowsAffected(); n == 0 {
-		c.JSON(400, gin.H{"error": "Order not found or cannot be cancelled"})
-		return
+	var req struct {
+		Reason string `json:"reason"`
 	}
-	c.JSON(200, gin.H{"message": "Order cancelled", "status": "cancelled"})
+	c.ShouldBindJSON(&req)
+	changeOrderStatus(c, orderCancelled, "", req.Reason, "Order cancelled")
 }
 
 // ── Payments ────────────────────────────────────────────────
@@ -918,32 +1395,227 @@ func cancelOrder(c *gin.Context) {
 func processPayment(c *gin.Context) {
 	tenantID := c.GetString("tenantId")
 	var req struct {
-		OrderID string  `json:"orderId" binding:"required"`
-		Method  string  `json:"method" binding:"required"`
-		Amount  float64 `json:"amount" binding:"required"`
+		OrderID        string       `json:"orderId" binding:"required"`
+		Method         string       `json:"method" binding:"required"`
+		Amount         money.Amount `json:"amount"`
+		Tendered       money.Amount `json:"tendered"`
+		Reference      string       `json:"reference"`
+		IdempotencyKey string       `json:"idempotencyKey"`
+		GiftCardCode   string       `json:"giftCardCode"`
+		GiftCardPin    string       `json:"giftCardPin"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
+	if !tenderMethods[req.Method] {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("Unsupported payment method %q", req.Method)})
+		return
+	}
+	if key := c.GetHeader("Idempotency-Key"); key != "" {
+		req.IdempotencyKey = key
+	}
+	if req.Method == "cash" && req.Tendered == 0 {
+		req.Tendered = req.Amount
+	}
+	if req.Amount <= 0 && req.Tendered <= 0 {
+		c.JSON(400, gin.H{"error": "Payment amount must be positive"})
+		return
+	}
+	if req.Method == storedGiftCard && req.GiftCardCode == "" {
+		c.JSON(400, gin.H{"error": "giftCardCode is required for gift card payments"})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Transaction failed"})
+		return
+	}
+	defer tx.Rollback()
+
+	var status, currency, locationID string
+	var total, paid money.Amount
+	var customerID sql.NullString
+	err = tx.QueryRow(
+		`SELECT status, currency, total, paid_amount, customer_id, location_id
+		 FROM orders WHERE id = $1 AND tenant_id = $2 FOR UPDATE`, req.OrderID, tenantID,
+	).Scan(&status, &currency, &total, &paid, &customerID, &locationID)
+	if err == sql.ErrNoRows {
+		c.JSON(404, gin.H{"error": "Order not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	// A retried request with the same key returns the original payment.
+	if req.IdempotencyKey != "" {
+		var pid, porder, pmethod, pstatus string
+		var pamount, pchange money.Amount
+		err := tx.QueryRow(
+			`SELECT id, order_id, method, amount, change_amount, status FROM payments
+			 WHERE tenant_id = $1 AND idempotency_key = $2`, tenantID, req.IdempotencyKey,
+		).Scan(&pid, &porder, &pmethod, &pamount, &pchange, &pstatus)
+		if err == nil {
+			if porder != req.OrderID {
+				c.JSON(409, gin.H{"error": "Idempotency key was already used for another order"})
+				return
+			}
+			c.JSON(200, gin.H{
+				"id": pid, "orderId": porder, "method": pmethod, "amount": pamount, "changeDue": pchange,
+				"status": pstatus, "orderBalance": money.Max(total-paid, 0),
+				"orderPaymentStatus": paymentStatusFor(total, paid), "orderStatus": status, "replayed": true,
+			})
+			return
+		}
+	}
+
+	if status == orderCancelled || status == orderVoided || status == orderRefunded {
+		c.JSON(409, gin.H{"error": fmt.Sprintf("Cannot take payment on a %s order", status)})
+		return
+	}
+	balance := total - paid
+	if !balance.IsPositive() {
+		c.JSON(409, gin.H{"error": "Order is already fully paid"})
+		return
+	}
+
+	amount := req.Amount
+	var tendered *money.Amount
+	var change money.Amount
+	if req.Method == "cash" {
+		// Cash settles the amount asked for, or as much of the balance as
+		// was handed over, and the rest of what was handed over is change.
+		t := req.Tendered
+		tendered = &t
+		if amount > 0 {
+			amount = money.Min(amount, balance)
+		} else {
+			amount = money.Min(t, balance)
+		}
+		if t < amount {
+			c.JSON(400, gin.H{"error": fmt.Sprintf("Tendered %s does not cover %s", t, amount)})
+			return
+		}
+		change = t - amount
+	} else if amount > balance {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("Payment %s exceeds balance due %s", amount, balance)})
+		return
+	}
+
+	var points int
+	if req.Method == "loyalty_points" {
+		points = loyaltyPointsFor(amount)
+		if err := redeemLoyaltyPoints(tx, tenantID, customerID, points); err != nil {
+			if pe, ok := err.(paymentError); ok {
+				c.JSON(400, gin.H{"error": pe.Error()})
+				return
+			}
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+	}
 
 	id := uuid.New().String()
-	_, err := db.Exec(
-		"INSERT INTO payments (id, tenant_id, order_id, method, amount, currency, status) VALUES ($1,$2,$3,$4,$5,'SAR','completed')",
-		id, tenantID, req.OrderID, req.Method, req.Amount,
+	by := actorFromContext(c)
+	var giftCardID *string
+	if req.Method == storedGiftCard || req.Method == storedStoreCredit {
+		cardID, err := redeemStoredValue(tx, tenantID, req.Method, req.GiftCardCode, req.GiftCardPin, approvalDevice(c), customerID, currency, amount,
+			ledgerRef{orderID: req.OrderID, paymentID: id, by: by})
+		if err != nil {
+			if pe, ok := err.(paymentError); ok {
+				c.JSON(400, gin.H{"error": pe.Error()})
+				return
+			}
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		giftCardID = &cardID
+		if req.Method == storedGiftCard && req.Reference == "" {
+			req.Reference = "Gift card ending " + req.GiftCardCode[max(len(req.GiftCardCode)-4, 0):]
+		}
+	}
+
+	drawerID, err := drawerFor(tx, tenantID, locationID, c.GetHeader("X-Drawer-ID"))
+	if err != nil {
+		if pe, ok := err.(paymentError); ok {
+			c.JSON(409, gin.H{"error": pe.Error()})
+			return
+		}
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	var key, reference *string
+	if req.IdempotencyKey != "" {
+		key = &req.IdempotencyKey
+	}
+	if req.Reference != "" {
+		reference = &req.Reference
+	}
+	_, err = tx.Exec(
+		`INSERT INTO payments (id, tenant_id, order_id, method, amount, currency, status, reference, tendered_amount, change_amount, loyalty_points_used, idempotency_key, received_by, cash_drawer_id, gift_card_id)
+		 VALUES ($1, $2, $3, $4, $5, $6, 'completed', $7, $8, $9, $10, $11, $12, $13, $14)`,
+		id, tenantID, req.OrderID, req.Method, amount, currency, reference, tendered, change, points, key, by.idOrNil(), drawerID, giftCardID,
 	)
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(201, gin.H{"id": id, "orderId": req.OrderID, "method": req.Method, "amount": req.Amount, "status": "completed"})
+	paid += amount
+	paymentStatus := paymentStatusFor(total, paid)
+	if _, err := tx.Exec("UPDATE orders SET paid_amount = $1, payment_status = $2, updated_at = NOW() WHERE id = $3 AND tenant_id = $4",
+		paid, paymentStatus, req.OrderID, tenantID); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	paymentEvent := gin.H{
+		"paymentId": id, "orderId": req.OrderID, "locationId": locationID, "method": req.Method, "amount": amount,
+		"currency": currency, "orderPaidAmount": paid, "orderPaymentStatus": paymentStatus,
+	}
+	if customerID.Valid {
+		paymentEvent["customerId"] = customerID.String
+	}
+	if err := enqueueEvent(tx, tenantID, eventPaymentProcessed, "order", req.OrderID, paymentEvent); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if paymentStatus == paymentPaid {
+		if err := recordOrderEvent(tx, tenantID, req.OrderID, orderEventPaid, gin.H{
+			"paidAmount": paid, "total": total, "currency": currency, "method": req.Method,
+		}); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	// Fully paid orders complete straight away unless the kitchen still has them.
+	if paymentStatus == paymentPaid && completesOnPayment(tx, req.OrderID, status) {
+		if _, err := transitionOrder(tx, tenantID, req.OrderID, orderCompleted, "", by, "Fully paid"); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		status = orderCompleted
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(201, gin.H{
+		"id": id, "orderId": req.OrderID, "method": req.Method, "amount": amount, "currency": currency,
+		"tendered": tendered, "changeDue": change, "loyaltyPointsUsed": points, "status": "completed",
+		"orderBalance": total - paid, "orderPaymentStatus": paymentStatus, "orderStatus": status,
+	})
 }
 
 func getOrderPayments(c *gin.Context) {
 	tenantID := c.GetString("tenantId")
 	orderID := c.Param("orderId")
-	rows, err := db.Query("SELECT id, method, amount, currency, status, created_at FROM payments WHERE order_id = $1 AND tenant_id = $2", orderID, tenantID)
+	rows, err := db.Query("SELECT id, method, amount, refunded_amount, currency, status, created_at FROM payments WHERE order_id = $1 AND tenant_id = $2", orderID, tenantID)
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
@@ -952,10 +1624,10 @@ func getOrderPayments(c *gin.Context) {
 	payments := []gin.H{}
 	for rows.Next() {
 		var id, method, cur, st string
-		var amount float64
+		var amount, refunded money.Amount
 		var createdAt time.Time
-		rows.Scan(&id, &method, &amount, &cur, &st, &createdAt)
-		payments = append(payments, gin.H{"id": id, "method": method, "amount": amount, "currency": cur, "status": st, "createdAt": createdAt})
+		rows.Scan(&id, &method, &amount, &refunded, &cur, &st, &createdAt)
+		payments = append(payments, gin.H{"id": id, "method": method, "amount": amount, "refundedAmount": refunded, "currency": cur, "status": st, "createdAt": createdAt})
 	}
 	c.JSON(200, gin.H{"payments": payments, "total": len(payments)})
 }
@@ -974,7 +1646,7 @@ func listCustomers(c *gin.Context) {
 	for rows.Next() {
 		var id, fn, ln, email, phone string
 		var points, visits int
-		var spent float64
+		var spent money.Amount
 		rows.Scan(&id, &fn, &ln, &email, &phone, &points, &spent, &visits)
 		customers = append(customers, gin.H{"id": id, "firstName": fn, "lastName": ln, "email": email, "phone": phone, "loyaltyPoints": points, "totalSpent": spent, "visitCount": visits})
 	}
@@ -1266,7 +1938,7 @@ func getStoreInfo(c *gin.Context) {
 	var logoUrl, heroImageUrl, cuisineType sql.NullString
 	var ratingAvg float64
 	var ratingCount, deliveryMin, deliveryMax int
-	var deliveryFee, minOrder float64
+	var deliveryFee, minOrder money.Amount
 
 	err := db.QueryRow(
 		`SELECT name, slug, logo_url, hero_image_url, COALESCE(rating_average, 0), COALESCE(rating_count, 0),
@@ -1286,6 +1958,17 @@ func getStoreInfo(c *gin.Context) {
 		"deliveryFee": deliveryFee, "minimumOrder": minOrder,
 		"cuisineType": cuisineType.String, "isOpen": true,
 	}
+	// Opening hours are the location's, ?locationId or the first active one.
+	locationID := c.Query("locationId")
+	if locationID == "" {
+		db.QueryRow("SELECT id FROM locations WHERE tenant_id = $1 AND status = 'active' ORDER BY created_at LIMIT 1", tenantID).Scan(&locationID)
+	}
+	if st, err := locationOpenStatus(tenantID, locationID); err == nil {
+		for k, v := range st.json() {
+			store[k] = v
+		}
+		store["locationId"] = locationID
+	}
 	if logoUrl.Valid {
 		store["logoUrl"] = logoUrl.String
 	}
@@ -1300,11 +1983,14 @@ func getStoreInfo(c *gin.Context) {
 func listInventory(c *gin.Context) {
 	tenantID := c.GetString("tenantId")
 	rows, err := db.Query(
-		`SELECT i.id, i.product_id, p.name, i.location_id, l.name, i.quantity, i.low_stock_threshold
+		`SELECT i.id, i.product_id, p.name, COALESCE(i.variant_id::text, ''), COALESCE(v.name, ''), COALESCE(v.sku, p.sku, ''),
+		        i.location_id, l.name, i.quantity, i.low_stock_threshold
 		 FROM inventory i
 		 JOIN products p ON p.id = i.product_id
+		 LEFT JOIN product_variants v ON v.id = i.variant_id
 		 JOIN locations l ON l.id = i.location_id
-		 WHERE i.tenant_id = $1`, tenantID)
+		 WHERE i.tenant_id = $1
+		 ORDER BY p.name, v.sort_order, v.name`, tenantID)
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
@@ -1312,36 +1998,71 @@ func listInventory(c *gin.Context) {
 	defer rows.Close()
 	inv := []gin.H{}
 	for rows.Next() {
-		var id, pid, pname, lid, lname string
+		var id, pid, pname, vid, vname, sku, lid, lname string
 		var qty, threshold float64
-		rows.Scan(&id, &pid, &pname, &lid, &lname, &qty, &threshold)
-		inv = append(inv, gin.H{"id": id, "productId": pid, "productName": pname, "locationId": lid, "locationName": lname, "quantity": qty, "lowStockThreshold": threshold})
+		rows.Scan(&id, &pid, &pname, &vid, &vname, &sku, &lid, &lname, &qty, &threshold)
+		inv = append(inv, gin.H{"id": id, "productId": pid, "productName": pname, "variantId": vid, "variantName": vname, "sku": sku,
+			"locationId": lid, "locationName": lname, "quantity": qty, "lowStockThreshold": threshold})
 	}
 	c.JSON(200, gin.H{"inventory": inv, "total": len(inv)})
 }
 
+// updateInventory sets the on-hand quantity from a count. The difference is
+// booked to the stock ledger as an adjustment.
 func updateInventory(c *gin.Context) {
 	tenantID := c.GetString("tenantId")
 	productID := c.Param("productId")
 	var req struct {
-		LocationID string  `json:"locationId" binding:"required"`
-		Quantity   float64 `json:"quantity" binding:"required"`
+		LocationID string   `json:"locationId" binding:"required"`
+		VariantID  string   `json:"variantId"`
+		Quantity   *float64 `json:"quantity" binding:"required"`
+		Reason     string   `json:"reason"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
-	_, err := db.Exec(
-		`INSERT INTO inventory (id, tenant_id, product_id, location_id, quantity)
-		 VALUES ($1, $2, $3, $4, $5)
-		 ON CONFLICT (tenant_id, product_id, location_id) DO UPDATE SET quantity = $5`,
-		uuid.New().String(), tenantID, productID, req.LocationID, req.Quantity,
-	)
+	var variantID *string
+	if req.VariantID != "" {
+		var ok bool
+		db.QueryRow("SELECT EXISTS(SELECT 1 FROM product_variants WHERE id = $1 AND product_id = $2 AND tenant_id = $3)",
+			req.VariantID, productID, tenantID).Scan(&ok)
+		if !ok {
+			c.JSON(404, gin.H{"error": "Variant not found"})
+			return
+		}
+		variantID = &req.VariantID
+	}
+
+	tx, err := db.Begin()
 	if err != nil {
+		c.JSON(500, gin.H{"error": "Transaction failed"})
+		return
+	}
+	defer tx.Rollback()
+	var current float64
+	tx.QueryRow(
+		`SELECT quantity FROM inventory
+		 WHERE tenant_id = $1 AND product_id = $2 AND location_id = $3 AND variant_id::text IS NOT DISTINCT FROM $4
+		 FOR UPDATE`, tenantID, productID, req.LocationID, variantID).Scan(&current)
+	if delta := *req.Quantity - current; delta != 0 {
+		reason := req.Reason
+		if reason == "" {
+			reason = fmt.Sprintf("Stock set to %g", *req.Quantity)
+		}
+		if _, err := recordStockMovement(tx, tenantID, stockMovement{
+			productID: productID, variantID: variantID, locationID: req.LocationID, kind: stockAdjustment,
+			quantity: delta, reason: reason, by: actorFromContext(c),
+		}); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(200, gin.H{"message": "Inventory updated", "quantity": req.Quantity})
+	c.JSON(200, gin.H{"message": "Inventory updated", "quantity": *req.Quantity})
 }
 
 // ── Reports ─────────────────────────────────────────────────
@@ -1351,14 +2072,28 @@ func getDailySales(c *gin.Context) {
 	date := c.DefaultQuery("date", time.Now().Format("2006-01-02"))
 
 	var totalOrders int
-	var totalRevenue, totalTax float64
+	var totalRevenue, totalTax money.Amount
 	db.QueryRow(
 		`SELECT COUNT(*), COALESCE(SUM(total),0), COALESCE(SUM(tax_amount),0)
-		 FROM orders WHERE tenant_id = $1 AND DATE(created_at) = $2 AND status = 'completed'`,
+		 FROM orders WHERE tenant_id = $1 AND DATE(created_at) = $2 AND status IN ('completed', 'refunded')`,
 		tenantID, date,
 	).Scan(&totalOrders, &totalRevenue, &totalTax)
 
-	c.JSON(200, gin.H{"date": date, "totalOrders": totalOrders, "totalRevenue": totalRevenue, "totalTax": totalTax, "currency": "SAR"})
+	// Refunds count against the day they were issued, not the day of the sale.
+	var refundCount int
+	var totalRefunds, refundedTax money.Amount
+	db.QueryRow(
+		`SELECT COUNT(*), COALESCE(SUM(amount),0), COALESCE(SUM(tax_amount),0)
+		 FROM refunds WHERE tenant_id = $1 AND DATE(created_at) = $2`,
+		tenantID, date,
+	).Scan(&refundCount, &totalRefunds, &refundedTax)
+
+	c.JSON(200, gin.H{
+		"date": date, "totalOrders": totalOrders, "totalRevenue": totalRevenue, "totalTax": totalTax,
+		"refundCount": refundCount, "totalRefunds": totalRefunds,
+		"netRevenue": totalRevenue - totalRefunds, "netTax": totalTax - refundedTax,
+		"currency": currencyFor(tenantID, ""),
+	})
 }
 
 func getTopProducts(c *gin.Context) {
@@ -1367,7 +2102,8 @@ func getTopProducts(c *gin.Context) {
 		`SELECT oi.product_id, oi.name, SUM(oi.quantity) as qty, SUM(oi.total_price) as revenue
 		 FROM order_items oi
 		 JOIN orders o ON o.id = oi.order_id
-		 WHERE oi.tenant_id = $1 AND o.status = 'completed'
+		 JOIN products p ON p.id = oi.product_id
+		 WHERE oi.tenant_id = $1 AND o.status = 'completed' AND oi.line_status <> 'voided' AND p.product_type NOT IN ('service', 'gift_card')
 		 GROUP BY oi.product_id, oi.name
 		 ORDER BY revenue DESC LIMIT 10`, tenantID)
 	if err != nil {
@@ -1378,7 +2114,8 @@ func getTopProducts(c *gin.Context) {
 	products := []gin.H{}
 	for rows.Next() {
 		var pid, name string
-		var qty, revenue float64
+		var qty float64
+		var revenue money.Amount
 		rows.Scan(&pid, &name, &qty, &revenue)
 		products = append(products, gin.H{"productId": pid, "name": name, "quantitySold": qty, "revenue": revenue})
 	}
@@ -1389,6 +2126,7 @@ func getTopProducts(c *gin.Context) {
 
 func seedCafeMenu(c *gin.Context) {
 	tenantID := c.GetString("tenantId")
+	currency := currencyFor(tenantID, "")
 
 	// Ensure supporting tables exist
 	db.Exec(`CREATE TABLE IF NOT EXISTS app_banners (
@@ -1433,16 +2171,22 @@ func seedCafeMenu(c *gin.Context) {
 	db.Exec("CREATE INDEX IF NOT EXISTS idx_item_ratings_product ON item_ratings(product_id)")
 
 	// Clean existing data
+	if err := purgeStock(tenantID); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
 	db.Exec("DELETE FROM item_ratings WHERE tenant_id = $1", tenantID)
 	db.Exec("DELETE FROM product_modifier_groups WHERE product_id IN (SELECT id FROM products WHERE tenant_id = $1)", tenantID)
 	db.Exec("DELETE FROM modifier_items WHERE tenant_id = $1", tenantID)
 	db.Exec("DELETE FROM modifier_groups WHERE tenant_id = $1", tenantID)
+	db.Exec("DELETE FROM refunds WHERE tenant_id = $1", tenantID)
 	db.Exec("DELETE FROM order_items WHERE tenant_id = $1", tenantID)
 	db.Exec("DELETE FROM payments WHERE tenant_id = $1", tenantID)
 	db.Exec("DELETE FROM reviews WHERE tenant_id = $1", tenantID)
 	db.Exec("DELETE FROM orders WHERE tenant_id = $1", tenantID)
 	db.Exec("DELETE FROM products WHERE tenant_id = $1", tenantID)
 	db.Exec("DELETE FROM categories WHERE tenant_id = $1", tenantID)
+	db.Exec("DELETE FROM ingredients WHERE tenant_id = $1", tenantID)
 
 	// Update store info
 	db.Exec(`UPDATE tenants SET
@@ -1541,6 +2285,7 @@ func seedCafeMenu(c *gin.Context) {
 		{"Bottle Water 330ml", "ماء 330 مل", "Bottled water 330ml", "مياه معبأة 330 مل", "others", "", 0.50},
 	}
 
+	productIDs := map[string]string{}
 	drinkIDs := []string{}
 	dripBlackIDs := []string{} // V60 / Black Coffee products that need origin modifier
 	for _, p := range products {
@@ -1550,8 +2295,9 @@ func seedCafeMenu(c *gin.Context) {
 			sku = sku[:20]
 		}
 		db.Exec(`INSERT INTO products (id, tenant_id, category_id, sku, name, name_en, name_ar, description, description_en, description_ar, price, currency, tax_rate, product_type, is_active, image_url)
-			VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,'SAR',15,'simple',true,$12)`,
-			pid, tenantID, catMap[p.catSlug], sku, p.nameEn, p.nameEn, p.nameAr, p.descEn, p.descEn, p.descAr, p.price, p.image)
+			VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,15,'simple',true,$13)`,
+			pid, tenantID, catMap[p.catSlug], sku, p.nameEn, p.nameEn, p.nameAr, p.descEn, p.descEn, p.descAr, p.price, currency, p.image)
+		productIDs[p.nameEn] = pid
 		if p.catSlug == "hot-drinks" || p.catSlug == "cold-drinks" || p.catSlug == "drip-black-hot" || p.catSlug == "drip-black-cold" {
 			drinkIDs = append(drinkIDs, pid)
 		}
@@ -1566,41 +2312,64 @@ func seedCafeMenu(c *gin.Context) {
 	sugarID := uuid.New().String()
 	extrasID := uuid.New().String()
 	originID := uuid.New().String()
+	modifierIDs := map[string]string{}
 
 	db.Exec(`INSERT INTO modifier_groups (id, tenant_id, name, name_en, name_ar, display_name, display_name_en, display_name_ar, selection_type, min_selections, max_selections, is_required, sort_order)
 	         VALUES ($1, $2, 'Size', 'Size', 'الحجم', 'Choose Size', 'Choose Size', 'اختر الحجم', 'single', 1, 1, true, 1)`, sizeID, tenantID)
-	for i, s := range []struct{ en, ar string; p float64; d bool }{{"Small", "صغير", 0, true}, {"Medium", "وسط", 4, false}, {"Large", "كبير", 8, false}} {
+	for i, s := range []struct {
+		en, ar string
+		p      float64
+		d      bool
+	}{{"Small", "صغير", 0, true}, {"Medium", "وسط", 4, false}, {"Large", "كبير", 8, false}} {
+		modifierIDs[s.en] = uuid.New().String()
 		db.Exec(`INSERT INTO modifier_items (id, tenant_id, modifier_group_id, name, name_en, name_ar, price_adjustment, is_default, sort_order) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9)`,
-			uuid.New().String(), tenantID, sizeID, s.en, s.en, s.ar, s.p, s.d, i)
+			modifierIDs[s.en], tenantID, sizeID, s.en, s.en, s.ar, s.p, s.d, i)
 	}
 
 	db.Exec(`INSERT INTO modifier_groups (id, tenant_id, name, name_en, name_ar, display_name, display_name_en, display_name_ar, selection_type, min_selections, max_selections, is_required, sort_order)
 	         VALUES ($1, $2, 'Milk Type', 'Milk Type', 'نوع الحليب', 'Choose Milk', 'Choose Milk', 'اختر نوع الحليب', 'single', 1, 1, false, 2)`, milkID, tenantID)
-	for i, s := range []struct{ en, ar string; p float64; d bool }{{"Regular Milk", "حليب عادي", 0, true}, {"Oat Milk", "حليب الشوفان", 5, false}, {"Almond Milk", "حليب اللوز", 5, false}, {"Soy Milk", "حليب الصويا", 4, false}, {"Skim Milk", "حليب خالي الدسم", 0, false}} {
+	for i, s := range []struct {
+		en, ar string
+		p      float64
+		d      bool
+	}{{"Regular Milk", "حليب عادي", 0, true}, {"Oat Milk", "حليب الشوفان", 5, false}, {"Almond Milk", "حليب اللوز", 5, false}, {"Soy Milk", "حليب الصويا", 4, false}, {"Skim Milk", "حليب خالي الدسم", 0, false}} {
+		modifierIDs[s.en] = uuid.New().String()
 		db.Exec(`INSERT INTO modifier_items (id, tenant_id, modifier_group_id, name, name_en, name_ar, price_adjustment, is_default, sort_order) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9)`,
-			uuid.New().String(), tenantID, milkID, s.en, s.en, s.ar, s.p, s.d, i)
+			modifierIDs[s.en], tenantID, milkID, s.en, s.en, s.ar, s.p, s.d, i)
 	}
 
 	db.Exec(`INSERT INTO modifier_groups (id, tenant_id, name, name_en, name_ar, display_name, display_name_en, display_name_ar, selection_type, min_selections, max_selections, is_required, sort_order)
 	         VALUES ($1, $2, 'Sugar Level', 'Sugar Level', 'مستوى السكر', 'Sugar Preference', 'Sugar Preference', 'تفضيل السكر', 'single', 1, 1, false, 3)`, sugarID, tenantID)
-	for i, s := range []struct{ en, ar string; d bool }{{"Regular Sugar", "سكر عادي", true}, {"Less Sugar", "سكر أقل", false}, {"No Sugar", "بدون سكر", false}, {"Extra Sweet", "سكر زيادة", false}} {
+	for i, s := range []struct {
+		en, ar string
+		d      bool
+	}{{"Regular Sugar", "سكر عادي", true}, {"Less Sugar", "سكر أقل", false}, {"No Sugar", "بدون سكر", false}, {"Extra Sweet", "سكر زيادة", false}} {
+		modifierIDs[s.en] = uuid.New().String()
 		db.Exec(`INSERT INTO modifier_items (id, tenant_id, modifier_group_id, name, name_en, name_ar, price_adjustment, is_default, sort_order) VALUES ($1,$2,$3,$4,$5,$6,0,$7,$8)`,
-			uuid.New().String(), tenantID, sugarID, s.en, s.en, s.ar, s.d, i)
+			modifierIDs[s.en], tenantID, sugarID, s.en, s.en, s.ar, s.d, i)
 	}
 
 	db.Exec(`INSERT INTO modifier_groups (id, tenant_id, name, name_en, name_ar, display_name, display_name_en, display_name_ar, selection_type, min_selections, max_selections, is_required, sort_order)
 	         VALUES ($1, $2, 'Extras', 'Extras', 'إضافات', 'Add Extras', 'Add Extras', 'أضف إضافات', 'multiple', 0, 3, false, 4)`, extrasID, tenantID)
-	for i, s := range []struct{ en, ar string; p float64 }{{"Extra Shot Espresso", "شوت اسبريسو اضافي", 3}, {"Whipped Cream", "كريمة مخفوقة", 4}, {"Caramel Drizzle", "صوص كراميل", 3}, {"Vanilla Syrup", "شراب الفانيلا", 3}, {"Hazelnut Syrup", "شراب البندق", 3}} {
+	for i, s := range []struct {
+		en, ar string
+		p      float64
+	}{{"Extra Shot Espresso", "شوت اسبريسو اضافي", 3}, {"Whipped Cream", "كريمة مخفوقة", 4}, {"Caramel Drizzle", "صوص كراميل", 3}, {"Vanilla Syrup", "شراب الفانيلا", 3}, {"Hazelnut Syrup", "شراب البندق", 3}} {
+		modifierIDs[s.en] = uuid.New().String()
 		db.Exec(`INSERT INTO modifier_items (id, tenant_id, modifier_group_id, name, name_en, name_ar, price_adjustment, is_default, sort_order) VALUES ($1,$2,$3,$4,$5,$6,$7,false,$8)`,
-			uuid.New().String(), tenantID, extrasID, s.en, s.en, s.ar, s.p, i)
+			modifierIDs[s.en], tenantID, extrasID, s.en, s.en, s.ar, s.p, i)
 	}
 
 	// Coffee Origin modifier — required for drip & black products (Ethiopian, Brazilian, Colombian)
 	db.Exec(`INSERT INTO modifier_groups (id, tenant_id, name, name_en, name_ar, display_name, display_name_en, display_name_ar, selection_type, min_selections, max_selections, is_required, sort_order)
 	         VALUES ($1, $2, 'Coffee Origin', 'Coffee Origin', 'نوع الحبوب', 'Choose Origin', 'Choose Origin', 'اختر نوع الحبوب', 'single', 1, 1, true, 0)`, originID, tenantID)
-	for i, s := range []struct{ en, ar string; d bool }{{"Ethiopian", "إثيوبي", true}, {"Brazilian", "برازيلي", false}, {"Colombian", "كولومبي", false}} {
+	for i, s := range []struct {
+		en, ar string
+		d      bool
+	}{{"Ethiopian", "إثيوبي", true}, {"Brazilian", "برازيلي", false}, {"Colombian", "كولومبي", false}} {
+		modifierIDs[s.en] = uuid.New().String()
 		db.Exec(`INSERT INTO modifier_items (id, tenant_id, modifier_group_id, name, name_en, name_ar, price_adjustment, is_default, sort_order) VALUES ($1,$2,$3,$4,$5,$6,0,$7,$8)`,
-			uuid.New().String(), tenantID, originID, s.en, s.en, s.ar, s.d, i)
+			modifierIDs[s.en], tenantID, originID, s.en, s.en, s.ar, s.d, i)
 	}
 
 	// Link modifiers to all drink products
@@ -1616,7 +2385,14 @@ func seedCafeMenu(c *gin.Context) {
 		db.Exec("INSERT INTO product_modifier_groups (product_id, modifier_group_id, sort_order) VALUES ($1,$2,0) ON CONFLICT DO NOTHING", pid, originID)
 	}
 
-	c.JSON(200, gin.H{"message": "Sedav Coffee menu seeded successfully", "categories": len(cats), "products": len(products), "modifiers": 5})
+	ingredientCount, recipeCount, err := seedCafeRecipes(tenantID, productIDs, modifierIDs)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "Sedav Coffee menu seeded successfully", "categories": len(cats), "products": len(products), "modifiers": 5,
+		"ingredients": ingredientCount, "recipes": recipeCount})
 }
 
 func min(a, b int) int {
@@ -0,0 +1,779 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/berhot/products/commerce/pos-engine/internal/money"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// ── Menus & Price Lists ─────────────────────────────────────
+
+// Sales channels an order can come through. Menus and price lists can be
+// limited to some of them.
+const (
+	channelInStore  = "in_store"
+	channelOnline   = "online"
+	channelDelivery = "delivery"
+)
+
+func migrateMenus() {
+	db.Exec(`ALTER TABLE orders ADD COLUMN IF NOT EXISTS channel VARCHAR(20) NOT NULL DEFAULT 'in_store'`)
+
+	// A menu offers its categories and products at the locations and on the
+	// channels it names (none means all) during its schedule: on the given
+	// days of the week (0 is Sunday, none means every day) between its start
+	// and end times in the location's timezone. An end before the start runs
+	// past midnight.
+	db.Exec(`CREATE TABLE IF NOT EXISTS menus (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		tenant_id UUID NOT NULL,
+		name VARCHAR(100) NOT NULL,
+		name_en VARCHAR(100),
+		name_ar VARCHAR(100),
+		location_id UUID REFERENCES locations(id) ON DELETE CASCADE,
+		channels TEXT[] NOT NULL DEFAULT '{}',
+		days SMALLINT[] NOT NULL DEFAULT '{}',
+		start_time TIME,
+		end_time TIME,
+		sort_order INTEGER NOT NULL DEFAULT 0,
+		is_active BOOLEAN NOT NULL DEFAULT true,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`)
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_menus_tenant ON menus(tenant_id) WHERE is_active")
+	db.Exec(`CREATE TABLE IF NOT EXISTS menu_categories (
+		menu_id UUID NOT NULL REFERENCES menus(id) ON DELETE CASCADE,
+		category_id UUID NOT NULL REFERENCES categories(id) ON DELETE CASCADE,
+		sort_order INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (menu_id, category_id)
+	)`)
+	db.Exec(`CREATE TABLE IF NOT EXISTS menu_products (
+		menu_id UUID NOT NULL REFERENCES menus(id) ON DELETE CASCADE,
+		product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+		sort_order INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (menu_id, product_id)
+	)`)
+
+	// Price lists override product prices and modifier price adjustments.
+	// They are scoped and scheduled like menus; where several are running
+	// the highest priority wins.
+	db.Exec(`CREATE TABLE IF NOT EXISTS price_lists (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		tenant_id UUID NOT NULL,
+		name VARCHAR(100) NOT NULL,
+		location_id UUID REFERENCES locations(id) ON DELETE CASCADE,
+		channels TEXT[] NOT NULL DEFAULT '{}',
+		days SMALLINT[] NOT NULL DEFAULT '{}',
+		start_time TIME,
+		end_time TIME,
+		priority INTEGER NOT NULL DEFAULT 0,
+		is_active BOOLEAN NOT NULL DEFAULT true,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`)
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_price_lists_tenant ON price_lists(tenant_id) WHERE is_active")
+	db.Exec(`CREATE TABLE IF NOT EXISTS price_list_items (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		price_list_id UUID NOT NULL REFERENCES price_lists(id) ON DELETE CASCADE,
+		product_id UUID REFERENCES products(id) ON DELETE CASCADE,
+		modifier_item_id UUID REFERENCES modifier_items(id) ON DELETE CASCADE,
+		price DECIMAL(12,2) NOT NULL CHECK (price >= 0),
+		CHECK ((product_id IS NULL) <> (modifier_item_id IS NULL))
+	)`)
+	db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_price_list_items_product ON price_list_items(price_list_id, product_id) WHERE product_id IS NOT NULL")
+	db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_price_list_items_modifier ON price_list_items(price_list_id, modifier_item_id) WHERE modifier_item_id IS NOT NULL")
+}
+
+// tillRoles are the staff roles that ring orders up at the till.
+var tillRoles = []string{"tenant_owner", "tenant_admin", "manager", "staff", "cashier"}
+
+// isTillUser reports whether a user is active staff who works the till.
+func isTillUser(q queryer, tenantID, userID string) bool {
+	if _, err := uuid.Parse(userID); err != nil {
+		return false
+	}
+	var ok bool
+	q.QueryRow(
+		`SELECT EXISTS(SELECT 1 FROM users WHERE id = $1 AND tenant_id = $2 AND status = 'active' AND role = ANY($3))`,
+		userID, tenantID, pq.Array(tillRoles)).Scan(&ok)
+	return ok
+}
+
+// orderChannel is the channel an order comes through, worked out from who
+// places it rather than taken from the client. Staff at the till order in
+// store unless they name another channel; anyone else, the customer app
+// included, orders online, or for delivery.
+func orderChannel(tenantID, userID, orderType, asked string) (string, error) {
+	if !isTillUser(db, tenantID, userID) {
+		if orderType == "delivery" {
+			return channelDelivery, nil
+		}
+		return channelOnline, nil
+	}
+	if asked == "" {
+		return channelFor(orderType), nil
+	}
+	if !validChannel(asked) {
+		return "", fmt.Errorf("Unknown channel %q", asked)
+	}
+	return asked, nil
+}
+
+// channelFor is the channel staff take an order of the given type through
+// when they do not say.
+func channelFor(orderType string) string {
+	switch orderType {
+	case "delivery":
+		return channelDelivery
+	case "online":
+		return channelOnline
+	}
+	return channelInStore
+}
+
+func validChannel(channel string) bool {
+	return channel == channelInStore || channel == channelOnline || channel == channelDelivery
+}
+
+// locationNow is the time now in a location's timezone.
+func locationNow(tenantID, locationID string) time.Time {
+	return time.Now().In(locationZone(tenantID, locationID))
+}
+
+// locationZone is a location's timezone, or UTC when the location or its
+// zone is unknown.
+func locationZone(tenantID, locationID string) *time.Location {
+	var tz string
+	db.QueryRow("SELECT timezone FROM locations WHERE id::text = $1 AND tenant_id = $2", locationID, tenantID).Scan(&tz)
+	loc, err := time.LoadLocation(tz)
+	if err != nil || tz == "" {
+		return time.UTC
+	}
+	return loc
+}
+
+// schedule is the weekly window a menu or price list runs in.
+type schedule struct {
+	days       pq.Int64Array
+	start, end sql.NullString
+}
+
+// activeAt reports whether the schedule is running at t, read as local time.
+// Past midnight, a window that started the evening before still counts as
+// the earlier day's.
+func (s schedule) activeAt(t time.Time) bool {
+	onDay := func(d time.Weekday) bool {
+		if len(s.days) == 0 {
+			return true
+		}
+		for _, day := range s.days {
+			if time.Weekday(day) == d {
+				return true
+			}
+		}
+		return false
+	}
+	if !s.start.Valid || !s.end.Valid {
+		return onDay(t.Weekday())
+	}
+	now := t.Hour()*60 + t.Minute()
+	start, end := clockMinutes(s.start.String), clockMinutes(s.end.String)
+	if start < end {
+		return onDay(t.Weekday()) && now >= start && now < end
+	}
+	return (onDay(t.Weekday()) && now >= start) || (onDay((t.Weekday()+6)%7) && now < end)
+}
+
+// clockMinutes reads a TIME value such as "08:30:00" as minutes past midnight.
+func clockMinutes(s string) int {
+	var h, m int
+	fmt.Sscanf(s, "%d:%d", &h, &m)
+	return h*60 + m
+}
+
+// scheduleRequest is the scope and schedule shared by menus and price lists.
+type scheduleRequest struct {
+	LocationID *string   `json:"locationId"`
+	Channels   *[]string `json:"channels"`
+	Days       *[]int64  `json:"days"`
+	StartTime  *string   `json:"startTime"`
+	EndTime    *string   `json:"endTime"`
+}
+
+// validate checks the channels, days and times given, which are "HH:MM".
+// An empty start or end time clears it.
+func (r scheduleRequest) validate() error {
+	if r.Channels != nil {
+		for _, ch := range *r.Channels {
+			if !validChannel(ch) {
+				return fmt.Errorf("Unknown channel %q", ch)
+			}
+		}
+	}
+	if r.Days != nil {
+		for _, d := range *r.Days {
+			if d < 0 || d > 6 {
+				return fmt.Errorf("Days run from 0 (Sunday) to 6 (Saturday)")
+			}
+		}
+	}
+	for _, t := range []*string{r.StartTime, r.EndTime} {
+		if t == nil || *t == "" {
+			continue
+		}
+		if _, err := time.Parse("15:04", *t); err != nil {
+			return fmt.Errorf("Times are HH:MM, got %q", *t)
+		}
+	}
+	if (r.StartTime == nil || *r.StartTime == "") != (r.EndTime == nil || *r.EndTime == "") {
+		return fmt.Errorf("Give both startTime and endTime, or neither")
+	}
+	return nil
+}
+
+// priceContext is what a location sells on a channel at a moment: which
+// products are on a menu open now and what the running price lists charge.
+// With no menus set up for the location and channel everything is on sale.
+type priceContext struct {
+	restricted     bool
+	products       map[string]bool
+	categories     map[string]bool
+	menus          []gin.H
+	productPrices  map[string]money.Amount
+	modifierPrices map[string]money.Amount
+	priceLists     []gin.H
+}
+
+// onMenu reports whether a product can be sold now.
+func (p priceContext) onMenu(productID, categoryID string) bool {
+	return !p.restricted || p.products[productID] || (categoryID != "" && p.categories[categoryID])
+}
+
+// loadPriceContext finds the menus and price lists that apply to a location
+// and channel at the given local time.
+func loadPriceContext(tenantID, locationID, channel string, at time.Time) (priceContext, error) {
+	p := priceContext{
+		products: map[string]bool{}, categories: map[string]bool{}, menus: []gin.H{},
+		productPrices: map[string]money.Amount{}, modifierPrices: map[string]money.Amount{}, priceLists: []gin.H{},
+	}
+	const scope = `tenant_id = $1 AND is_active AND (location_id IS NULL OR location_id::text = $2)
+		   AND (cardinality(channels) = 0 OR $3 = ANY(channels))`
+
+	rows, err := db.Query(`SELECT id, name, days, start_time::text, end_time::text FROM menus WHERE `+scope+` ORDER BY sort_order, name`,
+		tenantID, locationID, channel)
+	if err != nil {
+		return p, err
+	}
+	var open []string
+	for rows.Next() {
+		var id, name string
+		var s schedule
+		if err := rows.Scan(&id, &name, &s.days, &s.start, &s.end); err != nil {
+			rows.Close()
+			return p, err
+		}
+		p.restricted = true
+		if s.activeAt(at) {
+			open = append(open, id)
+			p.menus = append(p.menus, gin.H{"id": id, "name": name})
+		}
+	}
+	rows.Close()
+	if len(open) > 0 {
+		rows, err := db.Query(
+			`SELECT 'product', product_id::text FROM menu_products WHERE menu_id = ANY($1::uuid[])
+			 UNION ALL
+			 SELECT 'category', category_id::text FROM menu_categories WHERE menu_id = ANY($1::uuid[])`, pq.Array(open))
+		if err != nil {
+			return p, err
+		}
+		for rows.Next() {
+			var kind, id string
+			rows.Scan(&kind, &id)
+			if kind == "product" {
+				p.products[id] = true
+			} else {
+				p.categories[id] = true
+			}
+		}
+		rows.Close()
+	}
+
+	rows, err = db.Query(
+		`SELECT id, name, days, start_time::text, end_time::text FROM price_lists WHERE `+scope+`
+		 ORDER BY priority DESC, (location_id IS NOT NULL) DESC, created_at`, tenantID, locationID, channel)
+	if err != nil {
+		return p, err
+	}
+	var running []string
+	for rows.Next() {
+		var id, name string
+		var s schedule
+		if err := rows.Scan(&id, &name, &s.days, &s.start, &s.end); err != nil {
+			rows.Close()
+			return p, err
+		}
+		if s.activeAt(at) {
+			running = append(running, id)
+			p.priceLists = append(p.priceLists, gin.H{"id": id, "name": name})
+		}
+	}
+	rows.Close()
+	// Lists are read in priority order, so the first price seen for an item
+	// is the one that applies.
+	for _, id := range running {
+		rows, err := db.Query(
+			`SELECT COALESCE(product_id::text, ''), COALESCE(modifier_item_id::text, ''), price FROM price_list_items WHERE price_list_id = $1`, id)
+		if err != nil {
+			return p, err
+		}
+		for rows.Next() {
+			var productID, modifierID string
+			var price money.Amount
+			rows.Scan(&productID, &modifierID, &price)
+			if _, ok := p.productPrices[productID]; productID != "" && !ok {
+				p.productPrices[productID] = price
+			}
+			if _, ok := p.modifierPrices[modifierID]; modifierID != "" && !ok {
+				p.modifierPrices[modifierID] = price
+			}
+		}
+		rows.Close()
+	}
+	return p, nil
+}
+
+// scheduleFields reads a menu or price list's scope and schedule for a response.
+func scheduleFields(locationID sql.NullString, channels pq.StringArray, days pq.Int64Array, start, end sql.NullString) gin.H {
+	clock := func(t sql.NullString) string {
+		if !t.Valid || len(t.String) < 5 {
+			return ""
+		}
+		return t.String[:5]
+	}
+	return gin.H{
+		"locationId": locationID.String, "channels": []string(channels), "days": []int64(days),
+		"startTime": clock(start), "endTime": clock(end),
+	}
+}
+
+// ── Menus ───────────────────────────────────────────────────
+
+type menuRequest struct {
+	scheduleRequest
+	Name        *string   `json:"name"`
+	NameEn      *string   `json:"nameEn"`
+	NameAr      *string   `json:"nameAr"`
+	CategoryIDs *[]string `json:"categoryIds"`
+	ProductIDs  *[]string `json:"productIds"`
+	SortOrder   *int      `json:"sortOrder"`
+	IsActive    *bool     `json:"isActive"`
+}
+
+func listMenus(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	rows, err := db.Query(
+		`SELECT m.id, m.name, COALESCE(m.name_en, ''), COALESCE(m.name_ar, ''), m.location_id::text, m.channels, m.days,
+		        m.start_time::text, m.end_time::text, m.sort_order, m.is_active,
+		        COALESCE((SELECT array_agg(category_id::text ORDER BY sort_order) FROM menu_categories WHERE menu_id = m.id), '{}'),
+		        COALESCE((SELECT array_agg(product_id::text ORDER BY sort_order) FROM menu_products WHERE menu_id = m.id), '{}')
+		 FROM menus m WHERE m.tenant_id = $1 ORDER BY m.sort_order, m.name`, tenantID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	menus := []gin.H{}
+	for rows.Next() {
+		var id, name, nameEn, nameAr string
+		var locationID, start, end sql.NullString
+		var channels, categoryIDs, productIDs pq.StringArray
+		var days pq.Int64Array
+		var sortOrder int
+		var active bool
+		rows.Scan(&id, &name, &nameEn, &nameAr, &locationID, &channels, &days, &start, &end, &sortOrder, &active, &categoryIDs, &productIDs)
+		m := scheduleFields(locationID, channels, days, start, end)
+		m["id"], m["name"], m["nameEn"], m["nameAr"] = id, name, nameEn, nameAr
+		m["sortOrder"], m["isActive"] = sortOrder, active
+		m["categoryIds"], m["productIds"] = []string(categoryIDs), []string(productIDs)
+		menus = append(menus, m)
+	}
+	c.JSON(200, gin.H{"menus": menus, "total": len(menus)})
+}
+
+func createMenu(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	var req menuRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Name == nil || *req.Name == "" {
+		c.JSON(400, gin.H{"error": "name is required"})
+		return
+	}
+	if err := req.validate(); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Transaction failed"})
+		return
+	}
+	defer tx.Rollback()
+	id := uuid.New().String()
+	if _, err := tx.Exec(
+		`INSERT INTO menus (id, tenant_id, name, name_en, name_ar, sort_order) VALUES ($1, $2, $3, $4, $5, COALESCE($6, 0))`,
+		id, tenantID, *req.Name, req.NameEn, req.NameAr, req.SortOrder); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err := saveMenu(tx, tenantID, id, req); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(201, gin.H{"id": id, "name": *req.Name})
+}
+
+func updateMenu(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	id := c.Param("id")
+	var req menuRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if err := req.validate(); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Transaction failed"})
+		return
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(
+		`UPDATE menus SET name = COALESCE(NULLIF($1, ''), name), name_en = COALESCE($2, name_en), name_ar = COALESCE($3, name_ar),
+		        sort_order = COALESCE($4, sort_order), is_active = COALESCE($5, is_active), updated_at = NOW()
+		 WHERE id = $6 AND tenant_id = $7`, req.Name, req.NameEn, req.NameAr, req.SortOrder, req.IsActive, id, tenantID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(404, gin.H{"error": "Menu not found"})
+		return
+	}
+	if err := saveMenu(tx, tenantID, id, req); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Menu updated", "id": id})
+}
+
+// saveMenu writes the schedule and, when given, the categories and products
+// of a menu. Lists given replace the menu's; their order is the menu's order.
+func saveMenu(tx *sql.Tx, tenantID, id string, req menuRequest) error {
+	if err := saveSchedule(tx, "menus", id, req.scheduleRequest); err != nil {
+		return err
+	}
+	if req.CategoryIDs != nil {
+		if _, err := tx.Exec("DELETE FROM menu_categories WHERE menu_id = $1", id); err != nil {
+			return err
+		}
+		for i, categoryID := range *req.CategoryIDs {
+			if _, err := tx.Exec(
+				`INSERT INTO menu_categories (menu_id, category_id, sort_order)
+				 SELECT $1, id, $2 FROM categories WHERE id::text = $3 AND tenant_id = $4 ON CONFLICT DO NOTHING`,
+				id, i, categoryID, tenantID); err != nil {
+				return err
+			}
+		}
+	}
+	if req.ProductIDs != nil {
+		if _, err := tx.Exec("DELETE FROM menu_products WHERE menu_id = $1", id); err != nil {
+			return err
+		}
+		for i, productID := range *req.ProductIDs {
+			if _, err := tx.Exec(
+				`INSERT INTO menu_products (menu_id, product_id, sort_order)
+				 SELECT $1, id, $2 FROM products WHERE id::text = $3 AND tenant_id = $4 ON CONFLICT DO NOTHING`,
+				id, i, productID, tenantID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// saveSchedule writes the scope and schedule fields given on a menu or price
+// list. An empty locationId makes it apply to every location.
+func saveSchedule(tx *sql.Tx, table, id string, r scheduleRequest) error {
+	var channels, days interface{}
+	if r.Channels != nil {
+		channels = pq.Array(*r.Channels)
+	}
+	if r.Days != nil {
+		days = pq.Array(*r.Days)
+	}
+	_, err := tx.Exec(
+		`UPDATE `+table+` SET
+			location_id = CASE WHEN $1::text IS NULL THEN location_id ELSE NULLIF($1, '')::uuid END,
+			channels = COALESCE($2::text[], channels),
+			days = COALESCE($3::smallint[], days),
+			start_time = CASE WHEN $4::text IS NULL THEN start_time ELSE NULLIF($4, '')::time END,
+			end_time = CASE WHEN $5::text IS NULL THEN end_time ELSE NULLIF($5, '')::time END
+		 WHERE id = $6`, r.LocationID, channels, days, r.StartTime, r.EndTime, id)
+	return err
+}
+
+// ── Price Lists ─────────────────────────────────────────────
+
+type priceListRequest struct {
+	scheduleRequest
+	Name     *string `json:"name"`
+	Priority *int    `json:"priority"`
+	IsActive *bool   `json:"isActive"`
+}
+
+func listPriceLists(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	rows, err := db.Query(
+		`SELECT pl.id, pl.name, pl.location_id::text, pl.channels, pl.days, pl.start_time::text, pl.end_time::text, pl.priority, pl.is_active,
+		        (SELECT COUNT(*) FROM price_list_items WHERE price_list_id = pl.id)
+		 FROM price_lists pl WHERE pl.tenant_id = $1 ORDER BY pl.priority DESC, pl.name`, tenantID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	lists := []gin.H{}
+	for rows.Next() {
+		var id, name string
+		var locationID, start, end sql.NullString
+		var channels pq.StringArray
+		var days pq.Int64Array
+		var priority, itemCount int
+		var active bool
+		rows.Scan(&id, &name, &locationID, &channels, &days, &start, &end, &priority, &active, &itemCount)
+		l := scheduleFields(locationID, channels, days, start, end)
+		l["id"], l["name"], l["priority"], l["isActive"], l["itemCount"] = id, name, priority, active, itemCount
+		lists = append(lists, l)
+	}
+	c.JSON(200, gin.H{"priceLists": lists, "total": len(lists)})
+}
+
+func getPriceList(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	id := c.Param("id")
+	var name string
+	var locationID, start, end sql.NullString
+	var channels pq.StringArray
+	var days pq.Int64Array
+	var priority int
+	var active bool
+	err := db.QueryRow(
+		`SELECT name, location_id::text, channels, days, start_time::text, end_time::text, priority, is_active
+		 FROM price_lists WHERE id = $1 AND tenant_id = $2`, id, tenantID).
+		Scan(&name, &locationID, &channels, &days, &start, &end, &priority, &active)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Price list not found"})
+		return
+	}
+	rows, err := db.Query(
+		`SELECT COALESCE(pli.product_id::text, ''), COALESCE(pli.modifier_item_id::text, ''), COALESCE(p.name, mi.name), pli.price,
+		        COALESCE(p.price, mi.price_adjustment)
+		 FROM price_list_items pli
+		 LEFT JOIN products p ON p.id = pli.product_id
+		 LEFT JOIN modifier_items mi ON mi.id = pli.modifier_item_id
+		 WHERE pli.price_list_id = $1 ORDER BY 3`, id)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	items := []gin.H{}
+	for rows.Next() {
+		var productID, modifierID, itemName string
+		var price, base money.Amount
+		rows.Scan(&productID, &modifierID, &itemName, &price, &base)
+		items = append(items, gin.H{"productId": productID, "modifierItemId": modifierID, "name": itemName, "price": price, "basePrice": base})
+	}
+	l := scheduleFields(locationID, channels, days, start, end)
+	l["id"], l["name"], l["priority"], l["isActive"], l["items"] = id, name, priority, active, items
+	c.JSON(200, l)
+}
+
+func createPriceList(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	var req priceListRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Name == nil || *req.Name == "" {
+		c.JSON(400, gin.H{"error": "name is required"})
+		return
+	}
+	if err := req.validate(); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Transaction failed"})
+		return
+	}
+	defer tx.Rollback()
+	id := uuid.New().String()
+	if _, err := tx.Exec("INSERT INTO price_lists (id, tenant_id, name, priority) VALUES ($1, $2, $3, COALESCE($4, 0))",
+		id, tenantID, *req.Name, req.Priority); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err := saveSchedule(tx, "price_lists", id, req.scheduleRequest); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(201, gin.H{"id": id, "name": *req.Name})
+}
+
+func updatePriceList(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	id := c.Param("id")
+	var req priceListRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if err := req.validate(); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Transaction failed"})
+		return
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(
+		`UPDATE price_lists SET name = COALESCE(NULLIF($1, ''), name), priority = COALESCE($2, priority),
+		        is_active = COALESCE($3, is_active), updated_at = NOW()
+		 WHERE id = $4 AND tenant_id = $5`, req.Name, req.Priority, req.IsActive, id, tenantID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(404, gin.H{"error": "Price list not found"})
+		return
+	}
+	if err := saveSchedule(tx, "price_lists", id, req.scheduleRequest); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Price list updated", "id": id})
+}
+
+// setPriceListItems replaces the prices a list sets. Each item names a
+// product, whose base price it replaces, or a modifier item, whose price
+// adjustment it replaces.
+func setPriceListItems(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	id := c.Param("id")
+	var req struct {
+		Items []struct {
+			ProductID      string       `json:"productId"`
+			ModifierItemID string       `json:"modifierItemId"`
+			Price          money.Amount `json:"price"`
+		} `json:"items"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	var exists bool
+	db.QueryRow("SELECT EXISTS(SELECT 1 FROM price_lists WHERE id = $1 AND tenant_id = $2)", id, tenantID).Scan(&exists)
+	if !exists {
+		c.JSON(404, gin.H{"error": "Price list not found"})
+		return
+	}
+	seen := map[string]bool{}
+	for _, item := range req.Items {
+		if (item.ProductID == "") == (item.ModifierItemID == "") {
+			c.JSON(400, gin.H{"error": "Give either productId or modifierItemId on each item"})
+			return
+		}
+		if item.Price < 0 {
+			c.JSON(400, gin.H{"error": "Price cannot be negative"})
+			return
+		}
+		key, table := item.ProductID, "products"
+		if key == "" {
+			key, table = item.ModifierItemID, "modifier_items"
+		}
+		if seen[key] {
+			c.JSON(400, gin.H{"error": fmt.Sprintf("%s is listed twice", key)})
+			return
+		}
+		seen[key] = true
+		var known bool
+		db.QueryRow("SELECT EXISTS(SELECT 1 FROM "+table+" WHERE id::text = $1 AND tenant_id = $2)", key, tenantID).Scan(&known)
+		if !known {
+			c.JSON(404, gin.H{"error": fmt.Sprintf("%s not found", key)})
+			return
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Transaction failed"})
+		return
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM price_list_items WHERE price_list_id = $1", id); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	for _, item := range req.Items {
+		if _, err := tx.Exec(
+			`INSERT INTO price_list_items (price_list_id, product_id, modifier_item_id, price)
+			 VALUES ($1, NULLIF($2, '')::uuid, NULLIF($3, '')::uuid, $4)`,
+			id, item.ProductID, item.ModifierItemID, item.Price); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if _, err := tx.Exec("UPDATE price_lists SET updated_at = NOW() WHERE id = $1", id); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Price list items updated", "id": id, "items": len(req.Items)})
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/berhot/products/commerce/pos-engine/internal/money"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ── Open & Parked Orders ────────────────────────────────────
+
+func migrateOpenOrders() {
+	db.Exec(`ALTER TABLE orders ADD COLUMN IF NOT EXISTS tab_name VARCHAR(100)`)
+	db.Exec(`ALTER TABLE orders ADD COLUMN IF NOT EXISTS parked_at TIMESTAMPTZ`)
+	db.Exec(`ALTER TABLE orders ADD COLUMN IF NOT EXISTS parked_by UUID`)
+	db.Exec(`CREATE INDEX IF NOT EXISTS idx_orders_parked ON orders(tenant_id, location_id) WHERE parked_at IS NOT NULL`)
+}
+
+// insertEmptyOrder opens an order with no lines yet, taxed the way its
+// location taxes, and returns its id, number and currency.
+func insertEmptyOrder(tx *sql.Tx, tenantID, locationID, orderType, customerID, notes string) (string, string, string, error) {
+	taxCtx := loadTaxContext(tenantID, locationID)
+	currency := currencyFor(tenantID, locationID)
+	orderID := uuid.New().String()
+	orderNum := fmt.Sprintf("ORD-%s-%s", time.Now().Format("20060102150405"), uuid.New().String()[:4])
+	var customer *string
+	if customerID != "" {
+		customer = &customerID
+	}
+	_, err := tx.Exec(
+		`INSERT INTO orders (id, tenant_id, location_id, order_number, status, order_type, customer_id, subtotal, discount_amount, tax_amount, total, currency, notes,
+		        prices_include_tax, tax_rounding, channel)
+		 VALUES ($1, $2, $3, $4, 'pending', $5, $6, 0, 0, 0, 0, $7, $8, $9, $10, $11)`,
+		orderID, tenantID, locationID, orderNum, orderType, customer, currency, notes,
+		taxCtx.settings.PricesIncludeTax, taxCtx.settings.Rounding, channelFor(orderType))
+	return orderID, orderNum, currency, err
+}
+
+// openTab starts an order with no items for a customer who will keep
+// ordering, such as a bar tab. Items are added as rounds.
+func openTab(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	var req struct {
+		LocationID string `json:"locationId" binding:"required"`
+		OrderType  string `json:"orderType"`
+		Name       string `json:"name" binding:"required"`
+		CustomerID string `json:"customerId"`
+		Notes      string `json:"notes"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if req.OrderType == "" {
+		req.OrderType = "pickup"
+	}
+	var known bool
+	db.QueryRow("SELECT EXISTS(SELECT 1 FROM locations WHERE id = $1 AND tenant_id = $2)", req.LocationID, tenantID).Scan(&known)
+	if !known {
+		c.JSON(404, gin.H{"error": "Location not found"})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Transaction failed"})
+		return
+	}
+	defer tx.Rollback()
+	orderID, orderNum, currency, err := insertEmptyOrder(tx, tenantID, req.LocationID, req.OrderType, req.CustomerID, req.Notes)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := tx.Exec("UPDATE orders SET tab_name = $1 WHERE id = $2", req.Name, orderID); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err := recordNewOrder(tx, tenantID, orderID, actorFromContext(c), gin.H{"tabName": req.Name}); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(201, gin.H{
+		"id": orderID, "orderNumber": orderNum, "status": orderPending, "orderType": req.OrderType,
+		"locationId": req.LocationID, "tabName": req.Name, "currency": currency, "total": money.Amount(0),
+	})
+}
+
+// parkOrder puts an open order on hold so the till can serve someone else.
+// A name given here replaces the tab's name, to find it again by.
+func parkOrder(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	orderID := c.Param("id")
+	var req struct {
+		Name string `json:"name"`
+	}
+	c.ShouldBindJSON(&req)
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Transaction failed"})
+		return
+	}
+	defer tx.Rollback()
+	var status string
+	var parkedAt sql.NullTime
+	if err := tx.QueryRow("SELECT status, parked_at FROM orders WHERE id = $1 AND tenant_id = $2 FOR UPDATE", orderID, tenantID).
+		Scan(&status, &parkedAt); err != nil {
+		c.JSON(404, gin.H{"error": "Order not found"})
+		return
+	}
+	if !orderIsOpen(status) {
+		c.JSON(409, gin.H{"error": fmt.Sprintf("Cannot park a %s order", status), "status": status})
+		return
+	}
+	if parkedAt.Valid {
+		c.JSON(409, gin.H{"error": "Order is already parked", "parkedAt": parkedAt.Time})
+		return
+	}
+	by := actorFromContext(c)
+	if _, err := tx.Exec(
+		`UPDATE orders SET parked_at = NOW(), parked_by = $1, tab_name = COALESCE(NULLIF($2, ''), tab_name), updated_at = NOW()
+		 WHERE id = $3`, by.idOrNil(), req.Name, orderID); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err := recordOrderEvent(tx, tenantID, orderID, orderEventUpdated, gin.H{"change": "parked"}); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Order parked", "id": orderID})
+}
+
+// resumeOrder takes a parked order off hold.
+func resumeOrder(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	orderID := c.Param("id")
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Transaction failed"})
+		return
+	}
+	defer tx.Rollback()
+	var parkedAt sql.NullTime
+	if err := tx.QueryRow("SELECT parked_at FROM orders WHERE id = $1 AND tenant_id = $2 FOR UPDATE", orderID, tenantID).Scan(&parkedAt); err != nil {
+		c.JSON(404, gin.H{"error": "Order not found"})
+		return
+	}
+	if !parkedAt.Valid {
+		c.JSON(409, gin.H{"error": "Order is not parked"})
+		return
+	}
+	if _, err := tx.Exec("UPDATE orders SET parked_at = NULL, parked_by = NULL, updated_at = NOW() WHERE id = $1", orderID); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err := recordOrderEvent(tx, tenantID, orderID, orderEventUpdated, gin.H{"change": "resumed"}); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Order resumed", "id": orderID, "parkedFor": int(time.Since(parkedAt.Time).Seconds())})
+}
+
+// listOpenOrders lists the orders still open at a location, newest first:
+// tabs, parked sales and anything else not yet closed. parked=true limits
+// it to parked orders.
+func listOpenOrders(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	query := `SELECT o.id, o.order_number, o.status, o.order_type, COALESCE(o.tab_name, ''), o.total, o.paid_amount, o.currency,
+	                 o.parked_at, COALESCE(o.table_id::text, ''), o.created_at,
+	                 (SELECT COUNT(*) FROM order_items oi WHERE oi.order_id = o.id AND oi.line_status <> 'voided')
+	          FROM orders o WHERE o.tenant_id = $1 AND o.status IN ` + openOrderStatuses
+	args := []interface{}{tenantID}
+	if locationID := c.Query("locationId"); locationID != "" {
+		args = append(args, locationID)
+		query += fmt.Sprintf(" AND o.location_id = $%d", len(args))
+	}
+	if c.Query("parked") == "true" {
+		query += " AND o.parked_at IS NOT NULL"
+	}
+	rows, err := db.Query(query+" ORDER BY o.created_at DESC LIMIT 50", args...)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	orders := []gin.H{}
+	for rows.Next() {
+		var id, number, status, orderType, tabName, currency, tableID string
+		var total, paid money.Amount
+		var parkedAt sql.NullTime
+		var createdAt time.Time
+		var itemCount int
+		rows.Scan(&id, &number, &status, &orderType, &tabName, &total, &paid, &currency, &parkedAt, &tableID, &createdAt, &itemCount)
+		o := gin.H{
+			"id": id, "orderNumber": number, "status": status, "orderType": orderType, "tabName": tabName,
+			"total": total, "balanceDue": money.Max(total-paid, 0), "currency": currency, "tableId": tableID,
+			"parked": parkedAt.Valid, "itemCount": itemCount, "createdAt": createdAt,
+		}
+		if parkedAt.Valid {
+			o["parkedAt"] = parkedAt.Time
+		}
+		orders = append(orders, o)
+	}
+	c.JSON(200, gin.H{"orders": orders, "total": len(orders)})
+}
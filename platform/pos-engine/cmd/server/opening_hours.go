@@ -0,0 +1,501 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ── Opening Hours ───────────────────────────────────────────
+
+// openLookahead is how far ahead nextOpenAt is searched for; a location
+// closed for longer reports no next opening.
+const openLookahead = 31 * 24 * time.Hour
+
+func migrateOpeningHours() {
+	// Weekly opening hours. A day may have several shifts; one that closes
+	// at or before its opening time runs past midnight.
+	db.Exec(`CREATE TABLE IF NOT EXISTS location_hours (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		tenant_id UUID NOT NULL,
+		location_id UUID NOT NULL REFERENCES locations(id) ON DELETE CASCADE,
+		day_of_week SMALLINT NOT NULL CHECK (day_of_week BETWEEN 0 AND 6),
+		opens_at TIME NOT NULL,
+		closes_at TIME NOT NULL
+	)`)
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_location_hours_location ON location_hours(location_id, day_of_week)")
+
+	// Holidays and special hours replace the weekly hours on their date. A
+	// row without times closes the location for the day.
+	db.Exec(`CREATE TABLE IF NOT EXISTS location_special_hours (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		tenant_id UUID NOT NULL,
+		location_id UUID NOT NULL REFERENCES locations(id) ON DELETE CASCADE,
+		date DATE NOT NULL,
+		opens_at TIME,
+		closes_at TIME,
+		note VARCHAR(200) NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`)
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_location_special_hours_date ON location_special_hours(location_id, date)")
+
+	// Online ordering can be paused while the location stays open, until a
+	// time or, without one, until resumed.
+	db.Exec(`ALTER TABLE locations ADD COLUMN IF NOT EXISTS online_paused BOOLEAN NOT NULL DEFAULT false`)
+	db.Exec(`ALTER TABLE locations ADD COLUMN IF NOT EXISTS online_paused_until TIMESTAMPTZ`)
+	db.Exec(`ALTER TABLE locations ADD COLUMN IF NOT EXISTS online_paused_reason VARCHAR(200) NOT NULL DEFAULT ''`)
+}
+
+// openSpan is one stretch of time a location is open.
+type openSpan struct {
+	start, end time.Time
+}
+
+func (s openSpan) covers(from, to time.Time) bool {
+	return !from.Before(s.start) && !to.After(s.end)
+}
+
+// openingSpans lists the spans, in from's zone, in which a location is open
+// between from and to. Special hours replace the weekly hours on their date,
+// and spans that meet are joined, so one running through midnight is whole.
+// It reports false when the location has neither weekly nor special hours;
+// such a location is treated as always open. With only special hours, the
+// other days are open all day.
+func openingSpans(tenantID, locationID string, from, to time.Time) ([]openSpan, bool, error) {
+	type shift struct {
+		opens, closes int
+	}
+	weekly := map[time.Weekday][]shift{}
+	rows, err := db.Query(
+		"SELECT day_of_week, opens_at::text, closes_at::text FROM location_hours WHERE tenant_id = $1 AND location_id::text = $2",
+		tenantID, locationID)
+	if err != nil {
+		return nil, false, err
+	}
+	for rows.Next() {
+		var day time.Weekday
+		var opens, closes string
+		rows.Scan(&day, &opens, &closes)
+		weekly[day] = append(weekly[day], shift{clockMinutes(opens), clockMinutes(closes)})
+	}
+	rows.Close()
+
+	// A shift from the day before may still be running at from.
+	loc := from.Location()
+	first := time.Date(from.Year(), from.Month(), from.Day()-1, 0, 0, 0, 0, loc)
+	special := map[string][]shift{}
+	rows, err = db.Query(
+		`SELECT to_char(date, 'YYYY-MM-DD'), COALESCE(opens_at::text, ''), COALESCE(closes_at::text, '') FROM location_special_hours
+		 WHERE tenant_id = $1 AND location_id::text = $2 AND date BETWEEN $3 AND $4`,
+		tenantID, locationID, first.Format("2006-01-02"), to.Format("2006-01-02"))
+	if err != nil {
+		return nil, false, err
+	}
+	for rows.Next() {
+		var date, opens, closes string
+		rows.Scan(&date, &opens, &closes)
+		// A closed day is a date with no shifts.
+		shifts := special[date]
+		if opens != "" && closes != "" {
+			shifts = append(shifts, shift{clockMinutes(opens), clockMinutes(closes)})
+		}
+		special[date] = shifts
+	}
+	rows.Close()
+	if len(weekly) == 0 {
+		var anySpecial bool
+		db.QueryRow("SELECT EXISTS(SELECT 1 FROM location_special_hours WHERE tenant_id = $1 AND location_id::text = $2)",
+			tenantID, locationID).Scan(&anySpecial)
+		if !anySpecial {
+			return nil, false, nil
+		}
+	}
+
+	var spans []openSpan
+	for d := first; d.Before(to); d = d.AddDate(0, 0, 1) {
+		shifts, ok := special[d.Format("2006-01-02")]
+		if !ok {
+			shifts = weekly[d.Weekday()]
+			if len(weekly) == 0 {
+				shifts = []shift{{0, 0}}
+			}
+		}
+		for _, s := range shifts {
+			closes := s.closes
+			if closes <= s.opens {
+				closes += 24 * 60
+			}
+			spans = append(spans, openSpan{
+				start: time.Date(d.Year(), d.Month(), d.Day(), 0, s.opens, 0, 0, loc),
+				end:   time.Date(d.Year(), d.Month(), d.Day(), 0, closes, 0, 0, loc),
+			})
+		}
+	}
+
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start.Before(spans[j].start) })
+	var joined []openSpan
+	for _, span := range spans {
+		if n := len(joined); n > 0 && !span.start.After(joined[n-1].end) {
+			if span.end.After(joined[n-1].end) {
+				joined[n-1].end = span.end
+			}
+			continue
+		}
+		joined = append(joined, span)
+	}
+	var inRange []openSpan
+	for _, span := range joined {
+		if span.end.After(from) && span.start.Before(to) {
+			inRange = append(inRange, span)
+		}
+	}
+	return inRange, true, nil
+}
+
+// openStatus is whether a location is open at a moment and taking online
+// orders.
+type openStatus struct {
+	open                 bool
+	closesAt, nextOpenAt *time.Time
+	paused               bool
+	pausedUntil          *time.Time
+	pausedReason         string
+}
+
+// acceptsOnline reports whether online orders are taken now.
+func (s openStatus) acceptsOnline() bool {
+	return s.open && !s.paused
+}
+
+func (s openStatus) json() gin.H {
+	out := gin.H{"isOpen": s.open, "onlineOrdersPaused": s.paused, "acceptingOnlineOrders": s.acceptsOnline()}
+	if s.closesAt != nil {
+		out["closesAt"] = *s.closesAt
+	}
+	if s.nextOpenAt != nil {
+		out["nextOpenAt"] = *s.nextOpenAt
+	}
+	if s.paused {
+		out["pausedReason"] = s.pausedReason
+		if s.pausedUntil != nil {
+			out["pausedUntil"] = *s.pausedUntil
+		}
+	}
+	return out
+}
+
+// locationOpenStatus works out whether a location is open now, in its own
+// timezone, and when it next closes or opens.
+func locationOpenStatus(tenantID, locationID string) (openStatus, error) {
+	var st openStatus
+	var until sql.NullTime
+	err := db.QueryRow(
+		"SELECT online_paused, online_paused_until, online_paused_reason FROM locations WHERE id::text = $1 AND tenant_id = $2",
+		locationID, tenantID).Scan(&st.paused, &until, &st.pausedReason)
+	if err != nil {
+		return st, err
+	}
+	now := time.Now().In(locationZone(tenantID, locationID))
+	if until.Valid {
+		if until.Time.After(now) {
+			t := until.Time.In(now.Location())
+			st.pausedUntil = &t
+		} else {
+			st.paused = false
+		}
+	}
+
+	spans, hoursSet, err := openingSpans(tenantID, locationID, now, now.Add(openLookahead))
+	if err != nil {
+		return st, err
+	}
+	if !hoursSet {
+		st.open = true
+		return st, nil
+	}
+	for i := range spans {
+		span := spans[i]
+		if !now.Before(span.start) && now.Before(span.end) {
+			st.open, st.closesAt = true, &span.end
+			return st, nil
+		}
+		if span.start.After(now) {
+			st.nextOpenAt = &span.start
+			return st, nil
+		}
+	}
+	return st, nil
+}
+
+func getLocationHours(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	rows, err := db.Query(
+		`SELECT day_of_week, to_char(opens_at, 'HH24:MI'), to_char(closes_at, 'HH24:MI') FROM location_hours
+		 WHERE location_id::text = $1 AND tenant_id = $2 ORDER BY day_of_week, opens_at`, c.Param("id"), tenantID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	hours := []gin.H{}
+	for rows.Next() {
+		var day int
+		var opens, closes string
+		rows.Scan(&day, &opens, &closes)
+		hours = append(hours, gin.H{"day": day, "opensAt": opens, "closesAt": closes})
+	}
+	c.JSON(200, gin.H{"hours": hours})
+}
+
+// openingShift is one opening time to closing time, "HH:MM".
+type openingShift struct {
+	OpensAt  string `json:"opensAt"`
+	ClosesAt string `json:"closesAt"`
+}
+
+func (s openingShift) validate() error {
+	for _, t := range []string{s.OpensAt, s.ClosesAt} {
+		if _, err := time.Parse("15:04", t); err != nil {
+			return fmt.Errorf("Times are HH:MM, got %q", t)
+		}
+	}
+	return nil
+}
+
+// setLocationHours replaces a location's weekly hours. Days run from 0
+// (Sunday); a shift closing at or before it opens runs past midnight, and
+// 00:00 to 00:00 is open all day. No hours at all means always open.
+func setLocationHours(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	locationID := c.Param("id")
+	var req struct {
+		Hours []struct {
+			Day int `json:"day"`
+			openingShift
+		} `json:"hours"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	for _, h := range req.Hours {
+		if h.Day < 0 || h.Day > 6 {
+			c.JSON(400, gin.H{"error": "Days run from 0 (Sunday) to 6 (Saturday)"})
+			return
+		}
+		if err := h.validate(); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Transaction failed"})
+		return
+	}
+	defer tx.Rollback()
+	var known bool
+	tx.QueryRow("SELECT EXISTS(SELECT 1 FROM locations WHERE id::text = $1 AND tenant_id = $2)", locationID, tenantID).Scan(&known)
+	if !known {
+		c.JSON(404, gin.H{"error": "Location not found"})
+		return
+	}
+	if _, err := tx.Exec("DELETE FROM location_hours WHERE location_id = $1 AND tenant_id = $2", locationID, tenantID); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	for _, h := range req.Hours {
+		if _, err := tx.Exec(
+			"INSERT INTO location_hours (id, tenant_id, location_id, day_of_week, opens_at, closes_at) VALUES ($1, $2, $3, $4, $5, $6)",
+			uuid.New().String(), tenantID, locationID, h.Day, h.OpensAt, h.ClosesAt); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	tx.Commit()
+	c.JSON(200, gin.H{"message": "Opening hours updated", "shifts": len(req.Hours)})
+}
+
+// listSpecialHours lists a location's holidays and special hours from today,
+// or from ?from.
+func listSpecialHours(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	locationID := c.Param("id")
+	from := c.DefaultQuery("from", locationNow(tenantID, locationID).Format("2006-01-02"))
+	rows, err := db.Query(
+		`SELECT to_char(date, 'YYYY-MM-DD'), COALESCE(to_char(opens_at, 'HH24:MI'), ''), COALESCE(to_char(closes_at, 'HH24:MI'), ''), note
+		 FROM location_special_hours WHERE location_id::text = $1 AND tenant_id = $2 AND date >= $3
+		 ORDER BY date, opens_at`, locationID, tenantID, from)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	days := []gin.H{}
+	byDate := map[string]gin.H{}
+	for rows.Next() {
+		var date, opens, closes, note string
+		rows.Scan(&date, &opens, &closes, &note)
+		day, ok := byDate[date]
+		if !ok {
+			day = gin.H{"date": date, "closed": true, "hours": []openingShift{}, "note": note}
+			byDate[date] = day
+			days = append(days, day)
+		}
+		if opens != "" {
+			day["closed"] = false
+			day["hours"] = append(day["hours"].([]openingShift), openingShift{opens, closes})
+		}
+	}
+	c.JSON(200, gin.H{"specialHours": days})
+}
+
+// setSpecialHours sets the hours for one date, replacing the weekly hours
+// that day. Closed, or no hours given, shuts the location for the date.
+func setSpecialHours(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	locationID := c.Param("id")
+	var req struct {
+		Date   string         `json:"date" binding:"required"`
+		Closed bool           `json:"closed"`
+		Hours  []openingShift `json:"hours"`
+		Note   string         `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := time.Parse("2006-01-02", req.Date); err != nil {
+		c.JSON(400, gin.H{"error": "date must be YYYY-MM-DD"})
+		return
+	}
+	if req.Closed {
+		req.Hours = nil
+	}
+	for _, h := range req.Hours {
+		if err := h.validate(); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Transaction failed"})
+		return
+	}
+	defer tx.Rollback()
+	var known bool
+	tx.QueryRow("SELECT EXISTS(SELECT 1 FROM locations WHERE id::text = $1 AND tenant_id = $2)", locationID, tenantID).Scan(&known)
+	if !known {
+		c.JSON(404, gin.H{"error": "Location not found"})
+		return
+	}
+	if _, err := tx.Exec("DELETE FROM location_special_hours WHERE location_id = $1 AND tenant_id = $2 AND date = $3",
+		locationID, tenantID, req.Date); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.Hours) == 0 {
+		_, err = tx.Exec("INSERT INTO location_special_hours (id, tenant_id, location_id, date, note) VALUES ($1, $2, $3, $4, $5)",
+			uuid.New().String(), tenantID, locationID, req.Date, req.Note)
+	}
+	for _, h := range req.Hours {
+		if err != nil {
+			break
+		}
+		_, err = tx.Exec(
+			`INSERT INTO location_special_hours (id, tenant_id, location_id, date, opens_at, closes_at, note)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+			uuid.New().String(), tenantID, locationID, req.Date, h.OpensAt, h.ClosesAt, req.Note)
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	tx.Commit()
+	c.JSON(200, gin.H{"message": "Special hours set", "date": req.Date, "closed": len(req.Hours) == 0})
+}
+
+// deleteSpecialHours puts a date back on the weekly hours.
+func deleteSpecialHours(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	res, err := db.Exec("DELETE FROM location_special_hours WHERE location_id::text = $1 AND tenant_id = $2 AND date::text = $3",
+		c.Param("id"), tenantID, c.Param("date"))
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(404, gin.H{"error": "No special hours on that date"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Special hours removed"})
+}
+
+// setOnlineOrdering pauses or resumes online orders at a location. A pause
+// may run for a number of minutes, after which orders resume on their own.
+func setOnlineOrdering(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	var req struct {
+		Paused       *bool  `json:"paused" binding:"required"`
+		PauseMinutes int    `json:"pauseMinutes"`
+		Reason       string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if req.PauseMinutes < 0 {
+		c.JSON(400, gin.H{"error": "pauseMinutes cannot be negative"})
+		return
+	}
+	var until *time.Time
+	if *req.Paused && req.PauseMinutes > 0 {
+		t := time.Now().Add(time.Duration(req.PauseMinutes) * time.Minute)
+		until = &t
+	}
+	if !*req.Paused {
+		req.Reason = ""
+	}
+	res, err := db.Exec(
+		`UPDATE locations SET online_paused = $1, online_paused_until = $2, online_paused_reason = $3, updated_at = NOW()
+		 WHERE id::text = $4 AND tenant_id = $5`, *req.Paused, until, req.Reason, c.Param("id"), tenantID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(404, gin.H{"error": "Location not found"})
+		return
+	}
+	resp := gin.H{"message": "Online ordering updated", "paused": *req.Paused}
+	if until != nil {
+		resp["pausedUntil"] = *until
+	}
+	c.JSON(200, resp)
+}
+
+// getLocationStatus reports whether a location is open now and taking
+// online orders.
+func getLocationStatus(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	locationID := c.Param("id")
+	st, err := locationOpenStatus(tenantID, locationID)
+	if err == sql.ErrNoRows {
+		c.JSON(404, gin.H{"error": "Location not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	out := st.json()
+	out["locationId"], out["timezone"] = locationID, locationZone(tenantID, locationID).String()
+	c.JSON(200, out)
+}
@@ -0,0 +1,601 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/berhot/products/commerce/pos-engine/internal/money"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ── Voids & Comps ───────────────────────────────────────────
+
+// Line statuses. A voided line no longer counts towards the order; a comped
+// one is still served but given away, its price fully discounted. Neither is
+// deleted, so loss-prevention reports can see every one.
+const (
+	lineActive = "active"
+	lineVoided = "voided"
+	lineComped = "comped"
+)
+
+// Adjustment kinds, which are also the kinds of reason code.
+const (
+	adjustVoid = "void"
+	adjustComp = "comp"
+)
+
+// managerRoles may approve voids and comps.
+var managerRoles = []string{"tenant_owner", "tenant_admin", "manager"}
+
+var pinPattern = regexp.MustCompile(`^[0-9]{4,8}$`)
+
+func migrateOrderAdjustments() {
+	db.Exec(`ALTER TABLE order_items ADD COLUMN IF NOT EXISTS line_status VARCHAR(10) NOT NULL DEFAULT 'active'`)
+	db.Exec(`ALTER TABLE order_items DROP CONSTRAINT IF EXISTS order_items_line_status_check`)
+	db.Exec(`ALTER TABLE order_items ADD CONSTRAINT order_items_line_status_check CHECK (line_status IN ('active', 'voided', 'comped'))`)
+
+	db.Exec(`CREATE TABLE IF NOT EXISTS reason_codes (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		tenant_id UUID NOT NULL,
+		kind VARCHAR(10) NOT NULL CHECK (kind IN ('void', 'comp')),
+		code VARCHAR(50) NOT NULL,
+		name VARCHAR(255) NOT NULL,
+		requires_approval BOOLEAN NOT NULL DEFAULT true,
+		sort_order INTEGER NOT NULL DEFAULT 0,
+		is_active BOOLEAN NOT NULL DEFAULT true,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		UNIQUE (tenant_id, kind, code)
+	)`)
+	db.Exec(`CREATE TABLE IF NOT EXISTS manager_pins (
+		user_id UUID PRIMARY KEY,
+		tenant_id UUID NOT NULL,
+		pin_hash VARCHAR(100) NOT NULL,
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`)
+	// Failed PINs are counted per manager and per device until a lockout.
+	db.Exec(`CREATE TABLE IF NOT EXISTS pin_failures (
+		tenant_id UUID NOT NULL,
+		subject VARCHAR(255) NOT NULL,
+		failures INTEGER NOT NULL DEFAULT 0,
+		locked_until TIMESTAMPTZ,
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (tenant_id, subject)
+	)`)
+	db.Exec(`CREATE TABLE IF NOT EXISTS order_item_adjustments (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		tenant_id UUID NOT NULL,
+		location_id UUID,
+		order_id UUID NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+		order_item_id UUID NOT NULL,
+		kind VARCHAR(10) NOT NULL CHECK (kind IN ('void', 'comp')),
+		product_id UUID,
+		name VARCHAR(255) NOT NULL,
+		quantity DECIMAL(10,3) NOT NULL,
+		amount DECIMAL(12,2) NOT NULL,
+		reason_code VARCHAR(50) NOT NULL,
+		reason_name VARCHAR(255) NOT NULL DEFAULT '',
+		note TEXT NOT NULL DEFAULT '',
+		wasted BOOLEAN NOT NULL DEFAULT false,
+		requested_by UUID,
+		approved_by UUID,
+		source VARCHAR(50) NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`)
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_order_item_adjustments_tenant ON order_item_adjustments(tenant_id, created_at)")
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_order_item_adjustments_order ON order_item_adjustments(order_id)")
+}
+
+// checkNotOverpaid refuses a change that would leave an order owing less than
+// has already been paid on it; the difference has to be refunded first.
+func checkNotOverpaid(tx *sql.Tx, tenantID, orderID string) error {
+	var total, paid money.Amount
+	if err := tx.QueryRow("SELECT total, paid_amount FROM orders WHERE id = $1 AND tenant_id = $2", orderID, tenantID).Scan(&total, &paid); err != nil {
+		return err
+	}
+	if paid > total {
+		return &checkError{fmt.Sprintf("The order would total %s but %s has been paid; refund the difference first", total, paid)}
+	}
+	return nil
+}
+
+// ── Reason Codes ────────────────────────────────────────────
+
+func listReasonCodes(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	query := `SELECT id, kind, code, name, requires_approval, sort_order, is_active FROM reason_codes WHERE tenant_id = $1`
+	args := []interface{}{tenantID}
+	if kind := c.Query("kind"); kind != "" {
+		args = append(args, kind)
+		query += fmt.Sprintf(" AND kind = $%d", len(args))
+	}
+	rows, err := db.Query(query+" ORDER BY kind, sort_order, name", args...)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	codes := []gin.H{}
+	for rows.Next() {
+		var id, kind, code, name string
+		var approval, active bool
+		var sortOrder int
+		rows.Scan(&id, &kind, &code, &name, &approval, &sortOrder, &active)
+		codes = append(codes, gin.H{
+			"id": id, "kind": kind, "code": code, "name": name, "requiresApproval": approval,
+			"sortOrder": sortOrder, "isActive": active,
+		})
+	}
+	c.JSON(200, gin.H{"reasonCodes": codes, "total": len(codes)})
+}
+
+type reasonCodeRequest struct {
+	Kind             string `json:"kind"`
+	Code             string `json:"code"`
+	Name             string `json:"name"`
+	RequiresApproval *bool  `json:"requiresApproval"`
+	SortOrder        *int   `json:"sortOrder"`
+	IsActive         *bool  `json:"isActive"`
+}
+
+func createReasonCode(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	var req reasonCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Kind != adjustVoid && req.Kind != adjustComp {
+		c.JSON(400, gin.H{"error": "kind must be void or comp"})
+		return
+	}
+	if req.Code == "" || req.Name == "" {
+		c.JSON(400, gin.H{"error": "code and name are required"})
+		return
+	}
+	var taken bool
+	db.QueryRow("SELECT EXISTS(SELECT 1 FROM reason_codes WHERE tenant_id = $1 AND kind = $2 AND code = $3)",
+		tenantID, req.Kind, req.Code).Scan(&taken)
+	if taken {
+		c.JSON(409, gin.H{"error": fmt.Sprintf("A %s reason with this code already exists", req.Kind)})
+		return
+	}
+	approval := req.RequiresApproval == nil || *req.RequiresApproval
+	sortOrder := 0
+	if req.SortOrder != nil {
+		sortOrder = *req.SortOrder
+	}
+	id := uuid.New().String()
+	if _, err := db.Exec(
+		`INSERT INTO reason_codes (id, tenant_id, kind, code, name, requires_approval, sort_order) VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		id, tenantID, req.Kind, req.Code, req.Name, approval, sortOrder); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(201, gin.H{
+		"id": id, "kind": req.Kind, "code": req.Code, "name": req.Name, "requiresApproval": approval,
+		"sortOrder": sortOrder, "isActive": true,
+	})
+}
+
+// updateReasonCode edits a reason. Its kind and code are fixed, since past
+// adjustments are reported under them.
+func updateReasonCode(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	id := c.Param("id")
+	var req reasonCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	res, err := db.Exec(
+		`UPDATE reason_codes SET
+			name = COALESCE(NULLIF($1, ''), name),
+			requires_approval = COALESCE($2, requires_approval),
+			sort_order = COALESCE($3, sort_order),
+			is_active = COALESCE($4, is_active),
+			updated_at = NOW()
+		 WHERE id = $5 AND tenant_id = $6`,
+		req.Name, req.RequiresApproval, req.SortOrder, req.IsActive, id, tenantID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(404, gin.H{"error": "Reason code not found"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Reason code updated"})
+}
+
+// ── Manager Approval ────────────────────────────────────────
+
+// managerApproval is a manager vouching for an action at the till by
+// entering their PIN.
+type managerApproval struct {
+	ManagerID string `json:"managerId"`
+	Pin       string `json:"pin"`
+}
+
+type approvalError string
+
+func (e approvalError) Error() string { return string(e) }
+
+const (
+	errApprovalRequired = approvalError("This reason needs a manager's approval")
+	errApprovalInvalid  = approvalError("Manager ID or PIN is incorrect")
+	errApprovalLocked   = approvalError("Too many incorrect PINs; try again later")
+)
+
+const (
+	// pinMaxFailures wrong PINs in a row lock the manager or gift card, and
+	// the device they were tried from, out for pinLockout.
+	pinMaxFailures = 5
+	pinLockout     = 15 * time.Minute
+)
+
+// approvalDevice names the device a PIN is entered on: its X-Device-ID, or
+// its address when it sends none.
+func approvalDevice(c *gin.Context) string {
+	if d := c.GetHeader("X-Device-ID"); d != "" {
+		return d
+	}
+	return c.ClientIP()
+}
+
+// pinLocked reports whether any of subjects is locked out.
+func pinLocked(tenantID string, subjects ...string) bool {
+	var locked bool
+	db.QueryRow("SELECT EXISTS(SELECT 1 FROM pin_failures WHERE tenant_id = $1 AND subject = ANY($2) AND locked_until > NOW())",
+		tenantID, pq.Array(subjects)).Scan(&locked)
+	return locked
+}
+
+// recordPinFailure counts a wrong PIN against each of subjects, locking out
+// those that reach pinMaxFailures. It writes outside the caller's
+// transaction, which rolls back on the failure it reports.
+func recordPinFailure(tenantID string, subjects ...string) {
+	for _, s := range subjects {
+		db.Exec(
+			`INSERT INTO pin_failures (tenant_id, subject, failures) VALUES ($1, $2, 1)
+			 ON CONFLICT (tenant_id, subject) DO UPDATE SET failures = pin_failures.failures + 1, updated_at = NOW()`,
+			tenantID, s)
+	}
+	db.Exec(
+		`UPDATE pin_failures SET failures = 0, locked_until = NOW() + $3 * INTERVAL '1 second'
+		 WHERE tenant_id = $1 AND subject = ANY($2) AND failures >= $4`,
+		tenantID, pq.Array(subjects), pinLockout.Seconds(), pinMaxFailures)
+}
+
+// clearPinFailures forgets the wrong PINs counted against subjects.
+func clearPinFailures(tenantID string, subjects ...string) {
+	db.Exec("DELETE FROM pin_failures WHERE tenant_id = $1 AND subject = ANY($2)", tenantID, pq.Array(subjects))
+}
+
+// isManager reports whether a user is active and holds a manager role.
+func isManager(q queryer, tenantID, userID string) bool {
+	if _, err := uuid.Parse(userID); err != nil {
+		return false
+	}
+	var ok bool
+	q.QueryRow(
+		`SELECT EXISTS(SELECT 1 FROM users WHERE id = $1 AND tenant_id = $2 AND status = 'active' AND role = ANY($3))`,
+		userID, tenantID, pq.Array(managerRoles)).Scan(&ok)
+	return ok
+}
+
+// verifyManagerApproval checks a manager's PIN, entered on device, and
+// returns their id. Wrong managers and wrong PINs get the same answer, and
+// count towards locking out both the manager and the device.
+func verifyManagerApproval(q queryer, tenantID, device string, a *managerApproval) (string, error) {
+	if a == nil || a.ManagerID == "" || a.Pin == "" {
+		return "", errApprovalRequired
+	}
+	subjects := []string{"manager:" + a.ManagerID, "device:" + device}
+	if pinLocked(tenantID, subjects...) {
+		return "", errApprovalLocked
+	}
+	var hash string
+	if isManager(q, tenantID, a.ManagerID) {
+		q.QueryRow("SELECT pin_hash FROM manager_pins WHERE user_id = $1 AND tenant_id = $2", a.ManagerID, tenantID).Scan(&hash)
+	}
+	if hash == "" || bcrypt.CompareHashAndPassword([]byte(hash), []byte(a.Pin)) != nil {
+		recordPinFailure(tenantID, subjects...)
+		return "", errApprovalInvalid
+	}
+	clearPinFailures(tenantID, subjects...)
+	return a.ManagerID, nil
+}
+
+// setManagerPin lets a manager set the PIN they approve with. Only the
+// manager themselves can set it.
+func setManagerPin(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	userID := c.Param("id")
+	var req struct {
+		Pin string `json:"pin" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if c.GetString("userId") != userID {
+		c.JSON(403, gin.H{"error": "Managers set their own PIN"})
+		return
+	}
+	if !isManager(db, tenantID, userID) {
+		c.JSON(403, gin.H{"error": "Only managers can approve voids and comps"})
+		return
+	}
+	if !pinPattern.MatchString(req.Pin) {
+		c.JSON(400, gin.H{"error": "pin must be 4 to 8 digits"})
+		return
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.Pin), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := db.Exec(
+		`INSERT INTO manager_pins (user_id, tenant_id, pin_hash) VALUES ($1, $2, $3)
+		 ON CONFLICT (user_id) DO UPDATE SET pin_hash = EXCLUDED.pin_hash, updated_at = NOW()`,
+		userID, tenantID, string(hash)); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	clearPinFailures(tenantID, "manager:"+userID)
+	c.JSON(200, gin.H{"message": "PIN updated"})
+}
+
+// ── Line Voids & Comps ──────────────────────────────────────
+
+func voidOrderItem(c *gin.Context) {
+	adjustOrderItem(c, adjustVoid)
+}
+
+func compOrderItem(c *gin.Context) {
+	adjustOrderItem(c, adjustComp)
+}
+
+// adjustOrderItem voids or comps all or part of a line. A partial quantity is
+// split off into a line of its own first. Voiding something the kitchen has
+// not started puts its stock back; once started it is recorded as waste. The
+// order is re-totalled and the adjustment logged for reporting.
+func adjustOrderItem(c *gin.Context, kind string) {
+	tenantID := c.GetString("tenantId")
+	orderID := c.Param("id")
+	itemID := c.Param("itemId")
+	var req struct {
+		Quantity   float64          `json:"quantity"`
+		ReasonCode string           `json:"reasonCode" binding:"required"`
+		Note       string           `json:"note"`
+		Approval   *managerApproval `json:"approval"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Quantity < 0 {
+		c.JSON(400, gin.H{"error": "quantity must be positive"})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Transaction failed"})
+		return
+	}
+	defer tx.Rollback()
+	var status, locationID string
+	if err := tx.QueryRow("SELECT status, location_id FROM orders WHERE id = $1 AND tenant_id = $2 FOR UPDATE", orderID, tenantID).
+		Scan(&status, &locationID); err != nil {
+		c.JSON(404, gin.H{"error": "Order not found"})
+		return
+	}
+	if !orderIsOpen(status) {
+		c.JSON(409, gin.H{"error": fmt.Sprintf("Cannot %s items on a %s order", kind, status), "status": status})
+		return
+	}
+	var productID, name, lineStatus, kitchenStatus string
+	var qty float64
+	if err := tx.QueryRow(
+		`SELECT product_id, name, quantity, line_status, COALESCE(kitchen_status, '')
+		 FROM order_items WHERE id = $1 AND order_id = $2 AND tenant_id = $3 FOR UPDATE`, itemID, orderID, tenantID).
+		Scan(&productID, &name, &qty, &lineStatus, &kitchenStatus); err != nil {
+		c.JSON(404, gin.H{"error": "Item not found"})
+		return
+	}
+	if lineStatus != lineActive {
+		c.JSON(409, gin.H{"error": fmt.Sprintf("Line is already %s", lineStatus), "lineStatus": lineStatus})
+		return
+	}
+	quantity := req.Quantity
+	if quantity == 0 {
+		quantity = qty
+	}
+	if quantity > qty {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("Cannot %s %v of %s, the line has %v", kind, quantity, name, qty)})
+		return
+	}
+
+	var reasonName string
+	var needsApproval bool
+	if err := tx.QueryRow("SELECT name, requires_approval FROM reason_codes WHERE tenant_id = $1 AND kind = $2 AND code = $3 AND is_active",
+		tenantID, kind, req.ReasonCode).Scan(&reasonName, &needsApproval); err != nil {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("Unknown %s reason %q", kind, req.ReasonCode)})
+		return
+	}
+	var approvedBy *string
+	if needsApproval {
+		managerID, err := verifyManagerApproval(tx, tenantID, approvalDevice(c), req.Approval)
+		if err != nil {
+			c.JSON(403, gin.H{"error": err.Error()})
+			return
+		}
+		approvedBy = &managerID
+	}
+
+	target := itemID
+	if quantity < qty {
+		if target, err = splitOrderItem(tx, itemID, orderID, quantity); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	var amount money.Amount
+	tx.QueryRow("SELECT unit_price * quantity - discount_amount FROM order_items WHERE id = $1", target).Scan(&amount)
+
+	by := actorFromContext(c)
+	wasted := false
+	if kind == adjustVoid {
+		if _, err := tx.Exec("UPDATE order_items SET line_status = 'voided', tax_amount = 0, total_price = 0, net_amount = 0 WHERE id = $1", target); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		wasted = kitchenStatus != "" && kitchenStatus != kitchenNew
+		stock, err := itemStockLines(tx, tenantID, target, quantity)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		for _, l := range stock {
+			moves := []stockMovement{{kind: stockCancel, quantity: l.quantity, referenceType: "order", referenceID: orderID}}
+			// Wasted stock is taken off the order and booked as wastage
+			// instead, so it stays off the shelf if the order is cancelled.
+			if wasted {
+				moves = append(moves, stockMovement{kind: stockWastage, quantity: -l.quantity, referenceType: "order_item", referenceID: target})
+			}
+			for _, m := range moves {
+				m.productID, m.variantID, m.locationID, m.reason, m.by = l.productID, l.variantID, locationID, "Void: "+reasonName, by
+				if _, err := recordStockMovement(tx, tenantID, m); err != nil {
+					c.JSON(500, gin.H{"error": err.Error()})
+					return
+				}
+			}
+		}
+	} else {
+		if _, err := tx.Exec("UPDATE order_items SET line_status = 'comped', discount_amount = unit_price * quantity WHERE id = $1", target); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if err := recalculateOrder(tx, tenantID, orderID); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err := checkNotOverpaid(tx, tenantID, orderID); err != nil {
+		writeCheckError(c, err)
+		return
+	}
+
+	adjustmentID := uuid.New().String()
+	if _, err := tx.Exec(
+		`INSERT INTO order_item_adjustments (id, tenant_id, location_id, order_id, order_item_id, kind, product_id, name, quantity, amount,
+		        reason_code, reason_name, note, wasted, requested_by, approved_by, source)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)`,
+		adjustmentID, tenantID, locationID, orderID, target, kind, productID, name, quantity, amount,
+		req.ReasonCode, reasonName, req.Note, wasted, by.idOrNil(), approvedBy, by.Source); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err := recordOrderEvent(tx, tenantID, orderID, orderEventUpdated, gin.H{"itemId": target, "change": kind, "quantity": quantity}); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(201, gin.H{
+		"id": adjustmentID, "kind": kind, "itemId": target, "name": name, "quantity": quantity, "amount": amount,
+		"reasonCode": req.ReasonCode, "wasted": wasted, "approvedBy": approvedBy, "order": orderTotals(orderID),
+	})
+}
+
+// getVoidCompReport totals voids and comps over a period by reason, by the
+// staff member who asked and by the manager who approved.
+func getVoidCompReport(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	from := c.DefaultQuery("from", time.Now().Format("2006-01-02"))
+	to := c.DefaultQuery("to", from)
+	locationID := c.Query("locationId")
+
+	const filter = `a.tenant_id = $1 AND a.created_at >= $2::date AND a.created_at < $3::date + 1
+		   AND ($4 = '' OR a.location_id::text = $4)`
+	args := []interface{}{tenantID, from, to, locationID}
+
+	rows, err := db.Query(
+		`SELECT a.kind, a.reason_code, MAX(a.reason_name), COUNT(*), SUM(a.quantity), SUM(a.amount),
+		        COALESCE(SUM(a.amount) FILTER (WHERE a.wasted), 0)
+		 FROM order_item_adjustments a WHERE `+filter+`
+		 GROUP BY a.kind, a.reason_code ORDER BY SUM(a.amount) DESC`, args...)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	byReason := []gin.H{}
+	totals := map[string]money.Amount{}
+	var wastedTotal money.Amount
+	for rows.Next() {
+		var kind, code, name string
+		var count int
+		var qty float64
+		var amount, wasted money.Amount
+		rows.Scan(&kind, &code, &name, &count, &qty, &amount, &wasted)
+		totals[kind] += amount
+		wastedTotal += wasted
+		byReason = append(byReason, gin.H{
+			"kind": kind, "reasonCode": code, "reasonName": name, "count": count, "quantity": qty,
+			"amount": amount, "wastedAmount": wasted,
+		})
+	}
+	rows.Close()
+
+	staff := func(column string) ([]gin.H, error) {
+		rows, err := db.Query(
+			`SELECT COALESCE(a.`+column+`::text, ''), COALESCE(MAX(u.first_name || ' ' || u.last_name), ''),
+			        COUNT(*) FILTER (WHERE a.kind = 'void'), COALESCE(SUM(a.amount) FILTER (WHERE a.kind = 'void'), 0),
+			        COUNT(*) FILTER (WHERE a.kind = 'comp'), COALESCE(SUM(a.amount) FILTER (WHERE a.kind = 'comp'), 0)
+			 FROM order_item_adjustments a LEFT JOIN users u ON u.id = a.`+column+`
+			 WHERE `+filter+`
+			 GROUP BY a.`+column+` ORDER BY SUM(a.amount) DESC`, args...)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		out := []gin.H{}
+		for rows.Next() {
+			var id, name string
+			var voids, comps int
+			var voidAmount, compAmount money.Amount
+			rows.Scan(&id, &name, &voids, &voidAmount, &comps, &compAmount)
+			out = append(out, gin.H{
+				"userId": id, "name": name, "voids": voids, "voidAmount": voidAmount, "comps": comps, "compAmount": compAmount,
+			})
+		}
+		return out, nil
+	}
+	byStaff, err := staff("requested_by")
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	byApprover, err := staff("approved_by")
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"from": from, "to": to, "locationId": locationID,
+		"voidAmount": totals[adjustVoid], "compAmount": totals[adjustComp], "wastedAmount": wastedTotal,
+		"byReason": byReason, "byStaff": byStaff, "byApprover": byApprover,
+		"currency": currencyFor(tenantID, locationID),
+	})
+}
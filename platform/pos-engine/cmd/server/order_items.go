@@ -0,0 +1,498 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"github.com/berhot/products/commerce/pos-engine/internal/money"
+	"github.com/berhot/products/commerce/pos-engine/internal/tax"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ── Order Items ─────────────────────────────────────────────
+
+// orderItemRequest is one line as a client sends it, on a new order or on a
+// round added to an open one.
+type orderItemRequest struct {
+	ProductID string          `json:"productId" binding:"required"`
+	VariantID string          `json:"variantId"`
+	Quantity  float64         `json:"quantity" binding:"required"`
+	Notes     string          `json:"notes"`
+	Seat      int             `json:"seat"`
+	Modifiers []orderModifier `json:"modifiers"`
+}
+
+// orderModifier is a modifier chosen on a line, stored with the line as it
+// was priced.
+type orderModifier struct {
+	GroupID   string       `json:"groupId"`
+	GroupName string       `json:"groupName"`
+	ItemID    string       `json:"itemId"`
+	ItemName  string       `json:"itemName"`
+	Price     money.Amount `json:"priceAdjustment"`
+}
+
+// orderLine is a priced line ready to be taxed and stored.
+type orderLine struct {
+	productID     string
+	variantID     *string
+	categoryID    string
+	name          string
+	tracked       bool
+	price         money.Amount
+	taxCategory   tax.Category
+	quantity      float64
+	notes         string
+	modifiers     string
+	modifierTotal money.Amount
+	seat          int
+	round         int
+	components    []comboPart
+	giftCard      bool
+}
+
+// unitPrice is what one of the line sells for, modifiers included.
+func (l orderLine) unitPrice() money.Amount {
+	return l.price + l.modifierTotal
+}
+
+// priceOrderItems looks up each requested product and variant and resolves
+// its price and tax category. Products must be on a menu open now, and the
+// running price lists' prices replace product prices and modifier
+// adjustments. A combo's choices are checked and priced from the menu, and
+// its price shared out among its components. Errors name the line at fault
+// and are meant for the client.
+func priceOrderItems(tenantID string, taxCtx taxContext, prices priceContext, reqs []orderItemRequest) ([]orderLine, error) {
+	var lines []orderLine
+	for _, item := range reqs {
+		var name, productType string
+		var categoryID, taxCatID, taxCode, taxName, taxKind sql.NullString
+		var price money.Amount
+		var taxRate, taxCatRate sql.NullFloat64
+		var tracked bool
+		err := db.QueryRow(
+			`SELECT p.name, p.price, p.tax_rate, p.category_id, tc.id, tc.code, tc.name, tc.kind, tc.rate, p.track_inventory, p.product_type
+			 FROM products p
+			 LEFT JOIN tax_categories tc ON tc.id = p.tax_category_id
+			 WHERE p.id = $1 AND p.tenant_id = $2`, item.ProductID, tenantID).
+			Scan(&name, &price, &taxRate, &categoryID, &taxCatID, &taxCode, &taxName, &taxKind, &taxCatRate, &tracked, &productType)
+		if err != nil {
+			return nil, fmt.Errorf("Product %s not found", item.ProductID)
+		}
+		if !prices.onMenu(item.ProductID, categoryID.String) {
+			return nil, fmt.Errorf("%s is not on the menu now", name)
+		}
+		if listed, ok := prices.productPrices[item.ProductID]; ok {
+			price = listed
+		}
+		variant, err := variantForOrder(tenantID, item.ProductID, item.VariantID)
+		if err != nil {
+			return nil, err
+		}
+		var variantID *string
+		if variant != nil {
+			variantID = &variant.id
+			name = name + " - " + variant.name
+			price += variant.priceAdjustment
+		}
+		var parts []comboPart
+		if productType == "combo" {
+			if item.Modifiers, parts, err = comboSelections(tenantID, item.ProductID, name, item.Modifiers, prices); err != nil {
+				return nil, err
+			}
+		}
+		var modTotal money.Amount
+		for i, mod := range item.Modifiers {
+			if listed, ok := prices.modifierPrices[mod.ItemID]; ok {
+				item.Modifiers[i].Price = listed
+				mod.Price = listed
+			}
+			modTotal += mod.Price
+		}
+		allocateCombo(price+modTotal, parts)
+		modJSON, _ := json.Marshal(item.Modifiers)
+		taxCat := taxCtx.productTaxCategory(
+			tax.Category{ID: taxCatID.String, Code: taxCode.String, Name: taxName.String, Kind: taxKind.String, Rate: taxCatRate.Float64},
+			taxCatID.Valid, taxRate)
+		giftCard := productType == "gift_card"
+		if giftCard {
+			if item.Quantity != math.Trunc(item.Quantity) {
+				return nil, fmt.Errorf("%s is sold in whole cards", name)
+			}
+			// Selling a gift card is a change of means of payment; VAT falls
+			// on what it is spent on.
+			taxCat = tax.Category{Kind: tax.KindOutOfScope}
+		}
+		lines = append(lines, orderLine{
+			productID: item.ProductID, variantID: variantID, categoryID: categoryID.String, name: name, tracked: tracked,
+			price: price, taxCategory: taxCat, quantity: item.Quantity, notes: item.Notes, modifiers: string(modJSON),
+			modifierTotal: modTotal, seat: item.Seat, round: 1, components: parts, giftCard: giftCard,
+		})
+	}
+	return lines, nil
+}
+
+// insertOrderItem stores a priced and taxed line on an order.
+func insertOrderItem(tx *sql.Tx, tenantID, orderID, itemID string, l orderLine, discount money.Amount, lineTax tax.LineResult) error {
+	var seat *int
+	if l.seat > 0 {
+		seat = &l.seat
+	}
+	_, err := tx.Exec(
+		`INSERT INTO order_items (id, tenant_id, order_id, product_id, name, quantity, unit_price, discount_amount, tax_amount, total_price, notes, modifiers,
+		        tax_rate, tax_category_code, tax_kind, net_amount, variant_id, seat_number, round)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19)`,
+		itemID, tenantID, orderID, l.productID, l.name, l.quantity, l.unitPrice(), discount, lineTax.Tax, lineTax.Gross, l.notes, l.modifiers,
+		lineTax.Rate, l.taxCategory.Code, lineTax.Kind, lineTax.Net, l.variantID, seat, l.round,
+	)
+	if err != nil {
+		return err
+	}
+	return insertComboParts(tx, tenantID, itemID, l.components)
+}
+
+// orderStockLines picks out the stock the lines take: the tracked
+// components of combos and the other lines whose products track inventory.
+func orderStockLines(lines []orderLine) []stockLine {
+	var stock []stockLine
+	for _, l := range lines {
+		if len(l.components) > 0 {
+			for _, p := range l.components {
+				if p.tracked {
+					stock = append(stock, stockLine{p.productID, p.variantID, p.name, l.quantity * p.quantity})
+				}
+			}
+		} else if l.tracked {
+			stock = append(stock, stockLine{l.productID, l.variantID, l.name, l.quantity})
+		}
+	}
+	return stock
+}
+
+// recalculateOrder re-taxes an order from the lines it holds now and updates
+// its totals, tax summary and payment status. Voided lines no longer count
+// and comped lines count at nothing, their price being fully discounted. Lines keep the tax treatment
+// and discount they were sold with; the order keeps the price mode and
+// rounding it was created under.
+func recalculateOrder(tx *sql.Tx, tenantID, orderID string) error {
+	var settings tax.Settings
+	var paid money.Amount
+	err := tx.QueryRow(
+		`SELECT prices_include_tax, tax_rounding, paid_amount FROM orders WHERE id = $1 AND tenant_id = $2 FOR UPDATE`,
+		orderID, tenantID).Scan(&settings.PricesIncludeTax, &settings.Rounding, &paid)
+	if err != nil {
+		return err
+	}
+
+	rows, err := tx.Query(
+		`SELECT id, quantity, unit_price, discount_amount, tax_rate, tax_category_code, tax_kind
+		 FROM order_items WHERE order_id = $1 AND tenant_id = $2 AND line_status <> 'voided'`, orderID, tenantID)
+	if err != nil {
+		return err
+	}
+	var lines []tax.Line
+	var subtotal, discount money.Amount
+	for rows.Next() {
+		var id, code, kind string
+		var qty, rate float64
+		var unitPrice, lineDiscount money.Amount
+		if err := rows.Scan(&id, &qty, &unitPrice, &lineDiscount, &rate, &code, &kind); err != nil {
+			rows.Close()
+			return err
+		}
+		subtotal += unitPrice.Mul(qty)
+		discount += lineDiscount
+		lines = append(lines, tax.Line{
+			Key: id, Amount: unitPrice.Mul(qty) - lineDiscount,
+			Category: tax.Category{Code: code, Kind: kind, Rate: rate},
+		})
+	}
+	rows.Close()
+
+	taxes := tax.Calculate(lines, settings)
+	for id, lt := range taxes.Lines {
+		if _, err := tx.Exec("UPDATE order_items SET tax_amount = $1, total_price = $2, net_amount = $3 WHERE id = $4",
+			lt.Tax, lt.Gross, lt.Net, id); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM order_tax_summary WHERE order_id = $1 AND tenant_id = $2", orderID, tenantID); err != nil {
+		return err
+	}
+	if err := saveOrderTaxSummary(tx, tenantID, orderID, taxes.Summary); err != nil {
+		return err
+	}
+	if settings.PricesIncludeTax {
+		subtotal = taxes.Gross + discount - taxes.Tax
+	}
+	_, err = tx.Exec(
+		`UPDATE orders SET subtotal = $1, discount_amount = $2, tax_amount = $3, total = $4, payment_status = $5, updated_at = NOW()
+		 WHERE id = $6 AND tenant_id = $7`,
+		subtotal, discount, taxes.Tax, taxes.Gross, paymentStatusFor(taxes.Gross, paid), orderID, tenantID)
+	return err
+}
+
+// splitOrderItem takes qty off a line into a new line on toOrderID, which
+// may be the same order, and returns the new line's id. The discount follows
+// the quantity, with rounding left on the original, and a combo's components
+// are copied across. Both lines need re-taxing afterwards.
+func splitOrderItem(tx *sql.Tx, itemID, toOrderID string, qty float64) (string, error) {
+	var quantity float64
+	var discount money.Amount
+	if err := tx.QueryRow("SELECT quantity, discount_amount FROM order_items WHERE id = $1", itemID).Scan(&quantity, &discount); err != nil {
+		return "", err
+	}
+	part := discount.Mul(qty / quantity)
+	id := uuid.New().String()
+	if _, err := tx.Exec(
+		`INSERT INTO order_items (id, tenant_id, order_id, product_id, variant_id, name, quantity, unit_price, discount_amount,
+		        tax_amount, total_price, notes, modifiers, tax_rate, tax_category_code, tax_kind, net_amount, seat_number, round, line_status,
+		        kitchen_station_id, kitchen_status, kitchen_routed_at, kitchen_started_at, kitchen_ready_at, kitchen_bumped_at)
+		 SELECT $1, tenant_id, $2, product_id, variant_id, name, $3, unit_price, $4,
+		        0, 0, notes, modifiers, tax_rate, tax_category_code, tax_kind, 0, seat_number, round, line_status,
+		        kitchen_station_id, kitchen_status, kitchen_routed_at, kitchen_started_at, kitchen_ready_at, kitchen_bumped_at
+		 FROM order_items WHERE id = $5`,
+		id, toOrderID, qty, part, itemID); err != nil {
+		return "", err
+	}
+	if _, err := tx.Exec(
+		`INSERT INTO order_item_components (tenant_id, order_item_id, product_id, variant_id, modifier_item_id, name, quantity, unit_allocation)
+		 SELECT tenant_id, $1, product_id, variant_id, modifier_item_id, name, quantity, unit_allocation
+		 FROM order_item_components WHERE order_item_id = $2`, id, itemID); err != nil {
+		return "", err
+	}
+	_, err := tx.Exec("UPDATE order_items SET quantity = quantity - $1, discount_amount = discount_amount - $2 WHERE id = $3", qty, part, itemID)
+	return id, err
+}
+
+// orderIsOpen reports whether an order can still take changes to its lines.
+func orderIsOpen(status string) bool {
+	return status == orderPending || status == orderAccepted || status == orderPreparing || status == orderReady
+}
+
+// addOrderItems adds a round of items to an open order. The round is sent
+// to the kitchen on its own, its stock is taken and the order re-totalled.
+// Promotions are applied when an order is created and are not re-run.
+func addOrderItems(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	orderID := c.Param("id")
+	var req struct {
+		Items []orderItemRequest `json:"items" binding:"required,min=1"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	var locationID, channel, currency string
+	if err := db.QueryRow("SELECT location_id, channel, currency FROM orders WHERE id = $1 AND tenant_id = $2", orderID, tenantID).
+		Scan(&locationID, &channel, &currency); err != nil {
+		c.JSON(404, gin.H{"error": "Order not found"})
+		return
+	}
+	prices, err := loadPriceContext(tenantID, locationID, channel, locationNow(tenantID, locationID))
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	lines, err := priceOrderItems(tenantID, loadTaxContext(tenantID, locationID), prices, req.Items)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Transaction failed"})
+		return
+	}
+	defer tx.Rollback()
+
+	var status string
+	tx.QueryRow("SELECT status FROM orders WHERE id = $1 AND tenant_id = $2 FOR UPDATE", orderID, tenantID).Scan(&status)
+	if !orderIsOpen(status) {
+		c.JSON(409, gin.H{"error": fmt.Sprintf("Cannot add items to a %s order", status), "status": status})
+		return
+	}
+	var round int
+	tx.QueryRow("SELECT COALESCE(MAX(round), 0) + 1 FROM order_items WHERE order_id = $1", orderID).Scan(&round)
+
+	by := actorFromContext(c)
+	itemIDs := []string{}
+	giftCards := []gin.H{}
+	for _, l := range lines {
+		l.round = round
+		itemID := uuid.New().String()
+		// Tax is worked out for the whole order below.
+		if err := insertOrderItem(tx, tenantID, orderID, itemID, l, 0, tax.LineResult{}); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		itemIDs = append(itemIDs, itemID)
+		cards, err := openOrderGiftCards(tx, tenantID, orderID, currency, l, by)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		giftCards = append(giftCards, cards...)
+	}
+	if err := recalculateOrder(tx, tenantID, orderID); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err := routeOrderItems(tx, tenantID, orderID); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	stockWarnings, err := deductOrderStock(tx, tenantID, locationID, orderID, orderStockLines(lines), by)
+	if err != nil {
+		if se, ok := err.(*stockShortageError); ok {
+			c.JSON(409, gin.H{"error": se.Error(), "shortages": se.shortages})
+			return
+		}
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err := markTableOrdering(tx, tenantID, orderID); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err := recordOrderEvent(tx, tenantID, orderID, orderEventUpdated, gin.H{"round": round, "itemCount": len(lines)}); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	var total, paid money.Amount
+	db.QueryRow("SELECT total, paid_amount FROM orders WHERE id = $1", orderID).Scan(&total, &paid)
+	c.JSON(201, gin.H{
+		"orderId": orderID, "round": round, "itemIds": itemIDs, "total": total,
+		"balanceDue": money.Max(total-paid, 0), "stockWarnings": stockWarnings, "giftCards": giftCards,
+	})
+}
+
+// updateOrderItem changes a line's quantity, notes or seat. Quantity can only
+// change before the kitchen starts on the line; after that it is voided.
+func updateOrderItem(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	orderID := c.Param("id")
+	itemID := c.Param("itemId")
+	var req struct {
+		Quantity *float64 `json:"quantity"`
+		Notes    *string  `json:"notes"`
+		Seat     *int     `json:"seat"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Quantity != nil && *req.Quantity <= 0 {
+		c.JSON(400, gin.H{"error": "quantity must be positive; void the line to remove it"})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Transaction failed"})
+		return
+	}
+	defer tx.Rollback()
+	var status, locationID string
+	if err := tx.QueryRow("SELECT status, location_id FROM orders WHERE id = $1 AND tenant_id = $2 FOR UPDATE", orderID, tenantID).
+		Scan(&status, &locationID); err != nil {
+		c.JSON(404, gin.H{"error": "Order not found"})
+		return
+	}
+	if !orderIsOpen(status) {
+		c.JSON(409, gin.H{"error": fmt.Sprintf("Cannot change items on a %s order", status), "status": status})
+		return
+	}
+	var name, lineStatus, kitchenStatus string
+	var qty float64
+	if err := tx.QueryRow(
+		`SELECT name, quantity, line_status, COALESCE(kitchen_status, '')
+		 FROM order_items WHERE id = $1 AND order_id = $2 AND tenant_id = $3 FOR UPDATE`, itemID, orderID, tenantID).
+		Scan(&name, &qty, &lineStatus, &kitchenStatus); err != nil {
+		c.JSON(404, gin.H{"error": "Item not found"})
+		return
+	}
+	if lineStatus != lineActive {
+		c.JSON(409, gin.H{"error": fmt.Sprintf("Cannot change a %s line", lineStatus), "lineStatus": lineStatus})
+		return
+	}
+
+	by := actorFromContext(c)
+	if req.Quantity != nil && *req.Quantity != qty {
+		if isGiftCardLine(tx, itemID) {
+			c.JSON(409, gin.H{"error": "Gift card lines cannot change quantity; void the line and add it again"})
+			return
+		}
+		if kitchenStatus != "" && kitchenStatus != kitchenNew {
+			c.JSON(409, gin.H{"error": "The kitchen has started this line; void it instead", "kitchenStatus": kitchenStatus})
+			return
+		}
+		if _, err := tx.Exec("UPDATE order_items SET quantity = $1, discount_amount = discount_amount * $1 / quantity WHERE id = $2",
+			*req.Quantity, itemID); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		stock, err := itemStockLines(tx, tenantID, itemID, qty-*req.Quantity)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		for _, l := range stock {
+			m := stockMovement{
+				productID: l.productID, variantID: l.variantID, locationID: locationID, kind: stockSale,
+				quantity: l.quantity, referenceType: "order", referenceID: orderID, by: by,
+			}
+			if m.quantity > 0 {
+				m.kind, m.reason = stockCancel, "Quantity reduced"
+			}
+			if _, err := recordStockMovement(tx, tenantID, m); err != nil {
+				c.JSON(500, gin.H{"error": err.Error()})
+				return
+			}
+		}
+	}
+	if _, err := tx.Exec(
+		`UPDATE order_items SET notes = COALESCE($1, notes), seat_number = COALESCE($2, seat_number) WHERE id = $3`,
+		req.Notes, req.Seat, itemID); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err := recalculateOrder(tx, tenantID, orderID); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err := checkNotOverpaid(tx, tenantID, orderID); err != nil {
+		writeCheckError(c, err)
+		return
+	}
+	if err := recordOrderEvent(tx, tenantID, orderID, orderEventUpdated, gin.H{"itemId": itemID, "change": "updated"}); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": fmt.Sprintf("%s updated", name), "itemId": itemID, "order": orderTotals(orderID)})
+}
+
+// orderTotals is the short summary of an order returned after line changes.
+func orderTotals(orderID string) gin.H {
+	var subtotal, discount, taxAmount, total, paid money.Amount
+	db.QueryRow("SELECT subtotal, COALESCE(discount_amount, 0), tax_amount, total, paid_amount FROM orders WHERE id = $1", orderID).
+		Scan(&subtotal, &discount, &taxAmount, &total, &paid)
+	return gin.H{
+		"id": orderID, "subtotal": subtotal, "discountAmount": discount, "taxAmount": taxAmount, "total": total,
+		"paidAmount": paid, "balanceDue": money.Max(total-paid, 0),
+	}
+}
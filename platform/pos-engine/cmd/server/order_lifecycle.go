@@ -0,0 +1,310 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/berhot/products/commerce/pos-engine/internal/money"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ── Order Lifecycle ─────────────────────────────────────────
+
+const (
+	orderPending   = "pending"
+	orderAccepted  = "accepted"
+	orderPreparing = "preparing"
+	orderReady     = "ready"
+	orderCompleted = "completed"
+	orderCancelled = "cancelled"
+	orderRefunded  = "refunded"
+	orderVoided    = "voided"
+)
+
+// orderTransitions lists the statuses an order may move to from each status.
+// Counter sales are paid and handed over on the spot, so pending and accepted
+// orders may complete without passing through the kitchen states.
+var orderTransitions = map[string][]string{
+	orderPending:   {orderAccepted, orderPreparing, orderCompleted, orderCancelled, orderVoided},
+	orderAccepted:  {orderPreparing, orderReady, orderCompleted, orderCancelled, orderVoided},
+	orderPreparing: {orderReady, orderCancelled, orderVoided},
+	orderReady:     {orderCompleted, orderCancelled},
+	orderCompleted: {orderRefunded},
+	orderCancelled: {},
+	orderRefunded:  {},
+	orderVoided:    {},
+}
+
+// orderStatusTimestamps maps a status to the orders column stamped on entry.
+var orderStatusTimestamps = map[string]string{
+	orderAccepted:  "accepted_at",
+	orderPreparing: "preparing_at",
+	orderReady:     "ready_at",
+	orderCompleted: "completed_at",
+	orderCancelled: "cancelled_at",
+	orderRefunded:  "refunded_at",
+	orderVoided:    "voided_at",
+}
+
+func migrateOrderLifecycle() {
+	db.Exec(`ALTER TABLE orders DROP CONSTRAINT IF EXISTS orders_status_check`)
+	db.Exec(`ALTER TABLE orders ADD CONSTRAINT orders_status_check
+		CHECK (status IN ('pending', 'accepted', 'preparing', 'ready', 'completed', 'cancelled', 'refunded', 'voided'))`)
+	for _, col := range orderStatusTimestamps {
+		db.Exec(fmt.Sprintf(`ALTER TABLE orders ADD COLUMN IF NOT EXISTS %s TIMESTAMPTZ`, col))
+	}
+	db.Exec(`CREATE TABLE IF NOT EXISTS order_status_history (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		tenant_id UUID NOT NULL,
+		order_id UUID NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+		from_status VARCHAR(20),
+		to_status VARCHAR(20) NOT NULL,
+		actor_id UUID,
+		source VARCHAR(50) NOT NULL DEFAULT '',
+		reason TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		seq BIGSERIAL
+	)`)
+	// created_at is the same for every change made in one transaction, so the
+	// timeline is ordered by seq, which follows insertion.
+	db.Exec(`ALTER TABLE order_status_history ADD COLUMN IF NOT EXISTS seq BIGSERIAL`)
+	db.Exec("DROP INDEX IF EXISTS idx_order_status_history_order")
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_order_status_history_order_seq ON order_status_history(order_id, seq)")
+}
+
+func isValidOrderStatus(status string) bool {
+	_, ok := orderTransitions[status]
+	return ok
+}
+
+func canTransitionOrder(from, to string) bool {
+	for _, s := range orderTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// completesOnPayment reports whether a fully paid order can be completed
+// straight away: it is ready for handover, or the kitchen has nothing of it
+// left to make. Orders still being made complete through the kitchen flow,
+// and scheduled orders wait to be released first.
+func completesOnPayment(q queryer, orderID, status string) bool {
+	if status == orderReady {
+		return true
+	}
+	if !canTransitionOrder(status, orderCompleted) {
+		return false
+	}
+	var waiting bool
+	q.QueryRow(
+		`SELECT EXISTS(SELECT 1 FROM orders WHERE id = $1 AND release_at IS NOT NULL AND released_at IS NULL)
+		     OR EXISTS(SELECT 1 FROM order_items WHERE order_id = $1 AND kitchen_station_id IS NOT NULL
+		               AND kitchen_status <> 'bumped' AND line_status <> 'voided')`, orderID).Scan(&waiting)
+	return !waiting
+}
+
+// transitionError is returned when an order cannot move to the requested status.
+// Why, when set, says why a move the lifecycle allows was refused.
+type transitionError struct {
+	From, To string
+	Why      string
+}
+
+func (e *transitionError) Error() string {
+	if e.Why != "" {
+		return fmt.Sprintf("cannot move order from %s to %s: %s", e.From, e.To, e.Why)
+	}
+	return fmt.Sprintf("cannot move order from %s to %s", e.From, e.To)
+}
+
+// actor identifies who changed an order and from which app.
+type actor struct {
+	ID     string
+	Source string
+}
+
+func actorFromContext(c *gin.Context) actor {
+	return actor{ID: c.GetString("userId"), Source: c.GetString("clientApp")}
+}
+
+func (a actor) idOrNil() *string {
+	if _, err := uuid.Parse(a.ID); err != nil {
+		return nil
+	}
+	return &a.ID
+}
+
+// transitionOrder moves an order to a new status inside tx, stamping the
+// matching timestamp column, appending to order_status_history and publishing
+// the change on the order stream. Completed orders use up their recipe
+// ingredients and are given their e-invoice, cancelled or voided orders put
+// their stock and promotion uses back, and an order leaving service frees
+// its table for clearing, in the same transaction. The order row is locked
+// so concurrent writers see each other's changes. When expected is
+// non-empty the order must currently be in that status. An order holding
+// money it has not refunded cannot be cancelled or voided; it is refunded
+// first.
+func transitionOrder(tx *sql.Tx, tenantID, orderID, to, expected string, by actor, reason string) (string, error) {
+	var from string
+	var held money.Amount
+	err := tx.QueryRow("SELECT status, paid_amount - refunded_amount FROM orders WHERE id = $1 AND tenant_id = $2 FOR UPDATE", orderID, tenantID).Scan(&from, &held)
+	if err != nil {
+		return "", err
+	}
+	if expected != "" && expected != from {
+		return from, &transitionError{From: from, To: to}
+	}
+	if !canTransitionOrder(from, to) {
+		return from, &transitionError{From: from, To: to}
+	}
+	if (to == orderCancelled || to == orderVoided) && held.IsPositive() {
+		return from, &transitionError{From: from, To: to, Why: fmt.Sprintf("%s has been paid; refund it first", held)}
+	}
+
+	set := "status = $1, updated_at = NOW()"
+	if col, ok := orderStatusTimestamps[to]; ok {
+		set += ", " + col + " = NOW()"
+	}
+	if _, err := tx.Exec("UPDATE orders SET "+set+" WHERE id = $2 AND tenant_id = $3", to, orderID, tenantID); err != nil {
+		return from, err
+	}
+	if err := recordOrderStatus(tx, tenantID, orderID, from, to, by, reason); err != nil {
+		return from, err
+	}
+	event := orderEventStatusChanged
+	if to == orderCancelled || to == orderVoided {
+		event = orderEventCancelled
+	}
+	if err := recordOrderEvent(tx, tenantID, orderID, event, gin.H{"previousStatus": from, "reason": reason}); err != nil {
+		return from, err
+	}
+	if to == orderCompleted {
+		if err := enqueueOrderEvent(tx, tenantID, orderID, eventOrderCompleted, nil); err != nil {
+			return from, err
+		}
+		if err := deductOrderIngredients(tx, tenantID, orderID, by); err != nil {
+			return from, err
+		}
+		if err := issueInvoice(tx, tenantID, orderID); err != nil {
+			return from, err
+		}
+		if err := activateOrderGiftCards(tx, tenantID, orderID, by); err != nil {
+			return from, err
+		}
+	}
+	if to == orderCancelled || to == orderVoided {
+		if err := enqueueOrderEvent(tx, tenantID, orderID, eventOrderCancelled, gin.H{"previousStatus": from, "reason": reason}); err != nil {
+			return from, err
+		}
+		if err := restockOrder(tx, tenantID, orderID, by, reason); err != nil {
+			return from, err
+		}
+		if err := releasePromotions(tx, tenantID, orderID); err != nil {
+			return from, err
+		}
+		if err := voidPendingGiftCards(tx, tenantID, orderID); err != nil {
+			return from, err
+		}
+	}
+	if orderIsOpen(from) && !orderIsOpen(to) {
+		if err := releaseOrderTable(tx, tenantID, orderID); err != nil {
+			return from, err
+		}
+	}
+	return from, nil
+}
+
+// recordOrderStatus appends a row to the order's status timeline.
+// An empty from marks the order's creation.
+func recordOrderStatus(tx *sql.Tx, tenantID, orderID, from, to string, by actor, reason string) error {
+	var fromStatus *string
+	if from != "" {
+		fromStatus = &from
+	}
+	_, err := tx.Exec(
+		`INSERT INTO order_status_history (id, tenant_id, order_id, from_status, to_status, actor_id, source, reason)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		uuid.New().String(), tenantID, orderID, fromStatus, to, by.idOrNil(), by.Source, reason)
+	return err
+}
+
+// changeOrderStatus runs transitionOrder in its own transaction and writes the
+// HTTP response, so the status endpoints share one set of error replies.
+func changeOrderStatus(c *gin.Context, to, expected, reason, message string) {
+	tenantID := c.GetString("tenantId")
+	id := c.Param("id")
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Transaction failed"})
+		return
+	}
+	from, err := transitionOrder(tx, tenantID, id, to, expected, actorFromContext(c), reason)
+	if err != nil {
+		tx.Rollback()
+		writeTransitionError(c, from, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": message, "status": to, "previousStatus": from})
+}
+
+func writeTransitionError(c *gin.Context, from string, err error) {
+	if err == sql.ErrNoRows {
+		c.JSON(404, gin.H{"error": "Order not found"})
+		return
+	}
+	if te, ok := err.(*transitionError); ok {
+		allowed := orderTransitions[from]
+		if allowed == nil {
+			allowed = []string{}
+		}
+		c.JSON(409, gin.H{"error": te.Error(), "currentStatus": from, "allowedStatuses": allowed})
+		return
+	}
+	c.JSON(500, gin.H{"error": err.Error()})
+}
+
+func getOrderHistory(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	id := c.Param("id")
+
+	var status string
+	var createdAt time.Time
+	if err := db.QueryRow("SELECT status, created_at FROM orders WHERE id = $1 AND tenant_id = $2", id, tenantID).Scan(&status, &createdAt); err != nil {
+		c.JSON(404, gin.H{"error": "Order not found"})
+		return
+	}
+
+	rows, err := db.Query(
+		`SELECT id, COALESCE(from_status, ''), to_status, COALESCE(actor_id::text, ''), source, reason, created_at
+		 FROM order_status_history WHERE order_id = $1 AND tenant_id = $2
+		 ORDER BY seq`, id, tenantID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	history := []gin.H{}
+	for rows.Next() {
+		var hid, from, to, actorID, source, reason string
+		var at time.Time
+		rows.Scan(&hid, &from, &to, &actorID, &source, &reason, &at)
+		history = append(history, gin.H{
+			"id": hid, "fromStatus": from, "toStatus": to, "actorId": actorID,
+			"source": source, "reason": reason, "createdAt": at,
+		})
+	}
+	c.JSON(200, gin.H{
+		"orderId": id, "status": status, "createdAt": createdAt,
+		"allowedStatuses": orderTransitions[status], "history": history,
+	})
+}
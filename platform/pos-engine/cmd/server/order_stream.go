@@ -0,0 +1,299 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// ── Order Stream ────────────────────────────────────────────
+
+// Order event types pushed to live clients.
+const (
+	orderEventCreated       = "order.created"
+	orderEventStatusChanged = "order.status_changed"
+	orderEventUpdated       = "order.updated"
+	orderEventPaid          = "order.paid"
+	orderEventCancelled     = "order.cancelled"
+	orderEventReleased      = "order.released"
+)
+
+const (
+	orderEventsChannel = "pos_order_events"
+	// Events are kept long enough for a client to come back from a dropped
+	// connection or a sleeping tablet; older ones are pruned.
+	orderEventRetention = 24 * time.Hour
+	// Sequence values are taken before commit, so a later id can become
+	// visible before an earlier one. Live streams look back this many ids
+	// and skip what they have already sent so a slow commit is not lost.
+	orderEventLookback = 100
+	orderStreamPing    = 15 * time.Second
+)
+
+func migrateOrderEvents() {
+	db.Exec(`CREATE TABLE IF NOT EXISTS order_events (
+		id BIGSERIAL PRIMARY KEY,
+		tenant_id UUID NOT NULL,
+		location_id UUID,
+		order_id UUID NOT NULL,
+		event_type VARCHAR(50) NOT NULL,
+		payload JSONB NOT NULL DEFAULT '{}',
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`)
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_order_events_tenant ON order_events(tenant_id, id)")
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_order_events_created ON order_events(created_at)")
+
+	// NOTIFY inside a transaction is only delivered on commit, so listeners
+	// never hear about an event they cannot read yet.
+	db.Exec(`CREATE OR REPLACE FUNCTION notify_order_event() RETURNS trigger AS $$
+	BEGIN
+		PERFORM pg_notify('` + orderEventsChannel + `', NEW.id || ' ' || NEW.tenant_id || ' ' || COALESCE(NEW.location_id::text, ''));
+		RETURN NULL;
+	END;
+	$$ LANGUAGE plpgsql`)
+	db.Exec(`DROP TRIGGER IF EXISTS order_events_notify ON order_events`)
+	db.Exec(`CREATE TRIGGER order_events_notify AFTER INSERT ON order_events
+		FOR EACH ROW EXECUTE FUNCTION notify_order_event()`)
+}
+
+// recordOrderEvent appends an event for the order inside tx. The order's
+// status, number and location are added to data so clients can update a
+// list without fetching the order.
+func recordOrderEvent(tx *sql.Tx, tenantID, orderID, eventType string, data gin.H) error {
+	var number, status, locationID string
+	if err := tx.QueryRow("SELECT order_number, status, COALESCE(location_id::text, '') FROM orders WHERE id = $1 AND tenant_id = $2",
+		orderID, tenantID).Scan(&number, &status, &locationID); err != nil {
+		return err
+	}
+	payload := gin.H{"orderId": orderID, "orderNumber": number, "status": status, "locationId": locationID}
+	for k, v := range data {
+		payload[k] = v
+	}
+	body, _ := json.Marshal(payload)
+	var location *string
+	if locationID != "" {
+		location = &locationID
+	}
+	_, err := tx.Exec(
+		`INSERT INTO order_events (tenant_id, location_id, order_id, event_type, payload) VALUES ($1, $2, $3, $4, $5)`,
+		tenantID, location, orderID, eventType, string(body))
+	return err
+}
+
+// orderStreamHub fans database notifications out to the streams open on this
+// replica. Every replica listens on the same channel, so an order changed on
+// one reaches clients connected to any of them.
+type orderStreamHub struct {
+	mu   sync.Mutex
+	subs map[*orderSubscriber]struct{}
+}
+
+// orderSubscriber is one open stream. wake is buffered by one so bursts of
+// notifications collapse into a single read of the table.
+type orderSubscriber struct {
+	tenantID   string
+	locationID string
+	wake       chan struct{}
+}
+
+var orderStream = &orderStreamHub{subs: map[*orderSubscriber]struct{}{}}
+
+func (h *orderStreamHub) subscribe(tenantID, locationID string) *orderSubscriber {
+	s := &orderSubscriber{tenantID: tenantID, locationID: locationID, wake: make(chan struct{}, 1)}
+	h.mu.Lock()
+	h.subs[s] = struct{}{}
+	h.mu.Unlock()
+	return s
+}
+
+func (h *orderStreamHub) unsubscribe(s *orderSubscriber) {
+	h.mu.Lock()
+	delete(h.subs, s)
+	h.mu.Unlock()
+}
+
+// notify wakes the streams an event is for. An empty tenant wakes them all,
+// which is what happens after the listener reconnects and may have missed
+// notifications.
+func (h *orderStreamHub) notify(tenantID, locationID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range h.subs {
+		if tenantID != "" && s.tenantID != tenantID {
+			continue
+		}
+		if tenantID != "" && s.locationID != "" && locationID != "" && s.locationID != locationID {
+			continue
+		}
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// startOrderStream listens for order events for the life of the process and
+// prunes old ones once an hour.
+func startOrderStream(dbURL string) {
+	listener := pq.NewListener(dbURL, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("Order stream listener: %v", err)
+		}
+	})
+	if err := listener.Listen(orderEventsChannel); err != nil {
+		log.Printf("Order stream: LISTEN failed: %v", err)
+	}
+	go func() {
+		prune := time.NewTicker(time.Hour)
+		defer prune.Stop()
+		for {
+			select {
+			case n := <-listener.Notify:
+				// A nil notification means the connection was re-established.
+				if n == nil {
+					orderStream.notify("", "")
+					continue
+				}
+				parts := strings.SplitN(n.Extra, " ", 3)
+				if len(parts) == 3 {
+					orderStream.notify(parts[1], parts[2])
+				}
+			case <-prune.C:
+				db.Exec("DELETE FROM order_events WHERE created_at < $1", time.Now().Add(-orderEventRetention))
+			}
+		}
+	}()
+}
+
+// streamOrders serves order events as Server-Sent Events. Clients resume with
+// the standard Last-Event-ID header, or lastEventId in the query string since
+// browsers cannot set headers on the first EventSource request. Without
+// either the stream starts from now.
+func streamOrders(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	locationID := c.Query("locationId")
+	types := map[string]bool{}
+	for _, t := range strings.Split(c.Query("types"), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			types[t] = true
+		}
+	}
+
+	resume := c.GetHeader("Last-Event-ID")
+	if resume == "" {
+		resume = c.Query("lastEventId")
+	}
+	var latest int64
+	db.QueryRow("SELECT COALESCE(MAX(id), 0) FROM order_events WHERE tenant_id = $1", tenantID).Scan(&latest)
+	lastID := latest
+	if resume != "" {
+		id, err := strconv.ParseInt(resume, 10, 64)
+		if err != nil || id < 0 {
+			c.JSON(400, gin.H{"error": "Last-Event-ID must be an event id"})
+			return
+		}
+		lastID = id
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+
+	if resume != "" {
+		var oldest sql.NullInt64
+		// Pruning goes by age across all tenants, so the oldest id left
+		// anywhere marks how far back the table still reaches.
+		db.QueryRow("SELECT MIN(id) FROM order_events").Scan(&oldest)
+		if oldest.Valid && lastID < oldest.Int64-1 {
+			// The client has been away longer than events are kept. It is told
+			// to reload, and the reset carries the latest id so its next
+			// reconnect picks up from there rather than resetting again.
+			lastID = latest
+			fmt.Fprintf(c.Writer, "id: %d\nevent: reset\ndata: {\"reason\":\"Events since the last id are no longer available\"}\n\n", latest)
+			c.Writer.Flush()
+		}
+	}
+
+	// Events in the look-back window that are visible now were either sent
+	// before or are older than the client's position; only ones that commit
+	// later are new to it.
+	seen := map[int64]bool{}
+	if rows, err := db.Query("SELECT id FROM order_events WHERE tenant_id = $1 AND id > $2 AND id <= $3",
+		tenantID, lastID-orderEventLookback, lastID); err == nil {
+		for rows.Next() {
+			var id int64
+			rows.Scan(&id)
+			seen[id] = true
+		}
+		rows.Close()
+	}
+
+	sub := orderStream.subscribe(tenantID, locationID)
+	defer orderStream.unsubscribe(sub)
+
+	catchUp := lastID < latest
+	ping := time.NewTicker(orderStreamPing)
+	defer ping.Stop()
+	c.Stream(func(w io.Writer) bool {
+		if catchUp {
+			catchUp = false
+		} else {
+			select {
+			case <-c.Request.Context().Done():
+				return false
+			case <-ping.C:
+				// Comments keep proxies from closing an idle connection, and
+				// the read after it covers a notification that never came.
+				fmt.Fprint(w, ": ping\n\n")
+			case <-sub.wake:
+			}
+		}
+		rows, err := db.Query(
+			`SELECT id, event_type, payload, created_at FROM order_events
+			 WHERE tenant_id = $1 AND id > $2 AND ($3 = '' OR location_id::text = $3)
+			 ORDER BY id LIMIT 500`, tenantID, lastID-orderEventLookback, locationID)
+		if err != nil {
+			log.Printf("Order stream: %v", err)
+			return true
+		}
+		defer rows.Close()
+		read := 0
+		for rows.Next() {
+			read++
+			var id int64
+			var eventType, payload string
+			var at time.Time
+			rows.Scan(&id, &eventType, &payload, &at)
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			if id > lastID {
+				lastID = id
+			}
+			if len(types) > 0 && !types[eventType] {
+				continue
+			}
+			data, _ := json.Marshal(gin.H{"type": eventType, "occurredAt": at, "data": json.RawMessage(payload)})
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", id, eventType, data)
+		}
+		for id := range seen {
+			if id <= lastID-orderEventLookback {
+				delete(seen, id)
+			}
+		}
+		// A full page means more is waiting; read again without sleeping.
+		catchUp = read == 500
+		return true
+	})
+}
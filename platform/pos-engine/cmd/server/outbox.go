@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"time"
+
+	"github.com/berhot/products/commerce/pos-engine/internal/events"
+	"github.com/berhot/products/commerce/pos-engine/internal/money"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ── Outbox ──────────────────────────────────────────────────
+
+// Domain event types, named as in packages/contracts/events and used as the
+// Kafka topic.
+const (
+	eventOrderCreated     = "commerce.order.created"
+	eventOrderCompleted   = "commerce.order.completed"
+	eventOrderCancelled   = "commerce.order.cancelled"
+	eventPaymentProcessed = "commerce.payment.processed"
+	eventRefundProcessed  = "commerce.refund.processed"
+)
+
+const (
+	eventSource         = "/commerce/pos-engine"
+	outboxBatch         = 100
+	outboxMaxAttempts   = 10
+	outboxPollInterval  = time.Second
+	outboxMaxRetryDelay = 5 * time.Minute
+)
+
+func migrateOutbox() {
+	db.Exec(`CREATE TABLE IF NOT EXISTS outbox_events (
+		id UUID PRIMARY KEY,
+		seq BIGSERIAL,
+		tenant_id UUID NOT NULL,
+		aggregate_type VARCHAR(50) NOT NULL,
+		aggregate_id UUID NOT NULL,
+		event_type VARCHAR(100) NOT NULL,
+		payload JSONB NOT NULL,
+		status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'published', 'failed')),
+		attempts INTEGER NOT NULL DEFAULT 0,
+		last_error TEXT NOT NULL DEFAULT '',
+		next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		published_at TIMESTAMPTZ
+	)`)
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_outbox_events_pending ON outbox_events(seq) WHERE status = 'pending'")
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_outbox_events_aggregate ON outbox_events(aggregate_id, seq)")
+}
+
+// enqueueEvent writes a domain event to the outbox inside tx, so it is
+// published if and only if the change it describes commits.
+func enqueueEvent(tx *sql.Tx, tenantID, eventType, aggregateType, aggregateID string, data gin.H) error {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	id := uuid.New().String()
+	ev := events.New(id, eventType, eventSource, aggregateType+"/"+aggregateID, tenantID, body)
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		`INSERT INTO outbox_events (id, tenant_id, aggregate_type, aggregate_id, event_type, payload)
+		 VALUES ($1, $2, $3, $4, $5, $6)`,
+		id, tenantID, aggregateType, aggregateID, eventType, string(payload))
+	return err
+}
+
+// contractOrderTypes maps pos-engine order types onto the ones the contracts
+// know. Types with no counterpart are left out of events.
+var contractOrderTypes = map[string]string{
+	"dine_in":  "dine_in",
+	"takeout":  "takeout",
+	"pickup":   "takeout",
+	"delivery": "delivery",
+}
+
+// orderEventData is the part of every order event that describes the order.
+func orderEventData(tx *sql.Tx, tenantID, orderID string) (gin.H, error) {
+	var number, orderType, currency, status string
+	var locationID, customerID sql.NullString
+	var subtotal, discount, taxAmount, total, paid money.Amount
+	err := tx.QueryRow(
+		`SELECT order_number, location_id::text, customer_id::text, order_type, subtotal, COALESCE(discount_amount, 0),
+		        tax_amount, total, paid_amount, currency, status
+		 FROM orders WHERE id = $1 AND tenant_id = $2`, orderID, tenantID,
+	).Scan(&number, &locationID, &customerID, &orderType, &subtotal, &discount, &taxAmount, &total, &paid, &currency, &status)
+	if err != nil {
+		return nil, err
+	}
+	data := gin.H{
+		"orderId": orderID, "orderNumber": number, "locationId": locationID.String, "subtotal": subtotal,
+		"discountAmount": discount, "taxAmount": taxAmount, "total": total, "paidAmount": paid, "currency": currency,
+		"status": status,
+	}
+	if customerID.Valid {
+		data["customerId"] = customerID.String
+	}
+	if t, ok := contractOrderTypes[orderType]; ok {
+		data["orderType"] = t
+	}
+	return data, nil
+}
+
+// enqueueOrderEvent publishes an order event carrying the order's summary
+// plus extra.
+func enqueueOrderEvent(tx *sql.Tx, tenantID, orderID, eventType string, extra gin.H) error {
+	data, err := orderEventData(tx, tenantID, orderID)
+	if err != nil {
+		return err
+	}
+	for k, v := range extra {
+		data[k] = v
+	}
+	if eventType == eventOrderCompleted {
+		items, err := orderEventItems(tx, tenantID, orderID)
+		if err != nil {
+			return err
+		}
+		data["items"] = items
+	}
+	return enqueueEvent(tx, tenantID, eventType, "order", orderID, data)
+}
+
+func orderEventItems(tx *sql.Tx, tenantID, orderID string) ([]gin.H, error) {
+	rows, err := tx.Query(
+		`SELECT oi.product_id, oi.variant_id::text, p.category_id::text, oi.name, oi.quantity, oi.unit_price, oi.total_price
+		 FROM order_items oi JOIN products p ON p.id = oi.product_id
+		 WHERE oi.order_id = $1 AND oi.tenant_id = $2 AND oi.line_status <> 'voided' ORDER BY oi.created_at`, orderID, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []gin.H{}
+	for rows.Next() {
+		var productID, name string
+		var variantID, categoryID sql.NullString
+		var qty float64
+		var unitPrice, totalPrice money.Amount
+		rows.Scan(&productID, &variantID, &categoryID, &name, &qty, &unitPrice, &totalPrice)
+		item := gin.H{"productId": productID, "name": name, "quantity": qty, "unitPrice": unitPrice, "totalPrice": totalPrice}
+		if variantID.Valid {
+			item["variantId"] = variantID.String
+		}
+		if categoryID.Valid {
+			item["categoryId"] = categoryID.String
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// ── Relay ───────────────────────────────────────────────────
+
+// outboxBroker picks the broker from OUTBOX_BROKER: kafka (with
+// KAFKA_BROKERS), postgres, or memory for local runs. There is no default:
+// the memory broker marks events published and keeps them only until the
+// process exits, so it has to be asked for.
+func outboxBroker() (events.Broker, error) {
+	switch kind := os.Getenv("OUTBOX_BROKER"); kind {
+	case "":
+		return nil, fmt.Errorf("OUTBOX_BROKER is not set")
+	case "kafka":
+		return events.NewKafkaBroker(getEnv("KAFKA_BROKERS", "localhost:9092")), nil
+	case "postgres":
+		return events.NewPostgresBroker(db)
+	case "memory":
+		return events.NewMemoryBroker(1000), nil
+	default:
+		return nil, fmt.Errorf("unknown OUTBOX_BROKER %q", kind)
+	}
+}
+
+// startOutboxRelay publishes pending outbox events in the background. Every
+// replica runs one; SKIP LOCKED keeps them off each other's rows. Without its
+// contract schemas or a broker the relay does not start, and events wait in
+// the outbox.
+func startOutboxRelay() {
+	dir := getEnv("CONTRACTS_EVENTS_DIR", "../../packages/contracts/events")
+	registry, err := events.LoadRegistry(dir)
+	if err != nil {
+		log.Printf("Outbox relay not started: %v", err)
+		return
+	}
+	broker, err := outboxBroker()
+	if err != nil {
+		log.Printf("Outbox relay not started: %v", err)
+		return
+	}
+	log.Printf("Outbox relay publishing %d event types", len(registry.Types()))
+	go func() {
+		for {
+			n, err := relayOutbox(registry, broker)
+			if err != nil {
+				log.Printf("Outbox relay: %v", err)
+			}
+			if n < outboxBatch {
+				time.Sleep(outboxPollInterval)
+			}
+		}
+	}()
+}
+
+// relayOutbox publishes one batch. An event waits while an earlier one for
+// the same aggregate is still pending, so consumers see an order's events in
+// the order they happened. Events that break their contract are failed at
+// once; broker errors are retried with backoff until outboxMaxAttempts.
+func relayOutbox(registry *events.Registry, broker events.Broker) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	rows, err := tx.Query(
+		`SELECT o.id, o.aggregate_id, o.event_type, o.payload, o.attempts
+		 FROM outbox_events o
+		 WHERE o.status = 'pending' AND o.next_attempt_at <= NOW()
+		   AND NOT EXISTS (SELECT 1 FROM outbox_events e
+		                   WHERE e.aggregate_id = o.aggregate_id AND e.status = 'pending' AND e.seq < o.seq)
+		 ORDER BY o.seq LIMIT $1
+		 FOR UPDATE SKIP LOCKED`, outboxBatch)
+	if err != nil {
+		return 0, err
+	}
+	type pending struct {
+		id, key, eventType, payload string
+		attempts                    int
+	}
+	var batch []pending
+	for rows.Next() {
+		var p pending
+		rows.Scan(&p.id, &p.key, &p.eventType, &p.payload, &p.attempts)
+		batch = append(batch, p)
+	}
+	rows.Close()
+
+	for _, p := range batch {
+		if err := registry.Validate(p.eventType, []byte(p.payload)); err != nil {
+			log.Printf("Outbox event %s (%s) breaks its contract: %v", p.id, p.eventType, err)
+			tx.Exec("UPDATE outbox_events SET status = 'failed', last_error = $1 WHERE id = $2", err.Error(), p.id)
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		err := broker.Publish(ctx, events.Message{Topic: p.eventType, Key: p.key, Value: []byte(p.payload)})
+		cancel()
+		if err != nil {
+			attempts := p.attempts + 1
+			status := "pending"
+			if attempts >= outboxMaxAttempts {
+				status = "failed"
+			}
+			delay := time.Duration(math.Min(math.Pow(2, float64(attempts)), outboxMaxRetryDelay.Seconds())) * time.Second
+			tx.Exec("UPDATE outbox_events SET status = $1, attempts = $2, last_error = $3, next_attempt_at = $4 WHERE id = $5",
+				status, attempts, err.Error(), time.Now().Add(delay), p.id)
+			continue
+		}
+		tx.Exec("UPDATE outbox_events SET status = 'published', attempts = attempts + 1, last_error = '', published_at = NOW() WHERE id = $1", p.id)
+	}
+	return len(batch), tx.Commit()
+}
+
+// ── Outbox Admin ────────────────────────────────────────────
+
+func listOutboxEvents(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	status := c.DefaultQuery("status", "failed")
+	rows, err := db.Query(
+		`SELECT id, event_type, aggregate_type, aggregate_id, status, attempts, last_error, created_at, published_at
+		 FROM outbox_events WHERE tenant_id = $1 AND status = $2 ORDER BY seq DESC LIMIT 50`, tenantID, status)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	list := []gin.H{}
+	for rows.Next() {
+		var id, eventType, aggType, aggID, st, lastError string
+		var attempts int
+		var createdAt time.Time
+		var publishedAt *time.Time
+		rows.Scan(&id, &eventType, &aggType, &aggID, &st, &attempts, &lastError, &createdAt, &publishedAt)
+		list = append(list, gin.H{
+			"id": id, "type": eventType, "aggregateType": aggType, "aggregateId": aggID, "status": st,
+			"attempts": attempts, "lastError": lastError, "createdAt": createdAt, "publishedAt": publishedAt,
+		})
+	}
+	c.JSON(200, gin.H{"events": list, "total": len(list)})
+}
+
+// retryOutboxEvent puts a failed event back in the queue, for after a broker
+// outage or a contract fix.
+func retryOutboxEvent(c *gin.Context) {
+	res, err := db.Exec(
+		`UPDATE outbox_events SET status = 'pending', attempts = 0, next_attempt_at = NOW()
+		 WHERE id = $1 AND tenant_id = $2 AND status = 'failed'`, c.Param("id"), c.GetString("tenantId"))
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(404, gin.H{"error": "Failed event not found"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Event queued for retry"})
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/berhot/products/commerce/pos-engine/internal/money"
+	"github.com/berhot/products/commerce/pos-engine/internal/promotion"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ── Promotions ──────────────────────────────────────────────
+
+func migratePromotions() {
+	db.Exec(`CREATE TABLE IF NOT EXISTS promotions (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		tenant_id UUID NOT NULL,
+		name VARCHAR(255) NOT NULL,
+		code VARCHAR(50),
+		promo_type VARCHAR(20) NOT NULL CHECK (promo_type IN ('percentage', 'fixed', 'bogo', 'buy_x_get_y')),
+		scope VARCHAR(20) NOT NULL DEFAULT 'order' CHECK (scope IN ('order', 'product', 'category')),
+		value DECIMAL(12,2) NOT NULL DEFAULT 0,
+		priority INTEGER NOT NULL DEFAULT 0,
+		product_ids JSONB NOT NULL DEFAULT '[]',
+		category_ids JSONB NOT NULL DEFAULT '[]',
+		buy_quantity INTEGER NOT NULL DEFAULT 0,
+		get_quantity INTEGER NOT NULL DEFAULT 0,
+		get_discount_percent DECIMAL(5,2) NOT NULL DEFAULT 100,
+		get_product_ids JSONB NOT NULL DEFAULT '[]',
+		min_subtotal DECIMAL(12,2) NOT NULL DEFAULT 0,
+		max_discount DECIMAL(12,2) NOT NULL DEFAULT 0,
+		customer_tiers JSONB NOT NULL DEFAULT '[]',
+		stackable BOOLEAN NOT NULL DEFAULT false,
+		exclusive BOOLEAN NOT NULL DEFAULT false,
+		starts_at TIMESTAMPTZ,
+		ends_at TIMESTAMPTZ,
+		usage_limit INTEGER NOT NULL DEFAULT 0,
+		usage_count INTEGER NOT NULL DEFAULT 0,
+		is_active BOOLEAN NOT NULL DEFAULT true,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`)
+	db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_promotions_code ON promotions(tenant_id, UPPER(code)) WHERE code IS NOT NULL")
+	db.Exec(`CREATE TABLE IF NOT EXISTS order_promotions (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		tenant_id UUID NOT NULL,
+		order_id UUID NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+		promotion_id UUID NOT NULL REFERENCES promotions(id),
+		name VARCHAR(255) NOT NULL,
+		code VARCHAR(50),
+		amount DECIMAL(12,2) NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`)
+	db.Exec(`CREATE TABLE IF NOT EXISTS order_item_promotions (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		tenant_id UUID NOT NULL,
+		order_item_id UUID NOT NULL REFERENCES order_items(id) ON DELETE CASCADE,
+		promotion_id UUID NOT NULL REFERENCES promotions(id),
+		amount DECIMAL(12,2) NOT NULL
+	)`)
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_order_promotions_order ON order_promotions(order_id)")
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_order_promotions_promotion ON order_promotions(tenant_id, promotion_id)")
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_order_item_promotions_item ON order_item_promotions(order_item_id)")
+}
+
+type promotionRequest struct {
+	Name               *string       `json:"name"`
+	Code               *string       `json:"code"`
+	Type               *string       `json:"type"`
+	Scope              *string       `json:"scope"`
+	Value              *float64      `json:"value"`
+	Priority           *int          `json:"priority"`
+	ProductIDs         []string      `json:"productIds"`
+	CategoryIDs        []string      `json:"categoryIds"`
+	BuyQuantity        *int          `json:"buyQuantity"`
+	GetQuantity        *int          `json:"getQuantity"`
+	GetDiscountPercent *float64      `json:"getDiscountPercent"`
+	GetProductIDs      []string      `json:"getProductIds"`
+	MinSubtotal        *money.Amount `json:"minSubtotal"`
+	MaxDiscount        *money.Amount `json:"maxDiscount"`
+	CustomerTiers      []string      `json:"customerTiers"`
+	Stackable          *bool         `json:"stackable"`
+	Exclusive          *bool         `json:"exclusive"`
+	StartsAt           *time.Time    `json:"startsAt"`
+	EndsAt             *time.Time    `json:"endsAt"`
+	UsageLimit         *int          `json:"usageLimit"`
+	IsActive           *bool         `json:"isActive"`
+}
+
+func jsonList(v []string) *string {
+	if v == nil {
+		return nil
+	}
+	b, _ := json.Marshal(v)
+	s := string(b)
+	return &s
+}
+
+func createPromotion(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	var req promotionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Name == nil || *req.Name == "" || req.Type == nil {
+		c.JSON(400, gin.H{"error": "name and type are required"})
+		return
+	}
+	if req.Scope == nil {
+		scope := promotion.ScopeOrder
+		req.Scope = &scope
+	}
+	if req.Code != nil {
+		code := strings.ToUpper(strings.TrimSpace(*req.Code))
+		req.Code = &code
+		if code == "" {
+			req.Code = nil
+		}
+	}
+
+	id := uuid.New().String()
+	_, err := db.Exec(
+		`INSERT INTO promotions (id, tenant_id, name, code, promo_type, scope, value, priority,
+		        product_ids, category_ids, buy_quantity, get_quantity, get_discount_percent, get_product_ids,
+		        min_subtotal, max_discount, customer_tiers, stackable, exclusive, starts_at, ends_at, usage_limit, is_active)
+		 VALUES ($1, $2, $3, $4, $5, $6, COALESCE($7, 0), COALESCE($8, 0),
+		        COALESCE($9::jsonb, '[]'), COALESCE($10::jsonb, '[]'), COALESCE($11, 0), COALESCE($12, 0), COALESCE($13, 100), COALESCE($14::jsonb, '[]'),
+		        COALESCE($15, 0), COALESCE($16, 0), COALESCE($17::jsonb, '[]'), COALESCE($18, false), COALESCE($19, false), $20, $21, COALESCE($22, 0), COALESCE($23, true))`,
+		id, tenantID, *req.Name, req.Code, *req.Type, *req.Scope, req.Value, req.Priority,
+		jsonList(req.ProductIDs), jsonList(req.CategoryIDs), req.BuyQuantity, req.GetQuantity, req.GetDiscountPercent, jsonList(req.GetProductIDs),
+		req.MinSubtotal, req.MaxDiscount, jsonList(req.CustomerTiers), req.Stackable, req.Exclusive, req.StartsAt, req.EndsAt, req.UsageLimit, req.IsActive,
+	)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(201, gin.H{"id": id, "name": *req.Name, "code": req.Code, "type": *req.Type, "scope": *req.Scope})
+}
+
+func updatePromotion(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	id := c.Param("id")
+	var req promotionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Code != nil {
+		code := strings.ToUpper(strings.TrimSpace(*req.Code))
+		req.Code = &code
+	}
+	res, err := db.Exec(
+		`UPDATE promotions SET
+			name = COALESCE($3, name),
+			code = CASE WHEN $4::text IS NULL THEN code ELSE NULLIF($4, '') END,
+			promo_type = COALESCE($5, promo_type),
+			scope = COALESCE($6, scope),
+			value = COALESCE($7, value),
+			priority = COALESCE($8, priority),
+			product_ids = COALESCE($9, product_ids),
+			category_ids = COALESCE($10, category_ids),
+			buy_quantity = COALESCE($11, buy_quantity),
+			get_quantity = COALESCE($12, get_quantity),
+			get_discount_percent = COALESCE($13, get_discount_percent),
+			get_product_ids = COALESCE($14, get_product_ids),
+			min_subtotal = COALESCE($15, min_subtotal),
+			max_discount = COALESCE($16, max_discount),
+			customer_tiers = COALESCE($17, customer_tiers),
+			stackable = COALESCE($18, stackable),
+			exclusive = COALESCE($19, exclusive),
+			starts_at = COALESCE($20, starts_at),
+			ends_at = COALESCE($21, ends_at),
+			usage_limit = COALESCE($22, usage_limit),
+			is_active = COALESCE($23, is_active),
+			updated_at = NOW()
+		 WHERE id = $1 AND tenant_id = $2`,
+		id, tenantID, req.Name, req.Code, req.Type, req.Scope, req.Value, req.Priority,
+		jsonList(req.ProductIDs), jsonList(req.CategoryIDs), req.BuyQuantity, req.GetQuantity, req.GetDiscountPercent, jsonList(req.GetProductIDs),
+		req.MinSubtotal, req.MaxDiscount, jsonList(req.CustomerTiers), req.Stackable, req.Exclusive, req.StartsAt, req.EndsAt, req.UsageLimit, req.IsActive,
+	)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(404, gin.H{"error": "Promotion not found"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Promotion updated"})
+}
+
+func listPromotions(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	promos, err := loadPromotions(db, tenantID, c.Query("active") == "true")
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	out := []gin.H{}
+	for _, p := range promos {
+		out = append(out, gin.H{
+			"id": p.ID, "name": p.Name, "code": p.Code, "type": p.Type, "scope": p.Scope,
+			"value": p.Value, "priority": p.Priority, "productIds": p.ProductIDs, "categoryIds": p.CategoryIDs,
+			"buyQuantity": p.BuyQuantity, "getQuantity": p.GetQuantity, "getDiscountPercent": p.GetDiscountPercent,
+			"getProductIds": p.GetProductIDs, "minSubtotal": p.MinSubtotal, "maxDiscount": p.MaxDiscount,
+			"customerTiers": p.CustomerTiers, "stackable": p.Stackable, "exclusive": p.Exclusive,
+			"startsAt": p.StartsAt, "endsAt": p.EndsAt, "usageLimit": p.UsageLimit, "usageCount": p.UsageCount,
+		})
+	}
+	c.JSON(200, gin.H{"promotions": out, "total": len(out)})
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx.
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// loadPromotions reads a tenant's promotions. With activeOnly set, disabled
+// promotions are skipped; date windows and usage limits are left to the
+// promotion engine so it can explain why a code was refused.
+func loadPromotions(q queryer, tenantID string, activeOnly bool) ([]promotion.Promotion, error) {
+	query := `SELECT id, name, COALESCE(code, ''), promo_type, scope, value, priority,
+	                 product_ids, category_ids, buy_quantity, get_quantity, get_discount_percent, get_product_ids,
+	                 min_subtotal, max_discount, customer_tiers, stackable, exclusive, starts_at, ends_at,
+	                 usage_limit, usage_count
+	          FROM promotions WHERE tenant_id = $1`
+	if activeOnly {
+		query += " AND is_active = true"
+	}
+	query += " ORDER BY priority DESC, created_at"
+	rows, err := q.Query(query, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var promos []promotion.Promotion
+	for rows.Next() {
+		var p promotion.Promotion
+		var productIDs, categoryIDs, getProductIDs, tiers string
+		var startsAt, endsAt sql.NullTime
+		if err := rows.Scan(&p.ID, &p.Name, &p.Code, &p.Type, &p.Scope, &p.Value, &p.Priority,
+			&productIDs, &categoryIDs, &p.BuyQuantity, &p.GetQuantity, &p.GetDiscountPercent, &getProductIDs,
+			&p.MinSubtotal, &p.MaxDiscount, &tiers, &p.Stackable, &p.Exclusive, &startsAt, &endsAt,
+			&p.UsageLimit, &p.UsageCount); err != nil {
+			return nil, err
+		}
+		json.Unmarshal([]byte(productIDs), &p.ProductIDs)
+		json.Unmarshal([]byte(categoryIDs), &p.CategoryIDs)
+		json.Unmarshal([]byte(getProductIDs), &p.GetProductIDs)
+		json.Unmarshal([]byte(tiers), &p.CustomerTiers)
+		if startsAt.Valid {
+			p.StartsAt = &startsAt.Time
+		}
+		if endsAt.Valid {
+			p.EndsAt = &endsAt.Time
+		}
+		promos = append(promos, p)
+	}
+	return promos, rows.Err()
+}
+
+// redeemPromotions records the applied promotions on an order and its lines
+// and bumps usage counts. lineItems maps cart line keys to order_items ids.
+func redeemPromotions(tx *sql.Tx, tenantID, orderID string, applied []promotion.Applied, lineItems map[string]string) error {
+	for _, a := range applied {
+		res, err := tx.Exec(
+			`UPDATE promotions SET usage_count = usage_count + 1
+			 WHERE id = $1 AND tenant_id = $2 AND (usage_limit = 0 OR usage_count < usage_limit)`,
+			a.Promotion.ID, tenantID)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return fmt.Errorf("Promotion %s has reached its usage limit", a.Promotion.Name)
+		}
+		var code *string
+		if a.Promotion.Code != "" {
+			code = &a.Promotion.Code
+		}
+		if _, err := tx.Exec(
+			`INSERT INTO order_promotions (id, tenant_id, order_id, promotion_id, name, code, amount)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+			uuid.New().String(), tenantID, orderID, a.Promotion.ID, a.Promotion.Name, code, a.Amount); err != nil {
+			return err
+		}
+		for key, amount := range a.Lines {
+			if amount <= 0 {
+				continue
+			}
+			if _, err := tx.Exec(
+				`INSERT INTO order_item_promotions (id, tenant_id, order_item_id, promotion_id, amount)
+				 VALUES ($1, $2, $3, $4, $5)`,
+				uuid.New().String(), tenantID, lineItems[key], a.Promotion.ID, amount); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// releasePromotions gives back the uses a cancelled or voided order took, so
+// orders that never went through do not count against a usage limit.
+func releasePromotions(tx *sql.Tx, tenantID, orderID string) error {
+	_, err := tx.Exec(
+		`UPDATE promotions p SET usage_count = GREATEST(p.usage_count - u.uses, 0)
+		 FROM (SELECT promotion_id, COUNT(*) AS uses FROM order_promotions
+		       WHERE order_id = $1 AND tenant_id = $2 GROUP BY promotion_id) u
+		 WHERE p.id = u.promotion_id AND p.tenant_id = $2`, orderID, tenantID)
+	return err
+}
+
+func getPromotionReport(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	from := c.DefaultQuery("from", time.Now().AddDate(0, 0, -30).Format("2006-01-02"))
+	to := c.DefaultQuery("to", time.Now().Format("2006-01-02"))
+	rows, err := db.Query(
+		`SELECT op.promotion_id, op.name, COUNT(DISTINCT op.order_id), COALESCE(SUM(op.amount), 0)
+		 FROM order_promotions op
+		 JOIN orders o ON o.id = op.order_id
+		 WHERE op.tenant_id = $1 AND DATE(o.created_at) BETWEEN $2 AND $3 AND o.status IN ('completed', 'refunded')
+		 GROUP BY op.promotion_id, op.name
+		 ORDER BY 4 DESC`, tenantID, from, to)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	report := []gin.H{}
+	for rows.Next() {
+		var pid, name string
+		var orders int
+		var amount money.Amount
+		rows.Scan(&pid, &name, &orders, &amount)
+		report = append(report, gin.H{"promotionId": pid, "name": name, "orders": orders, "discountTotal": amount})
+	}
+	c.JSON(200, gin.H{"from": from, "to": to, "promotions": report})
+}
@@ -0,0 +1,800 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/berhot/products/commerce/pos-engine/internal/money"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ── Purchasing ──────────────────────────────────────────────
+
+// Purchase order statuses.
+const (
+	poDraft             = "draft"
+	poOrdered           = "ordered"
+	poPartiallyReceived = "partially_received"
+	poReceived          = "received"
+	poCancelled         = "cancelled"
+)
+
+func migratePurchasing() {
+	db.Exec(`CREATE TABLE IF NOT EXISTS suppliers (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		tenant_id UUID NOT NULL,
+		name VARCHAR(255) NOT NULL,
+		contact_name VARCHAR(255) NOT NULL DEFAULT '',
+		phone VARCHAR(50) NOT NULL DEFAULT '',
+		email VARCHAR(255) NOT NULL DEFAULT '',
+		vat_number VARCHAR(20) NOT NULL DEFAULT '',
+		address TEXT NOT NULL DEFAULT '',
+		lead_time_days INTEGER NOT NULL DEFAULT 0,
+		payment_terms_days INTEGER NOT NULL DEFAULT 0,
+		notes TEXT NOT NULL DEFAULT '',
+		is_active BOOLEAN NOT NULL DEFAULT true,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`)
+	db.Exec(`CREATE TABLE IF NOT EXISTS purchase_orders (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		tenant_id UUID NOT NULL,
+		supplier_id UUID NOT NULL REFERENCES suppliers(id),
+		location_id UUID NOT NULL REFERENCES locations(id),
+		po_number VARCHAR(50) NOT NULL,
+		status VARCHAR(20) NOT NULL DEFAULT 'draft'
+			CHECK (status IN ('draft', 'ordered', 'partially_received', 'received', 'cancelled')),
+		currency VARCHAR(3) NOT NULL DEFAULT 'SAR',
+		expected_at DATE,
+		notes TEXT NOT NULL DEFAULT '',
+		created_by UUID,
+		ordered_at TIMESTAMPTZ,
+		received_at TIMESTAMPTZ,
+		cancelled_at TIMESTAMPTZ,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		UNIQUE(tenant_id, po_number)
+	)`)
+	db.Exec(`CREATE TABLE IF NOT EXISTS purchase_order_lines (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		tenant_id UUID NOT NULL,
+		purchase_order_id UUID NOT NULL REFERENCES purchase_orders(id) ON DELETE CASCADE,
+		product_id UUID REFERENCES products(id),
+		variant_id UUID REFERENCES product_variants(id),
+		ingredient_id UUID REFERENCES ingredients(id),
+		description VARCHAR(255) NOT NULL,
+		quantity DECIMAL(12,3) NOT NULL CHECK (quantity > 0),
+		received_quantity DECIMAL(12,3) NOT NULL DEFAULT 0,
+		unit_cost DECIMAL(14,6) NOT NULL DEFAULT 0,
+		CHECK ((product_id IS NULL) <> (ingredient_id IS NULL))
+	)`)
+	db.Exec(`CREATE TABLE IF NOT EXISTS goods_receipts (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		tenant_id UUID NOT NULL,
+		purchase_order_id UUID NOT NULL REFERENCES purchase_orders(id),
+		landed_cost DECIMAL(12,2) NOT NULL DEFAULT 0,
+		supplier_invoice VARCHAR(100) NOT NULL DEFAULT '',
+		notes TEXT NOT NULL DEFAULT '',
+		received_by UUID,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`)
+	db.Exec(`CREATE TABLE IF NOT EXISTS goods_receipt_lines (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		tenant_id UUID NOT NULL,
+		goods_receipt_id UUID NOT NULL REFERENCES goods_receipts(id) ON DELETE CASCADE,
+		purchase_order_line_id UUID NOT NULL REFERENCES purchase_order_lines(id),
+		quantity DECIMAL(12,3) NOT NULL,
+		unit_cost DECIMAL(14,6) NOT NULL,
+		landed_unit_cost DECIMAL(14,6) NOT NULL
+	)`)
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_purchase_orders_tenant ON purchase_orders(tenant_id, created_at DESC)")
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_purchase_order_lines_po ON purchase_order_lines(purchase_order_id)")
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_goods_receipts_po ON goods_receipts(purchase_order_id)")
+
+	// Weighted-average cost of what is on the shelf. Ingredients keep theirs
+	// in cost_per_unit.
+	db.Exec(`ALTER TABLE products ADD COLUMN IF NOT EXISTS average_cost DECIMAL(14,6)`)
+	db.Exec(`ALTER TABLE product_variants ADD COLUMN IF NOT EXISTS average_cost DECIMAL(14,6)`)
+	db.Exec(`ALTER TABLE stock_movements ADD COLUMN IF NOT EXISTS unit_cost DECIMAL(14,6)`)
+}
+
+// ── Suppliers ───────────────────────────────────────────────
+
+type supplierRequest struct {
+	Name             string  `json:"name"`
+	ContactName      *string `json:"contactName"`
+	Phone            *string `json:"phone"`
+	Email            *string `json:"email"`
+	VATNumber        *string `json:"vatNumber"`
+	Address          *string `json:"address"`
+	LeadTimeDays     *int    `json:"leadTimeDays"`
+	PaymentTermsDays *int    `json:"paymentTermsDays"`
+	Notes            *string `json:"notes"`
+	IsActive         *bool   `json:"isActive"`
+}
+
+func listSuppliers(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	rows, err := db.Query(
+		`SELECT id, name, contact_name, phone, email, vat_number, address, lead_time_days, payment_terms_days, notes, is_active, created_at
+		 FROM suppliers WHERE tenant_id = $1 ORDER BY name`, tenantID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	suppliers := []gin.H{}
+	for rows.Next() {
+		var id, name, contact, phone, email, vat, address, notes string
+		var leadTime, terms int
+		var active bool
+		var createdAt time.Time
+		rows.Scan(&id, &name, &contact, &phone, &email, &vat, &address, &leadTime, &terms, &notes, &active, &createdAt)
+		suppliers = append(suppliers, gin.H{
+			"id": id, "name": name, "contactName": contact, "phone": phone, "email": email, "vatNumber": vat,
+			"address": address, "leadTimeDays": leadTime, "paymentTermsDays": terms, "notes": notes,
+			"isActive": active, "createdAt": createdAt,
+		})
+	}
+	c.JSON(200, gin.H{"suppliers": suppliers, "total": len(suppliers)})
+}
+
+func createSupplier(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	var req supplierRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Name == "" {
+		c.JSON(400, gin.H{"error": "Supplier name is required"})
+		return
+	}
+	str := func(p *string) string {
+		if p == nil {
+			return ""
+		}
+		return *p
+	}
+	num := func(p *int) int {
+		if p == nil {
+			return 0
+		}
+		return *p
+	}
+	id := uuid.New().String()
+	_, err := db.Exec(
+		`INSERT INTO suppliers (id, tenant_id, name, contact_name, phone, email, vat_number, address, lead_time_days, payment_terms_days, notes)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+		id, tenantID, req.Name, str(req.ContactName), str(req.Phone), str(req.Email), str(req.VATNumber), str(req.Address),
+		num(req.LeadTimeDays), num(req.PaymentTermsDays), str(req.Notes))
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(201, gin.H{"id": id, "name": req.Name, "isActive": true})
+}
+
+func updateSupplier(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	var req supplierRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	res, err := db.Exec(
+		`UPDATE suppliers SET
+			name = COALESCE(NULLIF($1, ''), name),
+			contact_name = COALESCE($2, contact_name),
+			phone = COALESCE($3, phone),
+			email = COALESCE($4, email),
+			vat_number = COALESCE($5, vat_number),
+			address = COALESCE($6, address),
+			lead_time_days = COALESCE($7, lead_time_days),
+			payment_terms_days = COALESCE($8, payment_terms_days),
+			notes = COALESCE($9, notes),
+			is_active = COALESCE($10, is_active),
+			updated_at = NOW()
+		 WHERE id = $11 AND tenant_id = $12`,
+		req.Name, req.ContactName, req.Phone, req.Email, req.VATNumber, req.Address, req.LeadTimeDays,
+		req.PaymentTermsDays, req.Notes, req.IsActive, c.Param("id"), tenantID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(404, gin.H{"error": "Supplier not found"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Supplier updated"})
+}
+
+// ── Purchase Orders ─────────────────────────────────────────
+
+// stockItem names what a purchase line or count line is for: a product (and
+// optionally one of its variants) or an ingredient.
+type stockItem struct {
+	productID    string
+	variantID    *string
+	ingredientID *string
+}
+
+// resolveStockItem checks an item belongs to the tenant and returns its
+// display name and current average cost.
+func resolveStockItem(q queryer, tenantID string, item stockItem) (string, float64, error) {
+	var name string
+	var cost float64
+	var err error
+	switch {
+	case item.ingredientID != nil:
+		err = q.QueryRow("SELECT name, cost_per_unit FROM ingredients WHERE id = $1 AND tenant_id = $2",
+			*item.ingredientID, tenantID).Scan(&name, &cost)
+	case item.variantID != nil:
+		err = q.QueryRow(
+			`SELECT p.name || ' - ' || v.name, COALESCE(v.average_cost, p.average_cost, p.cost_price, 0)
+			 FROM product_variants v JOIN products p ON p.id = v.product_id
+			 WHERE v.id = $1 AND v.product_id = $2 AND v.tenant_id = $3`,
+			*item.variantID, item.productID, tenantID).Scan(&name, &cost)
+	default:
+		err = q.QueryRow("SELECT name, COALESCE(average_cost, cost_price, 0) FROM products WHERE id = $1 AND tenant_id = $2",
+			item.productID, tenantID).Scan(&name, &cost)
+	}
+	if err == sql.ErrNoRows {
+		return "", 0, fmt.Errorf("Item not found")
+	}
+	return name, cost, err
+}
+
+// onHandTotal is what the tenant holds of an item across all locations.
+func onHandTotal(q queryer, tenantID string, item stockItem) float64 {
+	var total float64
+	switch {
+	case item.ingredientID != nil:
+		q.QueryRow("SELECT COALESCE(SUM(quantity), 0) FROM inventory WHERE tenant_id = $1 AND ingredient_id = $2",
+			tenantID, *item.ingredientID).Scan(&total)
+	case item.variantID != nil:
+		q.QueryRow("SELECT COALESCE(SUM(quantity), 0) FROM inventory WHERE tenant_id = $1 AND variant_id = $2",
+			tenantID, *item.variantID).Scan(&total)
+	default:
+		q.QueryRow("SELECT COALESCE(SUM(quantity), 0) FROM inventory WHERE tenant_id = $1 AND product_id = $2 AND variant_id IS NULL",
+			tenantID, item.productID).Scan(&total)
+	}
+	return total
+}
+
+// updateAverageCost folds qty units received at unitCost into the item's
+// weighted-average cost. Stock already below zero counts as none, so an
+// oversold item takes the cost of what arrives.
+func updateAverageCost(tx *sql.Tx, tenantID string, item stockItem, qty, unitCost float64) (float64, error) {
+	_, current, err := resolveStockItem(tx, tenantID, item)
+	if err != nil {
+		return 0, err
+	}
+	onHand := onHandTotal(tx, tenantID, item)
+	if onHand < 0 {
+		onHand = 0
+	}
+	avg := unitCost
+	if onHand+qty > 0 {
+		avg = (onHand*current + qty*unitCost) / (onHand + qty)
+	}
+	switch {
+	case item.ingredientID != nil:
+		_, err = tx.Exec("UPDATE ingredients SET cost_per_unit = $1, updated_at = NOW() WHERE id = $2 AND tenant_id = $3",
+			avg, *item.ingredientID, tenantID)
+	case item.variantID != nil:
+		_, err = tx.Exec("UPDATE product_variants SET average_cost = $1, updated_at = NOW() WHERE id = $2 AND tenant_id = $3",
+			avg, *item.variantID, tenantID)
+	default:
+		_, err = tx.Exec("UPDATE products SET average_cost = $1, updated_at = NOW() WHERE id = $2 AND tenant_id = $3",
+			avg, item.productID, tenantID)
+	}
+	return avg, err
+}
+
+func createPurchaseOrder(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	var req struct {
+		SupplierID string `json:"supplierId" binding:"required"`
+		LocationID string `json:"locationId" binding:"required"`
+		ExpectedAt string `json:"expectedAt"`
+		Notes      string `json:"notes"`
+		Lines      []struct {
+			ProductID    string   `json:"productId"`
+			VariantID    string   `json:"variantId"`
+			IngredientID string   `json:"ingredientId"`
+			Quantity     float64  `json:"quantity" binding:"required"`
+			UnitCost     *float64 `json:"unitCost"`
+		} `json:"lines" binding:"required,min=1"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	var expectedAt *string
+	if req.ExpectedAt != "" {
+		if _, err := time.Parse("2006-01-02", req.ExpectedAt); err != nil {
+			c.JSON(400, gin.H{"error": "expectedAt must be YYYY-MM-DD"})
+			return
+		}
+		expectedAt = &req.ExpectedAt
+	}
+	var supplierActive bool
+	if err := db.QueryRow("SELECT is_active FROM suppliers WHERE id = $1 AND tenant_id = $2", req.SupplierID, tenantID).Scan(&supplierActive); err != nil {
+		c.JSON(404, gin.H{"error": "Supplier not found"})
+		return
+	}
+	if !supplierActive {
+		c.JSON(409, gin.H{"error": "Supplier is inactive"})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Transaction failed"})
+		return
+	}
+	defer tx.Rollback()
+	id := uuid.New().String()
+	number := fmt.Sprintf("PO-%s-%s", time.Now().Format("20060102"), uuid.New().String()[:4])
+	currency := currencyFor(tenantID, req.LocationID)
+	if _, err := tx.Exec(
+		`INSERT INTO purchase_orders (id, tenant_id, supplier_id, location_id, po_number, currency, expected_at, notes, created_by)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		id, tenantID, req.SupplierID, req.LocationID, number, currency, expectedAt, req.Notes, actorFromContext(c).idOrNil()); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	for i, l := range req.Lines {
+		if (l.ProductID == "") == (l.IngredientID == "") {
+			c.JSON(400, gin.H{"error": fmt.Sprintf("Line %d needs either productId or ingredientId", i+1)})
+			return
+		}
+		if l.Quantity <= 0 {
+			c.JSON(400, gin.H{"error": fmt.Sprintf("Line %d quantity must be positive", i+1)})
+			return
+		}
+		item := stockItem{productID: l.ProductID}
+		if l.VariantID != "" {
+			item.variantID = &l.VariantID
+		}
+		if l.IngredientID != "" {
+			item.ingredientID = &l.IngredientID
+		}
+		name, cost, err := resolveStockItem(tx, tenantID, item)
+		if err != nil {
+			c.JSON(400, gin.H{"error": fmt.Sprintf("Line %d: %s", i+1, err.Error())})
+			return
+		}
+		// Lines without a quoted cost are expected at today's average.
+		if l.UnitCost != nil {
+			if *l.UnitCost < 0 {
+				c.JSON(400, gin.H{"error": fmt.Sprintf("Line %d unit cost cannot be negative", i+1)})
+				return
+			}
+			cost = *l.UnitCost
+		}
+		var productID *string
+		if item.ingredientID == nil {
+			productID = &item.productID
+		}
+		if _, err := tx.Exec(
+			`INSERT INTO purchase_order_lines (id, tenant_id, purchase_order_id, product_id, variant_id, ingredient_id, description, quantity, unit_cost)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+			uuid.New().String(), tenantID, id, productID, item.variantID, item.ingredientID, name, l.Quantity, cost); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	po, _ := loadPurchaseOrder(db, tenantID, id)
+	c.JSON(201, po)
+}
+
+// loadPurchaseOrder returns a purchase order with its lines and receipts.
+func loadPurchaseOrder(q queryer, tenantID, id string) (gin.H, error) {
+	var number, status, currency, notes, supplierID, supplierName, locationID string
+	var expectedAt, orderedAt, receivedAt *time.Time
+	var createdAt time.Time
+	err := q.QueryRow(
+		`SELECT po.po_number, po.status, po.currency, po.notes, po.supplier_id, s.name, po.location_id,
+		        po.expected_at, po.ordered_at, po.received_at, po.created_at
+		 FROM purchase_orders po JOIN suppliers s ON s.id = po.supplier_id
+		 WHERE po.id = $1 AND po.tenant_id = $2`, id, tenantID,
+	).Scan(&number, &status, &currency, &notes, &supplierID, &supplierName, &locationID, &expectedAt, &orderedAt, &receivedAt, &createdAt)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := q.Query(
+		`SELECT id, COALESCE(product_id::text, ''), COALESCE(variant_id::text, ''), COALESCE(ingredient_id::text, ''),
+		        description, quantity, received_quantity, unit_cost
+		 FROM purchase_order_lines WHERE purchase_order_id = $1 AND tenant_id = $2 ORDER BY description`, id, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	lines := []gin.H{}
+	var total money.Amount
+	for rows.Next() {
+		var lid, pid, vid, iid, desc string
+		var qty, received, cost float64
+		rows.Scan(&lid, &pid, &vid, &iid, &desc, &qty, &received, &cost)
+		lineTotal := money.FromFloat(qty * cost)
+		total += lineTotal
+		lines = append(lines, gin.H{
+			"id": lid, "productId": pid, "variantId": vid, "ingredientId": iid, "description": desc,
+			"quantity": qty, "receivedQuantity": received, "remainingQuantity": qty - received,
+			"unitCost": cost, "lineTotal": lineTotal,
+		})
+	}
+	rows.Close()
+
+	receipts := []gin.H{}
+	rows, err = q.Query(
+		`SELECT id, landed_cost, supplier_invoice, notes, COALESCE(received_by::text, ''), created_at
+		 FROM goods_receipts WHERE purchase_order_id = $1 AND tenant_id = $2 ORDER BY created_at`, id, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var rid, invoice, rnotes, by string
+		var landed money.Amount
+		var at time.Time
+		rows.Scan(&rid, &landed, &invoice, &rnotes, &by, &at)
+		receipts = append(receipts, gin.H{"id": rid, "landedCost": landed, "supplierInvoice": invoice, "notes": rnotes, "receivedBy": by, "receivedAt": at})
+	}
+	rows.Close()
+
+	return gin.H{
+		"id": id, "poNumber": number, "status": status, "currency": currency, "notes": notes,
+		"supplierId": supplierID, "supplierName": supplierName, "locationId": locationID,
+		"expectedAt": expectedAt, "orderedAt": orderedAt, "receivedAt": receivedAt, "createdAt": createdAt,
+		"lines": lines, "total": total, "receipts": receipts,
+	}, nil
+}
+
+func listPurchaseOrders(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	query := `SELECT po.id, po.po_number, po.status, po.currency, s.name, po.location_id, po.expected_at, po.created_at,
+	                 COALESCE((SELECT SUM(quantity * unit_cost) FROM purchase_order_lines WHERE purchase_order_id = po.id), 0)
+	          FROM purchase_orders po JOIN suppliers s ON s.id = po.supplier_id
+	          WHERE po.tenant_id = $1`
+	args := []interface{}{tenantID}
+	if status := c.Query("status"); status != "" {
+		args = append(args, status)
+		query += fmt.Sprintf(" AND po.status = $%d", len(args))
+	}
+	if supplier := c.Query("supplierId"); supplier != "" {
+		args = append(args, supplier)
+		query += fmt.Sprintf(" AND po.supplier_id = $%d", len(args))
+	}
+	query += " ORDER BY po.created_at DESC LIMIT 50"
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	orders := []gin.H{}
+	for rows.Next() {
+		var id, number, status, currency, supplier, locationID string
+		var expectedAt *time.Time
+		var createdAt time.Time
+		var total float64
+		rows.Scan(&id, &number, &status, &currency, &supplier, &locationID, &expectedAt, &createdAt, &total)
+		orders = append(orders, gin.H{
+			"id": id, "poNumber": number, "status": status, "currency": currency, "supplierName": supplier,
+			"locationId": locationID, "expectedAt": expectedAt, "createdAt": createdAt, "total": money.FromFloat(total),
+		})
+	}
+	c.JSON(200, gin.H{"purchaseOrders": orders, "total": len(orders)})
+}
+
+func getPurchaseOrder(c *gin.Context) {
+	po, err := loadPurchaseOrder(db, c.GetString("tenantId"), c.Param("id"))
+	if err == sql.ErrNoRows {
+		c.JSON(404, gin.H{"error": "Purchase order not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, po)
+}
+
+func submitPurchaseOrder(c *gin.Context) {
+	changePurchaseOrderStatus(c, []string{poDraft}, poOrdered, "ordered_at", "Purchase order sent")
+}
+
+func cancelPurchaseOrder(c *gin.Context) {
+	changePurchaseOrderStatus(c, []string{poDraft, poOrdered}, poCancelled, "cancelled_at", "Purchase order cancelled")
+}
+
+// changePurchaseOrderStatus moves a purchase order on from one of the from
+// statuses. Orders that have started receiving can no longer be cancelled.
+func changePurchaseOrderStatus(c *gin.Context, from []string, to, stamp, message string) {
+	tenantID := c.GetString("tenantId")
+	id := c.Param("id")
+	var status string
+	if err := db.QueryRow("SELECT status FROM purchase_orders WHERE id = $1 AND tenant_id = $2", id, tenantID).Scan(&status); err != nil {
+		c.JSON(404, gin.H{"error": "Purchase order not found"})
+		return
+	}
+	allowed := false
+	for _, f := range from {
+		allowed = allowed || f == status
+	}
+	if !allowed {
+		c.JSON(409, gin.H{"error": fmt.Sprintf("Cannot move a %s purchase order to %s", status, to)})
+		return
+	}
+	res, err := db.Exec(
+		"UPDATE purchase_orders SET status = $1, "+stamp+" = NOW(), updated_at = NOW() WHERE id = $2 AND tenant_id = $3 AND status = $4",
+		to, id, tenantID, status)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(409, gin.H{"error": "Purchase order changed, try again"})
+		return
+	}
+	c.JSON(200, gin.H{"message": message, "id": id, "status": to})
+}
+
+// receivePurchaseOrder books goods in against a purchase order. Lines not
+// listed are left open; with no lines at all everything outstanding is
+// received. Landed cost (freight, duties) is spread over the received lines
+// by value and lands in each item's weighted-average cost.
+func receivePurchaseOrder(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	poID := c.Param("id")
+	var req struct {
+		LandedCost      money.Amount `json:"landedCost"`
+		SupplierInvoice string       `json:"supplierInvoice"`
+		Notes           string       `json:"notes"`
+		Lines           []struct {
+			LineID   string   `json:"lineId" binding:"required"`
+			Quantity float64  `json:"quantity" binding:"required"`
+			UnitCost *float64 `json:"unitCost"`
+		} `json:"lines"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if req.LandedCost < 0 {
+		c.JSON(400, gin.H{"error": "landedCost cannot be negative"})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Transaction failed"})
+		return
+	}
+	defer tx.Rollback()
+	var status, locationID string
+	err = tx.QueryRow("SELECT status, location_id FROM purchase_orders WHERE id = $1 AND tenant_id = $2 FOR UPDATE", poID, tenantID).Scan(&status, &locationID)
+	if err == sql.ErrNoRows {
+		c.JSON(404, gin.H{"error": "Purchase order not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if status != poOrdered && status != poPartiallyReceived {
+		c.JSON(409, gin.H{"error": fmt.Sprintf("Cannot receive a %s purchase order", status)})
+		return
+	}
+
+	type poLine struct {
+		id, description    string
+		item               stockItem
+		remaining, ordered float64
+		unitCost           float64
+	}
+	lines := map[string]*poLine{}
+	var order []string
+	rows, err := tx.Query(
+		`SELECT id, COALESCE(product_id::text, ''), variant_id::text, ingredient_id::text, description,
+		        quantity - received_quantity, unit_cost
+		 FROM purchase_order_lines WHERE purchase_order_id = $1 AND tenant_id = $2 ORDER BY description FOR UPDATE`, poID, tenantID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	for rows.Next() {
+		var l poLine
+		var variantID, ingredientID sql.NullString
+		rows.Scan(&l.id, &l.item.productID, &variantID, &ingredientID, &l.description, &l.remaining, &l.unitCost)
+		if variantID.Valid {
+			l.item.variantID = &variantID.String
+		}
+		if ingredientID.Valid {
+			l.item.ingredientID = &ingredientID.String
+		}
+		lines[l.id] = &l
+		order = append(order, l.id)
+	}
+	rows.Close()
+
+	type receipt struct {
+		line     *poLine
+		quantity float64
+		unitCost float64
+	}
+	var receipts []receipt
+	if len(req.Lines) == 0 {
+		for _, id := range order {
+			if l := lines[id]; l.remaining > 0 {
+				receipts = append(receipts, receipt{l, l.remaining, l.unitCost})
+			}
+		}
+	} else {
+		for _, rl := range req.Lines {
+			l, ok := lines[rl.LineID]
+			if !ok {
+				c.JSON(400, gin.H{"error": fmt.Sprintf("Line %s is not on this purchase order", rl.LineID)})
+				return
+			}
+			if rl.Quantity <= 0 || rl.Quantity > l.remaining {
+				c.JSON(400, gin.H{"error": fmt.Sprintf("%s has %g left to receive", l.description, l.remaining)})
+				return
+			}
+			cost := l.unitCost
+			if rl.UnitCost != nil {
+				cost = *rl.UnitCost
+			}
+			l.remaining -= rl.Quantity
+			receipts = append(receipts, receipt{l, rl.Quantity, cost})
+		}
+	}
+	if len(receipts) == 0 {
+		c.JSON(400, gin.H{"error": "Nothing left to receive"})
+		return
+	}
+
+	weights := make([]money.Amount, len(receipts))
+	var value money.Amount
+	for i, r := range receipts {
+		weights[i] = money.FromFloat(r.quantity * r.unitCost)
+		value += weights[i]
+	}
+	// Free goods still carry freight, so spread by quantity if nothing has value.
+	if value == 0 {
+		for i, r := range receipts {
+			weights[i] = money.FromFloat(r.quantity)
+		}
+	}
+	landed := req.LandedCost.Allocate(weights)
+
+	by := actorFromContext(c)
+	receiptID := uuid.New().String()
+	if _, err := tx.Exec(
+		`INSERT INTO goods_receipts (id, tenant_id, purchase_order_id, landed_cost, supplier_invoice, notes, received_by)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		receiptID, tenantID, poID, req.LandedCost, req.SupplierInvoice, req.Notes, by.idOrNil()); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	received := []gin.H{}
+	for i, r := range receipts {
+		landedUnit := r.unitCost + landed[i].Float64()/r.quantity
+		avg, err := updateAverageCost(tx, tenantID, r.line.item, r.quantity, landedUnit)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		if _, err := recordStockMovement(tx, tenantID, stockMovement{
+			productID: r.line.item.productID, variantID: r.line.item.variantID, ingredientID: r.line.item.ingredientID,
+			locationID: locationID, kind: stockReceipt, quantity: r.quantity, unitCost: &landedUnit,
+			reason: "Received on purchase order", referenceType: "goods_receipt", referenceID: receiptID, by: by,
+		}); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		if _, err := tx.Exec(
+			`INSERT INTO goods_receipt_lines (id, tenant_id, goods_receipt_id, purchase_order_line_id, quantity, unit_cost, landed_unit_cost)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+			uuid.New().String(), tenantID, receiptID, r.line.id, r.quantity, r.unitCost, landedUnit); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		if _, err := tx.Exec("UPDATE purchase_order_lines SET received_quantity = received_quantity + $1 WHERE id = $2 AND tenant_id = $3",
+			r.quantity, r.line.id, tenantID); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		received = append(received, gin.H{
+			"lineId": r.line.id, "description": r.line.description, "quantity": r.quantity,
+			"unitCost": r.unitCost, "landedUnitCost": landedUnit, "averageCost": avg,
+		})
+	}
+
+	var outstanding bool
+	tx.QueryRow("SELECT EXISTS(SELECT 1 FROM purchase_order_lines WHERE purchase_order_id = $1 AND received_quantity < quantity)", poID).Scan(&outstanding)
+	newStatus := poReceived
+	if outstanding {
+		newStatus = poPartiallyReceived
+	}
+	if _, err := tx.Exec(
+		`UPDATE purchase_orders SET status = $1, received_at = CASE WHEN $1 = 'received' THEN NOW() ELSE received_at END, updated_at = NOW()
+		 WHERE id = $2 AND tenant_id = $3`, newStatus, poID, tenantID); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(201, gin.H{"receiptId": receiptID, "purchaseOrderId": poID, "status": newStatus, "landedCost": req.LandedCost, "lines": received})
+}
+
+// getReorderSuggestions lists stock at or below its low-stock threshold with
+// a quantity that brings it back up to twice the threshold, less whatever is
+// already on order. The supplier last ordered from is suggested with it.
+func getReorderSuggestions(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	locationID := c.Query("locationId")
+	rows, err := db.Query(
+		`SELECT i.location_id, COALESCE(i.product_id::text, ''), COALESCE(i.variant_id::text, ''), COALESCE(i.ingredient_id::text, ''),
+		        COALESCE(ing.name, p.name || COALESCE(' - ' || v.name, '')), COALESCE(ing.unit, 'pcs'),
+		        i.quantity, COALESCE(i.low_stock_threshold, 0),
+		        COALESCE((SELECT SUM(pol.quantity - pol.received_quantity)
+		                  FROM purchase_order_lines pol JOIN purchase_orders po ON po.id = pol.purchase_order_id
+		                  WHERE po.tenant_id = i.tenant_id AND po.location_id = i.location_id
+		                    AND po.status IN ('draft', 'ordered', 'partially_received')
+		                    AND pol.product_id IS NOT DISTINCT FROM i.product_id
+		                    AND pol.variant_id IS NOT DISTINCT FROM i.variant_id
+		                    AND pol.ingredient_id IS NOT DISTINCT FROM i.ingredient_id), 0),
+		        last.supplier_id, COALESCE(last.name, ''), COALESCE(last.unit_cost, 0)
+		 FROM inventory i
+		 LEFT JOIN products p ON p.id = i.product_id
+		 LEFT JOIN product_variants v ON v.id = i.variant_id
+		 LEFT JOIN ingredients ing ON ing.id = i.ingredient_id
+		 LEFT JOIN LATERAL (
+		     SELECT po.supplier_id, s.name, pol.unit_cost
+		     FROM purchase_order_lines pol
+		     JOIN purchase_orders po ON po.id = pol.purchase_order_id
+		     JOIN suppliers s ON s.id = po.supplier_id
+		     WHERE po.tenant_id = i.tenant_id AND po.status <> 'cancelled'
+		       AND pol.product_id IS NOT DISTINCT FROM i.product_id
+		       AND pol.variant_id IS NOT DISTINCT FROM i.variant_id
+		       AND pol.ingredient_id IS NOT DISTINCT FROM i.ingredient_id
+		     ORDER BY po.created_at DESC LIMIT 1
+		 ) last ON true
+		 WHERE i.tenant_id = $1 AND ($2 = '' OR i.location_id::text = $2)
+		   AND i.quantity <= COALESCE(i.low_stock_threshold, 0)
+		   AND (i.ingredient_id IS NOT NULL OR p.track_inventory)
+		 ORDER BY last.name NULLS LAST, 5`, tenantID, locationID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	suggestions := []gin.H{}
+	for rows.Next() {
+		var locID, pid, vid, iid, name, unit, supplierName string
+		var onHand, threshold, onOrder, lastCost float64
+		var supplierID sql.NullString
+		rows.Scan(&locID, &pid, &vid, &iid, &name, &unit, &onHand, &threshold, &onOrder, &supplierID, &supplierName, &lastCost)
+		suggested := 2*threshold - onHand - onOrder
+		if suggested <= 0 {
+			continue
+		}
+		suggestions = append(suggestions, gin.H{
+			"locationId": locID, "productId": pid, "variantId": vid, "ingredientId": iid, "name": name, "unit": unit,
+			"onHand": onHand, "lowStockThreshold": threshold, "onOrder": onOrder, "suggestedQuantity": suggested,
+			"supplierId": supplierID.String, "supplierName": supplierName, "lastUnitCost": lastCost,
+		})
+	}
+	c.JSON(200, gin.H{"suggestions": suggestions, "total": len(suggestions)})
+}
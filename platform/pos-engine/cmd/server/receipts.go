@@ -0,0 +1,398 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/berhot/products/commerce/pos-engine/internal/receipt"
+	"github.com/berhot/products/commerce/pos-engine/internal/zatca"
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// ── Receipts ────────────────────────────────────────────────
+
+// receiptFont sets Arabic text in PDF receipts. It is loaded from
+// RECEIPT_FONT_FILE, a TrueType font with Arabic presentation forms; without
+// one PDF receipts are refused for templates that show Arabic.
+var receiptFont *receipt.Font
+
+func migrateReceipts() {
+	db.Exec(`CREATE TABLE IF NOT EXISTS receipt_templates (
+		tenant_id UUID PRIMARY KEY,
+		store_name VARCHAR(255) NOT NULL DEFAULT '',
+		store_name_ar VARCHAR(255) NOT NULL DEFAULT '',
+		logo_url TEXT NOT NULL DEFAULT '',
+		header_text TEXT NOT NULL DEFAULT '',
+		footer_text TEXT NOT NULL DEFAULT '',
+		show_arabic BOOLEAN NOT NULL DEFAULT true,
+		show_qr BOOLEAN NOT NULL DEFAULT true,
+		escpos_code_page INT NOT NULL DEFAULT 37 CHECK (escpos_code_page BETWEEN 0 AND 255),
+		paper_width INT NOT NULL DEFAULT 80 CHECK (paper_width IN (58, 80)),
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`)
+
+	path := os.Getenv("RECEIPT_FONT_FILE")
+	if path == "" {
+		log.Println("POS Engine: RECEIPT_FONT_FILE not set, bilingual PDF receipts are disabled")
+		return
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("Failed to read receipt font: %v", err)
+	}
+	if receiptFont, err = receipt.ParseFont(data); err != nil {
+		log.Fatalf("Failed to load receipt font: %v", err)
+	}
+}
+
+// receiptSettings is a tenant's receipt template as stored. Store name and
+// logo left empty fall back to the tenant's own.
+type receiptSettings struct {
+	StoreName   string   `json:"storeName"`
+	StoreNameAr string   `json:"storeNameAr"`
+	LogoURL     string   `json:"logoUrl"`
+	Header      []string `json:"header"`
+	Footer      []string `json:"footer"`
+	ShowArabic  bool     `json:"showArabic"`
+	ShowQR      bool     `json:"showQr"`
+	CodePage    int      `json:"escposCodePage"`
+	PaperWidth  int      `json:"paperWidth"`
+}
+
+func loadReceiptSettings(tenantID string) (receiptSettings, error) {
+	s := receiptSettings{ShowArabic: true, ShowQR: true, CodePage: 37, PaperWidth: 80}
+	var header, footer string
+	err := db.QueryRow(
+		`SELECT store_name, store_name_ar, logo_url, header_text, footer_text, show_arabic, show_qr, escpos_code_page, paper_width
+		 FROM receipt_templates WHERE tenant_id = $1`, tenantID,
+	).Scan(&s.StoreName, &s.StoreNameAr, &s.LogoURL, &header, &footer, &s.ShowArabic, &s.ShowQR, &s.CodePage, &s.PaperWidth)
+	if err != nil && err != sql.ErrNoRows {
+		return s, err
+	}
+	s.Header, s.Footer = splitLines(header), splitLines(footer)
+	return s, nil
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(s, "\n")
+}
+
+func getReceiptTemplate(c *gin.Context) {
+	s, err := loadReceiptSettings(c.GetString("tenantId"))
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, s)
+}
+
+func updateReceiptTemplate(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	var req struct {
+		StoreName   string   `json:"storeName"`
+		StoreNameAr string   `json:"storeNameAr"`
+		LogoURL     string   `json:"logoUrl"`
+		Header      []string `json:"header"`
+		Footer      []string `json:"footer"`
+		ShowArabic  *bool    `json:"showArabic"`
+		ShowQR      *bool    `json:"showQr"`
+		CodePage    *int     `json:"escposCodePage"`
+		PaperWidth  int      `json:"paperWidth"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	s := receiptSettings{
+		StoreName: req.StoreName, StoreNameAr: req.StoreNameAr, LogoURL: req.LogoURL,
+		Header: req.Header, Footer: req.Footer, ShowArabic: true, ShowQR: true, CodePage: 37, PaperWidth: 80,
+	}
+	if req.ShowArabic != nil {
+		s.ShowArabic = *req.ShowArabic
+	}
+	if req.ShowQR != nil {
+		s.ShowQR = *req.ShowQR
+	}
+	if req.CodePage != nil {
+		if *req.CodePage < 0 || *req.CodePage > 255 {
+			c.JSON(400, gin.H{"error": "escposCodePage must be between 0 and 255"})
+			return
+		}
+		s.CodePage = *req.CodePage
+	}
+	if req.PaperWidth != 0 {
+		if req.PaperWidth != 58 && req.PaperWidth != 80 {
+			c.JSON(400, gin.H{"error": "paperWidth must be 58 or 80"})
+			return
+		}
+		s.PaperWidth = req.PaperWidth
+	}
+	for _, l := range append(append([]string{}, s.Header...), s.Footer...) {
+		if strings.ContainsAny(l, "\r\n") {
+			c.JSON(400, gin.H{"error": "Header and footer lines cannot contain line breaks"})
+			return
+		}
+	}
+	_, err := db.Exec(
+		`INSERT INTO receipt_templates (tenant_id, store_name, store_name_ar, logo_url, header_text, footer_text, show_arabic, show_qr, escpos_code_page, paper_width)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		 ON CONFLICT (tenant_id) DO UPDATE SET
+			store_name = EXCLUDED.store_name, store_name_ar = EXCLUDED.store_name_ar, logo_url = EXCLUDED.logo_url,
+			header_text = EXCLUDED.header_text, footer_text = EXCLUDED.footer_text, show_arabic = EXCLUDED.show_arabic,
+			show_qr = EXCLUDED.show_qr, escpos_code_page = EXCLUDED.escpos_code_page, paper_width = EXCLUDED.paper_width,
+			updated_at = NOW()`,
+		tenantID, s.StoreName, s.StoreNameAr, s.LogoURL, strings.Join(s.Header, "\n"), strings.Join(s.Footer, "\n"),
+		s.ShowArabic, s.ShowQR, s.CodePage, s.PaperWidth)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Receipt template saved"})
+}
+
+// loadLogo decodes a logo uploaded to this server. Logos hosted elsewhere
+// are not fetched; HTML receipts link to them and the other formats go
+// without.
+func loadLogo(url string) image.Image {
+	if !strings.HasPrefix(url, "/uploads/") {
+		return nil
+	}
+	f, err := os.Open(filepath.Join("./uploads", filepath.Base(url)))
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil
+	}
+	return img
+}
+
+// buildReceipt gathers an order into a receipt under the tenant's
+// template.
+func buildReceipt(tenantID, orderID string, s receiptSettings) (receipt.Receipt, error) {
+	r := receipt.Receipt{Title: "Receipt", TitleAr: "إيصال"}
+	var inclusive bool
+	err := db.QueryRow(
+		`SELECT order_number, created_at, currency, subtotal, COALESCE(discount_amount, 0), tax_amount, total, prices_include_tax
+		 FROM orders WHERE id = $1 AND tenant_id = $2`, orderID, tenantID,
+	).Scan(&r.OrderNumber, &r.IssuedAt, &r.Currency, &r.Subtotal, &r.Discount, &r.Tax, &r.Total, &inclusive)
+	if err != nil {
+		return r, err
+	}
+	r.PricesIncludeTax = inclusive
+
+	var tenantName, tenantLogo sql.NullString
+	db.QueryRow("SELECT name, logo_url FROM tenants WHERE id = $1", tenantID).Scan(&tenantName, &tenantLogo)
+	t := receipt.Template{
+		StoreName: s.StoreName, StoreNameAr: s.StoreNameAr, LogoURL: s.LogoURL,
+		Header: s.Header, Footer: s.Footer, Arabic: s.ShowArabic, ShowQR: s.ShowQR, CodePage: s.CodePage,
+	}
+	if t.StoreName == "" {
+		t.StoreName = tenantName.String
+	}
+	if t.LogoURL == "" {
+		t.LogoURL = tenantLogo.String
+	}
+	t.Logo = loadLogo(t.LogoURL)
+
+	rows, err := db.Query(
+		`SELECT oi.name,
+		        COALESCE(p.name_ar, '') || CASE WHEN COALESCE(v.name_ar, '') <> '' THEN ' - ' || v.name_ar ELSE '' END,
+		        oi.quantity, oi.unit_price, COALESCE(oi.discount_amount, 0), oi.total_price, COALESCE(oi.modifiers, '[]')
+		 FROM order_items oi
+		 LEFT JOIN products p ON p.id = oi.product_id
+		 LEFT JOIN product_variants v ON v.id = oi.variant_id
+		 WHERE oi.order_id = $1 AND oi.tenant_id = $2 AND oi.line_status <> 'voided'
+		 ORDER BY oi.round, oi.created_at`, orderID, tenantID)
+	if err != nil {
+		return r, err
+	}
+	var modifierIDs []string
+	var itemMods [][]orderModifier
+	for rows.Next() {
+		var it receipt.Item
+		var mods string
+		if err := rows.Scan(&it.Name, &it.NameAr, &it.Quantity, &it.UnitPrice, &it.Discount, &it.Total, &mods); err != nil {
+			rows.Close()
+			return r, err
+		}
+		var chosen []orderModifier
+		json.Unmarshal([]byte(mods), &chosen)
+		for _, m := range chosen {
+			modifierIDs = append(modifierIDs, m.ItemID)
+		}
+		r.Items = append(r.Items, it)
+		itemMods = append(itemMods, chosen)
+	}
+	rows.Close()
+
+	modifierAr := map[string]string{}
+	if len(modifierIDs) > 0 {
+		modRows, err := db.Query("SELECT id, COALESCE(name_ar, '') FROM modifier_items WHERE tenant_id = $1 AND id::text = ANY($2)", tenantID, pq.Array(modifierIDs))
+		if err != nil {
+			return r, err
+		}
+		for modRows.Next() {
+			var id, nameAr string
+			modRows.Scan(&id, &nameAr)
+			modifierAr[id] = nameAr
+		}
+		modRows.Close()
+	}
+	for i, chosen := range itemMods {
+		for _, m := range chosen {
+			r.Items[i].Modifiers = append(r.Items[i].Modifiers, receipt.Modifier{Name: m.ItemName, NameAr: modifierAr[m.ItemID], Price: m.Price})
+		}
+	}
+
+	taxRows, err := db.Query(
+		`SELECT s.rate, s.taxable_amount, s.tax_amount, COALESCE(tc.name, ''), COALESCE(tc.name_ar, '')
+		 FROM order_tax_summary s
+		 LEFT JOIN tax_categories tc ON tc.tenant_id = s.tenant_id AND tc.code = s.tax_category_code
+		 WHERE s.order_id = $1 AND s.tenant_id = $2 ORDER BY s.rate DESC, s.tax_kind`, orderID, tenantID)
+	if err != nil {
+		return r, err
+	}
+	for taxRows.Next() {
+		var rate float64
+		var tl receipt.TaxLine
+		var name, nameAr string
+		taxRows.Scan(&rate, &tl.Taxable, &tl.Tax, &name, &nameAr)
+		pct := strconv.FormatFloat(rate, 'f', -1, 64) + "%"
+		tl.Label, tl.LabelAr = "VAT "+pct, "ضريبة القيمة المضافة "+pct
+		if name != "" {
+			tl.Label = fmt.Sprintf("%s %s", name, pct)
+		}
+		if nameAr != "" {
+			tl.LabelAr = fmt.Sprintf("%s %s", nameAr, pct)
+		}
+		r.Taxes = append(r.Taxes, tl)
+	}
+	taxRows.Close()
+
+	payRows, err := db.Query(
+		`SELECT method, amount, COALESCE(tendered_amount, 0), change_amount FROM payments
+		 WHERE order_id = $1 AND tenant_id = $2 AND status IN ('completed', 'refunded') ORDER BY created_at`, orderID, tenantID)
+	if err != nil {
+		return r, err
+	}
+	for payRows.Next() {
+		var p receipt.Payment
+		payRows.Scan(&p.Method, &p.Amount, &p.Tendered, &p.Change)
+		if p.Tendered == p.Amount {
+			p.Tendered = 0
+		}
+		r.Payments = append(r.Payments, p)
+	}
+	payRows.Close()
+
+	// A signed e-invoice's QR is printed as issued. Before one is issued,
+	// or for orders that never get one, tenants set up for e-invoicing print
+	// the simplified invoice QR of the first five fields.
+	seller, invoicing, err := loadSeller(db, tenantID)
+	if err != nil {
+		return r, err
+	}
+	if invoicing {
+		r.Title, r.TitleAr = "Simplified Tax Invoice", "فاتورة ضريبية مبسطة"
+		t.VATNumber = seller.VATNumber
+		var issuedAt time.Time
+		err := db.QueryRow("SELECT qr_code, issued_at FROM e_invoices WHERE tenant_id = $1 AND order_id = $2", tenantID, orderID).Scan(&r.QR, &issuedAt)
+		switch {
+		case err == sql.ErrNoRows:
+			if r.QR, err = zatca.QR(seller.Name, seller.VATNumber, zatca.Timestamp(r.IssuedAt), r.Total.String(), r.Tax.String()); err != nil {
+				return r, err
+			}
+		case err != nil:
+			return r, err
+		default:
+			r.IssuedAt = issuedAt
+		}
+	}
+	r.Template = t
+	return r, nil
+}
+
+// getOrderReceipt renders an order's receipt. format is text (the default),
+// escpos, html or pdf; paper is 58 or 80 and defaults to the template's.
+func getOrderReceipt(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	orderID := c.Param("id")
+
+	s, err := loadReceiptSettings(tenantID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	paper := receipt.Paper(s.PaperWidth)
+	if p := c.Query("paper"); p != "" {
+		switch p {
+		case "58":
+			paper = receipt.Paper58
+		case "80":
+			paper = receipt.Paper80
+		default:
+			c.JSON(400, gin.H{"error": "paper must be 58 or 80"})
+			return
+		}
+	}
+	format := c.DefaultQuery("format", "text")
+	if format != "text" && format != "escpos" && format != "html" && format != "pdf" {
+		c.JSON(400, gin.H{"error": "format must be text, escpos, html or pdf"})
+		return
+	}
+	if format == "pdf" && s.ShowArabic && receiptFont == nil {
+		c.JSON(501, gin.H{"error": "PDF receipts need an Arabic font: set RECEIPT_FONT_FILE, or use format=html"})
+		return
+	}
+
+	r, err := buildReceipt(tenantID, orderID, s)
+	if err == sql.ErrNoRows {
+		c.JSON(404, gin.H{"error": "Order not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	name := fmt.Sprintf("receipt-%s", r.OrderNumber)
+	switch format {
+	case "text":
+		c.Data(200, "text/plain; charset=utf-8", []byte(receipt.Text(r, paper)))
+	case "escpos":
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.bin"`, name))
+		c.Data(200, "application/octet-stream", receipt.ESCPOS(r, paper))
+	case "html":
+		out, err := receipt.HTML(r, paper)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		c.Data(200, "text/html; charset=utf-8", out)
+	case "pdf":
+		out, err := receipt.PDF(r, paper, receiptFont)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		c.Header("Content-Disposition", fmt.Sprintf(`inline; filename="%s.pdf"`, name))
+		c.Data(200, "application/pdf", out)
+	}
+}
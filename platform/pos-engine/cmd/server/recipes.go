@@ -0,0 +1,617 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/berhot/products/commerce/pos-engine/internal/money"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ── Ingredients & Recipes ───────────────────────────────────
+
+// Units ingredients are stocked in. Recipes may use any unit of the same
+// dimension and are converted on save.
+var ingredientUnits = map[string]struct {
+	dimension string
+	factor    float64
+}{
+	"g":   {"mass", 1},
+	"kg":  {"mass", 1000},
+	"ml":  {"volume", 1},
+	"l":   {"volume", 1000},
+	"pcs": {"count", 1},
+}
+
+// convertUnit converts qty from one unit to another of the same dimension.
+func convertUnit(qty float64, from, to string) (float64, error) {
+	f, okFrom := ingredientUnits[from]
+	t, okTo := ingredientUnits[to]
+	if !okFrom || !okTo || f.dimension != t.dimension {
+		return 0, fmt.Errorf("Cannot convert %s to %s", from, to)
+	}
+	return qty * f.factor / t.factor, nil
+}
+
+func migrateRecipes() {
+	db.Exec(`CREATE TABLE IF NOT EXISTS ingredients (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		tenant_id UUID NOT NULL,
+		name VARCHAR(255) NOT NULL,
+		name_en VARCHAR(255),
+		name_ar VARCHAR(255),
+		sku VARCHAR(100),
+		unit VARCHAR(10) NOT NULL CHECK (unit IN ('g', 'kg', 'ml', 'l', 'pcs')),
+		cost_per_unit DECIMAL(14,6) NOT NULL DEFAULT 0,
+		is_active BOOLEAN NOT NULL DEFAULT true,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`)
+	db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_ingredients_name ON ingredients(tenant_id, LOWER(name))")
+
+	// Ingredients are stocked and moved through the same inventory rows and
+	// ledger as products.
+	db.Exec(`ALTER TABLE inventory ALTER COLUMN product_id DROP NOT NULL`)
+	db.Exec(`ALTER TABLE inventory ADD COLUMN IF NOT EXISTS ingredient_id UUID REFERENCES ingredients(id)`)
+	db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_inventory_ingredient_location ON inventory(tenant_id, ingredient_id, location_id) WHERE ingredient_id IS NOT NULL`)
+	db.Exec(`ALTER TABLE stock_movements ALTER COLUMN product_id DROP NOT NULL`)
+	db.Exec(`ALTER TABLE stock_movements ADD COLUMN IF NOT EXISTS ingredient_id UUID REFERENCES ingredients(id)`)
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_stock_movements_ingredient ON stock_movements(tenant_id, ingredient_id, location_id, created_at DESC) WHERE ingredient_id IS NOT NULL")
+
+	// A recipe row belongs to a product (optionally only one of its variants)
+	// or to a modifier item. Variant and modifier rows add to the product's
+	// base recipe; a negative quantity takes away, so "Oat Milk" can swap out
+	// the regular milk.
+	db.Exec(`CREATE TABLE IF NOT EXISTS recipe_items (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		tenant_id UUID NOT NULL,
+		product_id UUID REFERENCES products(id) ON DELETE CASCADE,
+		variant_id UUID REFERENCES product_variants(id) ON DELETE CASCADE,
+		modifier_item_id UUID REFERENCES modifier_items(id) ON DELETE CASCADE,
+		ingredient_id UUID NOT NULL REFERENCES ingredients(id) ON DELETE CASCADE,
+		quantity DECIMAL(12,3) NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		CHECK ((product_id IS NULL) <> (modifier_item_id IS NULL))
+	)`)
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_recipe_items_product ON recipe_items(product_id)")
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_recipe_items_modifier ON recipe_items(modifier_item_id)")
+}
+
+// deductOrderIngredients takes the ingredients an order used out of stock
+// when it completes: each line's product and variant recipe, the recipe
+// of every modifier chosen on it and those of a combo's components, times
+// the line quantity. Lines voided before
+// the kitchen started on them used nothing; ones voided later were made and
+// count as used.
+func deductOrderIngredients(tx *sql.Tx, tenantID, orderID string, by actor) error {
+	var locationID string
+	if err := tx.QueryRow("SELECT location_id FROM orders WHERE id = $1 AND tenant_id = $2", orderID, tenantID).Scan(&locationID); err != nil {
+		return err
+	}
+	rows, err := tx.Query(
+		`SELECT ingredient_id, SUM(used) FROM (
+			SELECT ri.ingredient_id, oi.quantity * ri.quantity AS used
+			FROM order_items oi
+			JOIN recipe_items ri ON ri.product_id = oi.product_id AND (ri.variant_id IS NULL OR ri.variant_id = oi.variant_id)
+			WHERE oi.order_id = $1 AND oi.tenant_id = $2 AND NOT (oi.line_status = 'voided' AND COALESCE(oi.kitchen_status, 'new') = 'new')
+			UNION ALL
+			SELECT ri.ingredient_id, oi.quantity * ri.quantity
+			FROM order_items oi
+			CROSS JOIN LATERAL jsonb_array_elements(COALESCE(oi.modifiers, '[]'::jsonb)) m
+			JOIN recipe_items ri ON ri.modifier_item_id::text = m->>'itemId'
+			WHERE oi.order_id = $1 AND oi.tenant_id = $2 AND NOT (oi.line_status = 'voided' AND COALESCE(oi.kitchen_status, 'new') = 'new')
+			UNION ALL
+			SELECT ri.ingredient_id, oi.quantity * oic.quantity * ri.quantity
+			FROM order_items oi
+			JOIN order_item_components oic ON oic.order_item_id = oi.id
+			JOIN recipe_items ri ON ri.product_id = oic.product_id AND (ri.variant_id IS NULL OR ri.variant_id = oic.variant_id)
+			WHERE oi.order_id = $1 AND oi.tenant_id = $2 AND NOT (oi.line_status = 'voided' AND COALESCE(oi.kitchen_status, 'new') = 'new')
+		) usage
+		GROUP BY ingredient_id HAVING SUM(used) <> 0`, orderID, tenantID)
+	if err != nil {
+		return err
+	}
+	type use struct {
+		ingredientID string
+		quantity     float64
+	}
+	var uses []use
+	for rows.Next() {
+		var u use
+		if err := rows.Scan(&u.ingredientID, &u.quantity); err != nil {
+			rows.Close()
+			return err
+		}
+		uses = append(uses, u)
+	}
+	rows.Close()
+	for _, u := range uses {
+		id := u.ingredientID
+		if _, err := recordStockMovement(tx, tenantID, stockMovement{
+			ingredientID: &id, locationID: locationID, kind: stockSale, quantity: -u.quantity,
+			referenceType: "order", referenceID: orderID, by: by,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func listIngredients(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	locationID := c.Query("locationId")
+	rows, err := db.Query(
+		`SELECT i.id, i.name, COALESCE(i.name_en, ''), COALESCE(i.name_ar, ''), COALESCE(i.sku, ''), i.unit, i.cost_per_unit, i.is_active,
+		        COALESCE((SELECT SUM(quantity) FROM inventory inv
+		                  WHERE inv.ingredient_id = i.id AND ($2 = '' OR inv.location_id::text = $2)), 0)
+		 FROM ingredients i WHERE i.tenant_id = $1
+		 ORDER BY i.name`, tenantID, locationID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	ingredients := []gin.H{}
+	for rows.Next() {
+		var id, name, nameEn, nameAr, sku, unit string
+		var cost, onHand float64
+		var active bool
+		rows.Scan(&id, &name, &nameEn, &nameAr, &sku, &unit, &cost, &active, &onHand)
+		ingredients = append(ingredients, gin.H{
+			"id": id, "name": name, "nameEn": nameEn, "nameAr": nameAr, "sku": sku, "unit": unit,
+			"costPerUnit": cost, "isActive": active, "onHand": onHand,
+		})
+	}
+	c.JSON(200, gin.H{"ingredients": ingredients, "total": len(ingredients)})
+}
+
+func createIngredient(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	var req struct {
+		Name        string  `json:"name" binding:"required"`
+		NameEn      string  `json:"nameEn"`
+		NameAr      string  `json:"nameAr"`
+		SKU         string  `json:"sku"`
+		Unit        string  `json:"unit" binding:"required"`
+		CostPerUnit float64 `json:"costPerUnit"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if _, ok := ingredientUnits[req.Unit]; !ok {
+		c.JSON(400, gin.H{"error": "unit must be g, kg, ml, l or pcs"})
+		return
+	}
+	if req.CostPerUnit < 0 {
+		c.JSON(400, gin.H{"error": "costPerUnit cannot be negative"})
+		return
+	}
+	var taken bool
+	db.QueryRow("SELECT EXISTS(SELECT 1 FROM ingredients WHERE tenant_id = $1 AND LOWER(name) = LOWER($2))", tenantID, req.Name).Scan(&taken)
+	if taken {
+		c.JSON(409, gin.H{"error": fmt.Sprintf("Ingredient %q already exists", req.Name)})
+		return
+	}
+	id := uuid.New().String()
+	if _, err := db.Exec(
+		`INSERT INTO ingredients (id, tenant_id, name, name_en, name_ar, sku, unit, cost_per_unit)
+		 VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7, $8)`,
+		id, tenantID, req.Name, req.NameEn, req.NameAr, req.SKU, req.Unit, req.CostPerUnit); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(201, gin.H{"id": id, "name": req.Name, "nameEn": req.NameEn, "nameAr": req.NameAr, "sku": req.SKU, "unit": req.Unit, "costPerUnit": req.CostPerUnit})
+}
+
+// updateIngredient edits an ingredient. The stock unit cannot change once
+// set, since the ledger and recipes are kept in it.
+func updateIngredient(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	var req struct {
+		Name        string   `json:"name"`
+		NameEn      string   `json:"nameEn"`
+		NameAr      string   `json:"nameAr"`
+		SKU         *string  `json:"sku"`
+		CostPerUnit *float64 `json:"costPerUnit"`
+		IsActive    *bool    `json:"isActive"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if req.CostPerUnit != nil && *req.CostPerUnit < 0 {
+		c.JSON(400, gin.H{"error": "costPerUnit cannot be negative"})
+		return
+	}
+	res, err := db.Exec(
+		`UPDATE ingredients SET
+			name = COALESCE(NULLIF($1, ''), name),
+			name_en = COALESCE(NULLIF($2, ''), name_en),
+			name_ar = COALESCE(NULLIF($3, ''), name_ar),
+			sku = CASE WHEN $4::text IS NULL THEN sku ELSE NULLIF($4, '') END,
+			cost_per_unit = COALESCE($5, cost_per_unit),
+			is_active = COALESCE($6, is_active),
+			updated_at = NOW()
+		 WHERE id = $7 AND tenant_id = $8`,
+		req.Name, req.NameEn, req.NameAr, req.SKU, req.CostPerUnit, req.IsActive, c.Param("id"), tenantID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(404, gin.H{"error": "Ingredient not found"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Ingredient updated"})
+}
+
+// recipeOwner is what a recipe belongs to: a product or a modifier item.
+type recipeOwner struct {
+	column, id string
+}
+
+func productRecipeOwner(c *gin.Context) recipeOwner {
+	return recipeOwner{"product_id", c.Param("id")}
+}
+
+func modifierRecipeOwner(c *gin.Context) recipeOwner {
+	return recipeOwner{"modifier_item_id", c.Param("id")}
+}
+
+func getProductRecipe(c *gin.Context)  { getRecipe(c, productRecipeOwner(c)) }
+func getModifierRecipe(c *gin.Context) { getRecipe(c, modifierRecipeOwner(c)) }
+func setProductRecipe(c *gin.Context)  { setRecipe(c, productRecipeOwner(c)) }
+func setModifierRecipe(c *gin.Context) { setRecipe(c, modifierRecipeOwner(c)) }
+
+func getRecipe(c *gin.Context, owner recipeOwner) {
+	tenantID := c.GetString("tenantId")
+	rows, err := db.Query(
+		`SELECT ri.ingredient_id, i.name, i.unit, ri.quantity, COALESCE(ri.variant_id::text, ''), i.cost_per_unit
+		 FROM recipe_items ri
+		 JOIN ingredients i ON i.id = ri.ingredient_id
+		 WHERE ri.`+owner.column+` = $1 AND ri.tenant_id = $2
+		 ORDER BY ri.variant_id NULLS FIRST, i.name`, owner.id, tenantID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	items := []gin.H{}
+	costs := map[string]float64{}
+	for rows.Next() {
+		var ingredientID, name, unit, variantID string
+		var qty, costPerUnit float64
+		rows.Scan(&ingredientID, &name, &unit, &qty, &variantID, &costPerUnit)
+		costs[variantID] += qty * costPerUnit
+		items = append(items, gin.H{
+			"ingredientId": ingredientID, "ingredientName": name, "unit": unit, "quantity": qty,
+			"variantId": variantID, "cost": money.FromFloat(qty * costPerUnit),
+		})
+	}
+	// Variant rows add to the base recipe, so each variant's cost includes it.
+	variantCosts := gin.H{}
+	for variantID, cost := range costs {
+		if variantID != "" {
+			variantCosts[variantID] = money.FromFloat(costs[""] + cost)
+		}
+	}
+	c.JSON(200, gin.H{"items": items, "cost": money.FromFloat(costs[""]), "variantCosts": variantCosts})
+}
+
+// setRecipe replaces a product's or modifier item's recipe.
+func setRecipe(c *gin.Context, owner recipeOwner) {
+	tenantID := c.GetString("tenantId")
+	var req struct {
+		Items []struct {
+			IngredientID string  `json:"ingredientId" binding:"required"`
+			VariantID    string  `json:"variantId"`
+			Quantity     float64 `json:"quantity" binding:"required"`
+			Unit         string  `json:"unit"`
+		} `json:"items"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	var exists bool
+	if owner.column == "product_id" {
+		db.QueryRow("SELECT EXISTS(SELECT 1 FROM products WHERE id = $1 AND tenant_id = $2)", owner.id, tenantID).Scan(&exists)
+	} else {
+		db.QueryRow("SELECT EXISTS(SELECT 1 FROM modifier_items WHERE id = $1 AND tenant_id = $2)", owner.id, tenantID).Scan(&exists)
+	}
+	if !exists {
+		c.JSON(404, gin.H{"error": "Recipe owner not found"})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Transaction failed"})
+		return
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM recipe_items WHERE "+owner.column+" = $1 AND tenant_id = $2", owner.id, tenantID); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	for _, item := range req.Items {
+		// Only modifiers may take an ingredient away.
+		if item.Quantity < 0 && owner.column == "product_id" {
+			c.JSON(400, gin.H{"error": "Product recipe quantities must be positive"})
+			return
+		}
+		var unit string
+		if err := tx.QueryRow("SELECT unit FROM ingredients WHERE id = $1 AND tenant_id = $2", item.IngredientID, tenantID).Scan(&unit); err != nil {
+			c.JSON(400, gin.H{"error": fmt.Sprintf("Ingredient %s not found", item.IngredientID)})
+			return
+		}
+		qty := item.Quantity
+		if item.Unit != "" && !strings.EqualFold(item.Unit, unit) {
+			if qty, err = convertUnit(item.Quantity, strings.ToLower(item.Unit), unit); err != nil {
+				c.JSON(400, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		var variantID *string
+		if item.VariantID != "" {
+			if owner.column != "product_id" {
+				c.JSON(400, gin.H{"error": "Only product recipes can be per variant"})
+				return
+			}
+			v := item.VariantID
+			variantID = &v
+		}
+		if _, err := tx.Exec(
+			"INSERT INTO recipe_items (id, tenant_id, "+owner.column+", variant_id, ingredient_id, quantity) VALUES ($1, $2, $3, $4, $5, $6)",
+			uuid.New().String(), tenantID, owner.id, variantID, item.IngredientID, qty); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	getRecipe(c, owner)
+}
+
+// getIngredientVariance compares what recipes say was used (theoretical)
+// with what left the shelf (actual) over a period. Actual usage is the
+// stock on hand at the start, plus what was received, less what is on hand
+// at the end, so the variance is wastage and the stock that went
+// unaccounted for. Receipts, transfers and opening balances are stock
+// received.
+func getIngredientVariance(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	from := c.DefaultQuery("from", time.Now().Format("2006-01-02"))
+	to := c.DefaultQuery("to", from)
+	locationID := c.Query("locationId")
+
+	rows, err := db.Query(
+		`SELECT i.id, i.name, i.unit, i.cost_per_unit,
+		        COALESCE(SUM(m.quantity) FILTER (WHERE m.created_at < $2::date), 0),
+		        COALESCE(SUM(m.quantity) FILTER (WHERE m.created_at >= $2::date AND m.movement_type IN ('receipt', 'transfer_in', 'transfer_out', 'opening')), 0),
+		        COALESCE(-SUM(m.quantity) FILTER (WHERE m.created_at >= $2::date AND m.movement_type IN ('sale', 'cancel', 'refund')), 0),
+		        COALESCE(-SUM(m.quantity) FILTER (WHERE m.created_at >= $2::date AND m.movement_type = 'wastage'), 0),
+		        COALESCE(SUM(m.quantity) FILTER (WHERE m.created_at >= $2::date AND m.movement_type = 'adjustment'), 0),
+		        COALESCE(SUM(m.quantity), 0)
+		 FROM ingredients i
+		 JOIN stock_movements m ON m.ingredient_id = i.id
+		 WHERE i.tenant_id = $1 AND m.created_at < $3::date + 1
+		   AND ($4 = '' OR m.location_id::text = $4)
+		 GROUP BY i.id, i.name, i.unit, i.cost_per_unit
+		 HAVING COUNT(*) FILTER (WHERE m.created_at >= $2::date) > 0
+		 ORDER BY i.name`, tenantID, from, to, locationID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	lines := []gin.H{}
+	var totalCost money.Amount
+	for rows.Next() {
+		var id, name, unit string
+		var costPerUnit, opening, received, theoretical, wastage, adjustments, closing float64
+		rows.Scan(&id, &name, &unit, &costPerUnit, &opening, &received, &theoretical, &wastage, &adjustments, &closing)
+		actual := opening + received - closing
+		variance := actual - theoretical
+		var variancePct float64
+		if theoretical != 0 {
+			variancePct = variance / theoretical * 100
+		}
+		cost := money.FromFloat(variance * costPerUnit)
+		totalCost += cost
+		lines = append(lines, gin.H{
+			"ingredientId": id, "name": name, "unit": unit,
+			"opening": opening, "received": received, "closing": closing,
+			"theoretical": theoretical, "wastage": wastage, "adjustments": adjustments,
+			"actual": actual, "variance": variance, "variancePercent": variancePct, "varianceCost": cost,
+		})
+	}
+	c.JSON(200, gin.H{"from": from, "to": to, "locationId": locationID, "ingredients": lines, "totalVarianceCost": totalCost})
+}
+
+// seedCafeRecipes gives the demo café menu its ingredients, recipes and
+// opening stock at the tenant's first location. products and modifiers map
+// English names to IDs. Milk swaps assume a 150 ml pour and only the large
+// size pulls a bigger shot.
+func seedCafeRecipes(tenantID string, products, modifiers map[string]string) (int, int, error) {
+	type ingredientDef struct {
+		name, nameAr, unit string
+		cost, opening      float64
+	}
+	ingredients := []ingredientDef{
+		{"Espresso Beans", "حبوب إسبريسو", "g", 0.12, 5000},
+		{"Filter Coffee Beans", "حبوب القهوة المقطرة", "g", 0.15, 3000},
+		{"Saudi Coffee Blend", "خلطة القهوة السعودية", "g", 0.10, 2000},
+		{"Whole Milk", "حليب كامل الدسم", "ml", 0.006, 20000},
+		{"Skim Milk", "حليب خالي الدسم", "ml", 0.006, 5000},
+		{"Oat Milk", "حليب الشوفان", "ml", 0.018, 5000},
+		{"Almond Milk", "حليب اللوز", "ml", 0.02, 5000},
+		{"Soy Milk", "حليب الصويا", "ml", 0.015, 5000},
+		{"Condensed Milk", "حليب مكثف محلى", "ml", 0.02, 3000},
+		{"Chocolate Sauce", "صوص الشوكولاتة", "ml", 0.03, 2000},
+		{"White Chocolate Sauce", "صوص الشوكولاتة البيضاء", "ml", 0.035, 2000},
+		{"Caramel Sauce", "صوص الكراميل", "ml", 0.03, 2000},
+		{"Vanilla Syrup", "شراب الفانيلا", "ml", 0.025, 2000},
+		{"Hazelnut Syrup", "شراب البندق", "ml", 0.025, 2000},
+		{"Pistachio Sauce", "صوص الفستق", "ml", 0.06, 2000},
+		{"Whipped Cream", "كريمة مخفوقة", "g", 0.04, 2000},
+		{"Ice", "ثلج", "g", 0.001, 20000},
+		{"Hot Cup", "كوب ساخن", "pcs", 0.35, 500},
+		{"Cold Cup", "كوب بارد", "pcs", 0.40, 500},
+		{"Lid", "غطاء", "pcs", 0.10, 1000},
+	}
+
+	type use struct {
+		ingredient string
+		qty        float64
+	}
+	type recipeDef struct {
+		cold bool
+		uses []use
+	}
+	shot := use{"Espresso Beans", 18}
+	recipes := map[string]recipeDef{
+		"V60":                     {false, []use{{"Filter Coffee Beans", 18}}},
+		"Black Coffee":            {false, []use{{"Filter Coffee Beans", 15}}},
+		"Iced V60":                {true, []use{{"Filter Coffee Beans", 20}}},
+		"Cold Black Coffee":       {true, []use{{"Filter Coffee Beans", 15}}},
+		"Saudi Coffee":            {false, []use{{"Saudi Coffee Blend", 12}}},
+		"Espresso":                {false, []use{shot}},
+		"Americano":               {false, []use{shot}},
+		"Latte":                   {false, []use{shot, {"Whole Milk", 200}}},
+		"Cappuccino":              {false, []use{shot, {"Whole Milk", 150}}},
+		"Cortado":                 {false, []use{shot, {"Whole Milk", 60}}},
+		"Flat White":              {false, []use{shot, {"Whole Milk", 120}}},
+		"Macchiato":               {false, []use{shot, {"Whole Milk", 30}}},
+		"Caramel Macchiato":       {false, []use{shot, {"Whole Milk", 180}, {"Vanilla Syrup", 15}, {"Caramel Sauce", 15}}},
+		"Mocha":                   {false, []use{shot, {"Whole Milk", 180}, {"Chocolate Sauce", 30}}},
+		"Pistachio Latte":         {false, []use{shot, {"Whole Milk", 180}, {"Pistachio Sauce", 30}}},
+		"Spanish Latte":           {false, []use{shot, {"Whole Milk", 160}, {"Condensed Milk", 40}}},
+		"Hot Chocolate":           {false, []use{{"Whole Milk", 220}, {"Chocolate Sauce", 40}}},
+		"Italian Coffee":          {false, []use{shot, {"Whole Milk", 100}}},
+		"Iced Americano":          {true, []use{shot}},
+		"Iced Latte":              {true, []use{shot, {"Whole Milk", 180}}},
+		"Iced Shaken White Mocha": {true, []use{shot, {"Whole Milk", 150}, {"White Chocolate Sauce", 30}}},
+		"Iced Mocha":              {true, []use{shot, {"Whole Milk", 160}, {"Chocolate Sauce", 30}}},
+		"Iced Spanish Latte":      {true, []use{shot, {"Whole Milk", 150}, {"Condensed Milk", 40}}},
+		"Iced Pistachio Latte":    {true, []use{shot, {"Whole Milk", 160}, {"Pistachio Sauce", 30}}},
+		"Iced Caramel Macchiato":  {true, []use{shot, {"Whole Milk", 160}, {"Vanilla Syrup", 15}, {"Caramel Sauce", 15}}},
+	}
+	modifierRecipes := map[string][]use{
+		"Large":               {{"Espresso Beans", 9}},
+		"Oat Milk":            {{"Oat Milk", 150}, {"Whole Milk", -150}},
+		"Almond Milk":         {{"Almond Milk", 150}, {"Whole Milk", -150}},
+		"Soy Milk":            {{"Soy Milk", 150}, {"Whole Milk", -150}},
+		"Skim Milk":           {{"Skim Milk", 150}, {"Whole Milk", -150}},
+		"Extra Shot Espresso": {shot},
+		"Whipped Cream":       {{"Whipped Cream", 30}},
+		"Caramel Drizzle":     {{"Caramel Sauce", 15}},
+		"Vanilla Syrup":       {{"Vanilla Syrup", 20}},
+		"Hazelnut Syrup":      {{"Hazelnut Syrup", 20}},
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	var locationID string
+	tx.QueryRow("SELECT id FROM locations WHERE tenant_id = $1 AND status = 'active' ORDER BY created_at LIMIT 1", tenantID).Scan(&locationID)
+	seeder := actor{Source: "seed"}
+
+	ids := map[string]string{}
+	for _, in := range ingredients {
+		id := uuid.New().String()
+		ids[in.name] = id
+		if _, err := tx.Exec(
+			`INSERT INTO ingredients (id, tenant_id, name, name_en, name_ar, unit, cost_per_unit) VALUES ($1, $2, $3, $3, $4, $5, $6)`,
+			id, tenantID, in.name, in.nameAr, in.unit, in.cost); err != nil {
+			return 0, 0, err
+		}
+		if locationID != "" {
+			if _, err := recordStockMovement(tx, tenantID, stockMovement{
+				ingredientID: &id, locationID: locationID, kind: stockOpening, quantity: in.opening,
+				reason: "Opening stock", by: seeder,
+			}); err != nil {
+				return 0, 0, err
+			}
+		}
+	}
+
+	addRecipe := func(column, ownerID string, uses []use) error {
+		for _, u := range uses {
+			if _, err := tx.Exec(
+				"INSERT INTO recipe_items (id, tenant_id, "+column+", ingredient_id, quantity) VALUES ($1, $2, $3, $4, $5)",
+				uuid.New().String(), tenantID, ownerID, ids[u.ingredient], u.qty); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	count := 0
+	for name, r := range recipes {
+		pid, ok := products[name]
+		if !ok {
+			continue
+		}
+		uses := append([]use{}, r.uses...)
+		if r.cold {
+			uses = append(uses, use{"Ice", 150}, use{"Cold Cup", 1}, use{"Lid", 1})
+		} else {
+			uses = append(uses, use{"Hot Cup", 1}, use{"Lid", 1})
+		}
+		if err := addRecipe("product_id", pid, uses); err != nil {
+			return 0, 0, err
+		}
+		count++
+	}
+	for name, uses := range modifierRecipes {
+		if mid, ok := modifiers[name]; ok {
+			if err := addRecipe("modifier_item_id", mid, uses); err != nil {
+				return 0, 0, err
+			}
+			count++
+		}
+	}
+
+	// Bakery, desserts and bottled water are bought in and counted as is.
+	if locationID != "" {
+		rows, err := tx.Query(
+			`SELECT p.id, p.name FROM products p JOIN categories c ON c.id = p.category_id
+			 WHERE p.tenant_id = $1 AND c.slug IN ('bakeries', 'desserts-cake', 'others')`, tenantID)
+		if err != nil {
+			return 0, 0, err
+		}
+		type counted struct{ id, name string }
+		var goods []counted
+		for rows.Next() {
+			var g counted
+			rows.Scan(&g.id, &g.name)
+			goods = append(goods, g)
+		}
+		rows.Close()
+		for _, g := range goods {
+			opening := 20.0
+			if strings.HasPrefix(g.name, "Bottle Water") {
+				opening = 48
+			}
+			if _, err := tx.Exec("UPDATE products SET track_inventory = true WHERE id = $1", g.id); err != nil {
+				return 0, 0, err
+			}
+			if _, err := recordStockMovement(tx, tenantID, stockMovement{
+				productID: g.id, locationID: locationID, kind: stockOpening, quantity: opening,
+				reason: "Opening stock", by: seeder,
+			}); err != nil {
+				return 0, 0, err
+			}
+		}
+	}
+	return len(ingredients), count, tx.Commit()
+}
@@ -0,0 +1,454 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/berhot/products/commerce/pos-engine/internal/money"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ── Refunds ─────────────────────────────────────────────────
+
+func migrateRefunds() {
+	db.Exec(`ALTER TABLE orders ADD COLUMN IF NOT EXISTS refunded_amount DECIMAL(12,2) NOT NULL DEFAULT 0`)
+	db.Exec(`ALTER TABLE order_items ADD COLUMN IF NOT EXISTS refunded_quantity DECIMAL(12,2) NOT NULL DEFAULT 0`)
+	db.Exec(`ALTER TABLE payments ADD COLUMN IF NOT EXISTS refunded_amount DECIMAL(12,2) NOT NULL DEFAULT 0`)
+	db.Exec(`CREATE TABLE IF NOT EXISTS refunds (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		tenant_id UUID NOT NULL,
+		order_id UUID NOT NULL REFERENCES orders(id),
+		refund_type VARCHAR(20) NOT NULL CHECK (refund_type IN ('full', 'items', 'amount')),
+		amount DECIMAL(12,2) NOT NULL,
+		tax_amount DECIMAL(12,2) NOT NULL DEFAULT 0,
+		currency VARCHAR(3) NOT NULL DEFAULT 'SAR',
+		reason TEXT NOT NULL,
+		cashier_id UUID,
+		restocked BOOLEAN NOT NULL DEFAULT false,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`)
+	db.Exec(`CREATE TABLE IF NOT EXISTS refund_items (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		tenant_id UUID NOT NULL,
+		refund_id UUID NOT NULL REFERENCES refunds(id) ON DELETE CASCADE,
+		order_item_id UUID NOT NULL REFERENCES order_items(id),
+		quantity DECIMAL(12,2) NOT NULL,
+		amount DECIMAL(12,2) NOT NULL,
+		tax_amount DECIMAL(12,2) NOT NULL DEFAULT 0
+	)`)
+	db.Exec(`CREATE TABLE IF NOT EXISTS refund_payments (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		tenant_id UUID NOT NULL,
+		refund_id UUID NOT NULL REFERENCES refunds(id) ON DELETE CASCADE,
+		payment_id UUID NOT NULL REFERENCES payments(id),
+		method VARCHAR(30) NOT NULL,
+		amount DECIMAL(12,2) NOT NULL
+	)`)
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_refunds_order ON refunds(order_id)")
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_refunds_tenant_created ON refunds(tenant_id, created_at DESC)")
+}
+
+type refundLine struct {
+	orderItemID string
+	quantity    float64
+	amount      money.Amount
+	taxAmount   money.Amount
+}
+
+func createRefund(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	orderID := c.Param("id")
+	var req struct {
+		Type   string       `json:"type" binding:"required,oneof=full items amount"`
+		Amount money.Amount `json:"amount"`
+		Items  []struct {
+			OrderItemID string  `json:"orderItemId" binding:"required"`
+			Quantity    float64 `json:"quantity" binding:"required,gt=0"`
+		} `json:"items"`
+		Reason  string `json:"reason" binding:"required"`
+		Restock bool   `json:"restock"`
+		// ToStoreCredit puts the refund on the customer's store credit
+		// instead of paying it back through the order's tenders.
+		ToStoreCredit bool `json:"toStoreCredit"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Type == "items" && len(req.Items) == 0 {
+		c.JSON(400, gin.H{"error": "Item refunds need at least one item"})
+		return
+	}
+	if req.Type == "amount" && req.Amount <= 0 {
+		c.JSON(400, gin.H{"error": "Amount refunds need a positive amount"})
+		return
+	}
+	by := actorFromContext(c)
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Transaction failed"})
+		return
+	}
+	defer tx.Rollback()
+
+	var status, locationID, currency string
+	var total, taxTotal, refunded money.Amount
+	var customerID sql.NullString
+	err = tx.QueryRow(
+		`SELECT status, location_id, currency, total, COALESCE(tax_amount, 0), refunded_amount, customer_id
+		 FROM orders WHERE id = $1 AND tenant_id = $2 FOR UPDATE`, orderID, tenantID,
+	).Scan(&status, &locationID, &currency, &total, &taxTotal, &refunded, &customerID)
+	if err == sql.ErrNoRows {
+		c.JSON(404, gin.H{"error": "Order not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	// Any order still holding money can be refunded up to what it holds,
+	// including one paid ahead that never completed.
+	var paid money.Amount
+	tx.QueryRow(
+		`SELECT COALESCE(SUM(amount - refunded_amount), 0) FROM payments
+		 WHERE order_id = $1 AND tenant_id = $2 AND status = 'completed'`, orderID, tenantID,
+	).Scan(&paid)
+	if status == orderRefunded || !paid.IsPositive() {
+		c.JSON(409, gin.H{"error": fmt.Sprintf("Order has no payments left to refund, order is %s", status)})
+		return
+	}
+	if req.ToStoreCredit && !customerID.Valid {
+		c.JSON(400, gin.H{"error": errStoreCreditCustomer.Error()})
+		return
+	}
+	remaining := total - refunded
+
+	// Work out the refunded lines and the money to return.
+	var lines []refundLine
+	var amount, taxAmount money.Amount
+	switch req.Type {
+	case "full":
+		lines, err = refundableLines(tx, tenantID, orderID, nil)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		amount = money.Min(remaining, paid)
+		taxAmount = taxTotal.Ratio(amount, total)
+	case "items":
+		wanted := map[string]float64{}
+		for _, it := range req.Items {
+			wanted[it.OrderItemID] += it.Quantity
+		}
+		lines, err = refundableLines(tx, tenantID, orderID, wanted)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		for _, l := range lines {
+			amount += l.amount
+			taxAmount += l.taxAmount
+		}
+	case "amount":
+		amount = req.Amount
+		taxAmount = taxTotal.Ratio(amount, total)
+	}
+	if !amount.IsPositive() {
+		c.JSON(409, gin.H{"error": "Nothing left to refund on this order"})
+		return
+	}
+	if amount > remaining {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("Refund %s exceeds refundable balance %s", amount, remaining)})
+		return
+	}
+	if amount > paid {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("Refund %s exceeds amount paid %s", amount, paid)})
+		return
+	}
+
+	refundID := uuid.New().String()
+	restock := req.Restock && req.Type != "amount"
+	_, err = tx.Exec(
+		`INSERT INTO refunds (id, tenant_id, order_id, refund_type, amount, tax_amount, currency, reason, cashier_id, restocked)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		refundID, tenantID, orderID, req.Type, amount, taxAmount, currency, req.Reason, by.idOrNil(), restock)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	refundItems := []gin.H{}
+	for _, l := range lines {
+		if _, err := tx.Exec(
+			`INSERT INTO refund_items (id, tenant_id, refund_id, order_item_id, quantity, amount, tax_amount)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+			uuid.New().String(), tenantID, refundID, l.orderItemID, l.quantity, l.amount, l.taxAmount); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		if _, err := tx.Exec("UPDATE order_items SET refunded_quantity = refunded_quantity + $1 WHERE id = $2 AND tenant_id = $3",
+			l.quantity, l.orderItemID, tenantID); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		if err := voidRefundedGiftCards(tx, tenantID, orderID, l.orderItemID, refundID, l.quantity, by); err != nil {
+			if pe, ok := err.(paymentError); ok {
+				c.JSON(409, gin.H{"error": pe.Error()})
+				return
+			}
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		if restock {
+			stock, err := itemStockLines(tx, tenantID, l.orderItemID, l.quantity)
+			if err != nil {
+				c.JSON(500, gin.H{"error": err.Error()})
+				return
+			}
+			for _, sl := range stock {
+				if _, err := recordStockMovement(tx, tenantID, stockMovement{
+					productID: sl.productID, variantID: sl.variantID, locationID: locationID, kind: stockRefund,
+					quantity: sl.quantity, reason: req.Reason, referenceType: "refund", referenceID: refundID, by: by,
+				}); err != nil {
+					c.JSON(500, gin.H{"error": err.Error()})
+					return
+				}
+			}
+		}
+		refundItems = append(refundItems, gin.H{"orderItemId": l.orderItemID, "quantity": l.quantity, "amount": l.amount, "taxAmount": l.taxAmount})
+	}
+
+	// Store credit pays nothing out, so no drawer is involved.
+	var drawerID *string
+	if !req.ToStoreCredit {
+		if drawerID, err = drawerFor(tx, tenantID, locationID, c.GetHeader("X-Drawer-ID")); err != nil {
+			if pe, ok := err.(paymentError); ok {
+				c.JSON(409, gin.H{"error": pe.Error()})
+				return
+			}
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	allocations, err := allocateRefund(tx, tenantID, orderID, refundID, drawerID, amount, req.ToStoreCredit, by)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	var storeCredit gin.H
+	if req.ToStoreCredit {
+		accountID, err := storeCreditAccount(tx, tenantID, customerID.String, currency, by)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		balance, err := postLedger(tx, tenantID, accountID, ledgerCredit, amount, ledgerRef{orderID: orderID, refundID: refundID, reason: req.Reason, by: by})
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		storeCredit = gin.H{"accountId": accountID, "customerId": customerID.String, "amount": amount, "balance": balance}
+	}
+
+	refunded += amount
+	if _, err := tx.Exec("UPDATE orders SET refunded_amount = $1, updated_at = NOW() WHERE id = $2 AND tenant_id = $3",
+		refunded, orderID, tenantID); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	refundEvent := gin.H{
+		"refundId": refundID, "orderId": orderID, "locationId": locationID, "refundType": req.Type, "amount": amount,
+		"taxAmount": taxAmount, "currency": currency, "reason": req.Reason, "orderRefundedAmount": refunded,
+	}
+	if err := enqueueEvent(tx, tenantID, eventRefundProcessed, "order", orderID, refundEvent); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	// Only a completed order becomes refunded; one refunded before it
+	// completed stays where it is, free to be cancelled.
+	newStatus := status
+	if status == orderCompleted && refunded >= total {
+		if _, err := transitionOrder(tx, tenantID, orderID, orderRefunded, "", by, req.Reason); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		newStatus = orderRefunded
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(201, gin.H{
+		"id": refundID, "orderId": orderID, "type": req.Type, "amount": amount, "taxAmount": taxAmount,
+		"currency": currency, "reason": req.Reason, "cashierId": by.ID, "restocked": restock,
+		"items": refundItems, "payments": allocations, "storeCredit": storeCredit,
+		"orderStatus": newStatus, "orderRefundedAmount": refunded, "orderRefundableAmount": total - refunded,
+	})
+}
+
+// refundableLines returns the order lines still open for refund. With a nil
+// wanted map every remaining quantity is returned; otherwise only the
+// requested quantities, which must not exceed what is left on each line.
+func refundableLines(tx *sql.Tx, tenantID, orderID string, wanted map[string]float64) ([]refundLine, error) {
+	rows, err := tx.Query(
+		`SELECT id, quantity, refunded_quantity, total_price, COALESCE(tax_amount, 0)
+		 FROM order_items WHERE order_id = $1 AND tenant_id = $2 AND line_status <> 'voided' FOR UPDATE`, orderID, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lines []refundLine
+	seen := map[string]bool{}
+	for rows.Next() {
+		var id string
+		var qty, refundedQty float64
+		var lineTotal, lineTax money.Amount
+		if err := rows.Scan(&id, &qty, &refundedQty, &lineTotal, &lineTax); err != nil {
+			return nil, err
+		}
+		open := qty - refundedQty
+		take := open
+		if wanted != nil {
+			w, ok := wanted[id]
+			if !ok {
+				continue
+			}
+			seen[id] = true
+			if w > open {
+				return nil, fmt.Errorf("Item %s has only %.2f left to refund", id, open)
+			}
+			take = w
+		}
+		if take <= 0 || qty <= 0 {
+			continue
+		}
+		l := refundLine{
+			orderItemID: id,
+			quantity:    take,
+			amount:      lineTotal.Share(take, qty),
+			taxAmount:   lineTax.Share(take, qty),
+		}
+		if take == open {
+			// The last of a line returns exactly what is left on it.
+			l.amount = lineTotal - lineTotal.Share(refundedQty, qty)
+			l.taxAmount = lineTax - lineTax.Share(refundedQty, qty)
+		}
+		lines = append(lines, l)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for id := range wanted {
+		if !seen[id] {
+			return nil, fmt.Errorf("Item %s is not part of this order", id)
+		}
+	}
+	return lines, nil
+}
+
+// allocateRefund returns money to the order's payments, most recent first,
+// and marks each payment refunded once nothing is left on it. Allocations are
+// booked against drawerID, the drawer the money is paid out of. Gift card
+// and store credit payments go back onto their card. With toStoreCredit the
+// payments are settled all the same, but the money goes to the customer's
+// store credit, which the caller credits, and nothing is paid back.
+func allocateRefund(tx *sql.Tx, tenantID, orderID, refundID string, drawerID *string, amount money.Amount, toStoreCredit bool, by actor) ([]gin.H, error) {
+	rows, err := tx.Query(
+		`SELECT id, method, amount - refunded_amount, amount, loyalty_points_used, COALESCE(gift_card_id::text, '') FROM payments
+		 WHERE order_id = $1 AND tenant_id = $2 AND status = 'completed' AND amount > refunded_amount
+		 ORDER BY created_at DESC FOR UPDATE`, orderID, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	type open struct {
+		id, method    string
+		left, amount  money.Amount
+		loyaltyPoints int
+		giftCardID    string
+	}
+	var payments []open
+	for rows.Next() {
+		var p open
+		rows.Scan(&p.id, &p.method, &p.left, &p.amount, &p.loyaltyPoints, &p.giftCardID)
+		payments = append(payments, p)
+	}
+	rows.Close()
+
+	allocations := []gin.H{}
+	remaining := amount
+	for _, p := range payments {
+		if !remaining.IsPositive() {
+			break
+		}
+		take := money.Min(remaining, p.left)
+		remaining -= take
+		method := p.method
+		if toStoreCredit {
+			method = storedStoreCredit
+		}
+		if _, err := tx.Exec(
+			`INSERT INTO refund_payments (id, tenant_id, refund_id, payment_id, method, amount, cash_drawer_id) VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+			uuid.New().String(), tenantID, refundID, p.id, method, take, drawerID); err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(
+			`UPDATE payments SET refunded_amount = refunded_amount + $1,
+			        status = CASE WHEN refunded_amount + $1 >= amount THEN 'refunded' ELSE status END
+			 WHERE id = $2 AND tenant_id = $3`, take, p.id, tenantID); err != nil {
+			return nil, err
+		}
+		allocations = append(allocations, gin.H{"paymentId": p.id, "method": method, "amount": take})
+		if toStoreCredit {
+			continue
+		}
+		if p.giftCardID != "" {
+			if _, err := postLedger(tx, tenantID, p.giftCardID, ledgerRefund, take, ledgerRef{orderID: orderID, paymentID: p.id, refundID: refundID, by: by}); err != nil {
+				return nil, err
+			}
+		}
+		// Points paid with are credited back to the customer pro rata.
+		if p.loyaltyPoints > 0 && p.amount > 0 {
+			points := int(math.Round(float64(p.loyaltyPoints) * float64(take) / float64(p.amount)))
+			if _, err := tx.Exec(
+				`UPDATE customers SET loyalty_points = loyalty_points + $1
+				 WHERE id = (SELECT customer_id FROM orders WHERE id = $2) AND tenant_id = $3`,
+				points, orderID, tenantID); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return allocations, nil
+}
+
+func listOrderRefunds(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	orderID := c.Param("id")
+	rows, err := db.Query(
+		`SELECT id, refund_type, amount, tax_amount, currency, reason, COALESCE(cashier_id::text, ''), restocked, created_at
+		 FROM refunds WHERE order_id = $1 AND tenant_id = $2 ORDER BY created_at`, orderID, tenantID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	refunds := []gin.H{}
+	for rows.Next() {
+		var id, rtype, cur, reason, cashierID string
+		var amount, tax money.Amount
+		var restocked bool
+		var createdAt time.Time
+		rows.Scan(&id, &rtype, &amount, &tax, &cur, &reason, &cashierID, &restocked, &createdAt)
+		refunds = append(refunds, gin.H{
+			"id": id, "type": rtype, "amount": amount, "taxAmount": tax, "currency": cur,
+			"reason": reason, "cashierId": cashierID, "restocked": restocked, "createdAt": createdAt,
+		})
+	}
+	c.JSON(200, gin.H{"refunds": refunds, "total": len(refunds)})
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ── Scheduled Orders ────────────────────────────────────────
+
+const (
+	// orderReleaseInterval is how often held orders are checked for release
+	// to the kitchen.
+	orderReleaseInterval = 30 * time.Second
+	orderReleaseBatch    = 100
+)
+
+func migrateScheduling() {
+	// A slot capacity of 0 leaves slots unlimited.
+	db.Exec(`ALTER TABLE locations ADD COLUMN IF NOT EXISTS prep_time_minutes INTEGER NOT NULL DEFAULT 15`)
+	db.Exec(`ALTER TABLE locations ADD COLUMN IF NOT EXISTS slot_minutes INTEGER NOT NULL DEFAULT 15`)
+	db.Exec(`ALTER TABLE locations ADD COLUMN IF NOT EXISTS pickup_slot_capacity INTEGER NOT NULL DEFAULT 0`)
+	db.Exec(`ALTER TABLE locations ADD COLUMN IF NOT EXISTS delivery_slot_capacity INTEGER NOT NULL DEFAULT 0`)
+	db.Exec(`ALTER TABLE locations ADD COLUMN IF NOT EXISTS schedule_days_ahead INTEGER NOT NULL DEFAULT 7`)
+
+	// scheduled_for is the time promised to the customer; release_at is when
+	// the kitchen must start to meet it.
+	db.Exec(`ALTER TABLE orders ADD COLUMN IF NOT EXISTS scheduled_for TIMESTAMPTZ`)
+	db.Exec(`ALTER TABLE orders ADD COLUMN IF NOT EXISTS release_at TIMESTAMPTZ`)
+	db.Exec(`ALTER TABLE orders ADD COLUMN IF NOT EXISTS released_at TIMESTAMPTZ`)
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_orders_release ON orders(release_at) WHERE released_at IS NULL")
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_orders_scheduled ON orders(location_id, scheduled_for) WHERE scheduled_for IS NOT NULL")
+}
+
+// slotSettings is how a location takes orders for later.
+type slotSettings struct {
+	loc                                 *time.Location
+	prepMinutes, slotMinutes, daysAhead int
+	pickupCapacity, deliveryCapacity    int
+}
+
+func loadSlotSettings(q queryer, tenantID, locationID string) (slotSettings, error) {
+	var s slotSettings
+	var tz string
+	err := q.QueryRow(
+		`SELECT timezone, prep_time_minutes, slot_minutes, schedule_days_ahead, pickup_slot_capacity, delivery_slot_capacity
+		 FROM locations WHERE id::text = $1 AND tenant_id = $2`, locationID, tenantID).
+		Scan(&tz, &s.prepMinutes, &s.slotMinutes, &s.daysAhead, &s.pickupCapacity, &s.deliveryCapacity)
+	if err != nil {
+		return s, err
+	}
+	if s.loc, err = time.LoadLocation(tz); err != nil || tz == "" {
+		s.loc = time.UTC
+	}
+	return s, nil
+}
+
+// capacity is how many orders of a type one slot takes, or 0 for no limit.
+func (s slotSettings) capacity(orderType string) int {
+	if orderType == "delivery" {
+		return s.deliveryCapacity
+	}
+	return s.pickupCapacity
+}
+
+// slotsOn lists the times on the local day an order can be promised for.
+// The kitchen starts the prep time, and for delivery the drive time too,
+// ahead of the promise; a time is offered when that start has not passed and
+// the prep falls within opening hours.
+func slotsOn(s slotSettings, spans []openSpan, hoursSet bool, day time.Time, etaMinutes int, now time.Time) []time.Time {
+	prep := time.Duration(s.prepMinutes) * time.Minute
+	eta := time.Duration(etaMinutes) * time.Minute
+	step := time.Duration(s.slotMinutes) * time.Minute
+	start := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, s.loc)
+	end := start.AddDate(0, 0, 1)
+	slots := []time.Time{}
+	for t := start; t.Before(end); t = t.Add(step) {
+		kitchen, ready := t.Add(-eta-prep), t.Add(-eta)
+		if kitchen.Before(now) {
+			continue
+		}
+		open := !hoursSet
+		for _, span := range spans {
+			if span.covers(kitchen, ready) {
+				open = true
+				break
+			}
+		}
+		if open {
+			slots = append(slots, t)
+		}
+	}
+	return slots
+}
+
+// daySlots works out a location's slots on a local day with what is already
+// booked into each.
+func daySlots(tenantID, locationID, orderType string, s slotSettings, day time.Time, etaMinutes int) ([]time.Time, map[time.Time]int, error) {
+	start := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, s.loc)
+	end := start.AddDate(0, 0, 1)
+	lead := time.Duration(s.prepMinutes+etaMinutes) * time.Minute
+	spans, hoursSet, err := openingSpans(tenantID, locationID, start.Add(-lead), end)
+	if err != nil {
+		return nil, nil, err
+	}
+	slots := slotsOn(s, spans, hoursSet, start, etaMinutes, time.Now())
+
+	rows, err := db.Query(
+		`SELECT scheduled_for, COUNT(*) FROM orders
+		 WHERE tenant_id = $1 AND location_id::text = $2 AND order_type = $3 AND scheduled_for >= $4 AND scheduled_for < $5
+		   AND status NOT IN ('cancelled', 'voided')
+		 GROUP BY scheduled_for`, tenantID, locationID, orderType, start, end)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	booked := map[time.Time]int{}
+	for rows.Next() {
+		var at time.Time
+		var n int
+		rows.Scan(&at, &n)
+		booked[at.In(s.loc)] = n
+	}
+	return slots, booked, rows.Err()
+}
+
+// promisedSlot checks that at is a slot the location offers for the order
+// type and returns when the kitchen must start on it. Capacity is checked
+// by reserveSlot when the order is written.
+func promisedSlot(tenantID, locationID, orderType string, at time.Time, etaMinutes int) (time.Time, error) {
+	s, err := loadSlotSettings(db, tenantID, locationID)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Location not found")
+	}
+	at = at.In(s.loc)
+	today := time.Now().In(s.loc)
+	last := time.Date(today.Year(), today.Month(), today.Day()+s.daysAhead, 23, 59, 59, 0, s.loc)
+	if at.After(last) {
+		return time.Time{}, fmt.Errorf("Orders can be scheduled up to %d days ahead", s.daysAhead)
+	}
+	slots, _, err := daySlots(tenantID, locationID, orderType, s, at, etaMinutes)
+	if err != nil {
+		return time.Time{}, err
+	}
+	for _, slot := range slots {
+		if slot.Equal(at) {
+			return at.Add(-time.Duration(s.prepMinutes+etaMinutes) * time.Minute), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%s is not an available %s slot", at.Format("2006-01-02 15:04"), orderType)
+}
+
+// reserveSlot books an order into its slot inside tx. The location row is
+// locked so concurrent orders cannot overfill a slot.
+func reserveSlot(tx *sql.Tx, tenantID, locationID, orderID, orderType string, at, release time.Time) error {
+	tx.Exec("SELECT 1 FROM locations WHERE id::text = $1 AND tenant_id = $2 FOR UPDATE", locationID, tenantID)
+	s, err := loadSlotSettings(tx, tenantID, locationID)
+	if err != nil {
+		return err
+	}
+	if capacity := s.capacity(orderType); capacity > 0 {
+		var booked int
+		tx.QueryRow(
+			`SELECT COUNT(*) FROM orders
+			 WHERE tenant_id = $1 AND location_id::text = $2 AND order_type = $3 AND scheduled_for = $4
+			   AND status NOT IN ('cancelled', 'voided')`, tenantID, locationID, orderType, at).Scan(&booked)
+		if booked >= capacity {
+			return fmt.Errorf("The %s slot is full", at.In(s.loc).Format("2006-01-02 15:04"))
+		}
+	}
+	// An order due in the kitchen already is released as it is written.
+	_, err = tx.Exec(
+		`UPDATE orders SET scheduled_for = $1, release_at = $2, released_at = CASE WHEN $2 <= NOW() THEN NOW() END
+		 WHERE id = $3`, at, release, orderID)
+	return err
+}
+
+// startOrderScheduler releases scheduled orders to the kitchen as their lead
+// time comes round. Every replica runs one; SKIP LOCKED keeps them off each
+// other's orders.
+func startOrderScheduler() {
+	go func() {
+		for {
+			n, err := releaseScheduledOrders()
+			if err != nil {
+				log.Printf("Order scheduler: %v", err)
+			}
+			if n < orderReleaseBatch {
+				time.Sleep(orderReleaseInterval)
+			}
+		}
+	}()
+}
+
+// releaseScheduledOrders routes one batch of due orders to their stations.
+// Orders cancelled while held are left alone.
+func releaseScheduledOrders() (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	rows, err := tx.Query(
+		`SELECT id, tenant_id, scheduled_for, status, payment_status = 'paid' FROM orders
+		 WHERE release_at <= NOW() AND released_at IS NULL AND status IN ('pending', 'accepted')
+		 ORDER BY release_at LIMIT $1
+		 FOR UPDATE SKIP LOCKED`, orderReleaseBatch)
+	if err != nil {
+		return 0, err
+	}
+	type due struct {
+		id, tenantID, status string
+		scheduledFor         time.Time
+		paid                 bool
+	}
+	var batch []due
+	for rows.Next() {
+		var d due
+		rows.Scan(&d.id, &d.tenantID, &d.scheduledFor, &d.status, &d.paid)
+		batch = append(batch, d)
+	}
+	rows.Close()
+
+	for _, d := range batch {
+		if _, err := tx.Exec("UPDATE orders SET released_at = NOW() WHERE id = $1", d.id); err != nil {
+			return 0, err
+		}
+		if err := routeOrderItems(tx, d.tenantID, d.id); err != nil {
+			return 0, err
+		}
+		if err := recordOrderEvent(tx, d.tenantID, d.id, orderEventReleased, gin.H{"scheduledFor": d.scheduledFor}); err != nil {
+			return 0, err
+		}
+		// A prepaid order the kitchen has nothing to make for is handed over
+		// as it would have been when paid, had it not been held.
+		if d.paid && completesOnPayment(tx, d.id, d.status) {
+			if _, err := transitionOrder(tx, d.tenantID, d.id, orderCompleted, "", actor{Source: "system"}, "Released prepaid"); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return len(batch), tx.Commit()
+}
+
+// deliveryLeadMinutes is the drive time assumed for a location's delivery
+// slots when no zone is given: the longest of its zones, to be safe.
+func deliveryLeadMinutes(tenantID, locationID, zoneID string) int {
+	var eta int
+	if zoneID != "" {
+		db.QueryRow("SELECT eta_minutes FROM delivery_zones WHERE id::text = $1 AND tenant_id = $2", zoneID, tenantID).Scan(&eta)
+		return eta
+	}
+	db.QueryRow("SELECT COALESCE(MAX(eta_minutes), 0) FROM delivery_zones WHERE tenant_id = $1 AND location_id::text = $2 AND is_active",
+		tenantID, locationID).Scan(&eta)
+	return eta
+}
+
+// getLocationSlots lists the times on a day that pickup or delivery orders
+// can be promised for, with the room left in each.
+func getLocationSlots(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	locationID := c.Param("id")
+	orderType := c.DefaultQuery("orderType", "pickup")
+	if orderType != "pickup" && orderType != "delivery" {
+		c.JSON(400, gin.H{"error": "orderType must be pickup or delivery"})
+		return
+	}
+	s, err := loadSlotSettings(db, tenantID, locationID)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Location not found"})
+		return
+	}
+	day := time.Now().In(s.loc)
+	if d := c.Query("date"); d != "" {
+		if day, err = time.ParseInLocation("2006-01-02", d, s.loc); err != nil {
+			c.JSON(400, gin.H{"error": "date must be YYYY-MM-DD"})
+			return
+		}
+	}
+	etaMinutes := 0
+	if orderType == "delivery" {
+		etaMinutes = deliveryLeadMinutes(tenantID, locationID, c.Query("zoneId"))
+	}
+	today := time.Now().In(s.loc)
+	if day.After(time.Date(today.Year(), today.Month(), today.Day()+s.daysAhead, 0, 0, 0, 0, s.loc)) {
+		c.JSON(200, gin.H{"date": day.Format("2006-01-02"), "timezone": s.loc.String(), "slots": []gin.H{}})
+		return
+	}
+	slots, booked, err := daySlots(tenantID, locationID, orderType, s, day, etaMinutes)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	capacity := s.capacity(orderType)
+	out := []gin.H{}
+	for _, t := range slots {
+		slot := gin.H{"at": t, "booked": booked[t], "available": true}
+		if capacity > 0 {
+			slot["capacity"], slot["remaining"] = capacity, max(capacity-booked[t], 0)
+			slot["available"] = booked[t] < capacity
+		}
+		out = append(out, slot)
+	}
+	c.JSON(200, gin.H{
+		"date": day.Format("2006-01-02"), "timezone": s.loc.String(), "orderType": orderType,
+		"prepTimeMinutes": s.prepMinutes, "etaMinutes": etaMinutes, "slots": out,
+	})
+}
+
+func updateLocationScheduling(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	var req struct {
+		PrepTimeMinutes      *int `json:"prepTimeMinutes"`
+		SlotMinutes          *int `json:"slotMinutes"`
+		PickupSlotCapacity   *int `json:"pickupSlotCapacity"`
+		DeliverySlotCapacity *int `json:"deliverySlotCapacity"`
+		ScheduleDaysAhead    *int `json:"scheduleDaysAhead"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if req.SlotMinutes != nil && (*req.SlotMinutes < 5 || *req.SlotMinutes > 240) {
+		c.JSON(400, gin.H{"error": "slotMinutes must be between 5 and 240"})
+		return
+	}
+	for _, n := range []*int{req.PrepTimeMinutes, req.PickupSlotCapacity, req.DeliverySlotCapacity, req.ScheduleDaysAhead} {
+		if n != nil && *n < 0 {
+			c.JSON(400, gin.H{"error": "Scheduling settings cannot be negative"})
+			return
+		}
+	}
+	res, err := db.Exec(
+		`UPDATE locations SET
+			prep_time_minutes = COALESCE($1, prep_time_minutes),
+			slot_minutes = COALESCE($2, slot_minutes),
+			pickup_slot_capacity = COALESCE($3, pickup_slot_capacity),
+			delivery_slot_capacity = COALESCE($4, delivery_slot_capacity),
+			schedule_days_ahead = COALESCE($5, schedule_days_ahead),
+			updated_at = NOW()
+		 WHERE id::text = $6 AND tenant_id = $7`,
+		req.PrepTimeMinutes, req.SlotMinutes, req.PickupSlotCapacity, req.DeliverySlotCapacity, req.ScheduleDaysAhead, c.Param("id"), tenantID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(404, gin.H{"error": "Location not found"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Scheduling settings updated"})
+}
@@ -0,0 +1,443 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ── Stock Ledger ────────────────────────────────────────────
+
+// Stock movement types. Every change to on-hand stock is one of these.
+const (
+	stockSale        = "sale"
+	stockCancel      = "cancel"
+	stockRefund      = "refund"
+	stockAdjustment  = "adjustment"
+	stockWastage     = "wastage"
+	stockTransferOut = "transfer_out"
+	stockTransferIn  = "transfer_in"
+	stockReceipt     = "receipt"
+	stockOpening     = "opening"
+)
+
+// Stock policies decide what a location does when an order wants more than
+// it has on hand.
+const (
+	stockPolicyAllow  = "allow"
+	stockPolicyWarn   = "warn"
+	stockPolicyReject = "reject"
+)
+
+func migrateStock() {
+	db.Exec(`ALTER TABLE inventory ALTER COLUMN quantity TYPE DECIMAL(12,3)`)
+	db.Exec(`ALTER TABLE locations ADD COLUMN IF NOT EXISTS stock_policy VARCHAR(10) NOT NULL DEFAULT 'warn'`)
+	db.Exec(`CREATE TABLE IF NOT EXISTS stock_movements (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		tenant_id UUID NOT NULL,
+		product_id UUID NOT NULL REFERENCES products(id),
+		variant_id UUID REFERENCES product_variants(id),
+		location_id UUID NOT NULL REFERENCES locations(id),
+		movement_type VARCHAR(20) NOT NULL,
+		quantity DECIMAL(12,3) NOT NULL,
+		balance_after DECIMAL(12,3) NOT NULL,
+		reason TEXT NOT NULL DEFAULT '',
+		reference_type VARCHAR(30),
+		reference_id UUID,
+		user_id UUID,
+		source VARCHAR(50) NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`)
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_stock_movements_item ON stock_movements(tenant_id, product_id, location_id, created_at DESC)")
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_stock_movements_reference ON stock_movements(reference_type, reference_id)")
+
+	// The ledger is append-only; corrections are new movements. Only a
+	// transaction that sets pos.stock_purge, as the demo seed does, may delete.
+	db.Exec(`CREATE OR REPLACE FUNCTION stock_movements_append_only() RETURNS trigger AS $$
+		BEGIN
+			IF TG_OP = 'DELETE' AND current_setting('pos.stock_purge', true) = 'on' THEN
+				RETURN OLD;
+			END IF;
+			RAISE EXCEPTION 'stock_movements is append-only';
+		END;
+		$$ LANGUAGE plpgsql`)
+	db.Exec(`DROP TRIGGER IF EXISTS trg_stock_movements_append_only ON stock_movements`)
+	db.Exec(`CREATE TRIGGER trg_stock_movements_append_only BEFORE UPDATE OR DELETE ON stock_movements
+		FOR EACH ROW EXECUTE FUNCTION stock_movements_append_only()`)
+
+	// Stock counted before the ledger existed opens it.
+	db.Exec(`INSERT INTO stock_movements (tenant_id, product_id, variant_id, location_id, movement_type, quantity, balance_after, reason)
+		SELECT i.tenant_id, i.product_id, i.variant_id, i.location_id, 'opening', i.quantity, i.quantity, 'Opening balance'
+		FROM inventory i
+		WHERE i.quantity <> 0 AND NOT EXISTS (
+			SELECT 1 FROM stock_movements m
+			WHERE m.tenant_id = i.tenant_id AND m.product_id = i.product_id AND m.location_id = i.location_id
+			  AND m.variant_id IS NOT DISTINCT FROM i.variant_id)`)
+}
+
+// stockMovement is one change to a location's stock of a product, variant or
+// ingredient. Quantity is signed: positive adds stock, negative removes it.
+type stockMovement struct {
+	productID     string
+	variantID     *string
+	ingredientID  *string
+	locationID    string
+	kind          string
+	quantity      float64
+	unitCost      *float64
+	reason        string
+	referenceType string
+	referenceID   string
+	by            actor
+}
+
+// stockLevel is what a location holds after a movement.
+type stockLevel struct {
+	onHand            float64
+	lowStockThreshold float64
+}
+
+// recordStockMovement appends m to the ledger inside tx. inventory.quantity
+// is the running sum of the ledger and moves with it in the same statement,
+// so the two never disagree.
+func recordStockMovement(tx *sql.Tx, tenantID string, m stockMovement) (stockLevel, error) {
+	var level stockLevel
+	var err error
+	if m.ingredientID != nil {
+		err = tx.QueryRow(
+			`INSERT INTO inventory (id, tenant_id, ingredient_id, location_id, quantity)
+			 VALUES ($1, $2, $3, $4, $5)
+			 ON CONFLICT (tenant_id, ingredient_id, location_id) WHERE ingredient_id IS NOT NULL
+			 DO UPDATE SET quantity = inventory.quantity + EXCLUDED.quantity, updated_at = NOW()
+			 RETURNING quantity, COALESCE(low_stock_threshold, 0)`,
+			uuid.New().String(), tenantID, *m.ingredientID, m.locationID, m.quantity,
+		).Scan(&level.onHand, &level.lowStockThreshold)
+	} else if m.variantID != nil {
+		err = tx.QueryRow(
+			`INSERT INTO inventory (id, tenant_id, product_id, variant_id, location_id, quantity)
+			 VALUES ($1, $2, $3, $4, $5, $6)
+			 ON CONFLICT (tenant_id, variant_id, location_id) WHERE variant_id IS NOT NULL
+			 DO UPDATE SET quantity = inventory.quantity + EXCLUDED.quantity, updated_at = NOW()
+			 RETURNING quantity, COALESCE(low_stock_threshold, 0)`,
+			uuid.New().String(), tenantID, m.productID, *m.variantID, m.locationID, m.quantity,
+		).Scan(&level.onHand, &level.lowStockThreshold)
+	} else {
+		err = tx.QueryRow(
+			`INSERT INTO inventory (id, tenant_id, product_id, location_id, quantity)
+			 VALUES ($1, $2, $3, $4, $5)
+			 ON CONFLICT (tenant_id, product_id, location_id) WHERE variant_id IS NULL
+			 DO UPDATE SET quantity = inventory.quantity + EXCLUDED.quantity, updated_at = NOW()
+			 RETURNING quantity, COALESCE(low_stock_threshold, 0)`,
+			uuid.New().String(), tenantID, m.productID, m.locationID, m.quantity,
+		).Scan(&level.onHand, &level.lowStockThreshold)
+	}
+	if err != nil {
+		return level, err
+	}
+	var productID, refType, refID *string
+	if m.ingredientID == nil {
+		productID = &m.productID
+	}
+	if m.referenceType != "" {
+		refType, refID = &m.referenceType, &m.referenceID
+	}
+	_, err = tx.Exec(
+		`INSERT INTO stock_movements (id, tenant_id, product_id, variant_id, ingredient_id, location_id, movement_type, quantity, balance_after,
+		        unit_cost, reason, reference_type, reference_id, user_id, source)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)`,
+		uuid.New().String(), tenantID, productID, m.variantID, m.ingredientID, m.locationID, m.kind, m.quantity, level.onHand,
+		m.unitCost, m.reason, refType, refID, m.by.idOrNil(), m.by.Source)
+	return level, err
+}
+
+// tracksInventory reports whether a product's stock is counted.
+func tracksInventory(q queryer, tenantID, productID string) bool {
+	var track bool
+	q.QueryRow("SELECT track_inventory FROM products WHERE id = $1 AND tenant_id = $2", productID, tenantID).Scan(&track)
+	return track
+}
+
+// stockLine is a quantity of a product or variant an order takes.
+type stockLine struct {
+	productID string
+	variantID *string
+	name      string
+	quantity  float64
+}
+
+// stockShortageError lists order lines the location cannot fill.
+type stockShortageError struct {
+	shortages []gin.H
+}
+
+func (e *stockShortageError) Error() string { return "Insufficient stock" }
+
+// deductOrderStock takes an order's tracked lines out of stock. Under the
+// reject policy any shortfall fails with a *stockShortageError; under warn
+// the shortfalls are returned and the order goes ahead.
+func deductOrderStock(tx *sql.Tx, tenantID, locationID, orderID string, lines []stockLine, by actor) ([]gin.H, error) {
+	var policy string
+	tx.QueryRow("SELECT stock_policy FROM locations WHERE id = $1 AND tenant_id = $2", locationID, tenantID).Scan(&policy)
+
+	shortages := []gin.H{}
+	for _, l := range lines {
+		level, err := recordStockMovement(tx, tenantID, stockMovement{
+			productID: l.productID, variantID: l.variantID, locationID: locationID, kind: stockSale,
+			quantity: -l.quantity, referenceType: "order", referenceID: orderID, by: by,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if level.onHand < 0 {
+			shortages = append(shortages, gin.H{
+				"productId": l.productID, "variantId": l.variantID, "name": l.name,
+				"requested": l.quantity, "available": level.onHand + l.quantity,
+			})
+		}
+		// Crossing the threshold raises the same alert the old SQL function did.
+		if level.onHand <= level.lowStockThreshold && level.onHand+l.quantity > level.lowStockThreshold {
+			alert, _ := json.Marshal(gin.H{"quantity": level.onHand, "threshold": level.lowStockThreshold, "locationId": locationID, "variantId": l.variantID})
+			if _, err := tx.Exec(
+				`INSERT INTO audit_log (tenant_id, user_id, action, resource_type, resource_id, new_values)
+				 VALUES ($1, $2, 'LOW_STOCK_ALERT', 'product', $3, $4)`,
+				tenantID, by.idOrNil(), l.productID, string(alert)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(shortages) > 0 && policy == stockPolicyReject {
+		return nil, &stockShortageError{shortages: shortages}
+	}
+	if policy == stockPolicyAllow {
+		return []gin.H{}, nil
+	}
+	return shortages, nil
+}
+
+// restockOrder puts back whatever an order still has out of stock, for
+// orders cancelled or voided before they complete.
+func restockOrder(tx *sql.Tx, tenantID, orderID string, by actor, reason string) error {
+	rows, err := tx.Query(
+		`SELECT COALESCE(product_id::text, ''), variant_id::text, ingredient_id::text, location_id, SUM(quantity) FROM stock_movements
+		 WHERE tenant_id = $1 AND reference_type = 'order' AND reference_id = $2
+		 GROUP BY product_id, variant_id, ingredient_id, location_id HAVING SUM(quantity) <> 0`, tenantID, orderID)
+	if err != nil {
+		return err
+	}
+	var moves []stockMovement
+	for rows.Next() {
+		var m stockMovement
+		var variantID, ingredientID sql.NullString
+		if err := rows.Scan(&m.productID, &variantID, &ingredientID, &m.locationID, &m.quantity); err != nil {
+			rows.Close()
+			return err
+		}
+		if variantID.Valid {
+			m.variantID = &variantID.String
+		}
+		if ingredientID.Valid {
+			m.ingredientID = &ingredientID.String
+		}
+		m.quantity = -m.quantity
+		moves = append(moves, m)
+	}
+	rows.Close()
+	for _, m := range moves {
+		m.kind, m.reason, m.referenceType, m.referenceID, m.by = stockCancel, reason, "order", orderID, by
+		if _, err := recordStockMovement(tx, tenantID, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// purgeStock wipes a tenant's stock ledger and on-hand quantities. It exists
+// for seedCafeMenu, which rebuilds a demo tenant from scratch.
+func purgeStock(tenantID string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("SET LOCAL pos.stock_purge = 'on'"); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM stock_movements WHERE tenant_id = $1", tenantID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM inventory WHERE tenant_id = $1", tenantID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func createStockMovement(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	var req struct {
+		ProductID    string  `json:"productId"`
+		VariantID    string  `json:"variantId"`
+		IngredientID string  `json:"ingredientId"`
+		LocationID   string  `json:"locationId" binding:"required"`
+		Type         string  `json:"type" binding:"required"`
+		Quantity     float64 `json:"quantity" binding:"required"`
+		Reason       string  `json:"reason" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if (req.ProductID == "") == (req.IngredientID == "") {
+		c.JSON(400, gin.H{"error": "Give either productId or ingredientId"})
+		return
+	}
+	// Wastage is entered as the amount thrown away and receipts, deliveries
+	// taken in outside a purchase order, as the amount received; adjustments
+	// are signed.
+	quantity := req.Quantity
+	switch req.Type {
+	case stockWastage:
+		if quantity <= 0 {
+			c.JSON(400, gin.H{"error": "Wastage quantity must be positive"})
+			return
+		}
+		quantity = -quantity
+	case stockReceipt:
+		if quantity <= 0 {
+			c.JSON(400, gin.H{"error": "Receipt quantity must be positive"})
+			return
+		}
+	case stockAdjustment:
+	default:
+		c.JSON(400, gin.H{"error": "type must be adjustment, wastage or receipt"})
+		return
+	}
+	var variantID, ingredientID *string
+	if req.VariantID != "" {
+		variantID = &req.VariantID
+	}
+	if req.IngredientID != "" {
+		ingredientID = &req.IngredientID
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Transaction failed"})
+		return
+	}
+	defer tx.Rollback()
+	var exists bool
+	if ingredientID != nil {
+		tx.QueryRow("SELECT EXISTS(SELECT 1 FROM ingredients WHERE id = $1 AND tenant_id = $2)", req.IngredientID, tenantID).Scan(&exists)
+	} else {
+		tx.QueryRow(
+			`SELECT EXISTS(SELECT 1 FROM products p WHERE p.id = $1 AND p.tenant_id = $2
+			   AND ($3::text IS NULL OR EXISTS(SELECT 1 FROM product_variants v WHERE v.id::text = $3 AND v.product_id = p.id)))`,
+			req.ProductID, tenantID, variantID).Scan(&exists)
+	}
+	if !exists {
+		c.JSON(404, gin.H{"error": "Product or ingredient not found"})
+		return
+	}
+	level, err := recordStockMovement(tx, tenantID, stockMovement{
+		productID: req.ProductID, variantID: variantID, ingredientID: ingredientID, locationID: req.LocationID, kind: req.Type,
+		quantity: quantity, reason: req.Reason, by: actorFromContext(c),
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(201, gin.H{
+		"productId": req.ProductID, "variantId": variantID, "ingredientId": ingredientID, "locationId": req.LocationID,
+		"type": req.Type, "quantity": quantity, "onHand": level.onHand,
+	})
+}
+
+func listStockMovements(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	query := `SELECT m.id, COALESCE(m.product_id::text, ''), COALESCE(p.name, ''), COALESCE(m.variant_id::text, ''), COALESCE(v.name, ''),
+	                 COALESCE(m.ingredient_id::text, ''), COALESCE(i.name, ''), COALESCE(i.unit, ''), m.location_id,
+	                 m.movement_type, m.quantity, m.balance_after, m.reason, COALESCE(m.reference_type, ''),
+	                 COALESCE(m.reference_id::text, ''), COALESCE(m.user_id::text, ''), m.source, m.created_at
+	          FROM stock_movements m
+	          LEFT JOIN products p ON p.id = m.product_id
+	          LEFT JOIN product_variants v ON v.id = m.variant_id
+	          LEFT JOIN ingredients i ON i.id = m.ingredient_id
+	          WHERE m.tenant_id = $1`
+	args := []interface{}{tenantID}
+	for param, column := range map[string]string{
+		"productId": "m.product_id::text", "variantId": "m.variant_id::text", "ingredientId": "m.ingredient_id::text",
+		"locationId": "m.location_id::text",
+		"type":       "m.movement_type", "referenceId": "m.reference_id::text",
+	} {
+		if v := c.Query(param); v != "" {
+			args = append(args, v)
+			query += fmt.Sprintf(" AND %s = $%d", column, len(args))
+		}
+	}
+	if from := c.Query("from"); from != "" {
+		args = append(args, from)
+		query += fmt.Sprintf(" AND m.created_at >= $%d::date", len(args))
+	}
+	if to := c.Query("to"); to != "" {
+		args = append(args, to)
+		query += fmt.Sprintf(" AND m.created_at < $%d::date + 1", len(args))
+	}
+	query += " ORDER BY m.created_at DESC LIMIT 200"
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	movements := []gin.H{}
+	for rows.Next() {
+		var id, pid, pname, vid, vname, iid, iname, unit, lid, kind, reason, refType, refID, userID, source string
+		var qty, balance float64
+		var createdAt time.Time
+		rows.Scan(&id, &pid, &pname, &vid, &vname, &iid, &iname, &unit, &lid, &kind, &qty, &balance, &reason, &refType, &refID, &userID, &source, &createdAt)
+		movements = append(movements, gin.H{
+			"id": id, "productId": pid, "productName": pname, "variantId": vid, "variantName": vname,
+			"ingredientId": iid, "ingredientName": iname, "unit": unit, "locationId": lid,
+			"type": kind, "quantity": qty, "balanceAfter": balance, "reason": reason,
+			"referenceType": refType, "referenceId": refID, "userId": userID, "source": source, "createdAt": createdAt,
+		})
+	}
+	c.JSON(200, gin.H{"movements": movements, "total": len(movements)})
+}
+
+func updateLocationStockPolicy(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	var req struct {
+		StockPolicy string `json:"stockPolicy" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	switch req.StockPolicy {
+	case stockPolicyAllow, stockPolicyWarn, stockPolicyReject:
+	default:
+		c.JSON(400, gin.H{"error": "stockPolicy must be allow, warn or reject"})
+		return
+	}
+	res, err := db.Exec("UPDATE locations SET stock_policy = $1, updated_at = NOW() WHERE id = $2 AND tenant_id = $3",
+		req.StockPolicy, c.Param("id"), tenantID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(404, gin.H{"error": "Location not found"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Stock policy updated", "stockPolicy": req.StockPolicy})
+}
@@ -0,0 +1,445 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ── Stock Counts ────────────────────────────────────────────
+
+// Stock count statuses. Counters enter what is on the shelf while counting;
+// a manager then reviews the variances and approves them into the ledger,
+// or sends the count back for a recount.
+const (
+	countCounting  = "counting"
+	countReview    = "review"
+	countApproved  = "approved"
+	countCancelled = "cancelled"
+)
+
+func migrateStockCounts() {
+	db.Exec(`CREATE TABLE IF NOT EXISTS stock_counts (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		tenant_id UUID NOT NULL,
+		location_id UUID NOT NULL REFERENCES locations(id),
+		count_number VARCHAR(50) NOT NULL,
+		status VARCHAR(20) NOT NULL DEFAULT 'counting'
+			CHECK (status IN ('counting', 'review', 'approved', 'cancelled')),
+		blind BOOLEAN NOT NULL DEFAULT true,
+		notes TEXT NOT NULL DEFAULT '',
+		started_by UUID,
+		submitted_at TIMESTAMPTZ,
+		approved_by UUID,
+		approved_at TIMESTAMPTZ,
+		cancelled_at TIMESTAMPTZ,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		UNIQUE(tenant_id, count_number)
+	)`)
+	db.Exec(`CREATE TABLE IF NOT EXISTS stock_count_lines (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		tenant_id UUID NOT NULL,
+		count_id UUID NOT NULL REFERENCES stock_counts(id) ON DELETE CASCADE,
+		product_id UUID REFERENCES products(id),
+		variant_id UUID REFERENCES product_variants(id),
+		ingredient_id UUID REFERENCES ingredients(id),
+		description VARCHAR(255) NOT NULL,
+		unit VARCHAR(10) NOT NULL DEFAULT 'pcs',
+		expected_quantity DECIMAL(12,3) NOT NULL,
+		counted_quantity DECIMAL(12,3),
+		unit_cost DECIMAL(14,6) NOT NULL DEFAULT 0,
+		counted_by UUID,
+		counted_at TIMESTAMPTZ
+	)`)
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_stock_counts_tenant ON stock_counts(tenant_id, created_at DESC)")
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_stock_count_lines_count ON stock_count_lines(count_id)")
+}
+
+// expectedWhenCounted is what the ledger expected of a count line l, of
+// count sc, at the moment it was counted: the snapshot taken when the count
+// opened plus whatever was booked since, like sales rung up while counting.
+const expectedWhenCounted = `l.expected_quantity + COALESCE((SELECT SUM(m.quantity) FROM stock_movements m
+	WHERE m.tenant_id = l.tenant_id AND m.location_id = sc.location_id
+	  AND m.created_at > sc.created_at AND m.created_at <= l.counted_at
+	  AND m.product_id IS NOT DISTINCT FROM l.product_id AND m.variant_id IS NOT DISTINCT FROM l.variant_id
+	  AND m.ingredient_id IS NOT DISTINCT FROM l.ingredient_id), 0)`
+
+// createStockCount opens a count at a location with one line per inventory
+// row there, snapshotting what the ledger expects. A location can only run
+// one count at a time.
+func createStockCount(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	var req struct {
+		LocationID         string `json:"locationId" binding:"required"`
+		Blind              *bool  `json:"blind"`
+		IncludeIngredients *bool  `json:"includeIngredients"`
+		Notes              string `json:"notes"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	blind := req.Blind == nil || *req.Blind
+	ingredients := req.IncludeIngredients == nil || *req.IncludeIngredients
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Transaction failed"})
+		return
+	}
+	defer tx.Rollback()
+	var one int
+	err = tx.QueryRow("SELECT 1 FROM locations WHERE id::text = $1 AND tenant_id = $2 FOR UPDATE", req.LocationID, tenantID).Scan(&one)
+	if err == sql.ErrNoRows {
+		c.JSON(404, gin.H{"error": "Location not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	var open bool
+	tx.QueryRow("SELECT EXISTS(SELECT 1 FROM stock_counts WHERE tenant_id = $1 AND location_id = $2 AND status IN ('counting', 'review'))",
+		tenantID, req.LocationID).Scan(&open)
+	if open {
+		c.JSON(409, gin.H{"error": "A stock count is already open at this location"})
+		return
+	}
+
+	id := uuid.New().String()
+	number := fmt.Sprintf("SC-%s-%s", time.Now().Format("20060102"), uuid.New().String()[:4])
+	if _, err := tx.Exec(
+		`INSERT INTO stock_counts (id, tenant_id, location_id, count_number, blind, notes, started_by)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		id, tenantID, req.LocationID, number, blind, req.Notes, actorFromContext(c).idOrNil()); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	res, err := tx.Exec(
+		`INSERT INTO stock_count_lines (id, tenant_id, count_id, product_id, variant_id, ingredient_id, description, unit, expected_quantity, unit_cost)
+		 SELECT gen_random_uuid(), i.tenant_id, $1, i.product_id, i.variant_id, i.ingredient_id,
+		        COALESCE(ing.name, p.name || COALESCE(' - ' || v.name, '')), COALESCE(ing.unit, 'pcs'), i.quantity,
+		        COALESCE(ing.cost_per_unit, v.average_cost, p.average_cost, p.cost_price, 0)
+		 FROM inventory i
+		 LEFT JOIN products p ON p.id = i.product_id
+		 LEFT JOIN product_variants v ON v.id = i.variant_id
+		 LEFT JOIN ingredients ing ON ing.id = i.ingredient_id
+		 WHERE i.tenant_id = $2 AND i.location_id = $3
+		   AND (p.track_inventory OR (i.ingredient_id IS NOT NULL AND $4))`,
+		id, tenantID, req.LocationID, ingredients)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	lines, _ := res.RowsAffected()
+	c.JSON(201, gin.H{"id": id, "countNumber": number, "locationId": req.LocationID, "status": countCounting, "blind": blind, "lineCount": lines})
+}
+
+func listStockCounts(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	query := `SELECT sc.id, sc.count_number, sc.location_id, l.name, sc.status, sc.blind, sc.created_at, sc.approved_at,
+	                 (SELECT COUNT(*) FROM stock_count_lines WHERE count_id = sc.id),
+	                 (SELECT COUNT(*) FROM stock_count_lines WHERE count_id = sc.id AND counted_quantity IS NOT NULL)
+	          FROM stock_counts sc JOIN locations l ON l.id = sc.location_id
+	          WHERE sc.tenant_id = $1`
+	args := []interface{}{tenantID}
+	if status := c.Query("status"); status != "" {
+		args = append(args, status)
+		query += fmt.Sprintf(" AND sc.status = $%d", len(args))
+	}
+	if loc := c.Query("locationId"); loc != "" {
+		args = append(args, loc)
+		query += fmt.Sprintf(" AND sc.location_id::text = $%d", len(args))
+	}
+	query += " ORDER BY sc.created_at DESC LIMIT 50"
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	counts := []gin.H{}
+	for rows.Next() {
+		var id, number, locID, locName, status string
+		var blind bool
+		var createdAt time.Time
+		var approvedAt *time.Time
+		var lineCount, counted int
+		rows.Scan(&id, &number, &locID, &locName, &status, &blind, &createdAt, &approvedAt, &lineCount, &counted)
+		counts = append(counts, gin.H{
+			"id": id, "countNumber": number, "locationId": locID, "locationName": locName, "status": status,
+			"blind": blind, "lineCount": lineCount, "countedLines": counted, "createdAt": createdAt, "approvedAt": approvedAt,
+		})
+	}
+	c.JSON(200, gin.H{"stockCounts": counts, "total": len(counts)})
+}
+
+// getStockCount returns a count with its lines. Blind counts hide what the
+// system expects until the count is submitted, so counters record what they
+// see rather than confirm what they are told.
+func getStockCount(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	id := c.Param("id")
+	var number, locID, status, notes string
+	var blind bool
+	var createdAt time.Time
+	var submittedAt, approvedAt *time.Time
+	err := db.QueryRow(
+		`SELECT count_number, location_id, status, blind, notes, created_at, submitted_at, approved_at
+		 FROM stock_counts WHERE id = $1 AND tenant_id = $2`, id, tenantID,
+	).Scan(&number, &locID, &status, &blind, &notes, &createdAt, &submittedAt, &approvedAt)
+	if err == sql.ErrNoRows {
+		c.JSON(404, gin.H{"error": "Stock count not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	hidden := blind && status == countCounting
+
+	rows, err := db.Query(
+		`SELECT l.id, COALESCE(l.product_id::text, ''), COALESCE(l.variant_id::text, ''), COALESCE(l.ingredient_id::text, ''),
+		        l.description, l.unit, l.expected_quantity, CASE WHEN l.counted_at IS NULL THEN l.expected_quantity ELSE `+expectedWhenCounted+` END,
+		        l.counted_quantity, l.unit_cost, l.counted_at
+		 FROM stock_count_lines l JOIN stock_counts sc ON sc.id = l.count_id
+		 WHERE l.count_id = $1 AND l.tenant_id = $2 ORDER BY l.description`, id, tenantID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	lines := []gin.H{}
+	var varianceValue float64
+	for rows.Next() {
+		var lid, pid, vid, iid, desc, unit string
+		var snapshot, expected, cost float64
+		var counted *float64
+		var countedAt *time.Time
+		rows.Scan(&lid, &pid, &vid, &iid, &desc, &unit, &snapshot, &expected, &counted, &cost, &countedAt)
+		line := gin.H{
+			"id": lid, "productId": pid, "variantId": vid, "ingredientId": iid, "description": desc, "unit": unit,
+			"countedQuantity": counted, "countedAt": countedAt,
+		}
+		if !hidden {
+			line["snapshotQuantity"] = snapshot
+			line["expectedQuantity"] = expected
+			line["unitCost"] = cost
+			if counted != nil {
+				variance := *counted - expected
+				line["variance"] = variance
+				line["varianceValue"] = variance * cost
+				varianceValue += variance * cost
+			}
+		}
+		lines = append(lines, line)
+	}
+	count := gin.H{
+		"id": id, "countNumber": number, "locationId": locID, "status": status, "blind": blind, "notes": notes,
+		"createdAt": createdAt, "submittedAt": submittedAt, "approvedAt": approvedAt, "lines": lines,
+	}
+	if !hidden {
+		count["varianceValue"] = varianceValue
+	}
+	c.JSON(200, count)
+}
+
+// recordStockCountLines saves counted quantities. Lines can be counted again
+// until the count is approved; a reviewer correcting a line is allowed too.
+func recordStockCountLines(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	id := c.Param("id")
+	var req struct {
+		Lines []struct {
+			LineID          string   `json:"lineId" binding:"required"`
+			CountedQuantity *float64 `json:"countedQuantity"`
+		} `json:"lines" binding:"required,min=1"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Transaction failed"})
+		return
+	}
+	defer tx.Rollback()
+	var status string
+	if err := tx.QueryRow("SELECT status FROM stock_counts WHERE id = $1 AND tenant_id = $2 FOR UPDATE", id, tenantID).Scan(&status); err != nil {
+		c.JSON(404, gin.H{"error": "Stock count not found"})
+		return
+	}
+	if status != countCounting && status != countReview {
+		c.JSON(409, gin.H{"error": fmt.Sprintf("Cannot record counts on a %s stock count", status)})
+		return
+	}
+	by := actorFromContext(c)
+	for _, l := range req.Lines {
+		if l.CountedQuantity != nil && *l.CountedQuantity < 0 {
+			c.JSON(400, gin.H{"error": "countedQuantity cannot be negative"})
+			return
+		}
+		res, err := tx.Exec(
+			`UPDATE stock_count_lines SET counted_quantity = $1, counted_by = $2, counted_at = CASE WHEN $1::numeric IS NULL THEN NULL ELSE NOW() END
+			 WHERE id = $3 AND count_id = $4 AND tenant_id = $5`,
+			l.CountedQuantity, by.idOrNil(), l.LineID, id, tenantID)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			c.JSON(400, gin.H{"error": fmt.Sprintf("Line %s is not on this stock count", l.LineID)})
+			return
+		}
+	}
+	tx.Exec("UPDATE stock_counts SET updated_at = NOW() WHERE id = $1", id)
+	if err := tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Counts recorded", "id": id, "lines": len(req.Lines)})
+}
+
+func submitStockCount(c *gin.Context) {
+	changeStockCountStatus(c, countCounting, countReview, "submitted_at", "Stock count submitted for review")
+}
+
+func recountStockCount(c *gin.Context) {
+	changeStockCountStatus(c, countReview, countCounting, "submitted_at", "Stock count sent back for recount")
+}
+
+func cancelStockCount(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	id := c.Param("id")
+	res, err := db.Exec(
+		`UPDATE stock_counts SET status = 'cancelled', cancelled_at = NOW(), updated_at = NOW()
+		 WHERE id = $1 AND tenant_id = $2 AND status IN ('counting', 'review')`, id, tenantID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(409, gin.H{"error": "Stock count not found or already closed"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Stock count cancelled", "id": id, "status": countCancelled})
+}
+
+// changeStockCountStatus moves a count between counting and review. Sending a
+// count back clears its submitted_at; submitting stamps it.
+func changeStockCountStatus(c *gin.Context, from, to, stamp, message string) {
+	tenantID := c.GetString("tenantId")
+	id := c.Param("id")
+	set := stamp + " = NOW()"
+	if to == countCounting {
+		set = stamp + " = NULL"
+	}
+	res, err := db.Exec("UPDATE stock_counts SET status = $1, "+set+", updated_at = NOW() WHERE id = $2 AND tenant_id = $3 AND status = $4",
+		to, id, tenantID, from)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(409, gin.H{"error": fmt.Sprintf("Stock count not found or not in %s", from)})
+		return
+	}
+	c.JSON(200, gin.H{"message": message, "id": id, "status": to})
+}
+
+// approveStockCount posts each counted line's variance as an adjustment.
+// The variance is taken against what the ledger expected when the line was
+// counted, so sales rung up while counting are neither mistaken for
+// shrinkage nor deducted twice. Uncounted lines are left as they are.
+func approveStockCount(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	id := c.Param("id")
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Transaction failed"})
+		return
+	}
+	defer tx.Rollback()
+	var status, locationID, number string
+	err = tx.QueryRow("SELECT status, location_id, count_number FROM stock_counts WHERE id = $1 AND tenant_id = $2 FOR UPDATE",
+		id, tenantID).Scan(&status, &locationID, &number)
+	if err == sql.ErrNoRows {
+		c.JSON(404, gin.H{"error": "Stock count not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if status != countReview {
+		c.JSON(409, gin.H{"error": fmt.Sprintf("Cannot approve a %s stock count", status)})
+		return
+	}
+
+	rows, err := tx.Query(
+		`SELECT * FROM (
+			SELECT COALESCE(l.product_id::text, ''), l.variant_id::text, l.ingredient_id::text,
+			       l.counted_quantity - (`+expectedWhenCounted+`) AS variance, l.unit_cost
+			FROM stock_count_lines l JOIN stock_counts sc ON sc.id = l.count_id
+			WHERE l.count_id = $1 AND l.tenant_id = $2 AND l.counted_quantity IS NOT NULL
+		 ) v WHERE variance <> 0`, id, tenantID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	type adjustment struct {
+		item     stockItem
+		variance float64
+		cost     float64
+	}
+	var adjustments []adjustment
+	for rows.Next() {
+		var a adjustment
+		var variantID, ingredientID sql.NullString
+		rows.Scan(&a.item.productID, &variantID, &ingredientID, &a.variance, &a.cost)
+		if variantID.Valid {
+			a.item.variantID = &variantID.String
+		}
+		if ingredientID.Valid {
+			a.item.ingredientID = &ingredientID.String
+		}
+		adjustments = append(adjustments, a)
+	}
+	rows.Close()
+
+	by := actorFromContext(c)
+	var varianceValue float64
+	for _, a := range adjustments {
+		if _, err := recordStockMovement(tx, tenantID, stockMovement{
+			productID: a.item.productID, variantID: a.item.variantID, ingredientID: a.item.ingredientID,
+			locationID: locationID, kind: stockAdjustment, quantity: a.variance, unitCost: &a.cost,
+			reason: "Stock count " + number, referenceType: "stock_count", referenceID: id, by: by,
+		}); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		varianceValue += a.variance * a.cost
+	}
+	if _, err := tx.Exec("UPDATE stock_counts SET status = $1, approved_by = $2, approved_at = NOW(), updated_at = NOW() WHERE id = $3",
+		countApproved, by.idOrNil(), id); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{
+		"message": "Stock count approved", "id": id, "status": countApproved,
+		"adjustments": len(adjustments), "varianceValue": varianceValue,
+	})
+}
@@ -0,0 +1,1070 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/berhot/products/commerce/pos-engine/internal/money"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ── Tables ──────────────────────────────────────────────────
+
+// Table statuses. A table is seated when guests sit down, ordering once the
+// first round goes in, bill_requested when they ask for the check and dirty
+// after its last check closes, until someone clears it back to free.
+const (
+	tableFree          = "free"
+	tableSeated        = "seated"
+	tableOrdering      = "ordering"
+	tableBillRequested = "bill_requested"
+	tableDirty         = "dirty"
+)
+
+// openOrderStatuses is the SQL list of statuses an order on a table can still
+// be served in; it matches orderIsOpen.
+const openOrderStatuses = "('pending', 'accepted', 'preparing', 'ready')"
+
+func migrateTables() {
+	db.Exec(`CREATE TABLE IF NOT EXISTS floors (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		tenant_id UUID NOT NULL,
+		location_id UUID NOT NULL,
+		name VARCHAR(100) NOT NULL,
+		sort_order INTEGER NOT NULL DEFAULT 0,
+		is_active BOOLEAN NOT NULL DEFAULT true,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		UNIQUE (tenant_id, location_id, name)
+	)`)
+	db.Exec(`CREATE TABLE IF NOT EXISTS dining_tables (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		tenant_id UUID NOT NULL,
+		location_id UUID NOT NULL,
+		floor_id UUID NOT NULL REFERENCES floors(id),
+		name VARCHAR(50) NOT NULL,
+		capacity INTEGER NOT NULL DEFAULT 4 CHECK (capacity > 0),
+		shape VARCHAR(20) NOT NULL DEFAULT 'square' CHECK (shape IN ('square', 'round', 'rectangle')),
+		pos_x INTEGER NOT NULL DEFAULT 0,
+		pos_y INTEGER NOT NULL DEFAULT 0,
+		status VARCHAR(20) NOT NULL DEFAULT 'free'
+			CHECK (status IN ('free', 'seated', 'ordering', 'bill_requested', 'dirty')),
+		covers INTEGER NOT NULL DEFAULT 0,
+		seated_at TIMESTAMPTZ,
+		is_active BOOLEAN NOT NULL DEFAULT true,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		UNIQUE (floor_id, name)
+	)`)
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_dining_tables_location ON dining_tables(tenant_id, location_id)")
+
+	db.Exec(`ALTER TABLE orders ADD COLUMN IF NOT EXISTS table_id UUID REFERENCES dining_tables(id)`)
+	db.Exec(`ALTER TABLE orders ADD COLUMN IF NOT EXISTS covers INTEGER`)
+	db.Exec(`CREATE INDEX IF NOT EXISTS idx_orders_table ON orders(table_id) WHERE table_id IS NOT NULL`)
+	db.Exec(`ALTER TABLE order_items ADD COLUMN IF NOT EXISTS seat_number INTEGER`)
+	db.Exec(`ALTER TABLE order_items ADD COLUMN IF NOT EXISTS round INTEGER NOT NULL DEFAULT 1`)
+}
+
+// markTableOrdering moves a seated table on to ordering once its order has
+// items. Orders without a table are left alone.
+func markTableOrdering(tx *sql.Tx, tenantID, orderID string) error {
+	_, err := tx.Exec(
+		`UPDATE dining_tables SET status = 'ordering', updated_at = NOW()
+		 WHERE id = (SELECT table_id FROM orders WHERE id = $1 AND tenant_id = $2) AND status = 'seated'`, orderID, tenantID)
+	return err
+}
+
+// releaseOrderTable marks an order's table dirty once no open check is left
+// on it. It is called after the order has left the open statuses.
+func releaseOrderTable(tx *sql.Tx, tenantID, orderID string) error {
+	_, err := tx.Exec(
+		`UPDATE dining_tables t SET status = 'dirty', covers = 0, seated_at = NULL, updated_at = NOW()
+		 FROM orders o
+		 WHERE o.id = $1 AND o.tenant_id = $2 AND t.id = o.table_id
+		   AND NOT EXISTS (SELECT 1 FROM orders x WHERE x.table_id = t.id AND x.status IN `+openOrderStatuses+`)`,
+		orderID, tenantID)
+	return err
+}
+
+// tableOpenOrders lists the open checks on a table, oldest first.
+func tableOpenOrders(q queryer, tableID string) ([]gin.H, error) {
+	rows, err := q.Query(
+		`SELECT id, order_number, status, total, paid_amount, COALESCE(covers, 0), created_at FROM orders
+		 WHERE table_id = $1 AND status IN `+openOrderStatuses+` ORDER BY created_at, id`, tableID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	orders := []gin.H{}
+	for rows.Next() {
+		var id, number, status string
+		var total, paid money.Amount
+		var covers int
+		var createdAt time.Time
+		rows.Scan(&id, &number, &status, &total, &paid, &covers, &createdAt)
+		orders = append(orders, gin.H{
+			"id": id, "orderNumber": number, "status": status, "total": total,
+			"balanceDue": money.Max(total-paid, 0), "covers": covers, "createdAt": createdAt,
+		})
+	}
+	return orders, nil
+}
+
+// ── Floors ──────────────────────────────────────────────────
+
+func listFloors(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	query := `SELECT f.id, f.location_id, f.name, f.sort_order, f.is_active,
+	                 (SELECT COUNT(*) FROM dining_tables t WHERE t.floor_id = f.id AND t.is_active),
+	                 (SELECT COUNT(*) FROM dining_tables t WHERE t.floor_id = f.id AND t.is_active AND t.status = 'free')
+	          FROM floors f WHERE f.tenant_id = $1`
+	args := []interface{}{tenantID}
+	if locationID := c.Query("locationId"); locationID != "" {
+		args = append(args, locationID)
+		query += fmt.Sprintf(" AND f.location_id = $%d", len(args))
+	}
+	rows, err := db.Query(query+" ORDER BY f.sort_order, f.name", args...)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	floors := []gin.H{}
+	for rows.Next() {
+		var id, locationID, name string
+		var sortOrder, tables, free int
+		var active bool
+		rows.Scan(&id, &locationID, &name, &sortOrder, &active, &tables, &free)
+		floors = append(floors, gin.H{
+			"id": id, "locationId": locationID, "name": name, "sortOrder": sortOrder, "isActive": active,
+			"tableCount": tables, "freeTables": free,
+		})
+	}
+	c.JSON(200, gin.H{"floors": floors, "total": len(floors)})
+}
+
+type floorRequest struct {
+	LocationID string `json:"locationId"`
+	Name       string `json:"name"`
+	SortOrder  *int   `json:"sortOrder"`
+	IsActive   *bool  `json:"isActive"`
+}
+
+func createFloor(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	var req floorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if req.LocationID == "" || req.Name == "" {
+		c.JSON(400, gin.H{"error": "locationId and name are required"})
+		return
+	}
+	var known bool
+	db.QueryRow("SELECT EXISTS(SELECT 1 FROM locations WHERE id = $1 AND tenant_id = $2)", req.LocationID, tenantID).Scan(&known)
+	if !known {
+		c.JSON(404, gin.H{"error": "Location not found"})
+		return
+	}
+	var taken bool
+	db.QueryRow("SELECT EXISTS(SELECT 1 FROM floors WHERE tenant_id = $1 AND location_id = $2 AND name = $3)",
+		tenantID, req.LocationID, req.Name).Scan(&taken)
+	if taken {
+		c.JSON(409, gin.H{"error": "A floor with this name already exists at the location"})
+		return
+	}
+	sortOrder := 0
+	if req.SortOrder != nil {
+		sortOrder = *req.SortOrder
+	}
+	id := uuid.New().String()
+	if _, err := db.Exec("INSERT INTO floors (id, tenant_id, location_id, name, sort_order) VALUES ($1, $2, $3, $4, $5)",
+		id, tenantID, req.LocationID, req.Name, sortOrder); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(201, gin.H{"id": id, "locationId": req.LocationID, "name": req.Name, "sortOrder": sortOrder, "isActive": true})
+}
+
+func updateFloor(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	id := c.Param("id")
+	var req floorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Name != "" {
+		var taken bool
+		db.QueryRow(
+			`SELECT EXISTS(SELECT 1 FROM floors f JOIN floors o ON o.location_id = f.location_id AND o.tenant_id = f.tenant_id
+			 WHERE f.id::text = $1 AND f.tenant_id = $2 AND o.id <> f.id AND o.name = $3)`, id, tenantID, req.Name).Scan(&taken)
+		if taken {
+			c.JSON(409, gin.H{"error": "A floor with this name already exists at the location"})
+			return
+		}
+	}
+	res, err := db.Exec(
+		`UPDATE floors SET
+			name = COALESCE(NULLIF($1, ''), name),
+			sort_order = COALESCE($2, sort_order),
+			is_active = COALESCE($3, is_active),
+			updated_at = NOW()
+		 WHERE id = $4 AND tenant_id = $5`,
+		req.Name, req.SortOrder, req.IsActive, id, tenantID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(404, gin.H{"error": "Floor not found"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Floor updated"})
+}
+
+// getFloorPlan returns a floor with its tables laid out and the open checks
+// on each, which is what the host stand and server tablets draw.
+func getFloorPlan(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	id := c.Param("id")
+	var locationID, name string
+	var active bool
+	if err := db.QueryRow("SELECT location_id, name, is_active FROM floors WHERE id = $1 AND tenant_id = $2", id, tenantID).
+		Scan(&locationID, &name, &active); err != nil {
+		c.JSON(404, gin.H{"error": "Floor not found"})
+		return
+	}
+	rows, err := db.Query(
+		`SELECT id, name, capacity, shape, pos_x, pos_y, status, covers, seated_at
+		 FROM dining_tables WHERE floor_id = $1 AND tenant_id = $2 AND is_active ORDER BY name`, id, tenantID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	type planTable struct {
+		data gin.H
+		id   string
+	}
+	var plan []planTable
+	for rows.Next() {
+		var tid, tname, shape, status string
+		var capacity, x, y, covers int
+		var seatedAt sql.NullTime
+		rows.Scan(&tid, &tname, &capacity, &shape, &x, &y, &status, &covers, &seatedAt)
+		t := gin.H{
+			"id": tid, "name": tname, "capacity": capacity, "shape": shape, "x": x, "y": y,
+			"status": status, "covers": covers,
+		}
+		if seatedAt.Valid {
+			t["seatedAt"] = seatedAt.Time
+			t["seatedMinutes"] = int(time.Since(seatedAt.Time).Minutes())
+		}
+		plan = append(plan, planTable{t, tid})
+	}
+	rows.Close()
+
+	tables := []gin.H{}
+	counts := map[string]int{}
+	for _, t := range plan {
+		orders, err := tableOpenOrders(db, t.id)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		t.data["orders"] = orders
+		tables = append(tables, t.data)
+		counts[t.data["status"].(string)]++
+	}
+	c.JSON(200, gin.H{
+		"id": id, "locationId": locationID, "name": name, "isActive": active,
+		"tables": tables, "statusCounts": counts,
+	})
+}
+
+// ── Dining Tables ───────────────────────────────────────────
+
+func listTables(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	query := `SELECT t.id, t.location_id, t.floor_id, f.name, t.name, t.capacity, t.shape, t.status, t.covers, t.seated_at, t.is_active
+	          FROM dining_tables t JOIN floors f ON f.id = t.floor_id
+	          WHERE t.tenant_id = $1`
+	args := []interface{}{tenantID}
+	if locationID := c.Query("locationId"); locationID != "" {
+		args = append(args, locationID)
+		query += fmt.Sprintf(" AND t.location_id = $%d", len(args))
+	}
+	if floorID := c.Query("floorId"); floorID != "" {
+		args = append(args, floorID)
+		query += fmt.Sprintf(" AND t.floor_id = $%d", len(args))
+	}
+	if status := c.Query("status"); status != "" {
+		args = append(args, status)
+		query += fmt.Sprintf(" AND t.status = $%d", len(args))
+	}
+	if minCovers := c.Query("minCapacity"); minCovers != "" {
+		args = append(args, minCovers)
+		query += fmt.Sprintf(" AND t.capacity >= $%d", len(args))
+	}
+	rows, err := db.Query(query+" ORDER BY f.sort_order, t.name", args...)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	tables := []gin.H{}
+	for rows.Next() {
+		var id, locationID, floorID, floorName, name, shape, status string
+		var capacity, covers int
+		var seatedAt sql.NullTime
+		var active bool
+		rows.Scan(&id, &locationID, &floorID, &floorName, &name, &capacity, &shape, &status, &covers, &seatedAt, &active)
+		t := gin.H{
+			"id": id, "locationId": locationID, "floorId": floorID, "floorName": floorName, "name": name,
+			"capacity": capacity, "shape": shape, "status": status, "covers": covers, "isActive": active,
+		}
+		if seatedAt.Valid {
+			t["seatedAt"] = seatedAt.Time
+		}
+		tables = append(tables, t)
+	}
+	c.JSON(200, gin.H{"tables": tables, "total": len(tables)})
+}
+
+type tableRequest struct {
+	FloorID  string `json:"floorId"`
+	Name     string `json:"name"`
+	Capacity *int   `json:"capacity"`
+	Shape    string `json:"shape"`
+	X        *int   `json:"x"`
+	Y        *int   `json:"y"`
+	IsActive *bool  `json:"isActive"`
+}
+
+func createTable(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	var req tableRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if req.FloorID == "" || req.Name == "" {
+		c.JSON(400, gin.H{"error": "floorId and name are required"})
+		return
+	}
+	if req.Capacity != nil && *req.Capacity <= 0 {
+		c.JSON(400, gin.H{"error": "capacity must be positive"})
+		return
+	}
+	if req.Shape == "" {
+		req.Shape = "square"
+	}
+	if req.Shape != "square" && req.Shape != "round" && req.Shape != "rectangle" {
+		c.JSON(400, gin.H{"error": "shape must be square, round or rectangle"})
+		return
+	}
+	var locationID string
+	if err := db.QueryRow("SELECT location_id FROM floors WHERE id = $1 AND tenant_id = $2", req.FloorID, tenantID).Scan(&locationID); err != nil {
+		c.JSON(404, gin.H{"error": "Floor not found"})
+		return
+	}
+	var taken bool
+	db.QueryRow("SELECT EXISTS(SELECT 1 FROM dining_tables WHERE floor_id = $1 AND name = $2)", req.FloorID, req.Name).Scan(&taken)
+	if taken {
+		c.JSON(409, gin.H{"error": "A table with this name already exists on the floor"})
+		return
+	}
+	capacity, x, y := 4, 0, 0
+	if req.Capacity != nil {
+		capacity = *req.Capacity
+	}
+	if req.X != nil {
+		x = *req.X
+	}
+	if req.Y != nil {
+		y = *req.Y
+	}
+	id := uuid.New().String()
+	if _, err := db.Exec(
+		`INSERT INTO dining_tables (id, tenant_id, location_id, floor_id, name, capacity, shape, pos_x, pos_y)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		id, tenantID, locationID, req.FloorID, req.Name, capacity, req.Shape, x, y); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(201, gin.H{
+		"id": id, "locationId": locationID, "floorId": req.FloorID, "name": req.Name, "capacity": capacity,
+		"shape": req.Shape, "x": x, "y": y, "status": tableFree,
+	})
+}
+
+func updateTable(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	id := c.Param("id")
+	var req tableRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Capacity != nil && *req.Capacity <= 0 {
+		c.JSON(400, gin.H{"error": "capacity must be positive"})
+		return
+	}
+	if req.Shape != "" && req.Shape != "square" && req.Shape != "round" && req.Shape != "rectangle" {
+		c.JSON(400, gin.H{"error": "shape must be square, round or rectangle"})
+		return
+	}
+	if req.FloorID != "" {
+		// Tables move between floors of the same location only.
+		var same bool
+		db.QueryRow(
+			`SELECT EXISTS(SELECT 1 FROM floors f JOIN dining_tables t ON t.location_id = f.location_id
+			 WHERE f.id::text = $1 AND t.id::text = $2 AND f.tenant_id = $3)`, req.FloorID, id, tenantID).Scan(&same)
+		if !same {
+			c.JSON(400, gin.H{"error": "floorId must be a floor at the table's location"})
+			return
+		}
+	}
+	if req.IsActive != nil && !*req.IsActive {
+		var busy bool
+		db.QueryRow("SELECT EXISTS(SELECT 1 FROM orders WHERE table_id::text = $1 AND status IN "+openOrderStatuses+")", id).Scan(&busy)
+		if busy {
+			c.JSON(409, gin.H{"error": "Table has open checks"})
+			return
+		}
+	}
+	res, err := db.Exec(
+		`UPDATE dining_tables SET
+			floor_id = COALESCE(NULLIF($1, '')::uuid, floor_id),
+			name = COALESCE(NULLIF($2, ''), name),
+			capacity = COALESCE($3, capacity),
+			shape = COALESCE(NULLIF($4, ''), shape),
+			pos_x = COALESCE($5, pos_x),
+			pos_y = COALESCE($6, pos_y),
+			is_active = COALESCE($7, is_active),
+			updated_at = NOW()
+		 WHERE id = $8 AND tenant_id = $9`,
+		req.FloorID, req.Name, req.Capacity, req.Shape, req.X, req.Y, req.IsActive, id, tenantID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(404, gin.H{"error": "Table not found"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Table updated"})
+}
+
+// diningTable is the part of a table row the service logic needs.
+type diningTable struct {
+	id, locationID, name, status string
+	covers                       int
+	active                       bool
+}
+
+// lockTable reads a table and locks it for the rest of tx.
+func lockTable(tx *sql.Tx, tenantID, id string) (diningTable, error) {
+	t := diningTable{id: id}
+	err := tx.QueryRow(
+		"SELECT location_id, name, status, covers, is_active FROM dining_tables WHERE id = $1 AND tenant_id = $2 FOR UPDATE",
+		id, tenantID).Scan(&t.locationID, &t.name, &t.status, &t.covers, &t.active)
+	return t, err
+}
+
+// seatTable seats a party and opens a dine-in check on the table. Items
+// follow as rounds on POST /orders/:id/items.
+func seatTable(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	tableID := c.Param("id")
+	var req struct {
+		Covers     int    `json:"covers" binding:"required,min=1"`
+		CustomerID string `json:"customerId"`
+		Notes      string `json:"notes"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Transaction failed"})
+		return
+	}
+	defer tx.Rollback()
+	table, err := lockTable(tx, tenantID, tableID)
+	if err != nil || !table.active {
+		c.JSON(404, gin.H{"error": "Table not found"})
+		return
+	}
+	if table.status != tableFree {
+		c.JSON(409, gin.H{"error": fmt.Sprintf("Table %s is %s", table.name, table.status), "status": table.status})
+		return
+	}
+
+	orderID, orderNum, currency, err := insertEmptyOrder(tx, tenantID, table.locationID, "dine_in", req.CustomerID, req.Notes)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := tx.Exec("UPDATE orders SET table_id = $1, covers = $2 WHERE id = $3", tableID, req.Covers, orderID); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := tx.Exec("UPDATE dining_tables SET status = 'seated', covers = $1, seated_at = NOW(), updated_at = NOW() WHERE id = $2",
+		req.Covers, tableID); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err := recordNewOrder(tx, tenantID, orderID, actorFromContext(c), gin.H{"tableId": tableID, "covers": req.Covers}); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(201, gin.H{
+		"tableId": tableID, "status": tableSeated, "covers": req.Covers,
+		"order": gin.H{"id": orderID, "orderNumber": orderNum, "status": orderPending, "orderType": "dine_in", "currency": currency},
+	})
+}
+
+// recordNewOrder writes the creation entries every order gets: its first
+// status history row, the stream event and the outbox event.
+func recordNewOrder(tx *sql.Tx, tenantID, orderID string, by actor, data gin.H) error {
+	if err := recordOrderStatus(tx, tenantID, orderID, "", orderPending, by, ""); err != nil {
+		return err
+	}
+	var orderType string
+	var itemCount int
+	tx.QueryRow("SELECT order_type, (SELECT COUNT(*) FROM order_items WHERE order_id = $1 AND line_status <> 'voided') FROM orders WHERE id = $1", orderID).
+		Scan(&orderType, &itemCount)
+	event := gin.H{"orderType": orderType, "itemCount": itemCount}
+	for k, v := range data {
+		event[k] = v
+	}
+	if err := recordOrderEvent(tx, tenantID, orderID, orderEventCreated, event); err != nil {
+		return err
+	}
+	return enqueueOrderEvent(tx, tenantID, orderID, eventOrderCreated, gin.H{"itemCount": itemCount})
+}
+
+// setTableStatus is how staff move a table through service by hand. Seating
+// goes through seatTable so the check is opened with it, and a table with
+// open checks cannot be cleared.
+func setTableStatus(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	tableID := c.Param("id")
+	var req struct {
+		Status string `json:"status" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	switch req.Status {
+	case tableOrdering, tableBillRequested, tableDirty, tableFree:
+	case tableSeated:
+		c.JSON(400, gin.H{"error": "Seat a table with POST /tables/:id/seat"})
+		return
+	default:
+		c.JSON(400, gin.H{"error": fmt.Sprintf("Unknown table status %q", req.Status)})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Transaction failed"})
+		return
+	}
+	defer tx.Rollback()
+	table, err := lockTable(tx, tenantID, tableID)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Table not found"})
+		return
+	}
+	var busy bool
+	tx.QueryRow("SELECT EXISTS(SELECT 1 FROM orders WHERE table_id = $1 AND status IN "+openOrderStatuses+")", tableID).Scan(&busy)
+	occupied := req.Status == tableOrdering || req.Status == tableBillRequested
+	if occupied && !busy {
+		c.JSON(409, gin.H{"error": "Table has no open check", "status": table.status})
+		return
+	}
+	if !occupied && busy {
+		c.JSON(409, gin.H{"error": "Table has open checks", "status": table.status})
+		return
+	}
+	set := "status = $1, updated_at = NOW()"
+	if !occupied {
+		set += ", covers = 0, seated_at = NULL"
+	}
+	if _, err := tx.Exec("UPDATE dining_tables SET "+set+" WHERE id = $2", req.Status, tableID); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"id": tableID, "status": req.Status, "previousStatus": table.status})
+}
+
+// transferTable moves a party and every open check to a free table, for
+// guests who change tables mid-meal.
+func transferTable(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	fromID := c.Param("id")
+	var req struct {
+		TableID string `json:"tableId" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if req.TableID == fromID {
+		c.JSON(400, gin.H{"error": "tableId must be a different table"})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Transaction failed"})
+		return
+	}
+	defer tx.Rollback()
+	from, to, ok := lockTablePair(c, tx, tenantID, fromID, req.TableID)
+	if !ok {
+		return
+	}
+	if to.status != tableFree {
+		c.JSON(409, gin.H{"error": fmt.Sprintf("Table %s is %s", to.name, to.status), "status": to.status})
+		return
+	}
+	orders, err := moveTableOrders(tx, tenantID, fromID, req.TableID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if len(orders) == 0 {
+		c.JSON(409, gin.H{"error": "Table has no open check"})
+		return
+	}
+	if _, err := tx.Exec(
+		`UPDATE dining_tables t SET status = f.status, covers = f.covers, seated_at = f.seated_at, updated_at = NOW()
+		 FROM dining_tables f WHERE t.id = $1 AND f.id = $2`, req.TableID, fromID); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := tx.Exec("UPDATE dining_tables SET status = 'dirty', covers = 0, seated_at = NULL, updated_at = NOW() WHERE id = $1", fromID); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	for _, orderID := range orders {
+		if err := recordOrderEvent(tx, tenantID, orderID, orderEventUpdated, gin.H{"tableId": req.TableID, "previousTableId": fromID}); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": fmt.Sprintf("Moved from %s to %s", from.name, to.name), "tableId": req.TableID, "orderIds": orders})
+}
+
+// lockTablePair locks two tables of one location in a fixed order, so two
+// transfers between the same tables cannot deadlock. On failure it has
+// already written the response.
+func lockTablePair(c *gin.Context, tx *sql.Tx, tenantID, fromID, toID string) (from, to diningTable, ok bool) {
+	ids := []string{fromID, toID}
+	sort.Strings(ids)
+	locked := map[string]diningTable{}
+	for _, id := range ids {
+		t, err := lockTable(tx, tenantID, id)
+		if err != nil || !t.active {
+			c.JSON(404, gin.H{"error": "Table not found", "tableId": id})
+			return from, to, false
+		}
+		locked[id] = t
+	}
+	from, to = locked[fromID], locked[toID]
+	if from.locationID != to.locationID {
+		c.JSON(400, gin.H{"error": "Tables are at different locations"})
+		return from, to, false
+	}
+	return from, to, true
+}
+
+// moveTableOrders re-points a table's open checks at another table and
+// returns their ids.
+func moveTableOrders(tx *sql.Tx, tenantID, fromID, toID string) ([]string, error) {
+	rows, err := tx.Query(
+		`UPDATE orders SET table_id = $1, updated_at = NOW()
+		 WHERE table_id = $2 AND tenant_id = $3 AND status IN `+openOrderStatuses+` RETURNING id`, toID, fromID, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		rows.Scan(&id)
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// mergeTables joins a party onto another table's: the source table's checks
+// are folded into the target's oldest open check and closed, and the source
+// table is left to be cleared.
+func mergeTables(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	fromID := c.Param("id")
+	var req struct {
+		TableID string `json:"tableId" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if req.TableID == fromID {
+		c.JSON(400, gin.H{"error": "tableId must be a different table"})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Transaction failed"})
+		return
+	}
+	defer tx.Rollback()
+	from, to, ok := lockTablePair(c, tx, tenantID, fromID, req.TableID)
+	if !ok {
+		return
+	}
+	var targetID, targetNumber string
+	if err := tx.QueryRow(
+		`SELECT id, order_number FROM orders WHERE table_id = $1 AND status IN `+openOrderStatuses+`
+		 ORDER BY created_at, id LIMIT 1 FOR UPDATE`, req.TableID).Scan(&targetID, &targetNumber); err != nil {
+		c.JSON(409, gin.H{"error": fmt.Sprintf("Table %s has no open check", to.name)})
+		return
+	}
+	sources, err := tableOpenOrders(tx, fromID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if len(sources) == 0 {
+		c.JSON(409, gin.H{"error": fmt.Sprintf("Table %s has no open check", from.name)})
+		return
+	}
+	by := actorFromContext(c)
+	reason := "Merged into " + targetNumber
+	covers := 0
+	for _, o := range sources {
+		orderID := o["id"].(string)
+		covers += o["covers"].(int)
+		if err := mergeOrderInto(tx, tenantID, orderID, targetID, by, reason); err != nil {
+			writeCheckError(c, err)
+			return
+		}
+	}
+	if _, err := tx.Exec(
+		`UPDATE orders SET covers = COALESCE(covers, 0) + $1, updated_at = NOW() WHERE id = $2`, covers, targetID); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := tx.Exec("UPDATE dining_tables SET covers = covers + $1, updated_at = NOW() WHERE id = $2", from.covers, req.TableID); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err := recalculateOrder(tx, tenantID, targetID); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err := markTableOrdering(tx, tenantID, targetID); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err := recordOrderEvent(tx, tenantID, targetID, orderEventUpdated, gin.H{"mergedFromTableId": fromID}); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	var total money.Amount
+	db.QueryRow("SELECT total FROM orders WHERE id = $1", targetID).Scan(&total)
+	c.JSON(200, gin.H{
+		"message": fmt.Sprintf("Merged %s into %s", from.name, to.name), "tableId": req.TableID,
+		"orderId": targetID, "orderNumber": targetNumber, "total": total,
+	})
+}
+
+// checkError is a request to merge or split checks that cannot be carried out.
+type checkError struct {
+	msg string
+}
+
+func (e *checkError) Error() string { return e.msg }
+
+func writeCheckError(c *gin.Context, err error) {
+	if ce, ok := err.(*checkError); ok {
+		c.JSON(409, gin.H{"error": ce.Error()})
+		return
+	}
+	c.JSON(500, gin.H{"error": err.Error()})
+}
+
+// mergeOrderInto moves every line of an unpaid check onto another check and
+// cancels the emptied one.
+func mergeOrderInto(tx *sql.Tx, tenantID, fromID, toID string, by actor, reason string) error {
+	var paid money.Amount
+	var number string
+	tx.QueryRow("SELECT order_number, paid_amount FROM orders WHERE id = $1 FOR UPDATE", fromID).Scan(&number, &paid)
+	if paid.IsPositive() {
+		return &checkError{fmt.Sprintf("Check %s has payments and cannot be merged", number)}
+	}
+	// Voided lines stay behind on the cancelled check with their record.
+	rows, err := tx.Query("SELECT id FROM order_items WHERE order_id = $1 AND tenant_id = $2 AND line_status <> 'voided'", fromID, tenantID)
+	if err != nil {
+		return err
+	}
+	var moves []checkItemMove
+	for rows.Next() {
+		var m checkItemMove
+		rows.Scan(&m.ItemID)
+		moves = append(moves, m)
+	}
+	rows.Close()
+	if err := moveOrderItems(tx, tenantID, fromID, toID, moves, by); err != nil {
+		return err
+	}
+	if err := recalculateOrder(tx, tenantID, fromID); err != nil {
+		return err
+	}
+	_, err = transitionOrder(tx, tenantID, fromID, orderCancelled, "", by, reason)
+	return err
+}
+
+// checkItemMove is one line, or part of one, moving between checks. A zero
+// quantity moves the whole line.
+type checkItemMove struct {
+	ItemID   string  `json:"itemId" binding:"required"`
+	Quantity float64 `json:"quantity"`
+}
+
+// moveOrderItems moves lines from one check to another, splitting a line
+// when only part of its quantity moves. The stock a moved line used is
+// handed over in the ledger too, so cancelling either check later restocks
+// exactly what it holds. Both checks need recalculating afterwards.
+func moveOrderItems(tx *sql.Tx, tenantID, fromID, toID string, moves []checkItemMove, by actor) error {
+	var locationID, toNumber string
+	if err := tx.QueryRow("SELECT location_id, order_number FROM orders WHERE id = $1 AND tenant_id = $2", toID, tenantID).
+		Scan(&locationID, &toNumber); err != nil {
+		return err
+	}
+	for _, m := range moves {
+		var name string
+		var qty float64
+		err := tx.QueryRow(
+			`SELECT name, quantity FROM order_items
+			 WHERE id = $1 AND order_id = $2 AND tenant_id = $3 AND line_status <> 'voided' FOR UPDATE`, m.ItemID, fromID, tenantID).
+			Scan(&name, &qty)
+		if err != nil {
+			return &checkError{fmt.Sprintf("Item %s is not on the check", m.ItemID)}
+		}
+		moved := m.Quantity
+		if moved < 0 || moved > qty {
+			return &checkError{fmt.Sprintf("Cannot move %v of %s, the check has %v", moved, name, qty)}
+		}
+		if moved == 0 || moved == qty {
+			moved = qty
+			if _, err := tx.Exec("UPDATE order_items SET order_id = $1 WHERE id = $2", toID, m.ItemID); err != nil {
+				return err
+			}
+		} else if _, err := splitOrderItem(tx, m.ItemID, toID, moved); err != nil {
+			return err
+		}
+		stock, err := itemStockLines(tx, tenantID, m.ItemID, moved)
+		if err != nil {
+			return err
+		}
+		for _, l := range stock {
+			for _, sm := range []stockMovement{
+				{kind: stockCancel, quantity: l.quantity, referenceID: fromID, reason: "Moved to " + toNumber},
+				{kind: stockSale, quantity: -l.quantity, referenceID: toID},
+			} {
+				sm.productID, sm.variantID, sm.locationID, sm.referenceType, sm.by = l.productID, l.variantID, locationID, "order", by
+				if _, err := recordStockMovement(tx, tenantID, sm); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// splitCheck splits an unpaid check into several. By item, the listed lines
+// (or quantities of them) go onto one new check. By seat, each listed seat
+// gets a check of its own; with no seats listed every seat after the first
+// is split off. New checks share the table and are taxed as the original.
+func splitCheck(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	orderID := c.Param("id")
+	var req struct {
+		By    string          `json:"by" binding:"required"`
+		Items []checkItemMove `json:"items"`
+		Seats []int           `json:"seats"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if req.By != "item" && req.By != "seat" {
+		c.JSON(400, gin.H{"error": "by must be item or seat"})
+		return
+	}
+	if req.By == "item" && len(req.Items) == 0 {
+		c.JSON(400, gin.H{"error": "items are required to split by item"})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Transaction failed"})
+		return
+	}
+	defer tx.Rollback()
+	var status, number string
+	var paid money.Amount
+	if err := tx.QueryRow("SELECT status, order_number, paid_amount FROM orders WHERE id = $1 AND tenant_id = $2 FOR UPDATE", orderID, tenantID).
+		Scan(&status, &number, &paid); err != nil {
+		c.JSON(404, gin.H{"error": "Order not found"})
+		return
+	}
+	if !orderIsOpen(status) {
+		c.JSON(409, gin.H{"error": fmt.Sprintf("Cannot split a %s order", status), "status": status})
+		return
+	}
+	if paid.IsPositive() {
+		c.JSON(409, gin.H{"error": "Checks cannot be split after a payment has been taken"})
+		return
+	}
+
+	// groups are the moves for each new check, in order.
+	var groups [][]checkItemMove
+	var labels []gin.H
+	if req.By == "item" {
+		groups = append(groups, req.Items)
+		labels = append(labels, gin.H{})
+	} else {
+		rows, err := tx.Query("SELECT id, seat_number FROM order_items WHERE order_id = $1 AND seat_number IS NOT NULL AND line_status <> 'voided' ORDER BY seat_number", orderID)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		bySeat := map[int][]checkItemMove{}
+		var seats []int
+		for rows.Next() {
+			var itemID string
+			var seat int
+			rows.Scan(&itemID, &seat)
+			if _, ok := bySeat[seat]; !ok {
+				seats = append(seats, seat)
+			}
+			bySeat[seat] = append(bySeat[seat], checkItemMove{ItemID: itemID})
+		}
+		rows.Close()
+		split := req.Seats
+		if len(split) == 0 && len(seats) > 0 {
+			split = seats[1:]
+		}
+		for _, seat := range split {
+			if len(bySeat[seat]) == 0 {
+				c.JSON(400, gin.H{"error": fmt.Sprintf("No items for seat %d", seat)})
+				return
+			}
+			groups = append(groups, bySeat[seat])
+			labels = append(labels, gin.H{"seat": seat})
+		}
+		if len(groups) == 0 {
+			c.JSON(409, gin.H{"error": "The check has only one seat to split"})
+			return
+		}
+	}
+
+	by := actorFromContext(c)
+	checks := []gin.H{}
+	for i, moves := range groups {
+		newID, newNumber, err := copyCheck(tx, tenantID, orderID)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		if err := moveOrderItems(tx, tenantID, orderID, newID, moves, by); err != nil {
+			writeCheckError(c, err)
+			return
+		}
+		if err := recalculateOrder(tx, tenantID, newID); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		if err := recordNewOrder(tx, tenantID, newID, by, gin.H{"splitFromOrderId": orderID}); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		var total money.Amount
+		tx.QueryRow("SELECT total FROM orders WHERE id = $1", newID).Scan(&total)
+		check := gin.H{"id": newID, "orderNumber": newNumber, "total": total}
+		for k, v := range labels[i] {
+			check[k] = v
+		}
+		checks = append(checks, check)
+	}
+	var left int
+	tx.QueryRow("SELECT COUNT(*) FROM order_items WHERE order_id = $1 AND line_status <> 'voided'", orderID).Scan(&left)
+	if left == 0 {
+		c.JSON(409, gin.H{"error": "A split must leave items on the original check"})
+		return
+	}
+	if err := recalculateOrder(tx, tenantID, orderID); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err := recordOrderEvent(tx, tenantID, orderID, orderEventUpdated, gin.H{"splitInto": len(checks)}); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	var total money.Amount
+	db.QueryRow("SELECT total FROM orders WHERE id = $1", orderID).Scan(&total)
+	c.JSON(201, gin.H{
+		"order":  gin.H{"id": orderID, "orderNumber": number, "total": total},
+		"checks": checks,
+	})
+}
+
+// copyCheck opens an empty check with the same table, customer, type and tax
+// treatment as orderID.
+func copyCheck(tx *sql.Tx, tenantID, orderID string) (string, string, error) {
+	id := uuid.New().String()
+	number := fmt.Sprintf("ORD-%s-%s", time.Now().Format("20060102150405"), uuid.New().String()[:4])
+	_, err := tx.Exec(
+		`INSERT INTO orders (id, tenant_id, location_id, order_number, status, order_type, customer_id, subtotal, discount_amount, tax_amount, total,
+		        currency, notes, prices_include_tax, tax_rounding, table_id, channel)
+		 SELECT $1, tenant_id, location_id, $2, 'pending', order_type, customer_id, 0, 0, 0, 0,
+		        currency, notes, prices_include_tax, tax_rounding, table_id, channel
+		 FROM orders WHERE id = $3 AND tenant_id = $4`, id, number, orderID, tenantID)
+	return id, number, err
+}
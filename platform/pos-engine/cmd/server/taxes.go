@@ -0,0 +1,244 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/berhot/products/commerce/pos-engine/internal/money"
+	"github.com/berhot/products/commerce/pos-engine/internal/tax"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ── Tax ─────────────────────────────────────────────────────
+
+func migrateTax() {
+	db.Exec(`CREATE TABLE IF NOT EXISTS tax_categories (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		tenant_id UUID NOT NULL,
+		code VARCHAR(20) NOT NULL,
+		name VARCHAR(255) NOT NULL,
+		name_ar VARCHAR(255) NOT NULL DEFAULT '',
+		kind VARCHAR(20) NOT NULL DEFAULT 'standard'
+			CHECK (kind IN ('standard', 'zero_rated', 'exempt', 'out_of_scope')),
+		rate DECIMAL(5,2) NOT NULL DEFAULT 0,
+		is_active BOOLEAN NOT NULL DEFAULT true,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		UNIQUE(tenant_id, code)
+	)`)
+	db.Exec(`ALTER TABLE products ADD COLUMN IF NOT EXISTS tax_category_id UUID REFERENCES tax_categories(id)`)
+	db.Exec(`ALTER TABLE locations ADD COLUMN IF NOT EXISTS prices_include_tax BOOLEAN NOT NULL DEFAULT false`)
+	db.Exec(`ALTER TABLE locations ADD COLUMN IF NOT EXISTS tax_rounding VARCHAR(10) NOT NULL DEFAULT 'line'`)
+	db.Exec(`ALTER TABLE locations ADD COLUMN IF NOT EXISTS default_tax_category_id UUID REFERENCES tax_categories(id)`)
+	db.Exec(`ALTER TABLE orders ADD COLUMN IF NOT EXISTS prices_include_tax BOOLEAN NOT NULL DEFAULT false`)
+	db.Exec(`ALTER TABLE orders ADD COLUMN IF NOT EXISTS tax_rounding VARCHAR(10) NOT NULL DEFAULT 'line'`)
+	db.Exec(`ALTER TABLE order_items ADD COLUMN IF NOT EXISTS tax_rate DECIMAL(5,2) NOT NULL DEFAULT 0`)
+	db.Exec(`ALTER TABLE order_items ADD COLUMN IF NOT EXISTS tax_category_code VARCHAR(20) NOT NULL DEFAULT ''`)
+	db.Exec(`ALTER TABLE order_items ADD COLUMN IF NOT EXISTS tax_kind VARCHAR(20) NOT NULL DEFAULT 'standard'`)
+	db.Exec(`ALTER TABLE order_items ADD COLUMN IF NOT EXISTS net_amount DECIMAL(12,2)`)
+	db.Exec(`CREATE TABLE IF NOT EXISTS order_tax_summary (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		tenant_id UUID NOT NULL,
+		order_id UUID NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+		tax_category_code VARCHAR(20) NOT NULL DEFAULT '',
+		tax_kind VARCHAR(20) NOT NULL,
+		rate DECIMAL(5,2) NOT NULL,
+		taxable_amount DECIMAL(12,2) NOT NULL,
+		tax_amount DECIMAL(12,2) NOT NULL
+	)`)
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_order_tax_summary_order ON order_tax_summary(order_id)")
+}
+
+// taxContext is how a location taxes its sales.
+type taxContext struct {
+	settings tax.Settings
+	fallback tax.Category
+}
+
+// loadTaxContext reads a location's price mode, rounding and default tax.
+// The location's default category wins over its plain tax_rate.
+func loadTaxContext(tenantID, locationID string) taxContext {
+	ctx := taxContext{settings: tax.Settings{Rounding: tax.RoundingLine}}
+	var rate float64
+	var catID, code, name, kind sql.NullString
+	var catRate sql.NullFloat64
+	err := db.QueryRow(
+		`SELECT l.prices_include_tax, l.tax_rounding, COALESCE(l.tax_rate, 0),
+		        tc.id, tc.code, tc.name, tc.kind, tc.rate
+		 FROM locations l
+		 LEFT JOIN tax_categories tc ON tc.id = l.default_tax_category_id
+		 WHERE l.id = $1 AND l.tenant_id = $2`, locationID, tenantID,
+	).Scan(&ctx.settings.PricesIncludeTax, &ctx.settings.Rounding, &rate, &catID, &code, &name, &kind, &catRate)
+	if err != nil {
+		return ctx
+	}
+	if catID.Valid {
+		ctx.fallback = tax.Category{ID: catID.String, Code: code.String, Name: name.String, Kind: kind.String, Rate: catRate.Float64}
+	} else {
+		ctx.fallback = tax.Category{Rate: rate}
+	}
+	return ctx
+}
+
+// productTaxCategory resolves a product's tax: its own category first, then
+// a rate set directly on the product, then the location default.
+func (t taxContext) productTaxCategory(cat tax.Category, hasCategory bool, productRate sql.NullFloat64) tax.Category {
+	if hasCategory {
+		return cat
+	}
+	if productRate.Valid {
+		return tax.Category{Rate: productRate.Float64}
+	}
+	return t.fallback
+}
+
+// saveOrderTaxSummary stores the per-rate totals of a taxed order.
+func saveOrderTaxSummary(tx *sql.Tx, tenantID, orderID string, summary []tax.Bucket) error {
+	for _, b := range summary {
+		if _, err := tx.Exec(
+			`INSERT INTO order_tax_summary (id, tenant_id, order_id, tax_category_code, tax_kind, rate, taxable_amount, tax_amount)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+			uuid.New().String(), tenantID, orderID, b.Code, b.Kind, b.Rate, b.Taxable, b.Tax); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func orderTaxSummary(tenantID, orderID string) []gin.H {
+	summary := []gin.H{}
+	rows, err := db.Query(
+		`SELECT tax_category_code, tax_kind, rate, taxable_amount, tax_amount FROM order_tax_summary
+		 WHERE order_id = $1 AND tenant_id = $2 ORDER BY rate DESC, tax_kind`, orderID, tenantID)
+	if err != nil {
+		return summary
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var code, kind string
+		var rate float64
+		var taxable, amount money.Amount
+		rows.Scan(&code, &kind, &rate, &taxable, &amount)
+		summary = append(summary, gin.H{"code": code, "kind": kind, "rate": rate, "taxableAmount": taxable, "taxAmount": amount})
+	}
+	return summary
+}
+
+func listTaxCategories(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	rows, err := db.Query(
+		`SELECT id, code, name, name_ar, kind, rate, is_active FROM tax_categories
+		 WHERE tenant_id = $1 ORDER BY rate DESC, code`, tenantID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	cats := []gin.H{}
+	for rows.Next() {
+		var id, code, name, nameAr, kind string
+		var rate float64
+		var active bool
+		rows.Scan(&id, &code, &name, &nameAr, &kind, &rate, &active)
+		cats = append(cats, gin.H{"id": id, "code": code, "name": name, "nameAr": nameAr, "kind": kind, "rate": rate, "isActive": active})
+	}
+	c.JSON(200, gin.H{"taxCategories": cats, "total": len(cats)})
+}
+
+func createTaxCategory(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	var req struct {
+		Code   string  `json:"code" binding:"required"`
+		Name   string  `json:"name" binding:"required"`
+		NameAr string  `json:"nameAr"`
+		Kind   string  `json:"kind"`
+		Rate   float64 `json:"rate"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Kind == "" {
+		req.Kind = tax.KindStandard
+	}
+	if req.Kind != tax.KindStandard {
+		req.Rate = 0
+	}
+	id := uuid.New().String()
+	_, err := db.Exec(
+		`INSERT INTO tax_categories (id, tenant_id, code, name, name_ar, kind, rate) VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		id, tenantID, req.Code, req.Name, req.NameAr, req.Kind, req.Rate)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(201, gin.H{"id": id, "code": req.Code, "name": req.Name, "nameAr": req.NameAr, "kind": req.Kind, "rate": req.Rate})
+}
+
+func updateLocationTaxSettings(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	id := c.Param("id")
+	var req struct {
+		PricesIncludeTax     *bool    `json:"pricesIncludeTax"`
+		TaxRounding          *string  `json:"taxRounding"`
+		TaxRate              *float64 `json:"taxRate"`
+		DefaultTaxCategoryID *string  `json:"defaultTaxCategoryId"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if req.TaxRounding != nil && *req.TaxRounding != tax.RoundingLine && *req.TaxRounding != tax.RoundingInvoice {
+		c.JSON(400, gin.H{"error": "taxRounding must be line or invoice"})
+		return
+	}
+	res, err := db.Exec(
+		`UPDATE locations SET
+			prices_include_tax = COALESCE($3, prices_include_tax),
+			tax_rounding = COALESCE($4, tax_rounding),
+			tax_rate = COALESCE($5, tax_rate),
+			default_tax_category_id = CASE WHEN $6::text IS NULL THEN default_tax_category_id ELSE NULLIF($6, '')::uuid END,
+			updated_at = NOW()
+		 WHERE id = $1 AND tenant_id = $2`,
+		id, tenantID, req.PricesIncludeTax, req.TaxRounding, req.TaxRate, req.DefaultTaxCategoryID)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(404, gin.H{"error": "Location not found"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Tax settings updated"})
+}
+
+func getTaxReport(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	from := c.DefaultQuery("from", time.Now().Format("2006-01-02"))
+	to := c.DefaultQuery("to", from)
+	rows, err := db.Query(
+		`SELECT ts.tax_category_code, ts.tax_kind, ts.rate, SUM(ts.taxable_amount), SUM(ts.tax_amount), COUNT(DISTINCT ts.order_id)
+		 FROM order_tax_summary ts
+		 JOIN orders o ON o.id = ts.order_id
+		 WHERE ts.tenant_id = $1 AND DATE(o.created_at) BETWEEN $2 AND $3 AND o.status IN ('completed', 'refunded')
+		 GROUP BY ts.tax_category_code, ts.tax_kind, ts.rate
+		 ORDER BY ts.rate DESC, ts.tax_kind`, tenantID, from, to)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	buckets := []gin.H{}
+	var taxable, taxTotal money.Amount
+	for rows.Next() {
+		var code, kind string
+		var rate float64
+		var t, a money.Amount
+		var orders int
+		rows.Scan(&code, &kind, &rate, &t, &a, &orders)
+		taxable += t
+		taxTotal += a
+		buckets = append(buckets, gin.H{"code": code, "kind": kind, "rate": rate, "taxableAmount": t, "taxAmount": a, "orders": orders})
+	}
+	c.JSON(200, gin.H{"from": from, "to": to, "taxSummary": buckets, "taxableAmount": taxable, "taxAmount": taxTotal})
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"database/sql"
+	"math"
+	"strconv"
+
+	"github.com/berhot/products/commerce/pos-engine/internal/money"
+	"github.com/gin-gonic/gin"
+)
+
+// ── Tenders & Settlement ────────────────────────────────────
+
+const (
+	paymentUnpaid        = "unpaid"
+	paymentPartiallyPaid = "partially_paid"
+	paymentPaid          = "paid"
+)
+
+// tenderMethods are the payment methods a cashier can settle an order with.
+// Cash may be over-tendered and gives change; every other method must not
+// exceed the balance due. Gift cards and store credit also must not exceed
+// their own balance.
+var tenderMethods = map[string]bool{
+	"cash":           true,
+	"card":           true,
+	"mobile_pay":     true,
+	"loyalty_points": true,
+	"online":         true,
+	"gift_card":      true,
+	"store_credit":   true,
+}
+
+// loyaltyPointsPerUnit is how many loyalty points buy one unit of currency.
+var loyaltyPointsPerUnit = func() float64 {
+	v, err := strconv.ParseFloat(getEnv("LOYALTY_POINTS_PER_UNIT", "10"), 64)
+	if err != nil || v <= 0 {
+		return 10
+	}
+	return v
+}()
+
+func migrateTenders() {
+	db.Exec(`ALTER TABLE orders ADD COLUMN IF NOT EXISTS paid_amount DECIMAL(12,2) NOT NULL DEFAULT 0`)
+	db.Exec(`ALTER TABLE orders ADD COLUMN IF NOT EXISTS payment_status VARCHAR(20) NOT NULL DEFAULT 'unpaid'`)
+	db.Exec(`ALTER TABLE payments ADD COLUMN IF NOT EXISTS tendered_amount DECIMAL(12,2)`)
+	db.Exec(`ALTER TABLE payments ADD COLUMN IF NOT EXISTS change_amount DECIMAL(12,2) NOT NULL DEFAULT 0`)
+	db.Exec(`ALTER TABLE payments ADD COLUMN IF NOT EXISTS loyalty_points_used INTEGER NOT NULL DEFAULT 0`)
+	db.Exec(`ALTER TABLE payments ADD COLUMN IF NOT EXISTS idempotency_key VARCHAR(255)`)
+	db.Exec(`ALTER TABLE payments ADD COLUMN IF NOT EXISTS received_by UUID`)
+	db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_payments_idempotency ON payments(tenant_id, idempotency_key) WHERE idempotency_key IS NOT NULL`)
+}
+
+func paymentStatusFor(total, paid money.Amount) string {
+	switch {
+	case !paid.IsPositive():
+		return paymentUnpaid
+	case paid >= total:
+		return paymentPaid
+	default:
+		return paymentPartiallyPaid
+	}
+}
+
+// loyaltyPointsFor converts a currency amount to the points needed to pay it.
+func loyaltyPointsFor(amount money.Amount) int {
+	return int(math.Ceil(float64(amount.Minor()) * loyaltyPointsPerUnit / 100))
+}
+
+// redeemLoyaltyPoints deducts points from the order's customer inside tx.
+func redeemLoyaltyPoints(tx *sql.Tx, tenantID string, customerID sql.NullString, points int) error {
+	if !customerID.Valid {
+		return errLoyaltyNoCustomer
+	}
+	res, err := tx.Exec(
+		`UPDATE customers SET loyalty_points = loyalty_points - $1
+		 WHERE id = $2 AND tenant_id = $3 AND loyalty_points >= $1`,
+		points, customerID.String, tenantID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errLoyaltyInsufficient
+	}
+	return nil
+}
+
+type paymentError string
+
+func (e paymentError) Error() string { return string(e) }
+
+const (
+	errLoyaltyNoCustomer   = paymentError("Loyalty points need an order with a customer")
+	errLoyaltyInsufficient = paymentError("Customer does not have enough loyalty points")
+)
+
+func getOrderBalance(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	id := c.Param("id")
+
+	var status, paymentStatus, currency string
+	var total, paid, refunded money.Amount
+	err := db.QueryRow(
+		`SELECT status, payment_status, currency, total, paid_amount, refunded_amount
+		 FROM orders WHERE id = $1 AND tenant_id = $2`, id, tenantID,
+	).Scan(&status, &paymentStatus, &currency, &total, &paid, &refunded)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Order not found"})
+		return
+	}
+
+	rows, err := db.Query(
+		`SELECT method, COUNT(*), SUM(amount), SUM(COALESCE(tendered_amount, amount)), SUM(change_amount)
+		 FROM payments WHERE order_id = $1 AND tenant_id = $2 AND status IN ('completed', 'refunded')
+		 GROUP BY method ORDER BY method`, id, tenantID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	tenders := []gin.H{}
+	for rows.Next() {
+		var method string
+		var count int
+		var amount, tendered, change money.Amount
+		rows.Scan(&method, &count, &amount, &tendered, &change)
+		tenders = append(tenders, gin.H{"method": method, "count": count, "amount": amount, "tendered": tendered, "change": change})
+	}
+
+	c.JSON(200, gin.H{
+		"orderId": id, "status": status, "paymentStatus": paymentStatus, "currency": currency,
+		"total": total, "paidAmount": paid, "balanceDue": money.Max(total-paid, 0),
+		"refundedAmount": refunded, "tenders": tenders,
+	})
+}
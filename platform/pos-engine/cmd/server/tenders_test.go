@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/berhot/products/commerce/pos-engine/internal/money"
+)
+
+func TestPaymentStatusFor(t *testing.T) {
+	tests := []struct {
+		total, paid money.Amount
+		want        string
+	}{
+		{2300, 0, paymentUnpaid},
+		{2300, 1000, paymentPartiallyPaid},
+		{2300, 2300, paymentPaid},
+		// Over-payment only happens through cash, which gives change, but
+		// an order paid past its total after a line void is still paid.
+		{2000, 2300, paymentPaid},
+		{0, 0, paymentUnpaid},
+	}
+	for _, tt := range tests {
+		if got := paymentStatusFor(tt.total, tt.paid); got != tt.want {
+			t.Errorf("paymentStatusFor(%s, %s) = %s, want %s", tt.total, tt.paid, got, tt.want)
+		}
+	}
+}
+
+func TestLoyaltyPointsFor(t *testing.T) {
+	defer func(v float64) { loyaltyPointsPerUnit = v }(loyaltyPointsPerUnit)
+	loyaltyPointsPerUnit = 10
+	tests := []struct {
+		amount money.Amount
+		want   int
+	}{
+		{1000, 100},
+		// Part of a point is never given away.
+		{1001, 101},
+		{5, 1},
+		{0, 0},
+	}
+	for _, tt := range tests {
+		if got := loyaltyPointsFor(tt.amount); got != tt.want {
+			t.Errorf("loyaltyPointsFor(%s) = %d, want %d", tt.amount, got, tt.want)
+		}
+	}
+}
+
+func TestCanTransitionOrder(t *testing.T) {
+	tests := []struct {
+		from, to string
+		want     bool
+	}{
+		{orderPending, orderCompleted, true},
+		{orderPreparing, orderCompleted, false},
+		{orderReady, orderCompleted, true},
+		{orderReady, orderVoided, false},
+		{orderCompleted, orderRefunded, true},
+		{orderCompleted, orderCancelled, false},
+		{orderCancelled, orderPending, false},
+		{"unknown", orderPending, false},
+	}
+	for _, tt := range tests {
+		if got := canTransitionOrder(tt.from, tt.to); got != tt.want {
+			t.Errorf("canTransitionOrder(%s, %s) = %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
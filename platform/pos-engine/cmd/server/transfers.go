@@ -0,0 +1,515 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ── Stock Transfers ─────────────────────────────────────────
+
+// Transfer statuses. Stock leaves the source when a transfer is dispatched
+// and arrives at the destination when it is received; in between it is on
+// the road and belongs to neither.
+const (
+	transferDraft     = "draft"
+	transferInTransit = "in_transit"
+	transferReceived  = "received"
+	transferCancelled = "cancelled"
+)
+
+func migrateTransfers() {
+	db.Exec(`CREATE TABLE IF NOT EXISTS stock_transfers (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		tenant_id UUID NOT NULL,
+		transfer_number VARCHAR(50) NOT NULL,
+		from_location_id UUID NOT NULL REFERENCES locations(id),
+		to_location_id UUID NOT NULL REFERENCES locations(id),
+		status VARCHAR(20) NOT NULL DEFAULT 'draft'
+			CHECK (status IN ('draft', 'in_transit', 'received', 'cancelled')),
+		notes TEXT NOT NULL DEFAULT '',
+		created_by UUID,
+		dispatched_by UUID,
+		dispatched_at TIMESTAMPTZ,
+		received_by UUID,
+		received_at TIMESTAMPTZ,
+		cancelled_at TIMESTAMPTZ,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		UNIQUE(tenant_id, transfer_number),
+		CHECK (from_location_id <> to_location_id)
+	)`)
+	db.Exec(`CREATE TABLE IF NOT EXISTS stock_transfer_lines (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		tenant_id UUID NOT NULL,
+		transfer_id UUID NOT NULL REFERENCES stock_transfers(id) ON DELETE CASCADE,
+		product_id UUID REFERENCES products(id),
+		variant_id UUID REFERENCES product_variants(id),
+		ingredient_id UUID REFERENCES ingredients(id),
+		description VARCHAR(255) NOT NULL,
+		quantity DECIMAL(12,3) NOT NULL CHECK (quantity > 0),
+		received_quantity DECIMAL(12,3),
+		CHECK ((product_id IS NULL) <> (ingredient_id IS NULL))
+	)`)
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_stock_transfers_tenant ON stock_transfers(tenant_id, created_at DESC)")
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_stock_transfer_lines_transfer ON stock_transfer_lines(transfer_id)")
+}
+
+// stockItemRequest is how request bodies name a product, variant or ingredient.
+type stockItemRequest struct {
+	ProductID    string `json:"productId"`
+	VariantID    string `json:"variantId"`
+	IngredientID string `json:"ingredientId"`
+}
+
+func (r stockItemRequest) item() (stockItem, error) {
+	if (r.ProductID == "") == (r.IngredientID == "") {
+		return stockItem{}, fmt.Errorf("give either productId or ingredientId")
+	}
+	item := stockItem{productID: r.ProductID}
+	if r.VariantID != "" {
+		item.variantID = &r.VariantID
+	}
+	if r.IngredientID != "" {
+		item.ingredientID = &r.IngredientID
+	}
+	return item, nil
+}
+
+// onHandAt is what one location holds of an item, locking its inventory row
+// when called inside a transaction that goes on to move it.
+func onHandAt(tx *sql.Tx, tenantID, locationID string, item stockItem) float64 {
+	var qty float64
+	switch {
+	case item.ingredientID != nil:
+		tx.QueryRow("SELECT quantity FROM inventory WHERE tenant_id = $1 AND location_id = $2 AND ingredient_id = $3 FOR UPDATE",
+			tenantID, locationID, *item.ingredientID).Scan(&qty)
+	case item.variantID != nil:
+		tx.QueryRow("SELECT quantity FROM inventory WHERE tenant_id = $1 AND location_id = $2 AND variant_id = $3 FOR UPDATE",
+			tenantID, locationID, *item.variantID).Scan(&qty)
+	default:
+		tx.QueryRow("SELECT quantity FROM inventory WHERE tenant_id = $1 AND location_id = $2 AND product_id = $3 AND variant_id IS NULL FOR UPDATE",
+			tenantID, locationID, item.productID).Scan(&qty)
+	}
+	return qty
+}
+
+// transferLine is a stock_transfer_lines row as the state changes need it.
+type transferLine struct {
+	id, description string
+	item            stockItem
+	quantity        float64
+}
+
+func loadTransferLines(tx *sql.Tx, tenantID, transferID string) ([]transferLine, error) {
+	rows, err := tx.Query(
+		`SELECT id, COALESCE(product_id::text, ''), variant_id::text, ingredient_id::text, description, quantity
+		 FROM stock_transfer_lines WHERE transfer_id = $1 AND tenant_id = $2 ORDER BY description`, transferID, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var lines []transferLine
+	for rows.Next() {
+		var l transferLine
+		var variantID, ingredientID sql.NullString
+		rows.Scan(&l.id, &l.item.productID, &variantID, &ingredientID, &l.description, &l.quantity)
+		if variantID.Valid {
+			l.item.variantID = &variantID.String
+		}
+		if ingredientID.Valid {
+			l.item.ingredientID = &ingredientID.String
+		}
+		lines = append(lines, l)
+	}
+	return lines, nil
+}
+
+func createTransfer(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	var req struct {
+		FromLocationID string `json:"fromLocationId" binding:"required"`
+		ToLocationID   string `json:"toLocationId" binding:"required"`
+		Notes          string `json:"notes"`
+		Lines          []struct {
+			stockItemRequest
+			Quantity float64 `json:"quantity" binding:"required"`
+		} `json:"lines" binding:"required,min=1"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if req.FromLocationID == req.ToLocationID {
+		c.JSON(400, gin.H{"error": "Source and destination must be different locations"})
+		return
+	}
+	var found int
+	db.QueryRow("SELECT COUNT(*) FROM locations WHERE tenant_id = $1 AND id::text IN ($2, $3)",
+		tenantID, req.FromLocationID, req.ToLocationID).Scan(&found)
+	if found != 2 {
+		c.JSON(404, gin.H{"error": "Location not found"})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Transaction failed"})
+		return
+	}
+	defer tx.Rollback()
+	id := uuid.New().String()
+	number := fmt.Sprintf("TR-%s-%s", time.Now().Format("20060102"), uuid.New().String()[:4])
+	if _, err := tx.Exec(
+		`INSERT INTO stock_transfers (id, tenant_id, transfer_number, from_location_id, to_location_id, notes, created_by)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		id, tenantID, number, req.FromLocationID, req.ToLocationID, req.Notes, actorFromContext(c).idOrNil()); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	for i, l := range req.Lines {
+		item, err := l.item()
+		if err == nil && l.Quantity <= 0 {
+			err = fmt.Errorf("quantity must be positive")
+		}
+		if err != nil {
+			c.JSON(400, gin.H{"error": fmt.Sprintf("Line %d: %s", i+1, err.Error())})
+			return
+		}
+		name, _, err := resolveStockItem(tx, tenantID, item)
+		if err != nil {
+			c.JSON(400, gin.H{"error": fmt.Sprintf("Line %d: %s", i+1, err.Error())})
+			return
+		}
+		var productID *string
+		if item.ingredientID == nil {
+			productID = &item.productID
+		}
+		if _, err := tx.Exec(
+			`INSERT INTO stock_transfer_lines (id, tenant_id, transfer_id, product_id, variant_id, ingredient_id, description, quantity)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+			uuid.New().String(), tenantID, id, productID, item.variantID, item.ingredientID, name, l.Quantity); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	transfer, _ := loadTransfer(db, tenantID, id)
+	c.JSON(201, transfer)
+}
+
+func loadTransfer(q queryer, tenantID, id string) (gin.H, error) {
+	var number, from, fromName, to, toName, status, notes string
+	var dispatchedAt, receivedAt *time.Time
+	var createdAt time.Time
+	err := q.QueryRow(
+		`SELECT t.transfer_number, t.from_location_id, lf.name, t.to_location_id, lt.name, t.status, t.notes,
+		        t.dispatched_at, t.received_at, t.created_at
+		 FROM stock_transfers t
+		 JOIN locations lf ON lf.id = t.from_location_id
+		 JOIN locations lt ON lt.id = t.to_location_id
+		 WHERE t.id = $1 AND t.tenant_id = $2`, id, tenantID,
+	).Scan(&number, &from, &fromName, &to, &toName, &status, &notes, &dispatchedAt, &receivedAt, &createdAt)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := q.Query(
+		`SELECT id, COALESCE(product_id::text, ''), COALESCE(variant_id::text, ''), COALESCE(ingredient_id::text, ''),
+		        description, quantity, received_quantity
+		 FROM stock_transfer_lines WHERE transfer_id = $1 AND tenant_id = $2 ORDER BY description`, id, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	lines := []gin.H{}
+	for rows.Next() {
+		var lid, pid, vid, iid, desc string
+		var qty float64
+		var received *float64
+		rows.Scan(&lid, &pid, &vid, &iid, &desc, &qty, &received)
+		line := gin.H{
+			"id": lid, "productId": pid, "variantId": vid, "ingredientId": iid, "description": desc,
+			"quantity": qty, "receivedQuantity": received,
+		}
+		if received != nil {
+			line["shortfall"] = qty - *received
+		}
+		lines = append(lines, line)
+	}
+	return gin.H{
+		"id": id, "transferNumber": number, "status": status, "notes": notes,
+		"fromLocationId": from, "fromLocationName": fromName, "toLocationId": to, "toLocationName": toName,
+		"dispatchedAt": dispatchedAt, "receivedAt": receivedAt, "createdAt": createdAt, "lines": lines,
+	}, nil
+}
+
+func listTransfers(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	query := `SELECT t.id, t.transfer_number, t.status, t.from_location_id, lf.name, t.to_location_id, lt.name,
+	                 (SELECT COUNT(*) FROM stock_transfer_lines WHERE transfer_id = t.id), t.created_at
+	          FROM stock_transfers t
+	          JOIN locations lf ON lf.id = t.from_location_id
+	          JOIN locations lt ON lt.id = t.to_location_id
+	          WHERE t.tenant_id = $1`
+	args := []interface{}{tenantID}
+	if status := c.Query("status"); status != "" {
+		args = append(args, status)
+		query += fmt.Sprintf(" AND t.status = $%d", len(args))
+	}
+	if loc := c.Query("locationId"); loc != "" {
+		args = append(args, loc)
+		query += fmt.Sprintf(" AND (t.from_location_id::text = $%d OR t.to_location_id::text = $%d)", len(args), len(args))
+	}
+	query += " ORDER BY t.created_at DESC LIMIT 50"
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	transfers := []gin.H{}
+	for rows.Next() {
+		var id, number, status, from, fromName, to, toName string
+		var lineCount int
+		var createdAt time.Time
+		rows.Scan(&id, &number, &status, &from, &fromName, &to, &toName, &lineCount, &createdAt)
+		transfers = append(transfers, gin.H{
+			"id": id, "transferNumber": number, "status": status,
+			"fromLocationId": from, "fromLocationName": fromName, "toLocationId": to, "toLocationName": toName,
+			"lineCount": lineCount, "createdAt": createdAt,
+		})
+	}
+	c.JSON(200, gin.H{"transfers": transfers, "total": len(transfers)})
+}
+
+func getTransfer(c *gin.Context) {
+	transfer, err := loadTransfer(db, c.GetString("tenantId"), c.Param("id"))
+	if err == sql.ErrNoRows {
+		c.JSON(404, gin.H{"error": "Transfer not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, transfer)
+}
+
+// lockTransfer loads a transfer's locations and status for update.
+func lockTransfer(tx *sql.Tx, tenantID, id string) (from, to, status string, err error) {
+	err = tx.QueryRow("SELECT from_location_id, to_location_id, status FROM stock_transfers WHERE id = $1 AND tenant_id = $2 FOR UPDATE",
+		id, tenantID).Scan(&from, &to, &status)
+	return
+}
+
+// dispatchTransfer takes the stock out of the source location. A location
+// cannot send what it does not have, whatever its stock policy.
+func dispatchTransfer(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	id := c.Param("id")
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Transaction failed"})
+		return
+	}
+	defer tx.Rollback()
+	from, _, status, err := lockTransfer(tx, tenantID, id)
+	if err == sql.ErrNoRows {
+		c.JSON(404, gin.H{"error": "Transfer not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if status != transferDraft {
+		c.JSON(409, gin.H{"error": fmt.Sprintf("Cannot dispatch a %s transfer", status)})
+		return
+	}
+	lines, err := loadTransferLines(tx, tenantID, id)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	shortages := []gin.H{}
+	for _, l := range lines {
+		if onHand := onHandAt(tx, tenantID, from, l.item); onHand < l.quantity {
+			shortages = append(shortages, gin.H{"lineId": l.id, "description": l.description, "requested": l.quantity, "available": onHand})
+		}
+	}
+	if len(shortages) > 0 {
+		c.JSON(409, gin.H{"error": "Insufficient stock", "shortages": shortages})
+		return
+	}
+	by := actorFromContext(c)
+	for _, l := range lines {
+		if _, err := recordStockMovement(tx, tenantID, stockMovement{
+			productID: l.item.productID, variantID: l.item.variantID, ingredientID: l.item.ingredientID,
+			locationID: from, kind: stockTransferOut, quantity: -l.quantity,
+			reason: "Transfer dispatched", referenceType: "stock_transfer", referenceID: id, by: by,
+		}); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if _, err := tx.Exec(
+		"UPDATE stock_transfers SET status = $1, dispatched_by = $2, dispatched_at = NOW(), updated_at = NOW() WHERE id = $3",
+		transferInTransit, by.idOrNil(), id); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Transfer dispatched", "id": id, "status": transferInTransit})
+}
+
+// receiveTransfer books the stock into the destination. Lines may be received
+// short; whatever did not arrive has already left the source and is reported
+// as the line's shortfall.
+func receiveTransfer(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	id := c.Param("id")
+	var req struct {
+		Lines []struct {
+			LineID           string  `json:"lineId" binding:"required"`
+			ReceivedQuantity float64 `json:"receivedQuantity"`
+		} `json:"lines"`
+	}
+	c.ShouldBindJSON(&req)
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Transaction failed"})
+		return
+	}
+	defer tx.Rollback()
+	_, to, status, err := lockTransfer(tx, tenantID, id)
+	if err == sql.ErrNoRows {
+		c.JSON(404, gin.H{"error": "Transfer not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if status != transferInTransit {
+		c.JSON(409, gin.H{"error": fmt.Sprintf("Cannot receive a %s transfer", status)})
+		return
+	}
+	lines, err := loadTransferLines(tx, tenantID, id)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	received := map[string]float64{}
+	for _, l := range lines {
+		received[l.id] = l.quantity
+	}
+	for _, rl := range req.Lines {
+		shipped, ok := received[rl.LineID]
+		if !ok {
+			c.JSON(400, gin.H{"error": fmt.Sprintf("Line %s is not on this transfer", rl.LineID)})
+			return
+		}
+		if rl.ReceivedQuantity < 0 || rl.ReceivedQuantity > shipped {
+			c.JSON(400, gin.H{"error": fmt.Sprintf("Received quantity for line %s must be between 0 and %g", rl.LineID, shipped)})
+			return
+		}
+		received[rl.LineID] = rl.ReceivedQuantity
+	}
+
+	by := actorFromContext(c)
+	shortfalls := []gin.H{}
+	for _, l := range lines {
+		qty := received[l.id]
+		if qty > 0 {
+			if _, err := recordStockMovement(tx, tenantID, stockMovement{
+				productID: l.item.productID, variantID: l.item.variantID, ingredientID: l.item.ingredientID,
+				locationID: to, kind: stockTransferIn, quantity: qty,
+				reason: "Transfer received", referenceType: "stock_transfer", referenceID: id, by: by,
+			}); err != nil {
+				c.JSON(500, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		if _, err := tx.Exec("UPDATE stock_transfer_lines SET received_quantity = $1 WHERE id = $2", qty, l.id); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		if qty < l.quantity {
+			shortfalls = append(shortfalls, gin.H{"lineId": l.id, "description": l.description, "shipped": l.quantity, "received": qty})
+		}
+	}
+	if _, err := tx.Exec(
+		"UPDATE stock_transfers SET status = $1, received_by = $2, received_at = NOW(), updated_at = NOW() WHERE id = $3",
+		transferReceived, by.idOrNil(), id); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Transfer received", "id": id, "status": transferReceived, "shortfalls": shortfalls})
+}
+
+// cancelTransfer drops a draft, or calls back one already on the road by
+// returning its stock to the source.
+func cancelTransfer(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	id := c.Param("id")
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Transaction failed"})
+		return
+	}
+	defer tx.Rollback()
+	from, _, status, err := lockTransfer(tx, tenantID, id)
+	if err == sql.ErrNoRows {
+		c.JSON(404, gin.H{"error": "Transfer not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if status != transferDraft && status != transferInTransit {
+		c.JSON(409, gin.H{"error": fmt.Sprintf("Cannot cancel a %s transfer", status)})
+		return
+	}
+	if status == transferInTransit {
+		lines, err := loadTransferLines(tx, tenantID, id)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		by := actorFromContext(c)
+		for _, l := range lines {
+			if _, err := recordStockMovement(tx, tenantID, stockMovement{
+				productID: l.item.productID, variantID: l.item.variantID, ingredientID: l.item.ingredientID,
+				locationID: from, kind: stockTransferIn, quantity: l.quantity,
+				reason: "Transfer cancelled", referenceType: "stock_transfer", referenceID: id, by: by,
+			}); err != nil {
+				c.JSON(500, gin.H{"error": err.Error()})
+				return
+			}
+		}
+	}
+	if _, err := tx.Exec("UPDATE stock_transfers SET status = $1, cancelled_at = NOW(), updated_at = NOW() WHERE id = $2",
+		transferCancelled, id); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Transfer cancelled", "id": id, "status": transferCancelled})
+}
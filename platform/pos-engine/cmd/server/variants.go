@@ -0,0 +1,327 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/berhot/products/commerce/pos-engine/internal/money"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ── Product Variants ────────────────────────────────────────
+
+func migrateVariants() {
+	db.Exec(`ALTER TABLE product_variants ADD COLUMN IF NOT EXISTS name_en VARCHAR(255)`)
+	db.Exec(`ALTER TABLE product_variants ADD COLUMN IF NOT EXISTS name_ar VARCHAR(255)`)
+	db.Exec(`ALTER TABLE product_variants ADD COLUMN IF NOT EXISTS barcode VARCHAR(50)`)
+	db.Exec(`ALTER TABLE product_variants ADD COLUMN IF NOT EXISTS options JSONB NOT NULL DEFAULT '{}'`)
+	db.Exec(`ALTER TABLE product_variants ADD COLUMN IF NOT EXISTS sort_order INTEGER NOT NULL DEFAULT 0`)
+	db.Exec(`ALTER TABLE product_variants ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()`)
+	db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_product_variants_sku ON product_variants(tenant_id, sku) WHERE sku IS NOT NULL AND is_active`)
+	db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_product_variants_barcode ON product_variants(tenant_id, barcode) WHERE barcode IS NOT NULL AND is_active`)
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_product_variants_product ON product_variants(product_id)")
+
+	// Stock is kept per variant, so a product can have one inventory row per
+	// variant and location plus its own row when it has no variants.
+	db.Exec(`ALTER TABLE inventory ADD COLUMN IF NOT EXISTS variant_id UUID REFERENCES product_variants(id)`)
+	db.Exec(`ALTER TABLE inventory DROP CONSTRAINT IF EXISTS inventory_tenant_id_product_id_location_id_key`)
+	db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_inventory_product_location ON inventory(tenant_id, product_id, location_id) WHERE variant_id IS NULL`)
+	db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_inventory_variant_location ON inventory(tenant_id, variant_id, location_id) WHERE variant_id IS NOT NULL`)
+}
+
+// orderVariant is the variant chosen for an order line.
+type orderVariant struct {
+	id, name        string
+	priceAdjustment money.Amount
+}
+
+// variantForOrder resolves the variant of an order line. Products with active
+// variants must name one; products without variants must not.
+func variantForOrder(tenantID, productID, variantID string) (*orderVariant, error) {
+	if variantID == "" {
+		var has bool
+		db.QueryRow(`SELECT EXISTS(SELECT 1 FROM product_variants WHERE product_id = $1 AND tenant_id = $2 AND is_active)`,
+			productID, tenantID).Scan(&has)
+		if has {
+			return nil, fmt.Errorf("Product %s needs a variant", productID)
+		}
+		return nil, nil
+	}
+	v := orderVariant{id: variantID}
+	err := db.QueryRow(
+		`SELECT name, COALESCE(price_adjustment, 0) FROM product_variants
+		 WHERE id = $1 AND product_id = $2 AND tenant_id = $3 AND is_active`, variantID, productID, tenantID,
+	).Scan(&v.name, &v.priceAdjustment)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("Variant %s not found for product %s", variantID, productID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// productVariants lists a product's variants with their selling price.
+func productVariants(tenantID, productID string, price money.Amount, activeOnly bool) ([]gin.H, error) {
+	query := `SELECT id, name, COALESCE(name_en, ''), COALESCE(name_ar, ''), COALESCE(sku, ''), COALESCE(barcode, ''),
+	                 COALESCE(price_adjustment, 0), options, sort_order, is_active
+	          FROM product_variants WHERE product_id = $1 AND tenant_id = $2`
+	if activeOnly {
+		query += " AND is_active"
+	}
+	rows, err := db.Query(query+" ORDER BY sort_order, name", productID, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	variants := []gin.H{}
+	for rows.Next() {
+		var id, name, nameEn, nameAr, sku, barcode, opts string
+		var adj money.Amount
+		var sortOrder int
+		var active bool
+		if err := rows.Scan(&id, &name, &nameEn, &nameAr, &sku, &barcode, &adj, &opts, &sortOrder, &active); err != nil {
+			return nil, err
+		}
+		var options map[string]string
+		json.Unmarshal([]byte(opts), &options)
+		variants = append(variants, gin.H{
+			"id": id, "productId": productID, "name": name, "nameEn": nameEn, "nameAr": nameAr,
+			"sku": sku, "barcode": barcode, "priceAdjustment": adj, "price": price + adj,
+			"options": options, "sortOrder": sortOrder, "isActive": active,
+		})
+	}
+	return variants, rows.Err()
+}
+
+func listVariants(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	productID := c.Param("id")
+	var price money.Amount
+	if err := db.QueryRow("SELECT price FROM products WHERE id = $1 AND tenant_id = $2", productID, tenantID).Scan(&price); err != nil {
+		c.JSON(404, gin.H{"error": "Product not found"})
+		return
+	}
+	variants, err := productVariants(tenantID, productID, price, c.Query("includeInactive") != "true")
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"variants": variants, "total": len(variants)})
+}
+
+type variantRequest struct {
+	Name            string            `json:"name"`
+	NameEn          string            `json:"nameEn"`
+	NameAr          string            `json:"nameAr"`
+	SKU             *string           `json:"sku"`
+	Barcode         *string           `json:"barcode"`
+	PriceAdjustment *money.Amount     `json:"priceAdjustment"`
+	Options         map[string]string `json:"options"`
+	SortOrder       *int              `json:"sortOrder"`
+	IsActive        *bool             `json:"isActive"`
+}
+
+// variantCodeTaken reports whether another active variant of the tenant
+// already uses sku or barcode.
+func variantCodeTaken(tenantID, variantID, column string, code *string) bool {
+	if code == nil || *code == "" {
+		return false
+	}
+	var taken bool
+	db.QueryRow(
+		`SELECT EXISTS(SELECT 1 FROM product_variants WHERE tenant_id = $1 AND `+column+` = $2 AND is_active AND id::text <> $3)`,
+		tenantID, *code, variantID).Scan(&taken)
+	return taken
+}
+
+func createVariant(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	productID := c.Param("id")
+	var req variantRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Name == "" {
+		c.JSON(400, gin.H{"error": "Variant name is required"})
+		return
+	}
+	var price money.Amount
+	if err := db.QueryRow("SELECT price FROM products WHERE id = $1 AND tenant_id = $2", productID, tenantID).Scan(&price); err != nil {
+		c.JSON(404, gin.H{"error": "Product not found"})
+		return
+	}
+	if variantCodeTaken(tenantID, "", "sku", req.SKU) {
+		c.JSON(409, gin.H{"error": fmt.Sprintf("SKU %s is already used by another variant", *req.SKU)})
+		return
+	}
+	if variantCodeTaken(tenantID, "", "barcode", req.Barcode) {
+		c.JSON(409, gin.H{"error": fmt.Sprintf("Barcode %s is already used by another variant", *req.Barcode)})
+		return
+	}
+	var adj money.Amount
+	if req.PriceAdjustment != nil {
+		adj = *req.PriceAdjustment
+	}
+	if price+adj < 0 {
+		c.JSON(400, gin.H{"error": "Variant price cannot be negative"})
+		return
+	}
+	sortOrder := 0
+	if req.SortOrder != nil {
+		sortOrder = *req.SortOrder
+	}
+	options, _ := json.Marshal(req.Options)
+	if req.Options == nil {
+		options = []byte("{}")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Transaction failed"})
+		return
+	}
+	defer tx.Rollback()
+	id := uuid.New().String()
+	if _, err := tx.Exec(
+		`INSERT INTO product_variants (id, tenant_id, product_id, name, name_en, name_ar, sku, barcode, price_adjustment, options, sort_order)
+		 VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), NULLIF($8, ''), $9, $10, $11)`,
+		id, tenantID, productID, req.Name, req.NameEn, req.NameAr, req.SKU, req.Barcode, adj, string(options), sortOrder); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	// A simple product that gains variants becomes a variant product.
+	if _, err := tx.Exec(
+		`UPDATE products SET product_type = 'variant', updated_at = NOW()
+		 WHERE id = $1 AND tenant_id = $2 AND product_type = 'simple'`, productID, tenantID); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(201, gin.H{
+		"id": id, "productId": productID, "name": req.Name, "nameEn": req.NameEn, "nameAr": req.NameAr,
+		"sku": req.SKU, "barcode": req.Barcode, "priceAdjustment": adj, "price": price + adj,
+		"options": req.Options, "sortOrder": sortOrder, "isActive": true,
+	})
+}
+
+func updateVariant(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	productID := c.Param("id")
+	variantID := c.Param("variantId")
+	var req variantRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if variantCodeTaken(tenantID, variantID, "sku", req.SKU) {
+		c.JSON(409, gin.H{"error": fmt.Sprintf("SKU %s is already used by another variant", *req.SKU)})
+		return
+	}
+	if variantCodeTaken(tenantID, variantID, "barcode", req.Barcode) {
+		c.JSON(409, gin.H{"error": fmt.Sprintf("Barcode %s is already used by another variant", *req.Barcode)})
+		return
+	}
+	if req.PriceAdjustment != nil {
+		var price money.Amount
+		db.QueryRow("SELECT price FROM products WHERE id = $1 AND tenant_id = $2", productID, tenantID).Scan(&price)
+		if price+*req.PriceAdjustment < 0 {
+			c.JSON(400, gin.H{"error": "Variant price cannot be negative"})
+			return
+		}
+	}
+	var options *string
+	if req.Options != nil {
+		b, _ := json.Marshal(req.Options)
+		s := string(b)
+		options = &s
+	}
+	res, err := db.Exec(
+		`UPDATE product_variants SET
+			name = COALESCE(NULLIF($1, ''), name),
+			name_en = COALESCE(NULLIF($2, ''), name_en),
+			name_ar = COALESCE(NULLIF($3, ''), name_ar),
+			sku = CASE WHEN $4::text IS NULL THEN sku ELSE NULLIF($4, '') END,
+			barcode = CASE WHEN $5::text IS NULL THEN barcode ELSE NULLIF($5, '') END,
+			price_adjustment = COALESCE($6, price_adjustment),
+			options = COALESCE($7::jsonb, options),
+			sort_order = COALESCE($8, sort_order),
+			is_active = COALESCE($9, is_active),
+			updated_at = NOW()
+		 WHERE id = $10 AND product_id = $11 AND tenant_id = $12`,
+		req.Name, req.NameEn, req.NameAr, req.SKU, req.Barcode, req.PriceAdjustment, options, req.SortOrder, req.IsActive,
+		variantID, productID, tenantID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(404, gin.H{"error": "Variant not found"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Variant updated"})
+}
+
+// deleteVariant deactivates a variant. Past orders and stock rows still point
+// at it, so the row is kept.
+func deleteVariant(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	res, err := db.Exec(
+		`UPDATE product_variants SET is_active = false, updated_at = NOW()
+		 WHERE id = $1 AND product_id = $2 AND tenant_id = $3`, c.Param("variantId"), c.Param("id"), tenantID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(404, gin.H{"error": "Variant not found"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Variant deactivated"})
+}
+
+// lookupProduct finds what a scanned barcode or typed SKU sells: a variant
+// first, then a product without variants.
+func lookupProduct(c *gin.Context) {
+	tenantID := c.GetString("tenantId")
+	code := c.Query("code")
+	if code == "" {
+		c.JSON(400, gin.H{"error": "code is required"})
+		return
+	}
+	var productID, name, currency string
+	var variantID, variantName sql.NullString
+	var price, adj money.Amount
+	err := db.QueryRow(
+		`SELECT p.id, p.name, p.price, p.currency, v.id, v.name, COALESCE(v.price_adjustment, 0)
+		 FROM product_variants v
+		 JOIN products p ON p.id = v.product_id
+		 WHERE v.tenant_id = $1 AND v.is_active AND p.is_active AND (v.barcode = $2 OR v.sku = $2)
+		 LIMIT 1`, tenantID, code,
+	).Scan(&productID, &name, &price, &currency, &variantID, &variantName, &adj)
+	if err == sql.ErrNoRows {
+		err = db.QueryRow(
+			`SELECT id, name, price, currency FROM products
+			 WHERE tenant_id = $1 AND is_active AND (barcode = $2 OR sku = $2)
+			 ORDER BY created_at LIMIT 1`, tenantID, code,
+		).Scan(&productID, &name, &price, &currency)
+	}
+	if err == sql.ErrNoRows {
+		c.JSON(404, gin.H{"error": "No product matches " + code})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{
+		"productId": productID, "name": name, "variantId": variantID.String, "variantName": variantName.String,
+		"price": price + adj, "currency": currency,
+	})
+}
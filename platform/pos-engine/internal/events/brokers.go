@@ -0,0 +1,119 @@
+package events
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// MemoryBroker keeps published messages in memory, for local runs and for
+// inspecting what the service emitted.
+type MemoryBroker struct {
+	mu       sync.Mutex
+	messages []Message
+	limit    int
+}
+
+// NewMemoryBroker keeps up to limit messages, dropping the oldest.
+func NewMemoryBroker(limit int) *MemoryBroker {
+	return &MemoryBroker{limit: limit}
+}
+
+func (b *MemoryBroker) Publish(_ context.Context, msg Message) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.messages = append(b.messages, msg)
+	if b.limit > 0 && len(b.messages) > b.limit {
+		b.messages = b.messages[len(b.messages)-b.limit:]
+	}
+	return nil
+}
+
+// Messages returns what has been published, oldest first.
+func (b *MemoryBroker) Messages() []Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]Message(nil), b.messages...)
+}
+
+func (b *MemoryBroker) Close() error { return nil }
+
+// PostgresBroker appends messages to an event_bus table and NOTIFYs the
+// topic, so other local services can consume without running Kafka.
+type PostgresBroker struct {
+	db *sql.DB
+}
+
+// NewPostgresBroker creates the event_bus table if it is missing.
+func NewPostgresBroker(db *sql.DB) (*PostgresBroker, error) {
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS event_bus (
+		id BIGSERIAL PRIMARY KEY,
+		topic VARCHAR(255) NOT NULL,
+		key VARCHAR(255) NOT NULL DEFAULT '',
+		value JSONB NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`); err != nil {
+		return nil, err
+	}
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_event_bus_topic ON event_bus(topic, id)")
+	return &PostgresBroker{db: db}, nil
+}
+
+func (b *PostgresBroker) Publish(ctx context.Context, msg Message) error {
+	tx, err := b.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var id int64
+	if err := tx.QueryRowContext(ctx, "INSERT INTO event_bus (topic, key, value) VALUES ($1, $2, $3) RETURNING id",
+		msg.Topic, msg.Key, string(msg.Value)).Scan(&id); err != nil {
+		return err
+	}
+	// Channel names are identifiers, so dots become underscores.
+	channel := strings.ReplaceAll(msg.Topic, ".", "_")
+	if _, err := tx.ExecContext(ctx, "SELECT pg_notify($1, $2)", channel, fmt.Sprint(id)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (b *PostgresBroker) Close() error { return nil }
+
+// KafkaBroker writes to Kafka, one topic per event type as laid out in
+// runtime/messaging/kafka/topics.yml. Messages are keyed so one order's
+// events land on one partition and stay in order.
+type KafkaBroker struct {
+	writer *kafka.Writer
+}
+
+// NewKafkaBroker connects to a comma-separated list of brokers. Publish is
+// synchronous, so the writer sends each message as it comes rather than
+// waiting the default second for a batch to fill.
+func NewKafkaBroker(brokers string) *KafkaBroker {
+	return &KafkaBroker{writer: &kafka.Writer{
+		Addr:         kafka.TCP(strings.Split(brokers, ",")...),
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+		BatchTimeout: 5 * time.Millisecond,
+		WriteTimeout: 10 * time.Second,
+	}}
+}
+
+func (b *KafkaBroker) Publish(ctx context.Context, msg Message) error {
+	return b.writer.WriteMessages(ctx, kafka.Message{
+		Topic: msg.Topic,
+		Key:   []byte(msg.Key),
+		Value: msg.Value,
+		Headers: []kafka.Header{
+			{Key: "content-type", Value: []byte("application/cloudevents+json")},
+		},
+	})
+}
+
+func (b *KafkaBroker) Close() error { return b.writer.Close() }
@@ -0,0 +1,127 @@
+// Package events publishes pos-engine's domain events.
+//
+// Events are CloudEvents 1.0 in structured JSON mode. The platform contracts
+// in packages/contracts/events predate CloudEvents and name the tenant and
+// the event time tenantId and timestamp, so both spellings are carried: a
+// CloudEvents consumer reads time, a contract consumer reads timestamp.
+//
+// Every event is checked against the contract schema for its type before it
+// leaves the service. A type without a schema is refused rather than sent
+// unchecked, so adding an event means adding its contract first.
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/berhot/products/commerce/pos-engine/internal/jsonschema"
+)
+
+// SpecVersion is the CloudEvents version produced.
+const SpecVersion = "1.0"
+
+// Event is a CloudEvent with the contract's extra envelope fields.
+type Event struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Type            string          `json:"type"`
+	Source          string          `json:"source"`
+	Subject         string          `json:"subject,omitempty"`
+	Time            time.Time       `json:"time"`
+	DataContentType string          `json:"datacontenttype"`
+	TenantID        string          `json:"tenantId"`
+	Timestamp       time.Time       `json:"timestamp"`
+	Version         int             `json:"version"`
+	Data            json.RawMessage `json:"data"`
+}
+
+// New builds an event stamped with the current time.
+func New(id, eventType, source, subject, tenantID string, data json.RawMessage) Event {
+	now := time.Now().UTC()
+	return Event{
+		SpecVersion: SpecVersion, ID: id, Type: eventType, Source: source, Subject: subject,
+		Time: now, DataContentType: "application/json", TenantID: tenantID, Timestamp: now, Version: 1,
+		Data: data,
+	}
+}
+
+// Registry holds the contract schema for each event type.
+type Registry struct {
+	schemas map[string]*jsonschema.Schema
+}
+
+// LoadRegistry reads every *.json schema under dir. A schema's event type is
+// the const of its "type" property, which is how the contracts pin it.
+func LoadRegistry(dir string) (*Registry, error) {
+	r := &Registry{schemas: map[string]*jsonschema.Schema{}}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !strings.HasSuffix(path, ".json") {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var probe struct {
+			Properties struct {
+				Type struct {
+					Const string `json:"const"`
+				} `json:"type"`
+			} `json:"properties"`
+		}
+		if err := json.Unmarshal(data, &probe); err != nil {
+			return fmt.Errorf("events: %s: %w", path, err)
+		}
+		if probe.Properties.Type.Const == "" {
+			return nil
+		}
+		schema, err := jsonschema.Compile(data)
+		if err != nil {
+			return fmt.Errorf("events: %s: %w", path, err)
+		}
+		r.schemas[probe.Properties.Type.Const] = schema
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Types lists the event types the registry knows.
+func (r *Registry) Types() []string {
+	types := make([]string, 0, len(r.schemas))
+	for t := range r.schemas {
+		types = append(types, t)
+	}
+	return types
+}
+
+// Validate checks an encoded event against the schema for its type.
+func (r *Registry) Validate(eventType string, encoded []byte) error {
+	schema, ok := r.schemas[eventType]
+	if !ok {
+		return fmt.Errorf("events: no contract schema for %s", eventType)
+	}
+	return schema.Validate(encoded)
+}
+
+// Message is what a broker delivers: the topic, a partitioning key and the
+// encoded event.
+type Message struct {
+	Topic string
+	Key   string
+	Value []byte
+}
+
+// Broker delivers messages. Publish returns once the broker has accepted
+// the message; an error means it may not have and the caller retries.
+type Broker interface {
+	Publish(ctx context.Context, msg Message) error
+	Close() error
+}
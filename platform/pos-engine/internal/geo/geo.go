@@ -0,0 +1,62 @@
+// Package geo does the little geometry delivery zones need: distances on
+// the earth's surface and point-in-polygon tests, worked out in-process.
+//
+// Polygons are tested on the plain latitude/longitude grid, which is exact
+// enough for city-sized zones away from the poles and the antimeridian.
+package geo
+
+import "math"
+
+// earthRadiusKm is the mean radius of the earth.
+const earthRadiusKm = 6371.0088
+
+// Point is a position in decimal degrees.
+type Point struct {
+	Lat float64 `json:"lat"`
+	Lng float64 `json:"lng"`
+}
+
+// Valid reports whether the point lies within the range of latitudes and
+// longitudes. The zero point, off the coast of Africa, is treated as unset.
+func (p Point) Valid() bool {
+	if p.Lat == 0 && p.Lng == 0 {
+		return false
+	}
+	return p.Lat >= -90 && p.Lat <= 90 && p.Lng >= -180 && p.Lng <= 180
+}
+
+// DistanceKm is the great-circle distance between two points, by the
+// haversine formula.
+func DistanceKm(a, b Point) float64 {
+	lat1, lat2 := radians(a.Lat), radians(b.Lat)
+	dLat := lat2 - lat1
+	dLng := radians(b.Lng - a.Lng)
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// InRing reports whether p is at least inner and less than outer kilometres
+// from center. An inner radius of zero makes the ring a disc.
+func InRing(p, center Point, inner, outer float64) bool {
+	d := DistanceKm(p, center)
+	return d >= inner && d < outer
+}
+
+// InPolygon reports whether p falls inside the polygon, by counting the
+// edges a ray from p crosses. The polygon may be given closed or open.
+func InPolygon(p Point, polygon []Point) bool {
+	if len(polygon) < 3 {
+		return false
+	}
+	inside := false
+	for i, j := 0, len(polygon)-1; i < len(polygon); j, i = i, i+1 {
+		a, b := polygon[i], polygon[j]
+		if (a.Lat > p.Lat) != (b.Lat > p.Lat) &&
+			p.Lng < (b.Lng-a.Lng)*(p.Lat-a.Lat)/(b.Lat-a.Lat)+a.Lng {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+func radians(deg float64) float64 { return deg * math.Pi / 180 }
@@ -0,0 +1,241 @@
+// Package jsonschema validates JSON documents against the subset of JSON
+// Schema draft-07 used by the contracts in packages/contracts: type,
+// required, properties, additionalProperties, items, const, enum, format
+// (uuid, date-time, email), minLength/maxLength and minimum/maximum.
+// Annotations such as title, description and default are ignored, as are
+// keywords outside that subset.
+package jsonschema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Schema is a compiled schema node.
+type Schema struct {
+	Title                string             `json:"title"`
+	Type                 typeList           `json:"type"`
+	Required             []string           `json:"required"`
+	Properties           map[string]*Schema `json:"properties"`
+	AdditionalProperties *bool              `json:"additionalProperties"`
+	Items                *Schema            `json:"items"`
+	Const                *json.RawMessage   `json:"const"`
+	Enum                 []json.RawMessage  `json:"enum"`
+	Format               string             `json:"format"`
+	MinLength            *int               `json:"minLength"`
+	MaxLength            *int               `json:"maxLength"`
+	Minimum              *float64           `json:"minimum"`
+	Maximum              *float64           `json:"maximum"`
+}
+
+// typeList accepts "type" as a single name or a list of names.
+type typeList []string
+
+func (t *typeList) UnmarshalJSON(b []byte) error {
+	var one string
+	if err := json.Unmarshal(b, &one); err == nil {
+		*t = typeList{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return fmt.Errorf("jsonschema: type must be a string or a list of strings")
+	}
+	*t = many
+	return nil
+}
+
+// Compile parses a schema document.
+func Compile(data []byte) (*Schema, error) {
+	var s Schema
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("jsonschema: %w", err)
+	}
+	return &s, nil
+}
+
+// ValidationError lists every place a document breaks its schema.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "jsonschema: " + strings.Join(e.Problems, "; ")
+}
+
+// Validate checks a JSON document against the schema.
+func (s *Schema) Validate(doc []byte) error {
+	var v any
+	if err := json.Unmarshal(doc, &v); err != nil {
+		return fmt.Errorf("jsonschema: %w", err)
+	}
+	var problems []string
+	s.validate("$", v, &problems)
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+var (
+	uuidPattern  = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	emailPattern = regexp.MustCompile(`^[^@\s]+@[^@\s]+$`)
+)
+
+func (s *Schema) validate(path string, v any, problems *[]string) {
+	fail := func(format string, args ...any) {
+		*problems = append(*problems, path+": "+fmt.Sprintf(format, args...))
+	}
+	if len(s.Type) > 0 && !s.Type.matches(v) {
+		fail("expected %s, got %s", strings.Join(s.Type, " or "), typeOf(v))
+		return
+	}
+	if s.Const != nil && !equalJSON(*s.Const, v) {
+		fail("must be %s", *s.Const)
+	}
+	if len(s.Enum) > 0 {
+		ok := false
+		for _, e := range s.Enum {
+			ok = ok || equalJSON(e, v)
+		}
+		if !ok {
+			fail("must be one of %s", joinRaw(s.Enum))
+		}
+	}
+
+	switch v := v.(type) {
+	case string:
+		n := len([]rune(v))
+		if s.MinLength != nil && n < *s.MinLength {
+			fail("shorter than %d", *s.MinLength)
+		}
+		if s.MaxLength != nil && n > *s.MaxLength {
+			fail("longer than %d", *s.MaxLength)
+		}
+		if !validFormat(s.Format, v) {
+			fail("not a valid %s", s.Format)
+		}
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum {
+			fail("below minimum %v", *s.Minimum)
+		}
+		if s.Maximum != nil && v > *s.Maximum {
+			fail("above maximum %v", *s.Maximum)
+		}
+	case map[string]any:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				fail("missing required property %q", name)
+			}
+		}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if prop, ok := s.Properties[k]; ok {
+				prop.validate(path+"."+k, v[k], problems)
+			} else if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+				fail("unexpected property %q", k)
+			}
+		}
+	case []any:
+		if s.Items != nil {
+			for i, item := range v {
+				s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item, problems)
+			}
+		}
+	}
+}
+
+func (t typeList) matches(v any) bool {
+	for _, name := range t {
+		switch name {
+		case "object":
+			if _, ok := v.(map[string]any); ok {
+				return true
+			}
+		case "array":
+			if _, ok := v.([]any); ok {
+				return true
+			}
+		case "string":
+			if _, ok := v.(string); ok {
+				return true
+			}
+		case "number":
+			if _, ok := v.(float64); ok {
+				return true
+			}
+		case "integer":
+			if f, ok := v.(float64); ok && f == math.Trunc(f) {
+				return true
+			}
+		case "boolean":
+			if _, ok := v.(bool); ok {
+				return true
+			}
+		case "null":
+			if v == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func typeOf(v any) string {
+	switch v.(type) {
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	case nil:
+		return "null"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// validFormat checks the formats the contracts use. Unknown formats pass, as
+// draft-07 treats format as an annotation unless a validator knows it.
+func validFormat(format, v string) bool {
+	switch format {
+	case "uuid":
+		return uuidPattern.MatchString(v)
+	case "date-time":
+		_, err := time.Parse(time.RFC3339, v)
+		return err == nil
+	case "email":
+		return emailPattern.MatchString(v)
+	}
+	return true
+}
+
+func equalJSON(raw json.RawMessage, v any) bool {
+	var want any
+	if err := json.Unmarshal(raw, &want); err != nil {
+		return false
+	}
+	return reflect.DeepEqual(want, v)
+}
+
+func joinRaw(values []json.RawMessage) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = string(v)
+	}
+	return "[" + strings.Join(parts, ", ") + "]"
+}
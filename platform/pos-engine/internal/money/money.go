@@ -0,0 +1,286 @@
+// Package money is fixed-point money for pos-engine.
+//
+// An Amount counts minor units (halalas, fils, cents), so adding up prices,
+// modifiers and tax never drifts the way float64 does. Amounts match the
+// DECIMAL(12,2) columns they are stored in: they scan from and write to
+// Postgres as decimal strings and encode to JSON as plain numbers with two
+// decimals, so API clients see the same values they always have.
+package money
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// DefaultCurrency is used when neither the location nor the tenant's country
+// names one.
+const DefaultCurrency = "SAR"
+
+// scale is the number of minor units in one major unit.
+const scale = 100
+
+// Amount is a sum of money in minor units.
+type Amount int64
+
+// FromFloat converts a major-unit float, rounding half away from zero. It is
+// for values that arrive as floats, such as configuration; request bodies
+// decode straight into Amount.
+func FromFloat(f float64) Amount {
+	return Amount(math.Round(f * scale))
+}
+
+// Parse reads a decimal string such as "12.5" or "-0.05". Digits beyond the
+// second decimal are rounded half away from zero.
+func Parse(s string) (Amount, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, errors.New("money: empty amount")
+	}
+	neg := false
+	switch s[0] {
+	case '-':
+		neg = true
+		s = s[1:]
+	case '+':
+		s = s[1:]
+	}
+	whole, frac, _ := strings.Cut(s, ".")
+	if whole == "" && frac == "" {
+		return 0, fmt.Errorf("money: invalid amount %q", s)
+	}
+	var units int64
+	if whole != "" {
+		n, err := strconv.ParseInt(whole, 10, 64)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("money: invalid amount %q", s)
+		}
+		units = n * scale
+	}
+	for i := 0; i < len(frac); i++ {
+		if frac[i] < '0' || frac[i] > '9' {
+			return 0, fmt.Errorf("money: invalid amount %q", s)
+		}
+	}
+	switch {
+	case len(frac) >= 2:
+		d, _ := strconv.ParseInt(frac[:2], 10, 64)
+		units += d
+		if len(frac) > 2 && frac[2] >= '5' {
+			units++
+		}
+	case len(frac) == 1:
+		units += int64(frac[0]-'0') * 10
+	}
+	if neg {
+		units = -units
+	}
+	return Amount(units), nil
+}
+
+// Minor returns the amount in minor units.
+func (a Amount) Minor() int64 { return int64(a) }
+
+// Float64 returns the amount in major units, for sums with unit costs that
+// carry more than two decimals.
+func (a Amount) Float64() float64 { return float64(a) / scale }
+
+// String formats the amount with two decimals, e.g. "12.50".
+func (a Amount) String() string {
+	sign := ""
+	n := int64(a)
+	if n < 0 {
+		sign, n = "-", -n
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, n/scale, n%scale)
+}
+
+// IsPositive reports whether the amount is more than zero.
+func (a Amount) IsPositive() bool { return a > 0 }
+
+// Min returns the smaller of a and b.
+func Min(a, b Amount) Amount {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Max returns the larger of a and b.
+func Max(a, b Amount) Amount {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// Mul multiplies by a quantity, which may be fractional up to three decimals
+// (weighed goods), rounding the result to the nearest minor unit.
+func (a Amount) Mul(qty float64) Amount {
+	milli := int64(math.Round(qty * 1000))
+	return Amount(divRound(int64(a)*milli, 1000))
+}
+
+// Percent returns rate percent of the amount, e.g. 15 for VAT at 15%.
+// Rates are honoured to two decimals.
+func (a Amount) Percent(rate float64) Amount {
+	bp := int64(math.Round(rate * 100))
+	return Amount(divRound(int64(a)*bp, 10000))
+}
+
+// TaxIncluded returns the tax already contained in a gross amount at rate
+// percent.
+func (a Amount) TaxIncluded(rate float64) Amount {
+	bp := int64(math.Round(rate * 100))
+	if bp <= 0 {
+		return 0
+	}
+	return Amount(divRound(int64(a)*bp, 10000+bp))
+}
+
+// Ratio returns a * num / den, rounded. It is how a part of an amount is
+// worked out pro rata, such as the tax share of a partial refund. A zero den
+// gives zero.
+func (a Amount) Ratio(num, den Amount) Amount {
+	if den == 0 {
+		return 0
+	}
+	return Amount(divRound(int64(a)*int64(num), int64(den)))
+}
+
+// Share returns the part of the amount that part is of whole, for quantities
+// such as refunding two of five units. Quantities are honoured to three
+// decimals; a zero whole gives zero.
+func (a Amount) Share(part, whole float64) Amount {
+	p, w := int64(math.Round(part*1000)), int64(math.Round(whole*1000))
+	if w == 0 {
+		return 0
+	}
+	return Amount(divRound(int64(a)*p, w))
+}
+
+// Allocate splits the amount across weights in proportion, handing leftover
+// minor units to the largest remainders so the parts add back up exactly.
+func (a Amount) Allocate(weights []Amount) []Amount {
+	parts := make([]Amount, len(weights))
+	var total int64
+	for _, w := range weights {
+		if w > 0 {
+			total += int64(w)
+		}
+	}
+	if total == 0 || len(weights) == 0 {
+		return parts
+	}
+	n := int64(a)
+	sign := int64(1)
+	if n < 0 {
+		sign, n = -1, -n
+	}
+	rems := make([]int64, len(weights))
+	var used int64
+	for i, w := range weights {
+		if w <= 0 {
+			continue
+		}
+		p := n * int64(w)
+		parts[i] = Amount(p / total)
+		rems[i] = p % total
+		used += p / total
+	}
+	for left := n - used; left > 0; left-- {
+		best := -1
+		for i, r := range rems {
+			if weights[i] > 0 && (best < 0 || r > rems[best]) {
+				best = i
+			}
+		}
+		parts[best]++
+		rems[best] = -1
+	}
+	if sign < 0 {
+		for i := range parts {
+			parts[i] = -parts[i]
+		}
+	}
+	return parts
+}
+
+// divRound divides n by d, rounding half away from zero.
+func divRound(n, d int64) int64 {
+	if d < 0 {
+		n, d = -n, -d
+	}
+	q, r := n/d, n%d
+	if r < 0 {
+		r = -r
+	}
+	if 2*r >= d {
+		if n < 0 {
+			q--
+		} else {
+			q++
+		}
+	}
+	return q
+}
+
+// MarshalJSON writes the amount as a JSON number with two decimals.
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalJSON accepts a JSON number or a numeric string.
+func (a *Amount) UnmarshalJSON(b []byte) error {
+	s := string(b)
+	if s == "null" {
+		return nil
+	}
+	s = strings.Trim(s, `"`)
+	if strings.ContainsAny(s, "eE") {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("money: invalid amount %s", b)
+		}
+		*a = FromFloat(f)
+		return nil
+	}
+	v, err := Parse(s)
+	if err != nil {
+		return err
+	}
+	*a = v
+	return nil
+}
+
+// Scan reads a DECIMAL column.
+func (a *Amount) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*a = 0
+		return nil
+	case []byte:
+		p, err := Parse(string(v))
+		*a = p
+		return err
+	case string:
+		p, err := Parse(v)
+		*a = p
+		return err
+	case int64:
+		*a = Amount(v * scale)
+		return nil
+	case float64:
+		*a = FromFloat(v)
+		return nil
+	}
+	return fmt.Errorf("money: cannot scan %T", src)
+}
+
+// Value writes the amount as a decimal string.
+func (a Amount) Value() (driver.Value, error) {
+	return a.String(), nil
+}
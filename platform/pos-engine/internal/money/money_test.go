@@ -0,0 +1,188 @@
+package money
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Amount
+		wantErr bool
+	}{
+		{"12.5", 1250, false},
+		{"12.50", 1250, false},
+		{"-0.05", -5, false},
+		{"+3", 300, false},
+		{".5", 50, false},
+		{" 7 ", 700, false},
+		{"1.234", 123, false},
+		{"1.235", 124, false},
+		{"0.005", 1, false},
+		{"-1.235", -124, false},
+		{"", 0, true},
+		{"-", 0, true},
+		{"abc", 0, true},
+		{"1.2x", 0, true},
+		{"1.-2", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Parse(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Parse(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		in   Amount
+		want string
+	}{
+		{0, "0.00"},
+		{5, "0.05"},
+		{-5, "-0.05"},
+		{1250, "12.50"},
+		{-123456, "-1234.56"},
+	}
+	for _, tt := range tests {
+		if got := tt.in.String(); got != tt.want {
+			t.Errorf("Amount(%d).String() = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRounding(t *testing.T) {
+	tests := []struct {
+		name string
+		got  Amount
+		want Amount
+	}{
+		{"FromFloat half up", FromFloat(0.125), 13},
+		{"FromFloat negative half", FromFloat(-0.125), -13},
+		{"Mul whole", Amount(1000).Mul(1.5), 1500},
+		{"Mul half up", Amount(333).Mul(0.5), 167},
+		{"Mul negative half", Amount(-333).Mul(0.5), -167},
+		{"Mul weighed", Amount(199).Mul(0.333), 66},
+		{"Percent VAT", Amount(10000).Percent(15), 1500},
+		{"Percent rounds", Amount(333).Percent(15), 50},
+		{"Percent half away from zero", Amount(5).Percent(50), 3},
+		{"Percent negative half", Amount(-5).Percent(50), -3},
+		{"TaxIncluded exact", Amount(11500).TaxIncluded(15), 1500},
+		{"TaxIncluded rounds", Amount(100).TaxIncluded(15), 13},
+		{"TaxIncluded zero rate", Amount(11500).TaxIncluded(0), 0},
+		{"Ratio", Amount(1500).Ratio(5000, 11500), 652},
+		{"Ratio whole", Amount(1500).Ratio(11500, 11500), 1500},
+		{"Ratio zero den", Amount(1500).Ratio(5000, 0), 0},
+		{"Share", Amount(1000).Share(2, 3), 667},
+		{"Share zero whole", Amount(1000).Share(2, 0), 0},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %d, want %d", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestAllocate(t *testing.T) {
+	tests := []struct {
+		name    string
+		amount  Amount
+		weights []Amount
+		want    []Amount
+	}{
+		{"even thirds", 100, []Amount{1, 1, 1}, []Amount{34, 33, 33}},
+		{"negative thirds", -100, []Amount{1, 1, 1}, []Amount{-34, -33, -33}},
+		{"largest remainder", 10, []Amount{1, 2}, []Amount{3, 7}},
+		{"zero weight left out", 1000, []Amount{0, 3, 1}, []Amount{0, 750, 250}},
+		{"negative weight left out", 1000, []Amount{-5, 1, 1}, []Amount{0, 500, 500}},
+		{"no weight", 1000, []Amount{0, 0}, []Amount{0, 0}},
+		{"no parts", 1000, nil, []Amount{}},
+	}
+	for _, tt := range tests {
+		got := tt.amount.Allocate(tt.weights)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: Allocate = %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		var sum Amount
+		for i := range got {
+			sum += got[i]
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: Allocate = %v, want %v", tt.name, got, tt.want)
+				break
+			}
+		}
+		var weighted bool
+		for _, w := range tt.weights {
+			weighted = weighted || w > 0
+		}
+		if weighted && sum != tt.amount {
+			t.Errorf("%s: parts add up to %d, want %d", tt.name, sum, tt.amount)
+		}
+	}
+}
+
+func TestJSON(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Amount
+	}{
+		{`12.3`, 1230},
+		{`"12.3"`, 1230},
+		{`1e1`, 1000},
+		{`-0.01`, -1},
+	}
+	for _, tt := range tests {
+		var a Amount
+		if err := json.Unmarshal([]byte(tt.in), &a); err != nil {
+			t.Errorf("Unmarshal(%s): %v", tt.in, err)
+			continue
+		}
+		if a != tt.want {
+			t.Errorf("Unmarshal(%s) = %d, want %d", tt.in, a, tt.want)
+		}
+	}
+
+	out, err := json.Marshal(struct {
+		Total Amount `json:"total"`
+	}{1230})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != `{"total":12.30}` {
+		t.Errorf("Marshal = %s, want {\"total\":12.30}", out)
+	}
+}
+
+func TestScan(t *testing.T) {
+	tests := []struct {
+		src  any
+		want Amount
+	}{
+		{[]byte("12.34"), 1234},
+		{"0.10", 10},
+		{int64(3), 300},
+		{float64(1.005), 100},
+		{nil, 0},
+	}
+	for _, tt := range tests {
+		a := Amount(99)
+		if err := a.Scan(tt.src); err != nil {
+			t.Errorf("Scan(%v): %v", tt.src, err)
+			continue
+		}
+		if a != tt.want {
+			t.Errorf("Scan(%v) = %d, want %d", tt.src, a, tt.want)
+		}
+	}
+	var a Amount
+	if err := a.Scan(true); err == nil {
+		t.Error("Scan(bool) succeeded, want an error")
+	}
+}
@@ -0,0 +1,415 @@
+// Package promotion prices discounts for a POS cart.
+//
+// A promotion has a type that says how the discount is worked out and a scope
+// that says which lines it looks at:
+//
+//   - percentage and fixed take money off the order, or off each qualifying
+//     product or category line;
+//   - bogo and buy_x_get_y discount the cheapest qualifying units once enough
+//     units are in the cart.
+//
+// Cart-threshold promotions are any promotion with MinSubtotal set. Code-only
+// promotions apply when the customer enters the matching code.
+//
+// Stacking follows three rules: an exclusive promotion never combines with
+// any other; at most one non-stackable promotion applies; stackable
+// promotions combine with everything that isn't exclusive. When an exclusive
+// promotion competes with a combination, whichever saves the customer more
+// wins.
+package promotion
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/berhot/products/commerce/pos-engine/internal/money"
+)
+
+// Promotion types.
+const (
+	TypePercentage = "percentage"
+	TypeFixed      = "fixed"
+	TypeBOGO       = "bogo"
+	TypeBuyXGetY   = "buy_x_get_y"
+)
+
+// Promotion scopes.
+const (
+	ScopeOrder    = "order"
+	ScopeProduct  = "product"
+	ScopeCategory = "category"
+)
+
+// Promotion is a discount rule as configured by the merchant.
+type Promotion struct {
+	ID       string
+	Name     string
+	Code     string
+	Type     string
+	Scope    string
+	Value    float64 // percent for percentage, amount for fixed
+	Priority int
+
+	ProductIDs  []string
+	CategoryIDs []string
+
+	// Buy-X-get-Y settings. Reward lines come from GetProductIDs when set,
+	// otherwise from the same qualifying lines.
+	BuyQuantity        int
+	GetQuantity        int
+	GetDiscountPercent float64
+	GetProductIDs      []string
+
+	MinSubtotal   money.Amount
+	MaxDiscount   money.Amount
+	CustomerTiers []string
+
+	Stackable bool
+	Exclusive bool
+
+	StartsAt   *time.Time
+	EndsAt     *time.Time
+	UsageLimit int // 0 means unlimited
+	UsageCount int
+}
+
+// Line is one cart line to price.
+type Line struct {
+	Key        string
+	ProductID  string
+	CategoryID string
+	UnitPrice  money.Amount
+	Quantity   float64
+}
+
+// Cart is what promotions are evaluated against.
+type Cart struct {
+	Lines        []Line
+	Codes        []string
+	CustomerTier string
+	At           time.Time
+}
+
+// Applied is a promotion that took money off, with its split across lines.
+type Applied struct {
+	Promotion Promotion
+	Amount    money.Amount
+	Lines     map[string]money.Amount
+}
+
+// Rejection explains why an entered promo code was not applied.
+type Rejection struct {
+	Code   string
+	Reason string
+}
+
+// Result is the outcome of Apply.
+type Result struct {
+	Applied       []Applied
+	LineDiscounts map[string]money.Amount
+	Total         money.Amount
+	Rejected      []Rejection
+}
+
+// Apply evaluates promos against cart and returns the discounts to apply.
+func Apply(cart Cart, promos []Promotion) Result {
+	codes := map[string]bool{}
+	for _, c := range cart.Codes {
+		if c = normalizeCode(c); c != "" {
+			codes[c] = true
+		}
+	}
+	var subtotal money.Amount
+	for _, l := range cart.Lines {
+		subtotal += l.UnitPrice.Mul(l.Quantity)
+	}
+
+	res := Result{LineDiscounts: map[string]money.Amount{}}
+	matched := map[string]bool{}
+	var eligible []Promotion
+	for _, p := range promos {
+		code := normalizeCode(p.Code)
+		if code != "" {
+			if !codes[code] {
+				continue
+			}
+			matched[code] = true
+		}
+		if reason := ineligible(p, cart, subtotal); reason != "" {
+			if code != "" {
+				res.Rejected = append(res.Rejected, Rejection{Code: p.Code, Reason: reason})
+			}
+			continue
+		}
+		eligible = append(eligible, p)
+	}
+	for c := range codes {
+		if !matched[c] {
+			res.Rejected = append(res.Rejected, Rejection{Code: c, Reason: "unknown promo code"})
+		}
+	}
+	sort.Slice(res.Rejected, func(i, j int) bool { return res.Rejected[i].Code < res.Rejected[j].Code })
+
+	// Stack everything that may combine, then see if one exclusive beats it.
+	stacked := applyStack(cart, eligible)
+	best := stacked
+	for _, p := range eligible {
+		if !p.Exclusive {
+			continue
+		}
+		alone := applyStack(cart, []Promotion{p})
+		if alone.Total > best.Total {
+			best = alone
+		}
+	}
+	res.Applied = best.Applied
+	res.LineDiscounts = best.LineDiscounts
+	res.Total = best.Total
+	return res
+}
+
+// applyStack applies the non-exclusive promotions in priority order. Only the
+// best non-stackable promotion is kept. A single exclusive promotion passed
+// on its own is applied as is.
+func applyStack(cart Cart, promos []Promotion) Result {
+	var chosen []Promotion
+	if len(promos) == 1 {
+		chosen = promos
+	} else {
+		var bestSingle *Promotion
+		var bestAmount money.Amount
+		for i, p := range promos {
+			if p.Exclusive {
+				continue
+			}
+			if p.Stackable {
+				chosen = append(chosen, p)
+				continue
+			}
+			amount, _ := discountFor(p, remainingAmounts(cart, nil))
+			if bestSingle == nil || amount > bestAmount {
+				bestSingle, bestAmount = &promos[i], amount
+			}
+		}
+		if bestSingle != nil {
+			chosen = append(chosen, *bestSingle)
+		}
+	}
+	sort.SliceStable(chosen, func(i, j int) bool { return chosen[i].Priority > chosen[j].Priority })
+
+	res := Result{LineDiscounts: map[string]money.Amount{}}
+	for _, p := range chosen {
+		amount, lines := discountFor(p, remainingAmounts(cart, res.LineDiscounts))
+		if !amount.IsPositive() {
+			continue
+		}
+		for k, v := range lines {
+			res.LineDiscounts[k] += v
+		}
+		res.Total += amount
+		res.Applied = append(res.Applied, Applied{Promotion: p, Amount: amount, Lines: lines})
+	}
+	return res
+}
+
+func ineligible(p Promotion, cart Cart, subtotal money.Amount) string {
+	if p.StartsAt != nil && cart.At.Before(*p.StartsAt) {
+		return "promotion has not started"
+	}
+	if p.EndsAt != nil && !cart.At.Before(*p.EndsAt) {
+		return "promotion has ended"
+	}
+	if p.UsageLimit > 0 && p.UsageCount >= p.UsageLimit {
+		return "usage limit reached"
+	}
+	if p.MinSubtotal > 0 && subtotal < p.MinSubtotal {
+		return "order is below the minimum amount"
+	}
+	if len(p.CustomerTiers) > 0 && !contains(p.CustomerTiers, cart.CustomerTier) {
+		return "customer tier not eligible"
+	}
+	return ""
+}
+
+// remaining is a cart line with the discount already taken off it.
+type remaining struct {
+	Line
+	Amount money.Amount
+}
+
+func remainingAmounts(cart Cart, taken map[string]money.Amount) []remaining {
+	out := make([]remaining, 0, len(cart.Lines))
+	for _, l := range cart.Lines {
+		amt := l.UnitPrice.Mul(l.Quantity) - taken[l.Key]
+		out = append(out, remaining{Line: l, Amount: money.Max(amt, 0)})
+	}
+	return out
+}
+
+func discountFor(p Promotion, lines []remaining) (money.Amount, map[string]money.Amount) {
+	split := map[string]money.Amount{}
+	switch p.Type {
+	case TypePercentage:
+		targets := targetLines(p, lines)
+		var base money.Amount
+		for _, l := range targets {
+			base += l.Amount
+		}
+		amount := capDiscount(p, base.Percent(p.Value), base)
+		spread(split, targets, amount)
+	case TypeFixed:
+		targets := targetLines(p, lines)
+		var base money.Amount
+		units := 0.0
+		for _, l := range targets {
+			base += l.Amount
+			units += l.Quantity
+		}
+		amount := money.FromFloat(p.Value)
+		if p.Scope == ScopeProduct || p.Scope == ScopeCategory {
+			amount = amount.Mul(units)
+		}
+		amount = capDiscount(p, amount, base)
+		spread(split, targets, amount)
+	case TypeBOGO, TypeBuyXGetY:
+		buy, get, pct := p.BuyQuantity, p.GetQuantity, p.GetDiscountPercent
+		if p.Type == TypeBOGO {
+			buy, get, pct = 1, 1, 100
+		}
+		if buy <= 0 || get <= 0 {
+			return 0, split
+		}
+		if pct <= 0 {
+			pct = 100
+		}
+		qualifying := targetLines(p, lines)
+		var rewards []remaining
+		var rewardSets int
+		if len(p.GetProductIDs) > 0 {
+			rewards = filterLines(lines, func(l remaining) bool { return contains(p.GetProductIDs, l.ProductID) })
+			rewardSets = int(unitCount(qualifying)) / buy
+		} else {
+			rewards = qualifying
+			rewardSets = int(unitCount(qualifying)) / (buy + get)
+		}
+		freeUnits := rewardSets * get
+		var amount money.Amount
+		for _, u := range cheapestUnits(rewards, freeUnits) {
+			d := u.price.Percent(pct)
+			split[u.key] += d
+			amount += d
+		}
+		if p.MaxDiscount.IsPositive() && amount > p.MaxDiscount {
+			split = map[string]money.Amount{}
+			spread(split, rewards, p.MaxDiscount)
+			amount = p.MaxDiscount
+		}
+		return amount, split
+	}
+	var total money.Amount
+	for _, v := range split {
+		total += v
+	}
+	return total, split
+}
+
+func capDiscount(p Promotion, amount, base money.Amount) money.Amount {
+	if p.MaxDiscount.IsPositive() && amount > p.MaxDiscount {
+		amount = p.MaxDiscount
+	}
+	return money.Max(money.Min(amount, base), 0)
+}
+
+func targetLines(p Promotion, lines []remaining) []remaining {
+	switch p.Scope {
+	case ScopeProduct:
+		return filterLines(lines, func(l remaining) bool { return contains(p.ProductIDs, l.ProductID) })
+	case ScopeCategory:
+		return filterLines(lines, func(l remaining) bool { return contains(p.CategoryIDs, l.CategoryID) })
+	}
+	if len(p.ProductIDs) > 0 || len(p.CategoryIDs) > 0 {
+		return filterLines(lines, func(l remaining) bool {
+			return contains(p.ProductIDs, l.ProductID) || contains(p.CategoryIDs, l.CategoryID)
+		})
+	}
+	return lines
+}
+
+func filterLines(lines []remaining, keep func(remaining) bool) []remaining {
+	var out []remaining
+	for _, l := range lines {
+		if l.Amount > 0 && keep(l) {
+			out = append(out, l)
+		}
+	}
+	return out
+}
+
+// spread splits amount across lines in proportion to what is left on each.
+func spread(split map[string]money.Amount, lines []remaining, amount money.Amount) {
+	if !amount.IsPositive() {
+		return
+	}
+	weights := make([]money.Amount, len(lines))
+	for i, l := range lines {
+		weights[i] = l.Amount
+	}
+	for i, share := range amount.Allocate(weights) {
+		split[lines[i].Key] += share
+	}
+}
+
+type unit struct {
+	key   string
+	price money.Amount
+}
+
+func unitCount(lines []remaining) float64 {
+	n := 0.0
+	for _, l := range lines {
+		n += math.Floor(l.Quantity)
+	}
+	return n
+}
+
+// cheapestUnits expands lines into whole units and returns the n cheapest.
+func cheapestUnits(lines []remaining, n int) []unit {
+	var units []unit
+	for _, l := range lines {
+		whole := int(math.Floor(l.Quantity))
+		if whole == 0 {
+			continue
+		}
+		// Share the line across its units so they add back up to it.
+		weights := make([]money.Amount, whole)
+		for i := range weights {
+			weights[i] = 1
+		}
+		for _, price := range l.Amount.Allocate(weights) {
+			units = append(units, unit{key: l.Key, price: price})
+		}
+	}
+	sort.SliceStable(units, func(i, j int) bool { return units[i].price < units[j].price })
+	if n < len(units) {
+		units = units[:n]
+	}
+	return units
+}
+
+func normalizeCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+func contains(list []string, v string) bool {
+	if v == "" {
+		return false
+	}
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
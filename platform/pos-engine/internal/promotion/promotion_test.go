@@ -0,0 +1,172 @@
+package promotion
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/berhot/products/commerce/pos-engine/internal/money"
+)
+
+var now = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// cart is two lattes at 10.00 and a muffin at 5.00.
+func cart(codes ...string) Cart {
+	return Cart{
+		Lines: []Line{
+			{Key: "latte", ProductID: "p-latte", CategoryID: "c-coffee", UnitPrice: 1000, Quantity: 2},
+			{Key: "muffin", ProductID: "p-muffin", CategoryID: "c-bakery", UnitPrice: 500, Quantity: 1},
+		},
+		Codes: codes,
+		At:    now,
+	}
+}
+
+func appliedIDs(res Result) []string {
+	ids := []string{}
+	for _, a := range res.Applied {
+		ids = append(ids, a.Promotion.ID)
+	}
+	return ids
+}
+
+func TestApply(t *testing.T) {
+	past, future := now.Add(-time.Hour), now.Add(time.Hour)
+	tests := []struct {
+		name      string
+		cart      Cart
+		promos    []Promotion
+		wantTotal money.Amount
+		wantIDs   []string
+		wantLines map[string]money.Amount
+	}{
+		{
+			name:      "percentage off the order",
+			cart:      cart(),
+			promos:    []Promotion{{ID: "pct", Type: TypePercentage, Scope: ScopeOrder, Value: 10}},
+			wantTotal: 250, wantIDs: []string{"pct"},
+			wantLines: map[string]money.Amount{"latte": 200, "muffin": 50},
+		},
+		{
+			name: "stackable promotions combine in priority order",
+			cart: cart(),
+			promos: []Promotion{
+				{ID: "fixed", Type: TypeFixed, Scope: ScopeOrder, Value: 5, Stackable: true, Priority: 1},
+				{ID: "pct", Type: TypePercentage, Scope: ScopeOrder, Value: 10, Stackable: true, Priority: 2},
+			},
+			wantTotal: 750, wantIDs: []string{"pct", "fixed"},
+			wantLines: map[string]money.Amount{"latte": 600, "muffin": 150},
+		},
+		{
+			name: "only the best non-stackable applies",
+			cart: cart(),
+			promos: []Promotion{
+				{ID: "pct", Type: TypePercentage, Scope: ScopeOrder, Value: 10},
+				{ID: "fixed", Type: TypeFixed, Scope: ScopeOrder, Value: 3},
+			},
+			wantTotal: 300, wantIDs: []string{"fixed"},
+		},
+		{
+			name: "an exclusive promotion beats a smaller stack",
+			cart: cart(),
+			promos: []Promotion{
+				{ID: "pct", Type: TypePercentage, Scope: ScopeOrder, Value: 10, Stackable: true},
+				{ID: "excl", Type: TypePercentage, Scope: ScopeOrder, Value: 20, Exclusive: true},
+			},
+			wantTotal: 500, wantIDs: []string{"excl"},
+		},
+		{
+			name: "a larger stack beats an exclusive promotion",
+			cart: cart(),
+			promos: []Promotion{
+				{ID: "pct", Type: TypePercentage, Scope: ScopeOrder, Value: 10, Stackable: true, Priority: 2},
+				{ID: "fixed", Type: TypeFixed, Scope: ScopeOrder, Value: 5, Stackable: true, Priority: 1},
+				{ID: "excl", Type: TypePercentage, Scope: ScopeOrder, Value: 20, Exclusive: true},
+			},
+			wantTotal: 750, wantIDs: []string{"pct", "fixed"},
+		},
+		{
+			name:      "fixed per unit on a category",
+			cart:      cart(),
+			promos:    []Promotion{{ID: "coffee", Type: TypeFixed, Scope: ScopeCategory, Value: 1, CategoryIDs: []string{"c-coffee"}}},
+			wantTotal: 200, wantIDs: []string{"coffee"},
+			wantLines: map[string]money.Amount{"latte": 200},
+		},
+		{
+			name:      "max discount caps a percentage",
+			cart:      cart(),
+			promos:    []Promotion{{ID: "half", Type: TypePercentage, Scope: ScopeOrder, Value: 50, MaxDiscount: 400}},
+			wantTotal: 400, wantIDs: []string{"half"},
+		},
+		{
+			name:      "bogo gives the cheapest unit free",
+			cart:      cart(),
+			promos:    []Promotion{{ID: "bogo", Type: TypeBOGO, Scope: ScopeProduct, ProductIDs: []string{"p-latte"}}},
+			wantTotal: 1000, wantIDs: []string{"bogo"},
+			wantLines: map[string]money.Amount{"latte": 1000},
+		},
+		{
+			name: "buy two lattes, muffin half price",
+			cart: cart(),
+			promos: []Promotion{{
+				ID: "x-y", Type: TypeBuyXGetY, Scope: ScopeProduct, ProductIDs: []string{"p-latte"},
+				BuyQuantity: 2, GetQuantity: 1, GetDiscountPercent: 50, GetProductIDs: []string{"p-muffin"},
+			}},
+			wantTotal: 250, wantIDs: []string{"x-y"},
+			wantLines: map[string]money.Amount{"muffin": 250},
+		},
+		{
+			name: "ineligible promotions are skipped",
+			cart: cart(),
+			promos: []Promotion{
+				{ID: "early", Type: TypePercentage, Scope: ScopeOrder, Value: 10, StartsAt: &future},
+				{ID: "ended", Type: TypePercentage, Scope: ScopeOrder, Value: 10, EndsAt: &past},
+				{ID: "used", Type: TypePercentage, Scope: ScopeOrder, Value: 10, UsageLimit: 5, UsageCount: 5},
+				{ID: "big", Type: TypePercentage, Scope: ScopeOrder, Value: 10, MinSubtotal: 3000},
+				{ID: "gold", Type: TypePercentage, Scope: ScopeOrder, Value: 10, CustomerTiers: []string{"gold"}},
+			},
+			wantTotal: 0, wantIDs: []string{},
+		},
+		{
+			name:      "codes match regardless of case and spacing",
+			cart:      cart(" save5 "),
+			promos:    []Promotion{{ID: "code", Code: "SAVE5", Type: TypeFixed, Scope: ScopeOrder, Value: 5}},
+			wantTotal: 500, wantIDs: []string{"code"},
+		},
+		{
+			name:      "code promotions need their code",
+			cart:      cart(),
+			promos:    []Promotion{{ID: "code", Code: "SAVE5", Type: TypeFixed, Scope: ScopeOrder, Value: 5}},
+			wantTotal: 0, wantIDs: []string{},
+		},
+	}
+	for _, tt := range tests {
+		res := Apply(tt.cart, tt.promos)
+		if res.Total != tt.wantTotal {
+			t.Errorf("%s: Total = %s, want %s", tt.name, res.Total, tt.wantTotal)
+		}
+		if ids := appliedIDs(res); !reflect.DeepEqual(ids, tt.wantIDs) {
+			t.Errorf("%s: applied %v, want %v", tt.name, ids, tt.wantIDs)
+		}
+		if tt.wantLines != nil && !reflect.DeepEqual(res.LineDiscounts, tt.wantLines) {
+			t.Errorf("%s: LineDiscounts = %v, want %v", tt.name, res.LineDiscounts, tt.wantLines)
+		}
+	}
+}
+
+func TestApplyRejectsCodes(t *testing.T) {
+	promos := []Promotion{
+		{ID: "big", Code: "BIG", Type: TypePercentage, Scope: ScopeOrder, Value: 10, MinSubtotal: 3000},
+	}
+	res := Apply(cart("big", "nope"), promos)
+	want := []Rejection{
+		{Code: "BIG", Reason: "order is below the minimum amount"},
+		{Code: "NOPE", Reason: "unknown promo code"},
+	}
+	if !reflect.DeepEqual(res.Rejected, want) {
+		t.Errorf("Rejected = %v, want %v", res.Rejected, want)
+	}
+	if res.Total != 0 {
+		t.Errorf("Total = %s, want 0.00", res.Total)
+	}
+}
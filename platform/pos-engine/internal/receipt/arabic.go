@@ -0,0 +1,280 @@
+package receipt
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// Receipt printers and the PDF writer draw characters one by one, left to
+// right, with no shaping of their own. Arabic has to reach them already
+// joined into its contextual letter forms and laid out in display order.
+
+// arabicForms gives a letter's isolated, final, initial and medial
+// presentation forms. Letters that only join to the letter before them have
+// no initial or medial form.
+var arabicForms = map[rune][4]rune{
+	'ء': {0xFE80, 0, 0, 0},
+	'آ': {0xFE81, 0xFE82, 0, 0},
+	'أ': {0xFE83, 0xFE84, 0, 0},
+	'ؤ': {0xFE85, 0xFE86, 0, 0},
+	'إ': {0xFE87, 0xFE88, 0, 0},
+	'ئ': {0xFE89, 0xFE8A, 0xFE8B, 0xFE8C},
+	'ا': {0xFE8D, 0xFE8E, 0, 0},
+	'ب': {0xFE8F, 0xFE90, 0xFE91, 0xFE92},
+	'ة': {0xFE93, 0xFE94, 0, 0},
+	'ت': {0xFE95, 0xFE96, 0xFE97, 0xFE98},
+	'ث': {0xFE99, 0xFE9A, 0xFE9B, 0xFE9C},
+	'ج': {0xFE9D, 0xFE9E, 0xFE9F, 0xFEA0},
+	'ح': {0xFEA1, 0xFEA2, 0xFEA3, 0xFEA4},
+	'خ': {0xFEA5, 0xFEA6, 0xFEA7, 0xFEA8},
+	'د': {0xFEA9, 0xFEAA, 0, 0},
+	'ذ': {0xFEAB, 0xFEAC, 0, 0},
+	'ر': {0xFEAD, 0xFEAE, 0, 0},
+	'ز': {0xFEAF, 0xFEB0, 0, 0},
+	'س': {0xFEB1, 0xFEB2, 0xFEB3, 0xFEB4},
+	'ش': {0xFEB5, 0xFEB6, 0xFEB7, 0xFEB8},
+	'ص': {0xFEB9, 0xFEBA, 0xFEBB, 0xFEBC},
+	'ض': {0xFEBD, 0xFEBE, 0xFEBF, 0xFEC0},
+	'ط': {0xFEC1, 0xFEC2, 0xFEC3, 0xFEC4},
+	'ظ': {0xFEC5, 0xFEC6, 0xFEC7, 0xFEC8},
+	'ع': {0xFEC9, 0xFECA, 0xFECB, 0xFECC},
+	'غ': {0xFECD, 0xFECE, 0xFECF, 0xFED0},
+	'ف': {0xFED1, 0xFED2, 0xFED3, 0xFED4},
+	'ق': {0xFED5, 0xFED6, 0xFED7, 0xFED8},
+	'ك': {0xFED9, 0xFEDA, 0xFEDB, 0xFEDC},
+	'ل': {0xFEDD, 0xFEDE, 0xFEDF, 0xFEE0},
+	'م': {0xFEE1, 0xFEE2, 0xFEE3, 0xFEE4},
+	'ن': {0xFEE5, 0xFEE6, 0xFEE7, 0xFEE8},
+	'ه': {0xFEE9, 0xFEEA, 0xFEEB, 0xFEEC},
+	'و': {0xFEED, 0xFEEE, 0, 0},
+	'ى': {0xFEEF, 0xFEF0, 0xFBE8, 0xFBE9},
+	'ي': {0xFEF1, 0xFEF2, 0xFEF3, 0xFEF4},
+}
+
+// lamAlef gives the isolated and final ligature of lam followed by an alef.
+var lamAlef = map[rune][2]rune{
+	'آ': {0xFEF5, 0xFEF6},
+	'أ': {0xFEF7, 0xFEF8},
+	'إ': {0xFEF9, 0xFEFA},
+	'ا': {0xFEFB, 0xFEFC},
+}
+
+const tatweel = 'ـ'
+
+// joinsNext reports whether r connects to the letter after it.
+func joinsNext(r rune) bool {
+	if r == tatweel {
+		return true
+	}
+	f, ok := arabicForms[r]
+	return ok && f[2] != 0
+}
+
+// joins reports whether r connects to the letter before it.
+func joins(r rune) bool {
+	if r == tatweel {
+		return true
+	}
+	f, ok := arabicForms[r]
+	return ok && f[1] != 0
+}
+
+// isMark reports whether r is a vowel mark or other combining character.
+// Marks are dropped: receipt printers cannot stack them over letters.
+func isMark(r rune) bool {
+	return unicode.Is(unicode.Mn, r)
+}
+
+// isArabic reports whether r is an Arabic letter, in logical or
+// presentation form.
+func isArabic(r rune) bool {
+	return unicode.Is(unicode.Arabic, r) && !unicode.IsDigit(r) && !isMark(r)
+}
+
+// hasArabic reports whether s contains any Arabic letter.
+func hasArabic(s string) bool {
+	for _, r := range s {
+		if isArabic(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// stripMarks removes combining marks from s.
+func stripMarks(s string) string {
+	out := make([]rune, 0, len(s))
+	for _, r := range s {
+		if !isMark(r) {
+			out = append(out, r)
+		}
+	}
+	return string(out)
+}
+
+// width is the number of character cells s takes once shaped.
+func width(s string) int {
+	return utf8.RuneCountInString(shape(s))
+}
+
+// shape replaces Arabic letters with the presentation form their neighbours
+// call for, joining lam-alef pairs into one ligature. The text stays in
+// logical order.
+func shape(s string) string {
+	in := []rune(stripMarks(s))
+	out := make([]rune, 0, len(in))
+	for i := 0; i < len(in); i++ {
+		r := in[i]
+		forms, ok := arabicForms[r]
+		if !ok {
+			out = append(out, r)
+			continue
+		}
+		prev := i > 0 && joinsNext(in[i-1])
+		if r == 'ل' && i+1 < len(in) {
+			if lig, ok := lamAlef[in[i+1]]; ok {
+				if prev {
+					out = append(out, lig[1])
+				} else {
+					out = append(out, lig[0])
+				}
+				i++
+				continue
+			}
+		}
+		next := joinsNext(r) && i+1 < len(in) && joins(in[i+1])
+		switch {
+		case prev && next:
+			out = append(out, forms[3])
+		case prev:
+			out = append(out, forms[1])
+		case next:
+			out = append(out, forms[2])
+		default:
+			out = append(out, forms[0])
+		}
+	}
+	return string(out)
+}
+
+// mirrored swaps paired punctuation that flips in right-to-left runs.
+var mirrored = map[rune]rune{'(': ')', ')': '(', '[': ']', ']': '[', '{': '}', '}': '{', '<': '>', '>': '<'}
+
+// numberPunct reports whether text[i] is a separator or sign that belongs
+// to the number beside it, as in "1,250.00", "15%" or "-3".
+func numberPunct(text []rune, i int) bool {
+	digitAt := func(j int) bool { return j >= 0 && j < len(text) && unicode.IsDigit(text[j]) }
+	switch text[i] {
+	case '.', ',', ':', '/':
+		return digitAt(i-1) && digitAt(i+1)
+	case '%':
+		return digitAt(i - 1)
+	case '-', '+':
+		return digitAt(i + 1)
+	}
+	return false
+}
+
+// visual shapes s and puts it in display order for a device that draws left
+// to right. It is a small subset of the Unicode bidi algorithm, enough for a
+// line of receipt text: the line reads right to left when its first strong
+// character is Arabic, runs of Arabic are reversed, runs of Latin letters and
+// digits keep their order, and spaces and punctuation between runs take the
+// direction around them.
+func visual(s string) string {
+	text := []rune(shape(s))
+	if len(text) == 0 {
+		return ""
+	}
+	const (
+		ltr = iota
+		rtl
+		neutral
+	)
+	class := make([]int, len(text))
+	base := ltr
+	baseSet := false
+	for i, r := range text {
+		switch {
+		case isArabic(r):
+			class[i] = rtl
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || numberPunct(text, i):
+			class[i] = ltr
+		default:
+			class[i] = neutral
+			continue
+		}
+		if !baseSet {
+			base, baseSet = class[i], true
+		}
+	}
+	if !baseSet {
+		return string(text)
+	}
+	if base == ltr && !hasArabic(s) {
+		return string(text)
+	}
+
+	// Neutrals between two runs of one direction take it; others take the
+	// line's direction.
+	for i := 0; i < len(text); i++ {
+		if class[i] != neutral {
+			continue
+		}
+		j := i
+		for j < len(text) && class[j] == neutral {
+			j++
+		}
+		before, after := base, base
+		if i > 0 {
+			before = class[i-1]
+		}
+		if j < len(text) {
+			after = class[j]
+		}
+		dir := base
+		if before == after {
+			dir = before
+		}
+		for k := i; k < j; k++ {
+			class[k] = dir
+		}
+		i = j - 1
+	}
+
+	type run struct {
+		dir  int
+		text []rune
+	}
+	var runs []run
+	for i, r := range text {
+		if n := len(runs); n > 0 && runs[n-1].dir == class[i] {
+			runs[n-1].text = append(runs[n-1].text, r)
+			continue
+		}
+		runs = append(runs, run{class[i], []rune{r}})
+	}
+	for _, r := range runs {
+		if r.dir != rtl {
+			continue
+		}
+		for i, j := 0, len(r.text)-1; i < j; i, j = i+1, j-1 {
+			r.text[i], r.text[j] = r.text[j], r.text[i]
+		}
+		for i, c := range r.text {
+			if m, ok := mirrored[c]; ok {
+				r.text[i] = m
+			}
+		}
+	}
+	if base == rtl {
+		for i, j := 0, len(runs)-1; i < j; i, j = i+1, j-1 {
+			runs[i], runs[j] = runs[j], runs[i]
+		}
+	}
+	out := make([]rune, 0, len(text))
+	for _, r := range runs {
+		out = append(out, r.text...)
+	}
+	return string(out)
+}
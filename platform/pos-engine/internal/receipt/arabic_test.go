@@ -0,0 +1,91 @@
+package receipt
+
+import "testing"
+
+func TestShape(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		// Initial, medial and final forms.
+		{"محمد", "ﻣﺤﻤﺪ"},
+		// Lam-alef joins into one ligature, in its final form after a
+		// joining letter.
+		{"سلام", "ﺳﻼﻡ"},
+		{"لا", "ﻻ"},
+		// Alef does not join forward, so the letter after it starts again.
+		{"المجموع", "ﺍﻟﻤﺠﻤﻮﻉ"},
+		// Vowel marks are dropped.
+		{"مَرْحَبًا", "ﻣﺮﺣﺒﺎ"},
+		{"Latte", "Latte"},
+	}
+	for _, tt := range tests {
+		if got := shape(tt.in); got != tt.want {
+			t.Errorf("shape(%q) = %U, want %U", tt.in, []rune(got), []rune(tt.want))
+		}
+	}
+}
+
+func TestVisual(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"Order 15", "Order 15"},
+		{"مرحبا", "ﺎﺒﺣﺮﻣ"},
+		// An Arabic line reads right to left, so the amount after the label
+		// is drawn first, digits in their own order.
+		{"المجموع 12.50", "12.50 ﻉﻮﻤﺠﻤﻟﺍ"},
+		{"ضريبة 15%", "15% ﺔﺒﻳﺮﺿ"},
+		// A Latin line keeps its order, reversing only the Arabic run.
+		{"Latte لاتيه x2", "Latte ﻪﻴﺗﻻ x2"},
+		// Brackets are mirrored in right-to-left text.
+		{"(قهوة)", "(ﺓﻮﻬﻗ)"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := visual(tt.in); got != tt.want {
+			t.Errorf("visual(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestWrap(t *testing.T) {
+	tests := []struct {
+		in   string
+		n    int
+		want []string
+	}{
+		{"Iced latte", 32, []string{"Iced latte"}},
+		{"Iced caramel latte with oat milk", 12, []string{"Iced caramel", "latte with", "oat milk"}},
+		{"Supercalifragilistic", 8, []string{"Supercal", "ifragili", "stic"}},
+		// Widths are counted once shaped: the lam-alef ligature is one cell.
+		{"سلام", 3, []string{"سلام"}},
+	}
+	for _, tt := range tests {
+		got := wrap(tt.in, tt.n)
+		if len(got) != len(tt.want) {
+			t.Errorf("wrap(%q, %d) = %q, want %q", tt.in, tt.n, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("wrap(%q, %d) = %q, want %q", tt.in, tt.n, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestEncodeCP864(t *testing.T) {
+	if got := string(encodeCP864("Total 12.50")); got != "Total 12.50" {
+		t.Errorf("encodeCP864 changed ASCII text to %q", got)
+	}
+	// Every shaped letter has a byte in the code page, falling back to the
+	// isolated or initial form where the page lacks the one asked for.
+	for _, s := range []string{"محمد", "سلام", "المجموع"} {
+		for _, b := range encodeCP864(visual(s)) {
+			if b == '?' {
+				t.Errorf("encodeCP864(visual(%q)) could not encode a letter", s)
+			}
+		}
+	}
+}
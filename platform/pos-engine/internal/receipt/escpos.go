@@ -0,0 +1,211 @@
+package receipt
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"strings"
+	"unicode/utf8"
+)
+
+// ESC/POS commands.
+var (
+	escInit    = []byte{0x1B, '@'}
+	escBoldOn  = []byte{0x1B, 'E', 1}
+	escBoldOff = []byte{0x1B, 'E', 0}
+	escLarge   = []byte{0x1D, '!', 0x11}
+	escNormal  = []byte{0x1D, '!', 0x00}
+	escFeedCut = []byte{0x1B, 'd', 4, 0x1D, 'V', 66, 0}
+)
+
+// defaultCodePage is where Epson printers keep code page 864.
+const defaultCodePage = 37
+
+func escAlign(a align) []byte {
+	return []byte{0x1B, 'a', byte(a)}
+}
+
+// ESCPOS renders the receipt as a command stream for an ESC/POS thermal
+// printer. Text goes out in code page 864, with Arabic shaped and in display
+// order. The logo and QR code are sent as raster images, so they print the
+// same on printers with and without a QR command of their own.
+func ESCPOS(r Receipt, paper Paper) []byte {
+	cols := paper.columns()
+	codePage := r.Template.CodePage
+	if codePage == 0 {
+		codePage = defaultCodePage
+	}
+	var b bytes.Buffer
+	b.Write(escInit)
+	b.Write([]byte{0x1B, 't', byte(codePage)})
+	for _, l := range layout(r, cols) {
+		switch l.kind {
+		case lineRule:
+			b.WriteString(strings.Repeat("-", cols))
+			b.WriteByte('\n')
+		case lineLogo:
+			if r.Template.Logo == nil {
+				continue
+			}
+			b.Write(escAlign(alignCenter))
+			writeRaster(&b, logoBitmap(r.Template.Logo, paper.dots()))
+			b.Write(escAlign(alignLeft))
+		case lineQR:
+			q, err := encodeQR([]byte(r.QR))
+			if err != nil {
+				continue
+			}
+			b.Write(escAlign(alignCenter))
+			writeRaster(&b, qrBitmap(q, paper.dots()/2))
+			b.Write(escAlign(alignLeft))
+		case lineText:
+			if l.bold {
+				b.Write(escBoldOn)
+			}
+			if l.large {
+				// At double width the grid is half as wide, so the printer
+				// centres the text itself.
+				b.Write(escAlign(alignCenter))
+				b.Write(escLarge)
+				b.Write(encodeCP864(visual(l.cells[0].text)))
+				b.Write(escNormal)
+				b.Write(escAlign(alignLeft))
+			} else {
+				b.Write(encodeCP864(l.grid(cols, visual)))
+			}
+			if l.bold {
+				b.Write(escBoldOff)
+			}
+			b.WriteByte('\n')
+		}
+	}
+	b.Write(escFeedCut)
+	return b.Bytes()
+}
+
+// formOf maps each presentation form back to its letter and the position
+// of the form in arabicForms.
+var formOf = func() map[rune][2]rune {
+	m := make(map[rune][2]rune)
+	for letter, forms := range arabicForms {
+		for i, f := range forms {
+			if f != 0 {
+				m[f] = [2]rune{letter, rune(i)}
+			}
+		}
+	}
+	for alef, lig := range lamAlef {
+		m[lig[1]] = [2]rune{alef, -1}
+	}
+	return m
+}()
+
+// encodeCP864 encodes shaped text in code page 864. The code page lacks
+// most final and medial forms; a final form is printed as the isolated one
+// and a medial as the initial, which is how printers using it are meant to
+// be driven. Anything else outside the code page prints as '?'.
+func encodeCP864(s string) []byte {
+	out := make([]byte, 0, len(s))
+	for _, r := range s {
+		if r < utf8.RuneSelf {
+			out = append(out, byte(r))
+			continue
+		}
+		if c, ok := cp864[r]; ok {
+			out = append(out, c)
+			continue
+		}
+		if f, ok := formOf[r]; ok {
+			var alt rune
+			switch f[1] {
+			case -1:
+				alt = lamAlef[f[0]][0]
+			case 1:
+				alt = arabicForms[f[0]][0]
+			case 3:
+				alt = arabicForms[f[0]][2]
+			}
+			if c, ok := cp864[alt]; ok {
+				out = append(out, c)
+				continue
+			}
+		}
+		out = append(out, '?')
+	}
+	return out
+}
+
+// bitmap is a one bit image, true for a printed dot.
+type bitmap struct {
+	w, h int
+	dots []bool
+}
+
+// qrBitmap scales the code to whole dots per module, no wider than max,
+// with the quiet zone of four modules the standard asks for.
+func qrBitmap(q *qrCode, max int) bitmap {
+	n := q.size + 8
+	scale := max / n
+	if scale < 1 {
+		scale = 1
+	}
+	bm := bitmap{w: n * scale, h: n * scale}
+	bm.dots = make([]bool, bm.w*bm.h)
+	for y := 0; y < bm.h; y++ {
+		for x := 0; x < bm.w; x++ {
+			bm.dots[y*bm.w+x] = q.dark(x/scale-4, y/scale-4)
+		}
+	}
+	return bm
+}
+
+// logoBitmap scales img down to fit half the paper's width, keeping its
+// proportions, and thresholds it to black and white.
+func logoBitmap(img image.Image, paperDots int) bitmap {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w == 0 || h == 0 {
+		return bitmap{}
+	}
+	maxW := paperDots / 2
+	tw, th := w, h
+	if tw > maxW {
+		tw, th = maxW, h*maxW/w
+	}
+	if th < 1 {
+		th = 1
+	}
+	bm := bitmap{w: tw, h: th, dots: make([]bool, tw*th)}
+	for y := 0; y < th; y++ {
+		for x := 0; x < tw; x++ {
+			c := img.At(bounds.Min.X+x*w/tw, bounds.Min.Y+y*h/th)
+			g := color.GrayModel.Convert(c).(color.Gray)
+			_, _, _, a := c.RGBA()
+			bm.dots[y*tw+x] = a > 0x7FFF && g.Y < 128
+		}
+	}
+	return bm
+}
+
+// writeRaster prints bm with GS v 0, the raster bit image command.
+func writeRaster(b *bytes.Buffer, bm bitmap) {
+	if bm.w == 0 || bm.h == 0 {
+		return
+	}
+	rowBytes := (bm.w + 7) / 8
+	b.Write([]byte{0x1D, 'v', '0', 0,
+		byte(rowBytes), byte(rowBytes >> 8),
+		byte(bm.h), byte(bm.h >> 8)})
+	row := make([]byte, rowBytes)
+	for y := 0; y < bm.h; y++ {
+		for i := range row {
+			row[i] = 0
+		}
+		for x := 0; x < bm.w; x++ {
+			if bm.dots[y*bm.w+x] {
+				row[x/8] |= 0x80 >> (x % 8)
+			}
+		}
+		b.Write(row)
+	}
+}
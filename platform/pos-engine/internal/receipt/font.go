@@ -0,0 +1,234 @@
+package receipt
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Font is a TrueType font for Arabic text in PDF receipts. PDF's standard
+// fonts have no Arabic, so one is embedded in every PDF that prints it.
+type Font struct {
+	data       []byte
+	name       string
+	unitsPerEm int
+	ascent     int
+	descent    int
+	bbox       [4]int
+	advances   []int
+	glyphs     map[rune]uint16
+}
+
+// ParseFont reads the tables of a TrueType (.ttf) font that PDF output
+// needs: metrics, advance widths and the character to glyph map.
+func ParseFont(data []byte) (*Font, error) {
+	if len(data) < 12 {
+		return nil, errors.New("receipt: font is too short")
+	}
+	if v := binary.BigEndian.Uint32(data); v != 0x00010000 && v != 0x74727565 {
+		return nil, errors.New("receipt: not a TrueType font")
+	}
+	tables := make(map[string][]byte)
+	n := int(binary.BigEndian.Uint16(data[4:]))
+	for i := 0; i < n; i++ {
+		rec := 12 + i*16
+		if rec+16 > len(data) {
+			return nil, errors.New("receipt: font table directory is truncated")
+		}
+		tag := string(data[rec : rec+4])
+		off := int(binary.BigEndian.Uint32(data[rec+8:]))
+		length := int(binary.BigEndian.Uint32(data[rec+12:]))
+		if off < 0 || length < 0 || off+length > len(data) {
+			return nil, fmt.Errorf("receipt: font table %q is out of range", tag)
+		}
+		tables[tag] = data[off : off+length]
+	}
+	for _, tag := range []string{"head", "hhea", "maxp", "hmtx", "cmap"} {
+		if tables[tag] == nil {
+			return nil, fmt.Errorf("receipt: font has no %q table", tag)
+		}
+	}
+	head, hhea, maxp, hmtx := tables["head"], tables["hhea"], tables["maxp"], tables["hmtx"]
+	if len(head) < 54 || len(hhea) < 36 || len(maxp) < 6 {
+		return nil, errors.New("receipt: font header is truncated")
+	}
+	f := &Font{
+		data:       data,
+		name:       "ReceiptArabic",
+		unitsPerEm: int(binary.BigEndian.Uint16(head[18:])),
+		ascent:     int(int16(binary.BigEndian.Uint16(hhea[4:]))),
+		descent:    int(int16(binary.BigEndian.Uint16(hhea[6:]))),
+	}
+	for i := range f.bbox {
+		f.bbox[i] = int(int16(binary.BigEndian.Uint16(head[36+i*2:])))
+	}
+	if f.unitsPerEm == 0 {
+		return nil, errors.New("receipt: font has no units per em")
+	}
+
+	numGlyphs := int(binary.BigEndian.Uint16(maxp[4:]))
+	numMetrics := int(binary.BigEndian.Uint16(hhea[34:]))
+	if numMetrics == 0 || numMetrics > numGlyphs || len(hmtx) < numMetrics*4 {
+		return nil, errors.New("receipt: font metrics are truncated")
+	}
+	f.advances = make([]int, numGlyphs)
+	for g := range f.advances {
+		m := g
+		if m >= numMetrics {
+			m = numMetrics - 1
+		}
+		f.advances[g] = int(binary.BigEndian.Uint16(hmtx[m*4:]))
+	}
+
+	glyphs, err := parseCmap(tables["cmap"])
+	if err != nil {
+		return nil, err
+	}
+	f.glyphs = glyphs
+	if n := tables["name"]; n != nil {
+		if ps := postScriptName(n); ps != "" {
+			f.name = ps
+		}
+	}
+	return f, nil
+}
+
+// parseCmap reads the Unicode subtable of a cmap, preferring the full
+// repertoire format 12 over the Basic Multilingual Plane format 4.
+func parseCmap(cmap []byte) (map[rune]uint16, error) {
+	if len(cmap) < 4 {
+		return nil, errors.New("receipt: font cmap is truncated")
+	}
+	var best []byte
+	bestFormat := 0
+	n := int(binary.BigEndian.Uint16(cmap[2:]))
+	for i := 0; i < n; i++ {
+		rec := 4 + i*8
+		if rec+8 > len(cmap) {
+			break
+		}
+		platform := binary.BigEndian.Uint16(cmap[rec:])
+		encoding := binary.BigEndian.Uint16(cmap[rec+2:])
+		off := int(binary.BigEndian.Uint32(cmap[rec+4:]))
+		if off+2 > len(cmap) {
+			continue
+		}
+		isUnicode := platform == 0 || (platform == 3 && (encoding == 1 || encoding == 10))
+		format := int(binary.BigEndian.Uint16(cmap[off:]))
+		if isUnicode && (format == 4 || format == 12) && format > bestFormat {
+			best, bestFormat = cmap[off:], format
+		}
+	}
+	glyphs := make(map[rune]uint16)
+	switch bestFormat {
+	case 4:
+		if len(best) < 14 {
+			return nil, errors.New("receipt: font cmap is truncated")
+		}
+		segs := int(binary.BigEndian.Uint16(best[6:])) / 2
+		ends, starts := 14, 16+segs*2
+		deltas, ranges := starts+segs*2, starts+segs*4
+		if ranges+segs*2 > len(best) {
+			return nil, errors.New("receipt: font cmap is truncated")
+		}
+		for s := 0; s < segs; s++ {
+			end := int(binary.BigEndian.Uint16(best[ends+s*2:]))
+			start := int(binary.BigEndian.Uint16(best[starts+s*2:]))
+			delta := int(binary.BigEndian.Uint16(best[deltas+s*2:]))
+			rangeOff := int(binary.BigEndian.Uint16(best[ranges+s*2:]))
+			for c := start; c <= end && c != 0xFFFF; c++ {
+				var g int
+				if rangeOff == 0 {
+					g = (c + delta) & 0xFFFF
+				} else {
+					at := ranges + s*2 + rangeOff + (c-start)*2
+					if at+2 > len(best) {
+						continue
+					}
+					g = int(binary.BigEndian.Uint16(best[at:]))
+					if g != 0 {
+						g = (g + delta) & 0xFFFF
+					}
+				}
+				if g != 0 {
+					glyphs[rune(c)] = uint16(g)
+				}
+			}
+		}
+	case 12:
+		if len(best) < 16 {
+			return nil, errors.New("receipt: font cmap is truncated")
+		}
+		groups := int(binary.BigEndian.Uint32(best[12:]))
+		if 16+groups*12 > len(best) {
+			return nil, errors.New("receipt: font cmap is truncated")
+		}
+		for i := 0; i < groups; i++ {
+			g := best[16+i*12:]
+			start := binary.BigEndian.Uint32(g)
+			end := binary.BigEndian.Uint32(g[4:])
+			glyph := binary.BigEndian.Uint32(g[8:])
+			if end > 0x10FFFF || end < start {
+				continue
+			}
+			for c := start; c <= end; c++ {
+				glyphs[rune(c)] = uint16(glyph + c - start)
+			}
+		}
+	default:
+		return nil, errors.New("receipt: font has no Unicode cmap")
+	}
+	return glyphs, nil
+}
+
+// postScriptName reads name ID 6 from the name table, if it is stored in
+// a form PDF can use as a font name.
+func postScriptName(name []byte) string {
+	if len(name) < 6 {
+		return ""
+	}
+	count := int(binary.BigEndian.Uint16(name[2:]))
+	storage := int(binary.BigEndian.Uint16(name[4:]))
+	for i := 0; i < count; i++ {
+		rec := 6 + i*12
+		if rec+12 > len(name) {
+			break
+		}
+		platform := binary.BigEndian.Uint16(name[rec:])
+		id := binary.BigEndian.Uint16(name[rec+6:])
+		length := int(binary.BigEndian.Uint16(name[rec+8:]))
+		off := storage + int(binary.BigEndian.Uint16(name[rec+10:]))
+		if id != 6 || off+length > len(name) {
+			continue
+		}
+		raw := name[off : off+length]
+		var s []byte
+		if platform == 1 {
+			s = raw
+		} else {
+			for j := 1; j < len(raw); j += 2 {
+				s = append(s, raw[j])
+			}
+		}
+		for _, c := range s {
+			if c <= ' ' || c > '~' || c == '/' || c == '(' || c == ')' || c == '[' || c == ']' || c == '<' || c == '>' || c == '{' || c == '}' || c == '%' {
+				return ""
+			}
+		}
+		return string(s)
+	}
+	return ""
+}
+
+// glyph returns the glyph for r, or 0, the font's missing glyph.
+func (f *Font) glyph(r rune) uint16 {
+	return f.glyphs[r]
+}
+
+// advance is the width of glyph g in font units.
+func (f *Font) advance(g uint16) int {
+	if int(g) < len(f.advances) {
+		return f.advances[g]
+	}
+	return 0
+}
@@ -0,0 +1,152 @@
+package receipt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"image/png"
+	"strings"
+)
+
+var htmlTemplate = template.Must(template.New("receipt").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { margin: 0; background: #fff; color: #000; }
+.receipt { width: {{.Cols}}ch; margin: 0 auto; padding: 1ch; font: 13px/1.4 "Courier New", monospace; }
+.line { position: relative; height: 1.4em; white-space: pre; }
+.line span { position: absolute; overflow: hidden; }
+.bold { font-weight: bold; }
+.large { height: auto; font-size: 2em; text-align: center; white-space: normal; }
+.rule { border-top: 1px dashed #000; margin: 0.7em 0; }
+.image { text-align: center; margin: 0.5em 0; }
+.image img { max-width: 50%; }
+.image svg { width: 50%; }
+@media print { .receipt { padding: 0; } }
+</style>
+</head>
+<body>
+<div class="receipt">
+{{- range .Lines}}
+{{- if .Rule}}
+<div class="rule"></div>
+{{- else if .Image}}
+<div class="image">{{.Image}}</div>
+{{- else if .Large}}
+<div class="line large bold"{{if .Large.RTL}} dir="rtl"{{end}}>{{.Large.Text}}</div>
+{{- else}}
+<div class="line{{if .Bold}} bold{{end}}">
+{{- range .Cells}}<span style="left:{{.Col}}ch;width:{{.Width}}ch;text-align:{{.Align}}"{{if .RTL}} dir="rtl"{{end}}>{{.Text}}</span>{{end -}}
+</div>
+{{- end}}
+{{- end}}
+</div>
+</body>
+</html>
+`))
+
+type htmlCell struct {
+	Text       string
+	Col, Width int
+	Align      string
+	RTL        bool
+}
+
+type htmlLine struct {
+	Rule  bool
+	Image template.HTML
+	Large *htmlCell
+	Bold  bool
+	Cells []htmlCell
+}
+
+// HTML renders the receipt as a standalone page sized to the paper, for
+// on-screen display, email and browser printing. Text sits on the same
+// character grid as the printed receipt; Arabic cells are marked
+// right-to-left and shaped by the browser.
+func HTML(r Receipt, paper Paper) ([]byte, error) {
+	cols := paper.columns()
+	var lines []htmlLine
+	for _, l := range layout(r, cols) {
+		switch l.kind {
+		case lineRule:
+			lines = append(lines, htmlLine{Rule: true})
+		case lineLogo:
+			img, err := logoHTML(r.Template)
+			if err != nil {
+				return nil, err
+			}
+			lines = append(lines, htmlLine{Image: img})
+		case lineQR:
+			q, err := encodeQR([]byte(r.QR))
+			if err != nil {
+				return nil, err
+			}
+			lines = append(lines, htmlLine{Image: qrSVG(q)})
+		case lineText:
+			if l.large {
+				c := l.cells[0]
+				lines = append(lines, htmlLine{Large: &htmlCell{Text: c.text, RTL: hasArabic(c.text)}})
+				continue
+			}
+			hl := htmlLine{Bold: l.bold}
+			for _, c := range l.cells {
+				hl.Cells = append(hl.Cells, htmlCell{
+					Text:  c.text,
+					Col:   c.col,
+					Width: c.width,
+					Align: [...]string{"left", "center", "right"}[c.align],
+					RTL:   hasArabic(c.text),
+				})
+			}
+			lines = append(lines, hl)
+		}
+	}
+	title := r.Title
+	if title == "" {
+		title = "Receipt"
+	}
+	var b bytes.Buffer
+	err := htmlTemplate.Execute(&b, struct {
+		Title string
+		Cols  int
+		Lines []htmlLine
+	}{fmt.Sprintf("%s #%s", title, r.OrderNumber), cols, lines})
+	if err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// logoHTML embeds the decoded logo as a PNG, or links to its URL.
+func logoHTML(t Template) (template.HTML, error) {
+	src := template.HTMLEscapeString(t.LogoURL)
+	if t.Logo != nil {
+		var b bytes.Buffer
+		if err := png.Encode(&b, t.Logo); err != nil {
+			return "", err
+		}
+		src = "data:image/png;base64," + base64.StdEncoding.EncodeToString(b.Bytes())
+	}
+	return template.HTML(`<img src="` + src + `" alt="">`), nil
+}
+
+// qrSVG draws the code as an SVG path of one unit square per dark module,
+// inside a four module quiet zone.
+func qrSVG(q *qrCode) template.HTML {
+	n := q.size + 8
+	var path strings.Builder
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; x++ {
+			if q.dark(x, y) {
+				fmt.Fprintf(&path, "M%d %dh1v1h-1z", x+4, y+4)
+			}
+		}
+	}
+	return template.HTML(fmt.Sprintf(
+		`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" shape-rendering="crispEdges"><rect width="%d" height="%d" fill="#fff"/><path d="%s" fill="#000"/></svg>`,
+		n, n, n, n, path.String()))
+}
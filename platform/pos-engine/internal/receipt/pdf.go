@@ -0,0 +1,295 @@
+package receipt
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"sort"
+	"strings"
+)
+
+// PDF layout, in points.
+const (
+	pdfMargin     = 8.0
+	pdfLineFactor = 1.3
+	// courierAdvance is the width of every Courier glyph, in ems.
+	courierAdvance = 0.6
+)
+
+// pageWidth is the paper's width in points.
+func (p Paper) pageWidth() float64 {
+	return float64(p) / 25.4 * 72
+}
+
+// PDF renders the receipt as a single page PDF as wide as the paper and as
+// long as the receipt, for archiving and for printers driven through a
+// print dialog. Latin text is set in Courier on the same character grid as
+// the printed receipt. Arabic is set in font, which is embedded; with a nil
+// font Arabic text is left out.
+func PDF(r Receipt, paper Paper, font *Font) ([]byte, error) {
+	cols := paper.columns()
+	pageW := paper.pageWidth()
+	charW := (pageW - 2*pdfMargin) / float64(cols)
+	size := charW / courierAdvance
+	lead := size * pdfLineFactor
+
+	w := &pdfWriter{}
+	var content bytes.Buffer
+	var ops []func(top float64)
+	used := make(map[uint16]bool)
+	y := 0.0
+	var logo *pdfImage
+
+	for _, l := range layout(r, cols) {
+		at := y
+		switch l.kind {
+		case lineRule:
+			ops = append(ops, func(top float64) {
+				ly := top - at - lead/2
+				fmt.Fprintf(&content, "[1 1] 0 d 0.5 w %.2f %.2f m %.2f %.2f l S [] 0 d\n", pdfMargin, ly, pageW-pdfMargin, ly)
+			})
+			y += lead
+		case lineLogo:
+			if r.Template.Logo == nil {
+				continue
+			}
+			img := pdfRGB(r.Template.Logo)
+			if img == nil {
+				continue
+			}
+			logo = img
+			dw := (pageW - 2*pdfMargin) / 2
+			if iw := float64(img.w) * 0.75; iw < dw {
+				dw = iw
+			}
+			dh := dw * float64(img.h) / float64(img.w)
+			ops = append(ops, func(top float64) {
+				fmt.Fprintf(&content, "q %.2f 0 0 %.2f %.2f %.2f cm /Logo Do Q\n", dw, dh, (pageW-dw)/2, top-at-dh)
+			})
+			y += dh + lead/2
+		case lineQR:
+			q, err := encodeQR([]byte(r.QR))
+			if err != nil {
+				return nil, err
+			}
+			side := (pageW - 2*pdfMargin) / 2
+			module := side / float64(q.size+8)
+			ops = append(ops, func(top float64) {
+				x0 := (pageW-side)/2 + 4*module
+				y0 := top - at - 4*module
+				for qy := 0; qy < q.size; qy++ {
+					for qx := 0; qx < q.size; qx++ {
+						if q.dark(qx, qy) {
+							fmt.Fprintf(&content, "%.2f %.2f %.2f %.2f re\n", x0+float64(qx)*module, y0-float64(qy+1)*module, module, module)
+						}
+					}
+				}
+				content.WriteString("f\n")
+			})
+			y += side
+		case lineText:
+			fontSize, height := size, lead
+			cells := l.cells
+			if l.large {
+				// Double size text: the grid is half as wide.
+				fontSize, height = size*2, lead*2
+				c := cells[0]
+				cells = []cell{{text: c.text, col: 0, width: cols / 2, align: alignCenter}}
+			}
+			scale := fontSize / size
+			latin := "/F1"
+			if l.bold {
+				latin = "/F2"
+			}
+			ops = append(ops, func(top float64) {
+				base := top - at - height + (height-fontSize)/2 + fontSize*0.2
+				for _, c := range cells {
+					left := pdfMargin + float64(c.col)*charW*scale
+					right := left + float64(c.width)*charW*scale
+					if !hasArabic(c.text) {
+						tw := float64(width(c.text)) * charW * scale
+						fmt.Fprintf(&content, "BT %s %.2f Tf %.2f %.2f Td (%s) Tj ET\n", latin, fontSize, alignX(c.align, left, right, tw), base, pdfString(c.text))
+						continue
+					}
+					if font == nil {
+						continue
+					}
+					var gids []uint16
+					units := 0
+					for _, ch := range visual(c.text) {
+						g := font.glyph(ch)
+						gids = append(gids, g)
+						used[g] = true
+						units += font.advance(g)
+					}
+					tw := float64(units) * fontSize / float64(font.unitsPerEm)
+					var hex strings.Builder
+					for _, g := range gids {
+						fmt.Fprintf(&hex, "%04X", g)
+					}
+					fmt.Fprintf(&content, "BT /F3 %.2f Tf %.2f %.2f Td <%s> Tj ET\n", fontSize, alignX(c.align, left, right, tw), base, hex.String())
+				}
+			})
+			y += height
+		}
+	}
+
+	pageH := y + 2*pdfMargin
+	for _, op := range ops {
+		op(pageH - pdfMargin)
+	}
+
+	// Objects 1 and 2 are the catalog and page tree; the page refers to
+	// the rest.
+	w.reserve(2)
+	resources := "/Font << /F1 << /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >> " +
+		"/F2 << /Type /Font /Subtype /Type1 /BaseFont /Courier-Bold /Encoding /WinAnsiEncoding >>"
+	if font != nil && len(used) > 0 {
+		resources += fmt.Sprintf(" /F3 %d 0 R", w.font(font, used))
+	}
+	resources += " >>"
+	if logo != nil {
+		resources += fmt.Sprintf(" /XObject << /Logo %d 0 R >>", w.image(logo))
+	}
+	contents := w.stream("", content.Bytes())
+	page := w.object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] /Resources << %s >> /Contents %d 0 R >>", pageW, pageH, resources, contents))
+	w.set(1, "<< /Type /Catalog /Pages 2 0 R >>")
+	w.set(2, fmt.Sprintf("<< /Type /Pages /Kids [%d 0 R] /Count 1 >>", page))
+	return w.bytes(), nil
+}
+
+func alignX(a align, left, right, textWidth float64) float64 {
+	switch a {
+	case alignCenter:
+		return left + (right-left-textWidth)/2
+	case alignRight:
+		return right - textWidth
+	}
+	return left
+}
+
+// pdfString escapes s for a PDF literal string in WinAnsiEncoding. Latin-1
+// characters map straight through; anything else prints as '?'.
+func pdfString(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '\\' || r == '(' || r == ')':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 0x80:
+			b.WriteRune(r)
+		case r >= 0xA0 && r <= 0xFF:
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+// pdfWriter collects numbered objects and writes them out with their
+// cross-reference table.
+type pdfWriter struct {
+	objects [][]byte
+}
+
+func (w *pdfWriter) reserve(n int) {
+	for i := 0; i < n; i++ {
+		w.objects = append(w.objects, nil)
+	}
+}
+
+func (w *pdfWriter) set(id int, body string) {
+	w.objects[id-1] = []byte(body)
+}
+
+func (w *pdfWriter) object(body string) int {
+	w.objects = append(w.objects, []byte(body))
+	return len(w.objects)
+}
+
+// stream adds a Flate compressed stream with extra dictionary entries.
+func (w *pdfWriter) stream(dict string, data []byte) int {
+	var z bytes.Buffer
+	zw := zlib.NewWriter(&z)
+	zw.Write(data)
+	zw.Close()
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "<< %s /Filter /FlateDecode /Length %d >>\nstream\n", dict, z.Len())
+	b.Write(z.Bytes())
+	b.WriteString("\nendstream")
+	w.objects = append(w.objects, b.Bytes())
+	return len(w.objects)
+}
+
+// font embeds f as a composite font addressed by glyph ID, with widths for
+// the glyphs used.
+func (w *pdfWriter) font(f *Font, used map[uint16]bool) int {
+	file := w.stream(fmt.Sprintf("/Length1 %d", len(f.data)), f.data)
+	em := func(v int) int { return v * 1000 / f.unitsPerEm }
+	descriptor := w.object(fmt.Sprintf(
+		"<< /Type /FontDescriptor /FontName /%s /Flags 4 /FontBBox [%d %d %d %d] /ItalicAngle 0 /Ascent %d /Descent %d /CapHeight %d /StemV 80 /FontFile2 %d 0 R >>",
+		f.name, em(f.bbox[0]), em(f.bbox[1]), em(f.bbox[2]), em(f.bbox[3]), em(f.ascent), em(f.descent), em(f.ascent), file))
+	gids := make([]int, 0, len(used))
+	for g := range used {
+		gids = append(gids, int(g))
+	}
+	sort.Ints(gids)
+	var widths strings.Builder
+	for _, g := range gids {
+		fmt.Fprintf(&widths, "%d [%d] ", g, em(f.advance(uint16(g))))
+	}
+	cid := w.object(fmt.Sprintf(
+		"<< /Type /Font /Subtype /CIDFontType2 /BaseFont /%s /CIDSystemInfo << /Registry (Adobe) /Ordering (Identity) /Supplement 0 >> /FontDescriptor %d 0 R /CIDToGIDMap /Identity /DW 0 /W [%s] >>",
+		f.name, descriptor, widths.String()))
+	return w.object(fmt.Sprintf("<< /Type /Font /Subtype /Type0 /BaseFont /%s /Encoding /Identity-H /DescendantFonts [%d 0 R] >>", f.name, cid))
+}
+
+// pdfImage is an 8 bit RGB image flattened onto white.
+type pdfImage struct {
+	w, h int
+	rgb  []byte
+}
+
+func pdfRGB(img image.Image) *pdfImage {
+	b := img.Bounds()
+	if b.Dx() == 0 || b.Dy() == 0 {
+		return nil
+	}
+	p := &pdfImage{w: b.Dx(), h: b.Dy(), rgb: make([]byte, 0, b.Dx()*b.Dy()*3)}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, a := img.At(x, y).RGBA()
+			// Colours are premultiplied by alpha; add the white showing
+			// through.
+			white := 0xFFFF - a
+			p.rgb = append(p.rgb, byte((r+white)>>8), byte((g+white)>>8), byte((bl+white)>>8))
+		}
+	}
+	return p
+}
+
+func (w *pdfWriter) image(img *pdfImage) int {
+	return w.stream(fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceRGB /BitsPerComponent 8", img.w, img.h), img.rgb)
+}
+
+func (w *pdfWriter) bytes() []byte {
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.4\n%\xE2\xE3\xCF\xD3\n")
+	offsets := make([]int, len(w.objects))
+	for i, obj := range w.objects {
+		offsets[i] = b.Len()
+		fmt.Fprintf(&b, "%d 0 obj\n", i+1)
+		b.Write(obj)
+		b.WriteString("\nendobj\n")
+	}
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(w.objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(w.objects)+1, xref)
+	return b.Bytes()
+}
@@ -0,0 +1,167 @@
+package receipt
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestQRDataCodewords(t *testing.T) {
+	// Level M data codewords from the version tables of ISO/IEC 18004.
+	want := map[int]int{1: 16, 2: 28, 3: 44, 4: 64, 5: 86, 7: 124, 10: 216, 40: 2334}
+	for v, n := range want {
+		if got := qrDataCodewords(v); got != n {
+			t.Errorf("qrDataCodewords(%d) = %d, want %d", v, got, n)
+		}
+	}
+}
+
+func TestRSRemainder(t *testing.T) {
+	// "HELLO WORLD" as a 1-M symbol, the standard worked example.
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	want := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+	if got := rsRemainder(data, rsDivisor(10)); !bytes.Equal(got, want) {
+		t.Errorf("rsRemainder = %v, want %v", got, want)
+	}
+}
+
+// readQR decodes a symbol encodeQR drew: it reads the mask from the format
+// bits, undoes it, collects the codewords, checks each block's error
+// correction and returns the byte-mode payload.
+func readQR(t *testing.T, q *qrCode) []byte {
+	t.Helper()
+	version := (q.size - 17) / 4
+	var format int
+	for i := 14; i >= 9; i-- {
+		format = format<<1 | b2i(q.modules[8][14-i])
+	}
+	format = format<<1 | b2i(q.modules[8][7])
+	format = format<<1 | b2i(q.modules[8][8])
+	format = format<<1 | b2i(q.modules[7][8])
+	for i := 5; i >= 0; i-- {
+		format = format<<1 | b2i(q.modules[i][8])
+	}
+	format ^= 0x5412
+	if level := format >> 13; level != qrFormatM {
+		t.Fatalf("error correction level bits = %02b, want M", level)
+	}
+	q.applyMask(format >> 10 & 7)
+	defer q.applyMask(format >> 10 & 7)
+
+	raw := make([]byte, qrRawModules(version)/8)
+	i := 0
+	for right := q.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < q.size; vert++ {
+			for j := 0; j < 2; j++ {
+				x, y := right-j, vert
+				if (right+1)&2 == 0 {
+					y = q.size - 1 - vert
+				}
+				if !q.isFunc[y][x] && i < len(raw)*8 {
+					if q.modules[y][x] {
+						raw[i>>3] |= 1 << (7 - uint(i&7))
+					}
+					i++
+				}
+			}
+		}
+	}
+
+	// Undo the interleaving: data codewords go round the blocks in turn,
+	// short blocks dropping out for the last one, then the error correction.
+	numBlocks, eccLen := qrECCBlocks[version], qrECCPerBlock[version]
+	numShort := numBlocks - len(raw)%numBlocks
+	shortData := len(raw)/numBlocks - eccLen
+	blocks := make([][]byte, numBlocks)
+	k := 0
+	for n := 0; n <= shortData; n++ {
+		for b := range blocks {
+			if n < shortData || b >= numShort {
+				blocks[b] = append(blocks[b], raw[k])
+				k++
+			}
+		}
+	}
+	var data []byte
+	divisor := rsDivisor(eccLen)
+	for b := range blocks {
+		ecc := make([]byte, eccLen)
+		for e := range ecc {
+			ecc[e] = raw[k+b+e*numBlocks]
+		}
+		if got := rsRemainder(blocks[b], divisor); !bytes.Equal(got, ecc) {
+			t.Fatalf("block %d error correction = %v, want %v", b, ecc, got)
+		}
+		data = append(data, blocks[b]...)
+	}
+
+	if data[0]>>4 != 0x4 {
+		t.Fatalf("mode = %x, want byte mode", data[0]>>4)
+	}
+	bits := func(from, n int) int {
+		v := 0
+		for i := from; i < from+n; i++ {
+			v = v<<1 | int(data[i>>3]>>(7-uint(i&7))&1)
+		}
+		return v
+	}
+	countBits := 8
+	if version > 9 {
+		countBits = 16
+	}
+	length := bits(4, countBits)
+	out := make([]byte, length)
+	for i := range out {
+		out[i] = byte(bits(4+countBits+8*i, 8))
+	}
+	return out
+}
+
+func b2i(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func TestEncodeQR(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		size int
+	}{
+		{"fits version 1", strings.Repeat("a", 14), 21},
+		{"needs version 2", strings.Repeat("a", 15), 25},
+		{"two blocks", strings.Repeat("b", 80), 37},
+		{"short and long blocks", strings.Repeat("c", 150), 49},
+		{"ZATCA-sized TLV", strings.Repeat("z", 400), 77},
+	}
+	for _, tt := range tests {
+		q, err := encodeQR([]byte(tt.data))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if q.size != tt.size {
+			t.Errorf("%s: size = %d, want %d", tt.name, q.size, tt.size)
+		}
+		for _, c := range [][2]int{{0, 0}, {q.size - 7, 0}, {0, q.size - 7}} {
+			if !q.dark(c[0], c[1]) || !q.dark(c[0]+6, c[1]+6) || q.dark(c[0]+1, c[1]+1) || !q.dark(c[0]+3, c[1]+3) {
+				t.Errorf("%s: no finder pattern at %v", tt.name, c)
+			}
+		}
+		if got := readQR(t, q); string(got) != tt.data {
+			t.Errorf("%s: decoded %q, want %q", tt.name, got, tt.data)
+		}
+	}
+
+	if _, err := encodeQR(make([]byte, 2331)); err != nil {
+		t.Errorf("encodeQR of a full version 40: %v", err)
+	}
+	if _, err := encodeQR(make([]byte, 2332)); err != ErrQRTooLong {
+		t.Errorf("encodeQR of too much data = %v, want ErrQRTooLong", err)
+	}
+}
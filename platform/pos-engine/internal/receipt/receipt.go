@@ -0,0 +1,359 @@
+// Package receipt renders order receipts as plain text, ESC/POS printer
+// commands, HTML and PDF.
+//
+// Every format is drawn from one layout laid out on a grid of character
+// columns, 32 for 58mm paper and 48 for 80mm, so a receipt reads the same
+// however it is printed. English and Arabic are printed side by side: item
+// and label text in both languages, amounts once. Arabic is kept in logical
+// order where the viewer lays text out itself (plain text and HTML) and is
+// shaped and put in display order for printers and PDF, which cannot.
+package receipt
+
+import (
+	"fmt"
+	"image"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/berhot/products/commerce/pos-engine/internal/money"
+)
+
+// Paper is a receipt roll width.
+type Paper int
+
+const (
+	Paper58 Paper = 58
+	Paper80 Paper = 80
+)
+
+// columns is the number of characters a line of the paper holds in the
+// printer's standard font.
+func (p Paper) columns() int {
+	if p == Paper58 {
+		return 32
+	}
+	return 48
+}
+
+// dots is the printable width in dots at 203 dpi.
+func (p Paper) dots() int {
+	if p == Paper58 {
+		return 384
+	}
+	return 576
+}
+
+// Template is a tenant's customisation of its receipts.
+type Template struct {
+	StoreName   string
+	StoreNameAr string
+	// Logo is printed at the top. HTML links to LogoURL when there is no
+	// decoded Logo.
+	Logo    image.Image
+	LogoURL string
+	Header  []string
+	Footer  []string
+	// VATNumber is printed under the header when set.
+	VATNumber string
+	// Arabic prints Arabic text alongside English.
+	Arabic bool
+	// ShowQR prints the receipt's QR code.
+	ShowQR bool
+	// CodePage is the ESC/POS code page number (ESC t n) under which the
+	// printer holds IBM code page 864. Epson models use 37; other makers
+	// vary.
+	CodePage int
+}
+
+// Modifier is a choice made on an item.
+type Modifier struct {
+	Name, NameAr string
+	Price        money.Amount
+}
+
+// Item is one line of the order.
+type Item struct {
+	Name, NameAr string
+	Quantity     float64
+	UnitPrice    money.Amount
+	Discount     money.Amount
+	Total        money.Amount
+	Modifiers    []Modifier
+}
+
+// TaxLine is one VAT rate's share of the order.
+type TaxLine struct {
+	Label, LabelAr string
+	Taxable, Tax   money.Amount
+}
+
+// Payment is one tender against the order. Tendered and Change are set for
+// cash handed over.
+type Payment struct {
+	Method           string
+	Amount, Tendered money.Amount
+	Change           money.Amount
+}
+
+// Receipt is everything printed for an order.
+type Receipt struct {
+	Template         Template
+	Title, TitleAr   string
+	OrderNumber      string
+	IssuedAt         time.Time
+	Currency         string
+	Items            []Item
+	Subtotal         money.Amount
+	Discount         money.Amount
+	Tax              money.Amount
+	Total            money.Amount
+	PricesIncludeTax bool
+	Taxes            []TaxLine
+	Payments         []Payment
+	// QR is the payload of the QR code, normally the ZATCA TLV.
+	QR string
+}
+
+// methodLabels names payment methods in English and Arabic.
+var methodLabels = map[string][2]string{
+	"cash":           {"Cash", "نقدا"},
+	"card":           {"Card", "بطاقة"},
+	"mobile_pay":     {"Mobile pay", "الدفع بالجوال"},
+	"online":         {"Online", "دفع إلكتروني"},
+	"loyalty_points": {"Loyalty points", "نقاط الولاء"},
+	"gift_card":      {"Gift card", "بطاقة هدية"},
+	"store_credit":   {"Store credit", "رصيد المتجر"},
+}
+
+type align int
+
+const (
+	alignLeft align = iota
+	alignCenter
+	alignRight
+)
+
+// cell is text placed in a span of columns.
+type cell struct {
+	text       string
+	col, width int
+	align      align
+}
+
+type lineKind int
+
+const (
+	lineText lineKind = iota
+	lineRule
+	lineQR
+	lineLogo
+)
+
+// line is one row of the layout. A large line is printed at double width
+// and height and holds a single centred cell.
+type line struct {
+	kind        lineKind
+	cells       []cell
+	bold, large bool
+}
+
+// layout lays the receipt out on a grid cols characters wide.
+func layout(r Receipt, cols int) []line {
+	t := r.Template
+	var lines []line
+	text := func(s string, a align, bold bool) {
+		for _, part := range wrap(s, cols) {
+			lines = append(lines, line{kind: lineText, bold: bold, cells: []cell{{text: part, width: cols, align: a}}})
+		}
+	}
+	ar := func(s string, a align) {
+		if t.Arabic && s != "" {
+			text(s, a, false)
+		}
+	}
+	pair := func(left, right string, bold bool) {
+		rw := width(right)
+		if width(left)+1+rw > cols {
+			text(left, alignLeft, bold)
+			text(right, alignRight, bold)
+			return
+		}
+		lines = append(lines, line{kind: lineText, bold: bold, cells: []cell{
+			{text: left, width: cols - rw - 1},
+			{text: right, col: cols - rw, width: rw, align: alignRight},
+		}})
+	}
+	// labeled prints an English label and the amount, with the Arabic label
+	// between them when it fits or on a line of its own when not.
+	labeled := func(en, arLabel string, amount money.Amount, bold bool) {
+		amt := amount.String()
+		if !t.Arabic || arLabel == "" {
+			pair(en, amt, bold)
+			return
+		}
+		ew, aw, mw := width(en), width(arLabel), width(amt)
+		if ew+1+aw+2+mw > cols {
+			pair(en, amt, bold)
+			text(arLabel, alignRight, false)
+			return
+		}
+		lines = append(lines, line{kind: lineText, bold: bold, cells: []cell{
+			{text: en, width: ew},
+			{text: arLabel, col: cols - mw - 2 - aw, width: aw, align: alignRight},
+			{text: amt, col: cols - mw, width: mw, align: alignRight},
+		}})
+	}
+	rule := func() { lines = append(lines, line{kind: lineRule}) }
+
+	if t.Logo != nil || t.LogoURL != "" {
+		lines = append(lines, line{kind: lineLogo})
+	}
+	if t.StoreName != "" {
+		for _, part := range wrap(t.StoreName, cols/2) {
+			lines = append(lines, line{kind: lineText, bold: true, large: true, cells: []cell{{text: part, width: cols, align: alignCenter}}})
+		}
+	}
+	ar(t.StoreNameAr, alignCenter)
+	for _, h := range t.Header {
+		text(h, alignCenter, false)
+	}
+	if t.VATNumber != "" {
+		text("VAT No. "+t.VATNumber, alignCenter, false)
+		ar("الرقم الضريبي "+t.VATNumber, alignCenter)
+	}
+	rule()
+
+	if r.Title != "" {
+		text(r.Title, alignCenter, true)
+	}
+	ar(r.TitleAr, alignCenter)
+	pair("#"+r.OrderNumber, r.IssuedAt.Format("2006-01-02 15:04"), false)
+	rule()
+
+	for _, it := range r.Items {
+		text(it.Name, alignLeft, true)
+		ar(it.NameAr, alignRight)
+		pair(fmt.Sprintf("  %s x %s", formatQuantity(it.Quantity), it.UnitPrice), it.Total.String(), false)
+		for _, m := range it.Modifiers {
+			if m.Price != 0 {
+				labeled("  + "+m.Name, m.NameAr, m.Price, false)
+			} else {
+				text("  + "+m.Name, alignLeft, false)
+			}
+		}
+		if it.Discount > 0 {
+			labeled("  Discount", "خصم", -it.Discount, false)
+		}
+	}
+	rule()
+
+	labeled("Subtotal", "المجموع الفرعي", r.Subtotal, false)
+	if r.Discount > 0 {
+		labeled("Discount", "الخصم", -r.Discount, false)
+	}
+	for _, tx := range r.Taxes {
+		labeled(tx.Label, tx.LabelAr, tx.Tax, false)
+	}
+	labeled("Total "+r.Currency, "الإجمالي", r.Total, true)
+	if r.PricesIncludeTax {
+		text("Prices include VAT", alignCenter, false)
+		ar("الأسعار شاملة ضريبة القيمة المضافة", alignCenter)
+	}
+
+	if len(r.Payments) > 0 {
+		rule()
+		for _, p := range r.Payments {
+			label, ok := methodLabels[p.Method]
+			if !ok {
+				label = [2]string{p.Method, ""}
+			}
+			labeled(label[0], label[1], p.Amount, false)
+			if p.Tendered > 0 {
+				labeled("  Tendered", "المبلغ المدفوع", p.Tendered, false)
+			}
+			if p.Change > 0 {
+				labeled("  Change", "الباقي", p.Change, false)
+			}
+		}
+	}
+
+	if t.ShowQR && r.QR != "" {
+		rule()
+		lines = append(lines, line{kind: lineQR})
+	}
+	if len(t.Footer) > 0 {
+		rule()
+		for _, f := range t.Footer {
+			text(f, alignCenter, false)
+		}
+	}
+	return lines
+}
+
+// wrap breaks s into lines of at most n columns, at spaces where it can.
+func wrap(s string, n int) []string {
+	s = strings.TrimSpace(s)
+	if width(s) <= n {
+		return []string{s}
+	}
+	var out []string
+	cur := ""
+	for _, word := range strings.Fields(s) {
+		for width(word) > n {
+			if cur != "" {
+				out = append(out, cur)
+				cur = ""
+			}
+			runes := []rune(word)
+			out = append(out, string(runes[:n]))
+			word = string(runes[n:])
+		}
+		switch {
+		case cur == "":
+			cur = word
+		case width(cur)+1+width(word) <= n:
+			cur += " " + word
+		default:
+			out = append(out, cur)
+			cur = word
+		}
+	}
+	if cur != "" {
+		out = append(out, cur)
+	}
+	return out
+}
+
+func formatQuantity(q float64) string {
+	return strconv.FormatFloat(q, 'f', -1, 64)
+}
+
+// grid lays a line's cells out as one string of cols characters. Each
+// cell's text is first passed through conv, which puts Arabic in the order
+// the output wants.
+func (l line) grid(cols int, conv func(string) string) string {
+	var b strings.Builder
+	pos := 0
+	for _, c := range l.cells {
+		s := conv(c.text)
+		w := width(c.text)
+		if w > c.width {
+			w = c.width
+		}
+		start := c.col
+		switch c.align {
+		case alignCenter:
+			start += (c.width - w) / 2
+		case alignRight:
+			start += c.width - w
+		}
+		if start < pos {
+			start = pos
+		}
+		b.WriteString(strings.Repeat(" ", start-pos))
+		b.WriteString(s)
+		pos = start + w
+	}
+	return strings.TrimRight(b.String(), " ")
+}
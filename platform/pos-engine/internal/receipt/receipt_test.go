@@ -0,0 +1,97 @@
+package receipt
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func testReceipt(arabic bool) Receipt {
+	return Receipt{
+		Template: Template{
+			StoreName: "Berhot Cafe", StoreNameAr: "مقهى برهوت", VATNumber: "310122393500003",
+			Arabic: arabic, ShowQR: true,
+		},
+		OrderNumber: "ORD-1",
+		IssuedAt:    time.Date(2026, 3, 1, 9, 30, 0, 0, time.UTC),
+		Currency:    "SAR",
+		Items: []Item{{
+			Name: "Iced caramel latte with an extra shot", NameAr: "لاتيه كراميل مثلج", Quantity: 2, UnitPrice: 1000, Total: 2000,
+			Modifiers: []Modifier{{Name: "Oat milk", NameAr: "حليب الشوفان", Price: 200}},
+		}},
+		Subtotal: 2000,
+		Tax:      300,
+		Total:    2300,
+		Taxes:    []TaxLine{{Label: "VAT 15%", LabelAr: "ضريبة 15%", Taxable: 2000, Tax: 300}},
+		Payments: []Payment{{Method: "cash", Amount: 2300, Tendered: 5000, Change: 2700}},
+		QR:       "AQtCZXJob3QgQ2FmZQ==",
+	}
+}
+
+func TestText(t *testing.T) {
+	for _, paper := range []Paper{Paper58, Paper80} {
+		out := Text(testReceipt(true), paper)
+		for _, l := range strings.Split(strings.TrimSuffix(out, "\n"), "\n") {
+			if w := width(l); w > paper.columns() {
+				t.Errorf("%dmm: line %q is %d columns, more than %d", paper, l, w, paper.columns())
+			}
+		}
+		for _, want := range []string{"Berhot Cafe", "مقهى برهوت", "#ORD-1", "23.00", "الإجمالي", "27.00", "حليب الشوفان"} {
+			if !strings.Contains(out, want) {
+				t.Errorf("%dmm: receipt has no %q:\n%s", paper, want, out)
+			}
+		}
+	}
+
+	english := Text(testReceipt(false), Paper80)
+	if hasArabic(english) {
+		t.Errorf("receipt without Arabic printed some:\n%s", english)
+	}
+}
+
+func TestESCPOS(t *testing.T) {
+	out := ESCPOS(testReceipt(true), Paper80)
+	if !bytes.HasPrefix(out, append(escInit, 0x1B, 't', defaultCodePage)) {
+		t.Errorf("ESC/POS does not start by resetting the printer and selecting code page %d", defaultCodePage)
+	}
+	if !bytes.HasSuffix(out, escFeedCut) {
+		t.Error("ESC/POS does not end by feeding and cutting")
+	}
+	if !bytes.Contains(out, []byte("23.00")) {
+		t.Error("ESC/POS has no total")
+	}
+	// Arabic goes out as code page 864 bytes, never as UTF-8.
+	if utf8.Valid(out) && bytes.ContainsRune(out, 'ا') {
+		t.Error("ESC/POS carries UTF-8 Arabic")
+	}
+
+	r := testReceipt(true)
+	r.Template.CodePage = 63
+	if out := ESCPOS(r, Paper58); !bytes.HasPrefix(out, append(escInit, 0x1B, 't', 63)) {
+		t.Error("ESC/POS ignores the template's code page")
+	}
+}
+
+func TestHTML(t *testing.T) {
+	out, err := HTML(testReceipt(true), Paper80)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Berhot Cafe", "مقهى برهوت", `dir="rtl"`, "<svg"} {
+		if !bytes.Contains(out, []byte(want)) {
+			t.Errorf("HTML has no %q", want)
+		}
+	}
+}
+
+func TestPDF(t *testing.T) {
+	out, err := PDF(testReceipt(false), Paper58, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(out, []byte("%PDF-1.4\n")) || !bytes.HasSuffix(out, []byte("%%EOF\n")) {
+		t.Error("PDF is not framed by its header and trailer")
+	}
+}
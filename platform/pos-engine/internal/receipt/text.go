@@ -0,0 +1,30 @@
+package receipt
+
+import "strings"
+
+// Text renders the receipt as plain text, one line per row of the layout.
+// Arabic is left in logical order for the viewer to lay out. The logo and
+// QR code are left out.
+func Text(r Receipt, paper Paper) string {
+	cols := paper.columns()
+	logical := func(s string) string { return s }
+	var b strings.Builder
+	prev := lineText
+	for _, l := range layout(r, cols) {
+		switch l.kind {
+		case lineRule:
+			// The QR code between two rules is left out; print one.
+			if prev == lineRule {
+				continue
+			}
+			b.WriteString(strings.Repeat("-", cols))
+		case lineText:
+			b.WriteString(l.grid(cols, logical))
+		default:
+			continue
+		}
+		b.WriteByte('\n')
+		prev = l.kind
+	}
+	return b.String()
+}
@@ -0,0 +1,172 @@
+// Package tax works out VAT for order lines.
+//
+// Prices are either tax-exclusive, where tax is added on top, or
+// tax-inclusive, where the tax is already inside the price and is backed out.
+// Tax can be rounded on every line or once per rate on the whole invoice;
+// with invoice rounding each rate's total is rounded and then shared back to
+// its lines so the lines still add up to the invoice.
+package tax
+
+import (
+	"sort"
+
+	"github.com/berhot/products/commerce/pos-engine/internal/money"
+)
+
+// Kinds of tax treatment. They map onto the ZATCA category codes S, Z, E and O.
+const (
+	KindStandard    = "standard"
+	KindZeroRated   = "zero_rated"
+	KindExempt      = "exempt"
+	KindOutOfScope  = "out_of_scope"
+	RoundingLine    = "line"
+	RoundingInvoice = "invoice"
+)
+
+// Category is a tax treatment a product can belong to.
+type Category struct {
+	ID   string
+	Code string
+	Name string
+	Kind string
+	Rate float64
+}
+
+// EffectiveRate is the percentage actually charged; only standard-rated
+// categories charge tax.
+func (c Category) EffectiveRate() float64 {
+	if c.Kind != "" && c.Kind != KindStandard {
+		return 0
+	}
+	return c.Rate
+}
+
+// kind defaults an unset kind from the rate: taxed lines are standard rated,
+// untaxed lines zero rated.
+func (c Category) kind() string {
+	if c.Kind != "" {
+		return c.Kind
+	}
+	if c.Rate == 0 {
+		return KindZeroRated
+	}
+	return KindStandard
+}
+
+// Settings control how an invoice is taxed.
+type Settings struct {
+	PricesIncludeTax bool
+	Rounding         string
+}
+
+// Line is one order line to tax. Amount is what the line sells for after
+// discounts, in the price mode given by Settings.
+type Line struct {
+	Key      string
+	Amount   money.Amount
+	Category Category
+}
+
+// LineResult is a line's split into net and tax, with the kind and rate it
+// was taxed at.
+type LineResult struct {
+	Kind  string
+	Rate  float64
+	Net   money.Amount
+	Tax   money.Amount
+	Gross money.Amount
+}
+
+// Bucket totals every line taxed at the same kind and rate.
+type Bucket struct {
+	Code    string       `json:"code"`
+	Kind    string       `json:"kind"`
+	Rate    float64      `json:"rate"`
+	Taxable money.Amount `json:"taxableAmount"`
+	Tax     money.Amount `json:"taxAmount"`
+}
+
+// Result is the taxed invoice.
+type Result struct {
+	Lines   map[string]LineResult
+	Summary []Bucket
+	Net     money.Amount
+	Tax     money.Amount
+	Gross   money.Amount
+}
+
+// Calculate taxes lines under s.
+func Calculate(lines []Line, s Settings) Result {
+	res := Result{Lines: map[string]LineResult{}}
+	type bucketKey struct {
+		kind string
+		rate float64
+	}
+	groups := map[bucketKey][]Line{}
+	codes := map[bucketKey]string{}
+	var order []bucketKey
+	for _, l := range lines {
+		k := bucketKey{l.Category.kind(), l.Category.EffectiveRate()}
+		if _, ok := groups[k]; !ok {
+			order = append(order, k)
+			codes[k] = l.Category.Code
+		}
+		groups[k] = append(groups[k], l)
+	}
+
+	taxes := map[string]money.Amount{}
+	for _, k := range order {
+		if s.Rounding == RoundingInvoice {
+			// Tax the bucket as a whole, then share it back by line amount.
+			var base money.Amount
+			weights := make([]money.Amount, len(groups[k]))
+			for i, l := range groups[k] {
+				base += l.Amount
+				weights[i] = l.Amount
+			}
+			for i, t := range lineTax(base, k.rate, s).Allocate(weights) {
+				taxes[groups[k][i].Key] = t
+			}
+			continue
+		}
+		for _, l := range groups[k] {
+			taxes[l.Key] = lineTax(l.Amount, k.rate, s)
+		}
+	}
+
+	buckets := map[bucketKey]*Bucket{}
+	for _, l := range lines {
+		t := taxes[l.Key]
+		k := bucketKey{l.Category.kind(), l.Category.EffectiveRate()}
+		lr := LineResult{Kind: k.kind, Rate: k.rate, Tax: t}
+		if s.PricesIncludeTax {
+			lr.Gross, lr.Net = l.Amount, l.Amount-t
+		} else {
+			lr.Net, lr.Gross = l.Amount, l.Amount+t
+		}
+		res.Lines[l.Key] = lr
+		res.Net += lr.Net
+		res.Tax += lr.Tax
+		res.Gross += lr.Gross
+
+		b, ok := buckets[k]
+		if !ok {
+			b = &Bucket{Code: codes[k], Kind: k.kind, Rate: k.rate}
+			buckets[k] = b
+		}
+		b.Taxable += lr.Net
+		b.Tax += lr.Tax
+	}
+	for _, k := range order {
+		res.Summary = append(res.Summary, *buckets[k])
+	}
+	sort.SliceStable(res.Summary, func(i, j int) bool { return res.Summary[i].Rate > res.Summary[j].Rate })
+	return res
+}
+
+func lineTax(amount money.Amount, rate float64, s Settings) money.Amount {
+	if s.PricesIncludeTax {
+		return amount.TaxIncluded(rate)
+	}
+	return amount.Percent(rate)
+}
@@ -0,0 +1,109 @@
+package tax
+
+import (
+	"testing"
+
+	"github.com/berhot/products/commerce/pos-engine/internal/money"
+)
+
+var vat = Category{Code: "VAT15", Kind: KindStandard, Rate: 15}
+
+func TestCalculateLines(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings Settings
+		lines    []Line
+		want     map[string]LineResult
+	}{
+		{
+			name:     "exclusive adds tax on top",
+			settings: Settings{Rounding: RoundingLine},
+			lines:    []Line{{Key: "a", Amount: 1000, Category: vat}, {Key: "b", Amount: 333, Category: vat}},
+			want: map[string]LineResult{
+				"a": {Kind: KindStandard, Rate: 15, Net: 1000, Tax: 150, Gross: 1150},
+				"b": {Kind: KindStandard, Rate: 15, Net: 333, Tax: 50, Gross: 383},
+			},
+		},
+		{
+			name:     "inclusive backs tax out",
+			settings: Settings{PricesIncludeTax: true, Rounding: RoundingLine},
+			lines:    []Line{{Key: "a", Amount: 1150, Category: vat}, {Key: "b", Amount: 100, Category: vat}},
+			want: map[string]LineResult{
+				"a": {Kind: KindStandard, Rate: 15, Net: 1000, Tax: 150, Gross: 1150},
+				"b": {Kind: KindStandard, Rate: 15, Net: 87, Tax: 13, Gross: 100},
+			},
+		},
+		{
+			name:     "line rounding loses fractions on each line",
+			settings: Settings{Rounding: RoundingLine},
+			lines:    []Line{{Key: "a", Amount: 3, Category: vat}, {Key: "b", Amount: 3, Category: vat}, {Key: "c", Amount: 3, Category: vat}},
+			want: map[string]LineResult{
+				"a": {Kind: KindStandard, Rate: 15, Net: 3, Tax: 0, Gross: 3},
+				"b": {Kind: KindStandard, Rate: 15, Net: 3, Tax: 0, Gross: 3},
+				"c": {Kind: KindStandard, Rate: 15, Net: 3, Tax: 0, Gross: 3},
+			},
+		},
+		{
+			name:     "invoice rounding shares the rounded total back",
+			settings: Settings{Rounding: RoundingInvoice},
+			lines:    []Line{{Key: "a", Amount: 3, Category: vat}, {Key: "b", Amount: 3, Category: vat}, {Key: "c", Amount: 3, Category: vat}},
+			want: map[string]LineResult{
+				"a": {Kind: KindStandard, Rate: 15, Net: 3, Tax: 1, Gross: 4},
+				"b": {Kind: KindStandard, Rate: 15, Net: 3, Tax: 0, Gross: 3},
+				"c": {Kind: KindStandard, Rate: 15, Net: 3, Tax: 0, Gross: 3},
+			},
+		},
+		{
+			name:     "untaxed kinds charge nothing",
+			settings: Settings{Rounding: RoundingLine},
+			lines: []Line{
+				{Key: "exempt", Amount: 500, Category: Category{Kind: KindExempt, Rate: 15}},
+				{Key: "out", Amount: 500, Category: Category{Kind: KindOutOfScope}},
+				{Key: "zero", Amount: 500, Category: Category{}},
+			},
+			want: map[string]LineResult{
+				"exempt": {Kind: KindExempt, Net: 500, Gross: 500},
+				"out":    {Kind: KindOutOfScope, Net: 500, Gross: 500},
+				"zero":   {Kind: KindZeroRated, Net: 500, Gross: 500},
+			},
+		},
+	}
+	for _, tt := range tests {
+		res := Calculate(tt.lines, tt.settings)
+		var net, tax, gross money.Amount
+		for key, want := range tt.want {
+			got := res.Lines[key]
+			if got != want {
+				t.Errorf("%s: line %s = %+v, want %+v", tt.name, key, got, want)
+			}
+			net, tax, gross = net+want.Net, tax+want.Tax, gross+want.Gross
+		}
+		if res.Net != net || res.Tax != tax || res.Gross != gross {
+			t.Errorf("%s: totals = %s/%s/%s, want %s/%s/%s", tt.name, res.Net, res.Tax, res.Gross, net, tax, gross)
+		}
+	}
+}
+
+func TestCalculateSummary(t *testing.T) {
+	reduced := Category{Code: "VAT5", Kind: KindStandard, Rate: 5}
+	res := Calculate([]Line{
+		{Key: "a", Amount: 1000, Category: reduced},
+		{Key: "b", Amount: 2000, Category: vat},
+		{Key: "c", Amount: 500, Category: Category{Code: "EX", Kind: KindExempt}},
+		{Key: "d", Amount: 1000, Category: vat},
+	}, Settings{Rounding: RoundingLine})
+
+	want := []Bucket{
+		{Code: "VAT15", Kind: KindStandard, Rate: 15, Taxable: 3000, Tax: 450},
+		{Code: "VAT5", Kind: KindStandard, Rate: 5, Taxable: 1000, Tax: 50},
+		{Code: "EX", Kind: KindExempt, Taxable: 500},
+	}
+	if len(res.Summary) != len(want) {
+		t.Fatalf("Summary = %+v, want %+v", res.Summary, want)
+	}
+	for i := range want {
+		if res.Summary[i] != want[i] {
+			t.Errorf("Summary[%d] = %+v, want %+v", i, res.Summary[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package zatca
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// canonicalize renders doc as Canonical XML 1.1 without comments, the form
+// ZATCA hashes and signs. With apex set, only the first element of that
+// qualified name and its content are rendered, carrying the namespaces in
+// scope from its ancestors, as a signature reference to a subtree is
+// canonicalized.
+//
+// It covers the XML this package writes: no DTDs, entities or xml:
+// attributes.
+func canonicalize(doc []byte, apex string) ([]byte, error) {
+	dec := xml.NewDecoder(bytes.NewReader(doc))
+	var out bytes.Buffer
+	// inScope holds the namespaces in scope at each open element, and
+	// rendered those already written out at each open output element.
+	var inScope, rendered []map[string]string
+	depth := 0
+	for {
+		tok, err := dec.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			scope := map[string]string{}
+			if n := len(inScope); n > 0 {
+				for p, uri := range inScope[n-1] {
+					scope[p] = uri
+				}
+			}
+			var attrs []xml.Attr
+			for _, a := range t.Attr {
+				switch {
+				case a.Name.Space == "" && a.Name.Local == "xmlns":
+					scope[""] = a.Value
+				case a.Name.Space == "xmlns":
+					scope[a.Name.Local] = a.Value
+				default:
+					attrs = append(attrs, a)
+				}
+			}
+			inScope = append(inScope, scope)
+			if depth == 0 && apex != "" && qualifiedName(t.Name) != apex {
+				continue
+			}
+			parent := map[string]string{}
+			if depth > 0 {
+				parent = rendered[len(rendered)-1]
+			}
+			depth++
+			out.WriteString("<" + qualifiedName(t.Name))
+			// Namespace declarations come first, by prefix, then the other
+			// attributes by namespace URI and local name.
+			var prefixes []string
+			for p, uri := range scope {
+				if parent[p] != uri {
+					prefixes = append(prefixes, p)
+				}
+			}
+			sort.Strings(prefixes)
+			written := map[string]string{}
+			for p, uri := range parent {
+				written[p] = uri
+			}
+			for _, p := range prefixes {
+				name := "xmlns"
+				if p != "" {
+					name += ":" + p
+				}
+				out.WriteString(" " + name + `="` + escapeAttr(scope[p]) + `"`)
+				written[p] = scope[p]
+			}
+			rendered = append(rendered, written)
+			sort.SliceStable(attrs, func(i, j int) bool {
+				ui, uj := scope[attrs[i].Name.Space], scope[attrs[j].Name.Space]
+				if attrs[i].Name.Space == "" {
+					ui = ""
+				}
+				if attrs[j].Name.Space == "" {
+					uj = ""
+				}
+				if ui != uj {
+					return ui < uj
+				}
+				return attrs[i].Name.Local < attrs[j].Name.Local
+			})
+			for _, a := range attrs {
+				out.WriteString(" " + qualifiedName(a.Name) + `="` + escapeAttr(a.Value) + `"`)
+			}
+			out.WriteString(">")
+		case xml.EndElement:
+			inScope = inScope[:len(inScope)-1]
+			if depth == 0 {
+				continue
+			}
+			out.WriteString("</" + qualifiedName(t.Name) + ">")
+			rendered = rendered[:len(rendered)-1]
+			depth--
+			if depth == 0 && apex != "" {
+				return out.Bytes(), nil
+			}
+		case xml.CharData:
+			if depth > 0 {
+				out.WriteString(escapeText(string(t)))
+			}
+		}
+	}
+	if apex != "" {
+		return nil, fmt.Errorf("zatca: no %s element to canonicalize", apex)
+	}
+	return out.Bytes(), nil
+}
+
+func qualifiedName(n xml.Name) string {
+	if n.Space == "" {
+		return n.Local
+	}
+	return n.Space + ":" + n.Local
+}
+
+var (
+	textEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", "&#xD;")
+	attrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", `"`, "&quot;", "\t", "&#x9;", "\n", "&#xA;", "\r", "&#xD;")
+)
+
+func escapeText(s string) string { return textEscaper.Replace(s) }
+func escapeAttr(s string) string { return attrEscaper.Replace(s) }
@@ -0,0 +1,69 @@
+package zatca
+
+import "testing"
+
+// The expected output of these cases is what xmllint --c14n11 produces, less
+// the comments it keeps.
+func TestCanonicalize(t *testing.T) {
+	tests := []struct {
+		name, in, apex, want string
+	}{
+		{
+			name: "declaration dropped and empty elements expanded",
+			in:   `<?xml version="1.0" encoding="UTF-8"?>` + "\n" + `<a/>`,
+			want: `<a></a>`,
+		},
+		{
+			name: "attributes sorted and comments dropped",
+			in:   `<a b="2" a="1"><!-- note --><c/></a>`,
+			want: `<a a="1" b="2"><c></c></a>`,
+		},
+		{
+			name: "namespaces first, sorted, and not repeated",
+			in:   `<a xmlns:z="urn:z" xmlns="urn:d" xmlns:b="urn:b"><b:c xmlns:b="urn:b" z:y="1" x="2"/></a>`,
+			want: `<a xmlns="urn:d" xmlns:b="urn:b" xmlns:z="urn:z"><b:c x="2" z:y="1"></b:c></a>`,
+		},
+		{
+			name: "qualified attributes sort by namespace URI",
+			in:   `<a xmlns:b="urn:b" b:z="1" b:a="2" z="3"/>`,
+			want: `<a xmlns:b="urn:b" z="3" b:a="2" b:z="1"></a>`,
+		},
+		{
+			name: "redeclared prefix kept",
+			in:   `<a xmlns:p="urn:p"><p:b xmlns:p="urn:other"><c/></p:b></a>`,
+			want: `<a xmlns:p="urn:p"><p:b xmlns:p="urn:other"><c></c></p:b></a>`,
+		},
+		{
+			name: "text escaping",
+			in:   `<a>x &gt; y &amp; "q" 'p'</a>`,
+			want: `<a>x &gt; y &amp; "q" 'p'</a>`,
+		},
+		{
+			name: "attribute escaping",
+			in:   `<a t="&quot;&lt;&gt;&#9;&#10;"/>`,
+			want: `<a t="&quot;&lt;>&#x9;&#xA;"></a>`,
+		},
+		{
+			name: "subtree carries the namespaces in scope",
+			in:   `<a xmlns="urn:d" xmlns:p="urn:p"><x/><p:b><c>x</c></p:b><p:b>second</p:b></a>`,
+			apex: "p:b",
+			want: `<p:b xmlns="urn:d" xmlns:p="urn:p"><c>x</c></p:b>`,
+		},
+	}
+	for _, tt := range tests {
+		got, err := canonicalize([]byte(tt.in), tt.apex)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s:\n got %s\nwant %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCanonicalizeMissingApex(t *testing.T) {
+	if _, err := canonicalize([]byte(`<a><b/></a>`), "ds:SignedInfo"); err == nil {
+		t.Error("canonicalize found a missing apex element")
+	}
+}
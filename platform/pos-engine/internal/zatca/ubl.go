@@ -0,0 +1,355 @@
+package zatca
+
+import (
+	"encoding/xml"
+	"strconv"
+
+	"github.com/berhot/products/commerce/pos-engine/internal/money"
+)
+
+// UBL 2.1 invoice, limited to what a ZATCA simplified invoice carries.
+// Element names carry their cbc:/cac: prefixes literally, which is how ZATCA's
+// samples and validator expect them.
+
+const (
+	nsInvoice = "urn:oasis:names:specification:ubl:schema:xsd:Invoice-2"
+	nsCAC     = "urn:oasis:names:specification:ubl:schema:xsd:CommonAggregateComponents-2"
+	nsCBC     = "urn:oasis:names:specification:ubl:schema:xsd:CommonBasicComponents-2"
+	nsEXT     = "urn:oasis:names:specification:ubl:schema:xsd:CommonExtensionComponents-2"
+	nsSIG     = "urn:oasis:names:specification:ubl:schema:xsd:CommonSignatureComponents-2"
+	nsSAC     = "urn:oasis:names:specification:ubl:schema:xsd:SignatureAggregateComponents-2"
+	nsSBC     = "urn:oasis:names:specification:ubl:schema:xsd:SignatureBasicComponents-2"
+	nsDS      = "http://www.w3.org/2000/09/xmldsig#"
+
+	signatureID     = "urn:oasis:names:specification:ubl:signature:Invoice"
+	signatureMethod = "urn:oasis:names:specification:ubl:dsig:enveloped:xades"
+
+	// Simplified tax invoice: type 388 with the 02 (simplified) subtype.
+	invoiceTypeCode = "388"
+	invoiceTypeName = "0200000"
+)
+
+type document struct {
+	XMLName xml.Name `xml:"Invoice"`
+	XMLNS   string   `xml:"xmlns,attr"`
+	CAC     string   `xml:"xmlns:cac,attr"`
+	CBC     string   `xml:"xmlns:cbc,attr"`
+	EXT     string   `xml:"xmlns:ext,attr"`
+
+	Extensions *extensions `xml:"ext:UBLExtensions,omitempty"`
+
+	ProfileID     string   `xml:"cbc:ProfileID"`
+	ID            string   `xml:"cbc:ID"`
+	UUID          string   `xml:"cbc:UUID"`
+	IssueDate     string   `xml:"cbc:IssueDate"`
+	IssueTime     string   `xml:"cbc:IssueTime"`
+	TypeCode      typeCode `xml:"cbc:InvoiceTypeCode"`
+	Currency      string   `xml:"cbc:DocumentCurrencyCode"`
+	TaxCurrency   string   `xml:"cbc:TaxCurrencyCode"`
+	References    []docRef `xml:"cac:AdditionalDocumentReference"`
+	Signature     *signatureRef
+	Supplier      party         `xml:"cac:AccountingSupplierParty>cac:Party"`
+	Customer      struct{}      `xml:"cac:AccountingCustomerParty"`
+	TaxTotals     []taxTotal    `xml:"cac:TaxTotal"`
+	MonetaryTotal monetaryTotal `xml:"cac:LegalMonetaryTotal"`
+	Lines         []invoiceLine `xml:"cac:InvoiceLine"`
+}
+
+type typeCode struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:",chardata"`
+}
+
+type docRef struct {
+	ID         string      `xml:"cbc:ID"`
+	UUID       string      `xml:"cbc:UUID,omitempty"`
+	Attachment *attachment `xml:"cac:Attachment,omitempty"`
+}
+
+type attachment struct {
+	Object binaryObject `xml:"cbc:EmbeddedDocumentBinaryObject"`
+}
+
+type binaryObject struct {
+	MimeCode string `xml:"mimeCode,attr"`
+	Value    string `xml:",chardata"`
+}
+
+type signatureRef struct {
+	XMLName xml.Name `xml:"cac:Signature"`
+	ID      string   `xml:"cbc:ID"`
+	Method  string   `xml:"cbc:SignatureMethod"`
+}
+
+type party struct {
+	Identification *partyID `xml:"cac:PartyIdentification,omitempty"`
+	Address        address  `xml:"cac:PostalAddress"`
+	TaxScheme      struct {
+		CompanyID string `xml:"cbc:CompanyID"`
+		Scheme    string `xml:"cac:TaxScheme>cbc:ID"`
+	} `xml:"cac:PartyTaxScheme"`
+	RegistrationName string `xml:"cac:PartyLegalEntity>cbc:RegistrationName"`
+}
+
+type partyID struct {
+	ID schemeID `xml:"cbc:ID"`
+}
+
+type schemeID struct {
+	Scheme string `xml:"schemeID,attr"`
+	Value  string `xml:",chardata"`
+}
+
+type address struct {
+	Street      string `xml:"cbc:StreetName,omitempty"`
+	Building    string `xml:"cbc:BuildingNumber,omitempty"`
+	District    string `xml:"cbc:CitySubdivisionName,omitempty"`
+	City        string `xml:"cbc:CityName,omitempty"`
+	PostalZone  string `xml:"cbc:PostalZone,omitempty"`
+	CountryCode string `xml:"cac:Country>cbc:IdentificationCode"`
+}
+
+type amount struct {
+	Currency string `xml:"currencyID,attr"`
+	Value    string `xml:",chardata"`
+}
+
+func amountIn(currency string, a money.Amount) amount {
+	return amount{Currency: currency, Value: a.String()}
+}
+
+type taxTotal struct {
+	TaxAmount amount        `xml:"cbc:TaxAmount"`
+	Subtotals []taxSubtotal `xml:"cac:TaxSubtotal"`
+}
+
+type taxSubtotal struct {
+	Taxable  amount      `xml:"cbc:TaxableAmount"`
+	Tax      amount      `xml:"cbc:TaxAmount"`
+	Category taxCategory `xml:"cac:TaxCategory"`
+}
+
+type taxCategory struct {
+	ID          string `xml:"cbc:ID"`
+	Percent     string `xml:"cbc:Percent"`
+	ReasonCode  string `xml:"cbc:TaxExemptionReasonCode,omitempty"`
+	Reason      string `xml:"cbc:TaxExemptionReason,omitempty"`
+	TaxSchemeID string `xml:"cac:TaxScheme>cbc:ID"`
+}
+
+type monetaryTotal struct {
+	LineExtension amount `xml:"cbc:LineExtensionAmount"`
+	TaxExclusive  amount `xml:"cbc:TaxExclusiveAmount"`
+	TaxInclusive  amount `xml:"cbc:TaxInclusiveAmount"`
+	Allowance     amount `xml:"cbc:AllowanceTotalAmount"`
+	Payable       amount `xml:"cbc:PayableAmount"`
+}
+
+type invoiceLine struct {
+	ID            string `xml:"cbc:ID"`
+	Quantity      quantity
+	LineExtension amount `xml:"cbc:LineExtensionAmount"`
+	TaxTotal      struct {
+		TaxAmount amount `xml:"cbc:TaxAmount"`
+		Rounding  amount `xml:"cbc:RoundingAmount"`
+	} `xml:"cac:TaxTotal"`
+	Item struct {
+		Name     string      `xml:"cbc:Name"`
+		Category taxCategory `xml:"cac:ClassifiedTaxCategory"`
+	} `xml:"cac:Item"`
+	Price amount `xml:"cac:Price>cbc:PriceAmount"`
+}
+
+type quantity struct {
+	XMLName  xml.Name `xml:"cbc:InvoicedQuantity"`
+	UnitCode string   `xml:"unitCode,attr"`
+	Value    string   `xml:",chardata"`
+}
+
+type extensions struct {
+	Extension struct {
+		URI     string `xml:"ext:ExtensionURI"`
+		Content struct {
+			Signatures struct {
+				XMLName xml.Name `xml:"sig:UBLDocumentSignatures"`
+				SIG     string   `xml:"xmlns:sig,attr"`
+				SAC     string   `xml:"xmlns:sac,attr"`
+				SBC     string   `xml:"xmlns:sbc,attr"`
+				Info    struct {
+					ID           string      `xml:"cbc:ID"`
+					ReferencedID string      `xml:"sbc:ReferencedSignatureID"`
+					Signature    dsSignature `xml:"ds:Signature"`
+				} `xml:"sac:SignatureInformation"`
+			}
+		} `xml:"ext:ExtensionContent"`
+	} `xml:"ext:UBLExtension"`
+}
+
+type dsSignature struct {
+	DS         string `xml:"xmlns:ds,attr"`
+	ID         string `xml:"Id,attr"`
+	SignedInfo struct {
+		Canonicalization algorithm `xml:"ds:CanonicalizationMethod"`
+		Method           algorithm `xml:"ds:SignatureMethod"`
+		Reference        struct {
+			ID         string      `xml:"Id,attr"`
+			URI        string      `xml:"URI,attr"`
+			Transforms []transform `xml:"ds:Transforms>ds:Transform"`
+			Digest     algorithm   `xml:"ds:DigestMethod"`
+			Value      string      `xml:"ds:DigestValue"`
+		} `xml:"ds:Reference"`
+	} `xml:"ds:SignedInfo"`
+	Value       string `xml:"ds:SignatureValue"`
+	Certificate string `xml:"ds:KeyInfo>ds:X509Data>ds:X509Certificate"`
+}
+
+type algorithm struct {
+	Algorithm string `xml:"Algorithm,attr"`
+}
+
+type transform struct {
+	Algorithm string `xml:"Algorithm,attr"`
+	XPath     string `xml:"ds:XPath,omitempty"`
+}
+
+const (
+	algC14N11 = "http://www.w3.org/2006/12/xml-c14n11"
+	algXPath  = "http://www.w3.org/TR/1999/REC-xpath-19991116"
+)
+
+// hashTransforms tell a verifier how the invoice hash was taken: the
+// signature, its UBL reference and the QR code are left out and the rest
+// canonicalized.
+var hashTransforms = []transform{
+	{Algorithm: algXPath, XPath: "not(//ancestor-or-self::ext:UBLExtensions)"},
+	{Algorithm: algXPath, XPath: "not(//ancestor-or-self::cac:Signature)"},
+	{Algorithm: algXPath, XPath: "not(//ancestor-or-self::cac:AdditionalDocumentReference[cbc:ID='QR'])"},
+	{Algorithm: algC14N11},
+}
+
+func newDocument(inv Invoice) *document {
+	cur := inv.Currency
+	if cur == "" {
+		cur = money.DefaultCurrency
+	}
+	d := &document{
+		XMLNS: nsInvoice, CAC: nsCAC, CBC: nsCBC, EXT: nsEXT,
+		ProfileID:   "reporting:1.0",
+		ID:          inv.Number,
+		UUID:        inv.UUID,
+		IssueDate:   localTime(inv.IssuedAt).Format("2006-01-02"),
+		IssueTime:   localTime(inv.IssuedAt).Format("15:04:05"),
+		TypeCode:    typeCode{Name: invoiceTypeName, Value: invoiceTypeCode},
+		Currency:    cur,
+		TaxCurrency: TaxCurrency,
+		References: []docRef{
+			{ID: "ICV", UUID: strconv.FormatInt(inv.Counter, 10)},
+			{ID: "PIH", Attachment: &attachment{Object: binaryObject{MimeCode: "text/plain", Value: inv.PreviousHash}}},
+		},
+	}
+
+	s := inv.Seller
+	if s.CRNumber != "" {
+		d.Supplier.Identification = &partyID{ID: schemeID{Scheme: "CRN", Value: s.CRNumber}}
+	}
+	country := s.CountryCode
+	if country == "" {
+		country = "SA"
+	}
+	d.Supplier.Address = address{
+		Street: s.Street, Building: s.BuildingNumber, District: s.District,
+		City: s.City, PostalZone: s.PostalCode, CountryCode: country,
+	}
+	d.Supplier.TaxScheme.CompanyID = s.VATNumber
+	d.Supplier.TaxScheme.Scheme = "VAT"
+	d.Supplier.RegistrationName = s.Name
+
+	// VAT is reported once in the tax currency, then again with a
+	// breakdown per category in the invoice currency.
+	d.TaxTotals = append(d.TaxTotals, taxTotal{TaxAmount: amountIn(TaxCurrency, inv.Tax)})
+	breakdown := taxTotal{TaxAmount: amountIn(cur, inv.Tax)}
+	for _, b := range inv.Summary {
+		breakdown.Subtotals = append(breakdown.Subtotals, taxSubtotal{
+			Taxable:  amountIn(cur, b.Taxable),
+			Tax:      amountIn(cur, b.Tax),
+			Category: newTaxCategory(b.Kind, b.Rate),
+		})
+	}
+	d.TaxTotals = append(d.TaxTotals, breakdown)
+
+	d.MonetaryTotal = monetaryTotal{
+		LineExtension: amountIn(cur, inv.Net),
+		TaxExclusive:  amountIn(cur, inv.Net),
+		TaxInclusive:  amountIn(cur, inv.Total),
+		Allowance:     amountIn(cur, 0),
+		Payable:       amountIn(cur, inv.Total),
+	}
+
+	for i, l := range inv.Lines {
+		line := invoiceLine{
+			ID:            strconv.Itoa(i + 1),
+			Quantity:      quantity{UnitCode: "PCE", Value: strconv.FormatFloat(l.Quantity, 'f', -1, 64)},
+			LineExtension: amountIn(cur, l.Net),
+			Price:         amountIn(cur, l.Net.Share(1, l.Quantity)),
+		}
+		line.TaxTotal.TaxAmount = amountIn(cur, l.Tax)
+		line.TaxTotal.Rounding = amountIn(cur, l.Net+l.Tax)
+		line.Item.Name = l.Name
+		line.Item.Category = newTaxCategory(l.Kind, l.Rate)
+		d.Lines = append(d.Lines, line)
+	}
+	return d
+}
+
+func newTaxCategory(kind string, rate float64) taxCategory {
+	code, reason := exemptionReason(kind)
+	return taxCategory{
+		ID:          categoryCode(kind),
+		Percent:     strconv.FormatFloat(rate, 'f', 2, 64),
+		ReasonCode:  code,
+		Reason:      reason,
+		TaxSchemeID: "VAT",
+	}
+}
+
+// sign adds the signature over the invoice hash, short of its value, which
+// is taken over the canonical SignedInfo this writes. certificate is the
+// base64 DER of the signing certificate.
+func (d *document) sign(hash, certificate string) {
+	ext := &extensions{}
+	ext.Extension.URI = signatureMethod
+	sigs := &ext.Extension.Content.Signatures
+	sigs.SIG, sigs.SAC, sigs.SBC = nsSIG, nsSAC, nsSBC
+	sigs.Info.ID = "urn:oasis:names:specification:ubl:signature:1"
+	sigs.Info.ReferencedID = signatureID
+	ds := &sigs.Info.Signature
+	ds.DS, ds.ID = nsDS, "signature"
+	ds.SignedInfo.Canonicalization.Algorithm = algC14N11
+	ds.SignedInfo.Method.Algorithm = "http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha256"
+	ds.SignedInfo.Reference.ID = "invoiceSignedData"
+	ds.SignedInfo.Reference.Transforms = hashTransforms
+	ds.SignedInfo.Reference.Digest.Algorithm = "http://www.w3.org/2001/04/xmlenc#sha256"
+	ds.SignedInfo.Reference.Value = hash
+	ds.Certificate = certificate
+	d.Extensions = ext
+	d.Signature = &signatureRef{ID: signatureID, Method: signatureMethod}
+}
+
+// seal fills in the signature value and adds the QR code.
+func (d *document) seal(signature, qr string) {
+	d.Extensions.Extension.Content.Signatures.Info.Signature.Value = signature
+	d.References = append(d.References, docRef{
+		ID:         "QR",
+		Attachment: &attachment{Object: binaryObject{MimeCode: "text/plain", Value: qr}},
+	})
+}
+
+// render writes the document without indentation, so that taking the
+// signature elements back out leaves exactly the XML that was hashed.
+func (d *document) render() ([]byte, error) {
+	out, err := xml.Marshal(d)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), out...), nil
+}
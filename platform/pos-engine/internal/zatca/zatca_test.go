@@ -0,0 +1,213 @@
+package zatca
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/berhot/products/commerce/pos-engine/internal/tax"
+)
+
+func testInvoice() Invoice {
+	return Invoice{
+		UUID:         "3cf5ee18-ee25-44ea-a444-2c37ba7f28be",
+		Number:       "INV-000001",
+		Counter:      1,
+		PreviousHash: FirstPreviousHash,
+		IssuedAt:     time.Date(2026, 3, 1, 9, 30, 0, 0, time.UTC),
+		Currency:     "SAR",
+		Seller: Seller{
+			Name: "Berhot Cafe", VATNumber: "310122393500003", CRNumber: "1010010000",
+			Street: "King Fahd Road", BuildingNumber: "1234", District: "Al Olaya",
+			City: "Riyadh", PostalCode: "12211", CountryCode: "SA",
+		},
+		Lines: []Line{
+			{Name: "Latte", Quantity: 2, Net: 2000, Tax: 300, Kind: tax.KindStandard, Rate: 15},
+			{Name: "Water", Quantity: 1, Net: 300, Kind: tax.KindZeroRated},
+		},
+		Summary: []tax.Bucket{
+			{Code: "VAT15", Kind: tax.KindStandard, Rate: 15, Taxable: 2000, Tax: 300},
+			{Code: "ZERO", Kind: tax.KindZeroRated, Taxable: 300},
+		},
+		Net:   2300,
+		Tax:   300,
+		Total: 2600,
+	}
+}
+
+func TestQR(t *testing.T) {
+	// ZATCA's published example of the first five tags.
+	got, err := QR("Bobs Records", "310122393500003", "2022-04-25T15:30:00Z", "1000.00", "150.00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "AQxCb2JzIFJlY29yZHMCDzMxMDEyMjM5MzUwMDAwMwMUMjAyMi0wNC0yNVQxNTozMDowMFoEBzEwMDAuMDAFBjE1MC4wMA=="
+	if got != want {
+		t.Errorf("QR = %s, want %s", got, want)
+	}
+	if _, err := QR(strings.Repeat("x", 256)); err == nil {
+		t.Error("QR accepted a field longer than 255 bytes")
+	}
+}
+
+// decodeQR splits a QR payload back into its values, in tag order.
+func decodeQR(t *testing.T, qr string) []string {
+	t.Helper()
+	b, err := base64.StdEncoding.DecodeString(qr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var values []string
+	for len(b) > 0 {
+		if len(b) < 2 || int(b[0]) != len(values)+1 || len(b) < 2+int(b[1]) {
+			t.Fatalf("malformed TLV at tag %d", len(values)+1)
+		}
+		values = append(values, string(b[2:2+int(b[1])]))
+		b = b[2+int(b[1]):]
+	}
+	return values
+}
+
+func TestFirstPreviousHash(t *testing.T) {
+	sum := sha256.Sum256([]byte("0"))
+	if want := base64.StdEncoding.EncodeToString([]byte(hex.EncodeToString(sum[:]))); FirstPreviousHash != want {
+		t.Errorf("FirstPreviousHash = %s, want %s", FirstPreviousHash, want)
+	}
+}
+
+func TestTimestamp(t *testing.T) {
+	if got := Timestamp(time.Date(2026, 3, 1, 22, 30, 0, 0, time.UTC)); got != "2026-03-02T01:30:00" {
+		t.Errorf("Timestamp = %s, want Saudi time 2026-03-02T01:30:00", got)
+	}
+}
+
+// signatureParts are what the invoice hash leaves out, as hashTransforms
+// describe.
+var signatureParts = regexp.MustCompile(`<ext:UBLExtensions>.*?</ext:UBLExtensions>|<cac:Signature>.*?</cac:Signature>|<cac:AdditionalDocumentReference><cbc:ID>QR</cbc:ID>.*?</cac:AdditionalDocumentReference>`)
+
+func TestIssue(t *testing.T) {
+	// testdata/invoice.xml is testInvoice canonicalized, checked against
+	// xmllint --c14n11.
+	want, err := os.ReadFile("testdata/invoice.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := GenerateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := s.Issue(testInvoice())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	digest := sha256.Sum256(want)
+	if hash := base64.StdEncoding.EncodeToString(digest[:]); signed.Hash != hash {
+		t.Errorf("Hash = %s, want %s", signed.Hash, hash)
+	}
+	unsigned, err := canonicalize(signatureParts.ReplaceAll(signed.XML, nil), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(unsigned, want) {
+		t.Errorf("signed invoice without its signature differs from testdata/invoice.xml:\n%s", unsigned)
+	}
+
+	signedInfo, err := canonicalize(signed.XML, "ds:SignedInfo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(signedInfo, []byte("<ds:DigestValue>"+signed.Hash+"</ds:DigestValue>")) {
+		t.Error("SignedInfo does not reference the invoice hash")
+	}
+	sig, err := base64.StdEncoding.DecodeString(signed.Signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	infoDigest := sha256.Sum256(signedInfo)
+	if !ecdsa.VerifyASN1(&s.key.PublicKey, infoDigest[:], sig) {
+		t.Error("signature does not verify over the canonical SignedInfo")
+	}
+	if !bytes.Contains(signed.XML, []byte("<ds:SignatureValue>"+signed.Signature+"</ds:SignatureValue>")) {
+		t.Error("signature value missing from the invoice")
+	}
+	if !bytes.Contains(signed.XML, []byte(">"+signed.QR+"<")) {
+		t.Error("QR code missing from the invoice")
+	}
+
+	pub, err := s.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantQR := []string{
+		"Berhot Cafe", "310122393500003", "2026-03-01T12:30:00", "26.00", "3.00",
+		signed.Hash, signed.Signature, string(pub), string(s.cert.Signature),
+	}
+	if got := decodeQR(t, signed.QR); !reflect.DeepEqual(got, wantQR) {
+		t.Errorf("QR values = %q, want %q", got, wantQR)
+	}
+}
+
+func TestIssueNeedsVATNumber(t *testing.T) {
+	s, err := GenerateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	inv := testInvoice()
+	inv.Seller.VATNumber = ""
+	if _, err := s.Issue(inv); err == nil {
+		t.Error("Issue accepted a seller without a VAT number")
+	}
+}
+
+func TestNewSigner(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	s, err := NewSigner(keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.key.Equal(key) || !key.PublicKey.Equal(s.cert.PublicKey) {
+		t.Error("NewSigner did not pair the key with a certificate for it")
+	}
+
+	// A certificate for the key is used as is; one for another key is refused.
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.cert.Raw})
+	withCert, err := NewSigner(append(keyPEM, certPEM...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(withCert.cert.Raw, s.cert.Raw) {
+		t.Error("NewSigner did not use the certificate given")
+	}
+	other, err := GenerateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: other.cert.Raw})
+	if _, err := NewSigner(append(keyPEM, otherPEM...)); err == nil {
+		t.Error("NewSigner accepted a certificate for another key")
+	}
+	if _, err := NewSigner([]byte("not a key")); err == nil {
+		t.Error("NewSigner accepted input without a PEM block")
+	}
+}